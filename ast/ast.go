@@ -518,6 +518,8 @@ func (ie *IndexExpression) String() string {
 type HashLiteral struct {
 	Token token.Token
 	Pairs map[Expression]Expression
+	// Keys are the keys of Pairs in the order of the source, the hash is built in this order
+	Keys []Expression
 }
 
 // SetLine .
@@ -540,11 +542,251 @@ func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
-	pairs := make([]string, 0, len(hl.Pairs))
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	pairs := make([]string, 0, len(hl.Keys))
+	for _, key := range hl.Keys {
+		pairs = append(pairs, key.String()+":"+hl.Pairs[key].String())
 	}
 
 	out.WriteString(fmt.Sprintf("{ %s }", strings.Join(pairs, ", ")))
 	return out.String()
 }
+
+// EnumVariant is a variant inside an enum declaration like Circle(r)
+type EnumVariant struct {
+	Name   *Identifier
+	Fields []*Identifier
+}
+
+// String .
+func (ev *EnumVariant) String() string {
+	if len(ev.Fields) == 0 {
+		return ev.Name.String()
+	}
+	fields := make([]string, 0, len(ev.Fields))
+	for _, f := range ev.Fields {
+		fields = append(fields, f.String())
+	}
+	return fmt.Sprintf("%s(%s)", ev.Name.String(), strings.Join(fields, ", "))
+}
+
+// EnumStatement declares a tagged union: enum Shape { Circle(r), Rect(w, h) }
+type EnumStatement struct {
+	Token    token.Token
+	Name     *Identifier
+	Variants []*EnumVariant
+}
+
+// SetLine .
+func (es *EnumStatement) SetLine(s uint64) {
+	es.Token.Line = s
+}
+
+// Line .
+func (es *EnumStatement) Line() uint64 {
+	return es.Token.Line
+}
+
+func (es *EnumStatement) statementNode() {}
+
+// TokenLiteral .
+func (es *EnumStatement) TokenLiteral() string { return es.Token.Literal }
+
+// String .
+func (es *EnumStatement) String() string {
+	variants := make([]string, 0, len(es.Variants))
+	for _, v := range es.Variants {
+		variants = append(variants, v.String())
+	}
+	return fmt.Sprintf("enum %s { %s }", es.Name.String(), strings.Join(variants, ", "))
+}
+
+// VariantPattern matches a variant of an enum and binds its fields: Shape.Circle(r)
+type VariantPattern struct {
+	Enum     *Identifier
+	Variant  *Identifier
+	Bindings []*Identifier
+}
+
+// Tag returns the enum and variant names like Shape.Circle
+func (vp *VariantPattern) Tag() string {
+	return vp.Enum.Value + "." + vp.Variant.Value
+}
+
+// String .
+func (vp *VariantPattern) String() string {
+	if len(vp.Bindings) == 0 {
+		return vp.Tag()
+	}
+	bindings := make([]string, 0, len(vp.Bindings))
+	for _, b := range vp.Bindings {
+		bindings = append(bindings, b.String())
+	}
+	return fmt.Sprintf("%s(%s)", vp.Tag(), strings.Join(bindings, ", "))
+}
+
+// MatchArm is a pattern with the code that runs when it matches, a nil Pattern is the wildcard _
+type MatchArm struct {
+	Pattern *VariantPattern
+	Body    *BlockStatement
+}
+
+// String .
+func (ma *MatchArm) String() string {
+	pattern := "_"
+	if ma.Pattern != nil {
+		pattern = ma.Pattern.String()
+	}
+	return fmt.Sprintf("%s => { %s }", pattern, ma.Body.String())
+}
+
+// MatchExpression represents match (<subject>) { <pattern> => <body>, ... }
+type MatchExpression struct {
+	Token   token.Token
+	Subject Expression
+	Arms    []*MatchArm
+}
+
+// SetLine .
+func (me *MatchExpression) SetLine(s uint64) {
+	me.Token.Line = s
+}
+
+// Line .
+func (me *MatchExpression) Line() uint64 {
+	return me.Token.Line
+}
+
+func (me *MatchExpression) expressionNode() {}
+
+// TokenLiteral .
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+
+// String .
+func (me *MatchExpression) String() string {
+	arms := make([]string, 0, len(me.Arms))
+	for _, arm := range me.Arms {
+		arms = append(arms, arm.String())
+	}
+	return fmt.Sprintf("match %s { %s }", me.Subject.String(), strings.Join(arms, ", "))
+}
+
+// MacroLiteral macro(x, y, ...) {}, its arguments are the quoted AST of the call
+type MacroLiteral struct {
+	Token      token.Token
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+// SetLine .
+func (ml *MacroLiteral) SetLine(s uint64) {
+	ml.Token.Line = s
+}
+
+// Line .
+func (ml *MacroLiteral) Line() uint64 {
+	return ml.Token.Line
+}
+
+func (ml *MacroLiteral) expressionNode() {}
+
+// TokenLiteral .
+func (ml *MacroLiteral) TokenLiteral() string { return ml.Token.Literal }
+
+// String .
+func (ml *MacroLiteral) String() string {
+	params := make([]string, 0, len(ml.Parameters))
+	for _, p := range ml.Parameters {
+		params = append(params, p.String())
+	}
+	return fmt.Sprintf("%s(%s) %s", ml.TokenLiteral(), strings.Join(params, ", "), ml.Body.String())
+}
+
+// TupleLiteral (a, b, ...), also what return a, b returns
+type TupleLiteral struct {
+	Token    token.Token
+	Elements []Expression
+}
+
+// SetLine .
+func (tl *TupleLiteral) SetLine(s uint64) {
+	tl.Token.Line = s
+}
+
+// Line .
+func (tl *TupleLiteral) Line() uint64 {
+	return tl.Token.Line
+}
+
+func (tl *TupleLiteral) expressionNode() {}
+
+// TokenLiteral .
+func (tl *TupleLiteral) TokenLiteral() string { return tl.Token.Literal }
+
+// String .
+func (tl *TupleLiteral) String() string {
+	elements := make([]string, 0, len(tl.Elements))
+	for _, el := range tl.Elements {
+		elements = append(elements, el.String())
+	}
+	return "(" + strings.Join(elements, ", ") + ")"
+}
+
+// DestructureStatement is let (a, b) = tuple;
+type DestructureStatement struct {
+	Token token.Token // let
+	Names []*Identifier
+	Value Expression
+}
+
+// SetLine .
+func (ds *DestructureStatement) SetLine(s uint64) {
+	ds.Token.Line = s
+}
+
+// Line .
+func (ds *DestructureStatement) Line() uint64 {
+	return ds.Token.Line
+}
+
+func (ds *DestructureStatement) statementNode() {}
+
+// TokenLiteral .
+func (ds *DestructureStatement) TokenLiteral() string { return ds.Token.Literal }
+
+// String .
+func (ds *DestructureStatement) String() string {
+	names := make([]string, 0, len(ds.Names))
+	for _, name := range ds.Names {
+		names = append(names, name.String())
+	}
+	return fmt.Sprintf("%s (%s) = %s;", ds.TokenLiteral(), strings.Join(names, ", "), ds.Value.String())
+}
+
+// IndexAssignStatement is h[k] = v or a compound form like arr[i] += v, Left of the target is the variable that
+// gets the updated array or hashmap
+type IndexAssignStatement struct {
+	Token    token.Token // =, +=, -=, *= or /=
+	Target   *IndexExpression
+	Operator string // the infix operator of a compound form, empty for =
+	Value    Expression
+}
+
+// SetLine .
+func (ias *IndexAssignStatement) SetLine(s uint64) {
+	ias.Token.Line = s
+}
+
+// Line .
+func (ias *IndexAssignStatement) Line() uint64 {
+	return ias.Token.Line
+}
+
+func (ias *IndexAssignStatement) statementNode() {}
+
+// TokenLiteral .
+func (ias *IndexAssignStatement) TokenLiteral() string { return ias.Token.Literal }
+
+// String .
+func (ias *IndexAssignStatement) String() string {
+	return fmt.Sprintf("%s[%s] %s %s;", ias.Target.Left.String(), ias.Target.Right.String(), ias.TokenLiteral(), ias.Value.String())
+}
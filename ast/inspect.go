@@ -0,0 +1,86 @@
+package ast
+
+// Inspect walks the tree in depth-first order and calls f for every node before its children, in the order
+// of the source. The children of a node aren't visited when f returns false for it
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+	switch node := node.(type) {
+	case *Program:
+		inspectStatements(node.Statements, f)
+	case *ExpressionStatement:
+		Inspect(node.Expression, f)
+	case *InfixExpression:
+		Inspect(node.Left, f)
+		Inspect(node.Right, f)
+	case *PrefixExpression:
+		Inspect(node.Right, f)
+	case *IndexExpression:
+		Inspect(node.Left, f)
+		Inspect(node.Right, f)
+	case *IfExpression:
+		Inspect(node.Condition, f)
+		Inspect(node.Consequence, f)
+		if node.Alternative != nil {
+			Inspect(node.Alternative, f)
+		}
+	case *BlockStatement:
+		inspectStatements(node.Statements, f)
+	case *ReturnStatement:
+		Inspect(node.ReturnValue, f)
+	case *LetStatement:
+		Inspect(node.Name, f)
+		Inspect(node.Value, f)
+	case *DestructureStatement:
+		inspectIdentifiers(node.Names, f)
+		Inspect(node.Value, f)
+	case *IndexAssignStatement:
+		Inspect(node.Target, f)
+		Inspect(node.Value, f)
+	case *FunctionLiteral:
+		inspectIdentifiers(node.Parameters, f)
+		Inspect(node.Body, f)
+	case *MacroLiteral:
+		inspectIdentifiers(node.Parameters, f)
+		Inspect(node.Body, f)
+	case *CallExpression:
+		Inspect(node.Function, f)
+		for _, argument := range node.Arguments {
+			Inspect(argument, f)
+		}
+	case *ArrayLiteral:
+		for _, element := range node.Elements {
+			Inspect(element, f)
+		}
+	case *TupleLiteral:
+		for _, element := range node.Elements {
+			Inspect(element, f)
+		}
+	case *HashLiteral:
+		for _, key := range node.Keys {
+			Inspect(key, f)
+			Inspect(node.Pairs[key], f)
+		}
+	case *MatchExpression:
+		Inspect(node.Subject, f)
+		for _, arm := range node.Arms {
+			if arm.Pattern != nil {
+				inspectIdentifiers(arm.Pattern.Bindings, f)
+			}
+			Inspect(arm.Body, f)
+		}
+	}
+}
+
+func inspectStatements(statements []Statement, f func(Node) bool) {
+	for _, s := range statements {
+		Inspect(s, f)
+	}
+}
+
+func inspectIdentifiers(identifiers []*Identifier, f func(Node) bool) {
+	for _, ident := range identifiers {
+		Inspect(ident, f)
+	}
+}
@@ -0,0 +1,146 @@
+package ast
+
+// ModifierFunc returns the node that replaces the node passed
+type ModifierFunc func(Node) Node
+
+// Modify walks the tree in depth-first order and replaces every node with what modifier returns,
+// children are modified before their parent.
+// The tree passed isn't changed, every node that contains other nodes is copied
+func Modify(node Node, modifier ModifierFunc) Node {
+	switch node := node.(type) {
+	case *Program:
+		cp := *node
+		cp.Statements = modifyStatements(node.Statements, modifier)
+		return modifier(&cp)
+	case *ExpressionStatement:
+		cp := *node
+		cp.Expression, _ = Modify(node.Expression, modifier).(Expression)
+		return modifier(&cp)
+	case *InfixExpression:
+		cp := *node
+		cp.Left, _ = Modify(node.Left, modifier).(Expression)
+		cp.Right, _ = Modify(node.Right, modifier).(Expression)
+		return modifier(&cp)
+	case *PrefixExpression:
+		cp := *node
+		cp.Right, _ = Modify(node.Right, modifier).(Expression)
+		return modifier(&cp)
+	case *IndexExpression:
+		cp := *node
+		cp.Left, _ = Modify(node.Left, modifier).(Expression)
+		cp.Right, _ = Modify(node.Right, modifier).(Expression)
+		return modifier(&cp)
+	case *IfExpression:
+		cp := *node
+		cp.Condition, _ = Modify(node.Condition, modifier).(Expression)
+		cp.Consequence, _ = Modify(node.Consequence, modifier).(*BlockStatement)
+		if node.Alternative != nil {
+			cp.Alternative, _ = Modify(node.Alternative, modifier).(*BlockStatement)
+		}
+		return modifier(&cp)
+	case *BlockStatement:
+		cp := *node
+		cp.Statements = modifyStatements(node.Statements, modifier)
+		return modifier(&cp)
+	case *ReturnStatement:
+		cp := *node
+		cp.ReturnValue, _ = Modify(node.ReturnValue, modifier).(Expression)
+		return modifier(&cp)
+	case *LetStatement:
+		cp := *node
+		cp.Name, _ = Modify(node.Name, modifier).(*Identifier)
+		cp.Value, _ = Modify(node.Value, modifier).(Expression)
+		return modifier(&cp)
+	case *DestructureStatement:
+		cp := *node
+		cp.Names = modifyIdentifiers(node.Names, modifier)
+		cp.Value, _ = Modify(node.Value, modifier).(Expression)
+		return modifier(&cp)
+	case *IndexAssignStatement:
+		cp := *node
+		cp.Target, _ = Modify(node.Target, modifier).(*IndexExpression)
+		cp.Value, _ = Modify(node.Value, modifier).(Expression)
+		return modifier(&cp)
+	case *FunctionLiteral:
+		cp := *node
+		cp.Parameters = modifyIdentifiers(node.Parameters, modifier)
+		cp.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
+		return modifier(&cp)
+	case *MacroLiteral:
+		cp := *node
+		cp.Parameters = modifyIdentifiers(node.Parameters, modifier)
+		cp.Body, _ = Modify(node.Body, modifier).(*BlockStatement)
+		return modifier(&cp)
+	case *CallExpression:
+		cp := *node
+		cp.Function, _ = Modify(node.Function, modifier).(Expression)
+		cp.Arguments = modifyExpressions(node.Arguments, modifier)
+		return modifier(&cp)
+	case *ArrayLiteral:
+		cp := *node
+		cp.Elements = modifyExpressions(node.Elements, modifier)
+		return modifier(&cp)
+	case *TupleLiteral:
+		cp := *node
+		cp.Elements = modifyExpressions(node.Elements, modifier)
+		return modifier(&cp)
+	case *HashLiteral:
+		cp := *node
+		cp.Pairs = make(map[Expression]Expression, len(node.Pairs))
+		cp.Keys = make([]Expression, 0, len(node.Keys))
+		for _, key := range node.Keys {
+			newKey, _ := Modify(key, modifier).(Expression)
+			newValue, _ := Modify(node.Pairs[key], modifier).(Expression)
+			cp.Pairs[newKey] = newValue
+			cp.Keys = append(cp.Keys, newKey)
+		}
+		return modifier(&cp)
+	case *MatchExpression:
+		cp := *node
+		cp.Subject, _ = Modify(node.Subject, modifier).(Expression)
+		cp.Arms = make([]*MatchArm, 0, len(node.Arms))
+		for _, arm := range node.Arms {
+			newArm := &MatchArm{}
+			if arm.Pattern != nil {
+				pattern := *arm.Pattern
+				pattern.Bindings = modifyIdentifiers(arm.Pattern.Bindings, modifier)
+				newArm.Pattern = &pattern
+			}
+			newArm.Body, _ = Modify(arm.Body, modifier).(*BlockStatement)
+			cp.Arms = append(cp.Arms, newArm)
+		}
+		return modifier(&cp)
+	}
+	if node == nil {
+		return nil
+	}
+	return modifier(node)
+}
+
+func modifyStatements(statements []Statement, modifier ModifierFunc) []Statement {
+	result := make([]Statement, 0, len(statements))
+	for _, s := range statements {
+		if modified, ok := Modify(s, modifier).(Statement); ok {
+			result = append(result, modified)
+		}
+	}
+	return result
+}
+
+func modifyExpressions(expressions []Expression, modifier ModifierFunc) []Expression {
+	result := make([]Expression, 0, len(expressions))
+	for _, exp := range expressions {
+		modified, _ := Modify(exp, modifier).(Expression)
+		result = append(result, modified)
+	}
+	return result
+}
+
+func modifyIdentifiers(identifiers []*Identifier, modifier ModifierFunc) []*Identifier {
+	result := make([]*Identifier, 0, len(identifiers))
+	for _, ident := range identifiers {
+		modified, _ := Modify(ident, modifier).(*Identifier)
+		result = append(result, modified)
+	}
+	return result
+}
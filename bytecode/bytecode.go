@@ -0,0 +1,294 @@
+// Package bytecode saves the bytecode of a compiled program in a file and loads it back,
+// so a program can run on the VM without being parsed and compiled again.
+//
+// A file starts with the magic number "XBC" followed by a zero and the version of the format
+// as a big endian uint16. Then come the instructions and the line table of the program and
+// its constants. The numbers are varints and the strings and instructions have their length before them
+package bytecode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"xlang/code"
+	"xlang/compiler"
+	"xlang/object"
+)
+
+// Magic is the start of every bytecode file
+const Magic = "XBC\x00"
+
+// Version is the version of the format that Encode writes, Decode only reads this version
+const Version = 2
+
+// Extension is the extension of the bytecode files
+const Extension = ".xbc"
+
+// maxLength is the biggest length of an instruction list, a string or a list of constants that Decode accepts
+const maxLength = 1 << 30
+
+// The tags that tell the type of a constant
+const (
+	tagInteger byte = iota + 1
+	tagString
+	tagFunction
+	tagEnum
+)
+
+// ErrNotBytecode is returned by Decode when the input doesn't start with the magic number
+var ErrNotBytecode = errors.New("not a bytecode file, the magic number is missing")
+
+// Encode writes the instructions, line table and constants of bytecode to w
+func Encode(w io.Writer, bytecode *compiler.Bytecode) error {
+	e := &encoder{buf: &bytes.Buffer{}}
+	e.buf.WriteString(Magic)
+	version := make([]byte, 2)
+	binary.BigEndian.PutUint16(version, Version)
+	e.buf.Write(version)
+
+	e.instructions(bytecode.Instructions)
+	e.lines(bytecode.Lines)
+	e.uint(uint64(len(bytecode.Constants)))
+	for i, constant := range bytecode.Constants {
+		if err := e.constant(constant); err != nil {
+			return fmt.Errorf("constant %d: %s", i, err)
+		}
+	}
+	_, err := w.Write(e.buf.Bytes())
+	return err
+}
+
+type encoder struct {
+	buf *bytes.Buffer
+}
+
+func (e *encoder) uint(n uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	e.buf.Write(b[:binary.PutUvarint(b, n)])
+}
+
+func (e *encoder) int(n int64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	e.buf.Write(b[:binary.PutVarint(b, n)])
+}
+
+func (e *encoder) string(s string) {
+	e.uint(uint64(len(s)))
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) instructions(ins code.Instructions) {
+	e.uint(uint64(len(ins)))
+	e.buf.Write(ins)
+}
+
+func (e *encoder) lines(lines code.LineTable) {
+	e.uint(uint64(len(lines)))
+	for _, entry := range lines {
+		e.uint(uint64(entry.Offset))
+		e.uint(uint64(entry.Line))
+	}
+}
+
+func (e *encoder) constant(constant object.Object) error {
+	switch constant := constant.(type) {
+	case *object.Integer:
+		e.buf.WriteByte(tagInteger)
+		e.int(constant.Value)
+	case *object.String:
+		e.buf.WriteByte(tagString)
+		e.string(constant.Value)
+	case *object.CompiledFunction:
+		e.buf.WriteByte(tagFunction)
+		e.uint(uint64(constant.NumLocals))
+		e.uint(uint64(constant.NumParameters))
+		e.string(constant.Name)
+		e.instructions(constant.Instructions)
+		e.lines(constant.Lines)
+		e.uint(uint64(len(constant.Free)))
+		for _, name := range constant.Free {
+			e.string(name)
+		}
+	case *object.Enum:
+		e.buf.WriteByte(tagEnum)
+		e.string(constant.Name)
+		e.uint(uint64(len(constant.Variants)))
+		for _, def := range constant.Variants {
+			e.string(def.Name)
+			e.uint(uint64(len(def.Fields)))
+			for _, field := range def.Fields {
+				e.string(field)
+			}
+		}
+	default:
+		return fmt.Errorf("can't encode a constant of type %s", constant.Type())
+	}
+	return nil
+}
+
+// Decode reads bytecode written by Encode
+func Decode(r io.Reader) (*compiler.Bytecode, error) {
+	d := &decoder{r: bufio.NewReader(r)}
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(d.r, magic); err != nil || string(magic) != Magic {
+		return nil, ErrNotBytecode
+	}
+	version := make([]byte, 2)
+	if _, err := io.ReadFull(d.r, version); err != nil {
+		return nil, fmt.Errorf("the version is missing: %s", err)
+	}
+	if v := binary.BigEndian.Uint16(version); v != Version {
+		return nil, fmt.Errorf("unsupported bytecode version %d, expected %d", v, Version)
+	}
+
+	bytecode := &compiler.Bytecode{}
+	bytecode.Instructions = d.instructions()
+	bytecode.Lines = d.lines()
+	n := d.length()
+	bytecode.Constants = make([]object.Object, 0, min(n, 1024))
+	for i := 0; i < n && d.err == nil; i++ {
+		constant := d.constant()
+		if d.err != nil {
+			return nil, fmt.Errorf("constant %d: %s", i, d.err)
+		}
+		bytecode.Constants = append(bytecode.Constants, constant)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if _, err := d.r.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the constants")
+	}
+	return bytecode, nil
+}
+
+// decoder reads the values of a bytecode file, after the first error it only returns zero values
+// and the error is in err
+type decoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (d *decoder) fail(err error) {
+	if d.err != nil {
+		return
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	d.err = err
+}
+
+func (d *decoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(d.r)
+	d.fail(err)
+	return n
+}
+
+func (d *decoder) int() int64 {
+	if d.err != nil {
+		return 0
+	}
+	n, err := binary.ReadVarint(d.r)
+	d.fail(err)
+	return n
+}
+
+// length reads a length and checks that it's not over maxLength
+func (d *decoder) length() int {
+	n := d.uint()
+	if n > maxLength {
+		d.fail(fmt.Errorf("length %d is too big", n))
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.length()
+	if d.err != nil {
+		return nil
+	}
+	// The bytes are read as they come, so a length that is bigger than the input doesn't allocate all of it
+	var b bytes.Buffer
+	read, err := io.Copy(&b, io.LimitReader(d.r, int64(n)))
+	if err == nil && read < int64(n) {
+		err = io.ErrUnexpectedEOF
+	}
+	d.fail(err)
+	return b.Bytes()
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) instructions() code.Instructions {
+	return code.Instructions(d.bytes())
+}
+
+func (d *decoder) lines() code.LineTable {
+	n := d.length()
+	lines := code.LineTable{}
+	for i := 0; i < n && d.err == nil; i++ {
+		offset := d.length()
+		line := d.length()
+		lines = append(lines, code.LineEntry{Offset: offset, Line: line})
+	}
+	return lines
+}
+
+func (d *decoder) constant() object.Object {
+	tag, err := d.r.ReadByte()
+	if err != nil {
+		d.fail(err)
+		return nil
+	}
+	switch tag {
+	case tagInteger:
+		return &object.Integer{Value: d.int()}
+	case tagString:
+		return object.InternString(d.string())
+	case tagFunction:
+		fn := &object.CompiledFunction{}
+		fn.NumLocals = d.length()
+		fn.NumParameters = d.length()
+		fn.Name = d.string()
+		fn.Instructions = d.instructions()
+		fn.Lines = d.lines()
+		fn.Free = []string{}
+		for i, n := 0, d.length(); i < n && d.err == nil; i++ {
+			fn.Free = append(fn.Free, d.string())
+		}
+		return fn
+	case tagEnum:
+		name := d.string()
+		n := d.length()
+		names := []string{}
+		fields := [][]string{}
+		for i := 0; i < n && d.err == nil; i++ {
+			names = append(names, d.string())
+			variantFields := []string{}
+			for j, m := 0, d.length(); j < m && d.err == nil; j++ {
+				variantFields = append(variantFields, d.string())
+			}
+			fields = append(fields, variantFields)
+		}
+		return object.NewEnum(name, names, fields)
+	}
+	d.fail(fmt.Errorf("unknown constant tag %d", tag))
+	return nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,131 @@
+package bytecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"xlang/compiler"
+	"xlang/lexer"
+	"xlang/object"
+	"xlang/parser"
+	"xlang/vm"
+)
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	p := parser.New(lexer.New(input))
+	comp := compiler.New()
+	comp.SetOptions(compiler.Options{FoldConstants: true, Peephole: true})
+	if err := comp.Compile(p.ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`1 + 2 * -3`, "-5"},
+		{`"hello" + " " + "world"`, "hello world"},
+		{`let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)`, "610"},
+		{`let adder = fn(a) { fn(b) { a + b } }; adder(2)(3)`, "5"},
+		{`enum Shape { Circle(r), Rect(w, h), Empty }
+		match (Shape.Rect(2, 3)) { Shape.Rect(w, h) => w * h, _ => 0 }`, "6"},
+		{`let (a, b) = (1, [2, {"three": 3}]); b[1]["three"]`, "3"},
+	}
+
+	for _, tt := range tests {
+		original := compile(t, tt.input)
+		buf := &bytes.Buffer{}
+		if err := Encode(buf, original); err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+		decoded, err := Decode(buf)
+		if err != nil {
+			t.Fatalf("decode error: %s", err)
+		}
+		if !bytes.Equal(decoded.Instructions, original.Instructions) {
+			t.Fatalf("wrong instructions:\nwant=%s\ngot=%s", original.Instructions, decoded.Instructions)
+		}
+		if !reflect.DeepEqual(decoded.Lines, original.Lines) {
+			t.Fatalf("wrong lines: want=%v, got=%v", original.Lines, decoded.Lines)
+		}
+		if len(decoded.Constants) != len(original.Constants) {
+			t.Fatalf("wrong number of constants: want=%d, got=%d", len(original.Constants), len(decoded.Constants))
+		}
+		for i, constant := range original.Constants {
+			if fn, ok := constant.(*object.CompiledFunction); ok {
+				if !reflect.DeepEqual(decoded.Constants[i], fn) {
+					t.Fatalf("wrong function %d: want=%+v, got=%+v", i, fn, decoded.Constants[i])
+				}
+				continue
+			}
+			if decoded.Constants[i].Inspect() != constant.Inspect() {
+				t.Fatalf("wrong constant %d: want=%s, got=%s", i, constant.Inspect(), decoded.Constants[i].Inspect())
+			}
+		}
+
+		machine := vm.New(decoded)
+		if err := machine.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if result := machine.LastPoppedStackElem().Inspect(); result != tt.expected {
+			t.Fatalf("wrong result for %q: want=%s, got=%s", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := Encode(buf, compile(t, `let f = fn(x) { x * 2 }; f("a")`)); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	valid := buf.Bytes()
+
+	tests := []struct {
+		input    []byte
+		expected string
+	}{
+		{[]byte("let x = 1;"), ErrNotBytecode.Error()},
+		{[]byte{}, ErrNotBytecode.Error()},
+		{append([]byte(Magic), 0, 99), "unsupported bytecode version 99, expected 2"},
+		{valid[:len(valid)-3], "unexpected EOF"},
+		{append(append([]byte{}, valid...), 0), "unexpected data after the constants"},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.input))
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Fatalf("wrong error: want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestDecodeHugeLength(t *testing.T) {
+	// The length of the instructions is 1 GB but the input ends after it
+	input := append([]byte(Magic), byte(Version>>8), byte(Version))
+	length := make([]byte, binary.MaxVarintLen64)
+	input = append(input, length[:binary.PutUvarint(length, maxLength)]...)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := Decode(bytes.NewReader(input))
+	runtime.ReadMemStats(&after)
+	if err == nil || err.Error() != "unexpected EOF" {
+		t.Fatalf("wrong error: want=%q, got=%v", "unexpected EOF", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Fatalf("decoding %d bytes allocated %d bytes", len(input), allocated)
+	}
+}
+
+func TestEncodeUnsupportedConstant(t *testing.T) {
+	err := Encode(&bytes.Buffer{}, compile(t, `quote(1 + 2)`))
+	if err == nil || err.Error() != "constant 0: can't encode a constant of type QUOTE" {
+		t.Fatalf("wrong error: %v", err)
+	}
+}
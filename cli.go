@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"xlang/ast"
+	"xlang/bytecode"
+	"xlang/compiler"
+	"xlang/eval"
+	"xlang/ir"
+	"xlang/lexer"
+	"xlang/lint"
+	"xlang/object"
+	"xlang/parser"
+	"xlang/runtime"
+	"xlang/transpile"
+	"xlang/vm"
+)
+
+const usage = `usage:
+	xlang                                       starts the REPL
+	xlang compile <file.xlang> [-o file.xbc]    compiles a program to bytecode
+	xlang compile --ir <file.xlang> [-o ...]    compiles a program with the optimizations of the IR
+	xlang run <file.xbc>                        runs a compiled program
+	xlang disasm <file.xlang|file.xbc>          lists the bytecode of a program
+	xlang lint <file.xlang>                     lists the code that is probably wrong
+	xlang build --go <file.xlang> [-o file.go]  writes a program as the source of a Go program
+`
+
+// runCommand runs the command of the arguments and returns the exit code
+func runCommand(args []string, out io.Writer, errOut io.Writer) int {
+	var err error
+	switch {
+	case args[0] == "compile" && len(args) == 2:
+		err = compileFile(args[1], strings.TrimSuffix(args[1], filepath.Ext(args[1]))+bytecode.Extension, false, errOut)
+	case args[0] == "compile" && len(args) == 4 && args[2] == "-o":
+		err = compileFile(args[1], args[3], false, errOut)
+	case args[0] == "compile" && len(args) == 3 && args[1] == "--ir":
+		err = compileFile(args[2], strings.TrimSuffix(args[2], filepath.Ext(args[2]))+bytecode.Extension, true, errOut)
+	case args[0] == "compile" && len(args) == 5 && args[1] == "--ir" && args[3] == "-o":
+		err = compileFile(args[2], args[4], true, errOut)
+	case args[0] == "run" && len(args) == 2:
+		err = runFile(args[1], out)
+	case args[0] == "disasm" && len(args) == 2:
+		err = disassembleFile(args[1], out)
+	case args[0] == "lint" && len(args) == 2:
+		err = lintFile(args[1], out)
+	case args[0] == "build" && len(args) == 3 && args[1] == "--go":
+		err = buildGoFile(args[2], strings.TrimSuffix(args[2], filepath.Ext(args[2]))+".go")
+	case args[0] == "build" && len(args) == 5 && args[1] == "--go" && args[3] == "-o":
+		err = buildGoFile(args[2], args[4])
+	default:
+		io.WriteString(errOut, usage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(errOut, "%s\n", err)
+		if err, ok := err.(*vm.RuntimeError); ok {
+			io.WriteString(errOut, err.Trace.String())
+		}
+		return 1
+	}
+	return 0
+}
+
+// readSource reads the program in path without its comments, like runtime.Parse does
+func readSource(path string) (string, error) {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return runtime.StripComments(string(source)), nil
+}
+
+// parseSource parses the program in path and expands its macros, it returns the program and its source
+func parseSource(path string) (*ast.Program, string, error) {
+	source, err := readSource(path)
+	if err != nil {
+		return nil, "", err
+	}
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, "", fmt.Errorf("%s: parsing failed:\n\t%s", path, strings.Join(p.Errors(), "\n\t"))
+	}
+	program, err = eval.Expand(program, object.NewEnvironment())
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: expanding macros failed: %s", path, err)
+	}
+	return program, source, nil
+}
+
+// compileSource compiles the program in path, it returns its bytecode and its source.
+// withIR compiles it with ir.Compile instead of the compiler, the programs that use something that
+// the IR doesn't support are compiled by the compiler, with a warning in errOut
+func compileSource(path string, withIR bool, errOut io.Writer) (*compiler.Bytecode, string, error) {
+	program, source, err := parseSource(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if withIR {
+		if err := ir.Supported(program); err != nil {
+			diagnostic := err.(compiler.Diagnostics)[0]
+			fmt.Fprintf(errOut, "%s: line %d: %s, compiled without the IR\n", path, diagnostic.Line, diagnostic.Message)
+			withIR = false
+		}
+	}
+	if withIR {
+		compiled, err := ir.Compile(program)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: compilation failed:\n\t%s", path, strings.Replace(err.Error(), "\n", "\n\t", -1))
+		}
+		return compiled, source, nil
+	}
+	comp := compiler.New()
+	comp.SetOptions(compiler.Options{FoldConstants: true, Peephole: true})
+	if err := comp.Compile(program); err != nil {
+		return nil, "", fmt.Errorf("%s: compilation failed:\n\t%s", path, strings.Replace(err.Error(), "\n", "\n\t", -1))
+	}
+	return comp.Bytecode(), source, nil
+}
+
+// compileFile compiles the program in path and saves its bytecode in output
+func compileFile(path string, output string, withIR bool, errOut io.Writer) error {
+	program, _, err := compileSource(path, withIR, errOut)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := bytecode.Encode(file, program); err != nil {
+		file.Close()
+		os.Remove(output)
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return file.Close()
+}
+
+// buildGoFile writes the program in path as the source of a Go program in output
+func buildGoFile(path string, output string) error {
+	program, _, err := parseSource(path)
+	if err != nil {
+		return err
+	}
+	source, err := transpile.Transpile(program)
+	if err != nil {
+		return fmt.Errorf("%s: transpiling failed:\n\t%s", path, strings.Replace(err.Error(), "\n", "\n\t", -1))
+	}
+	return ioutil.WriteFile(output, source, 0644)
+}
+
+// runFile runs the bytecode saved in path and writes the last value to out
+func runFile(path string, out io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	program, err := bytecode.Decode(file)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	if err := vm.Verify(program); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	machine := vm.New(program)
+	if err := machine.Run(); err != nil {
+		return err
+	}
+	if last := machine.LastPoppedStackElem(); last != nil {
+		fmt.Fprintln(out, last.Inspect())
+	}
+	return nil
+}
+
+// disassembleFile writes the listing of the bytecode of a program or of a bytecode file to out,
+// the listing of a program has its source lines
+func disassembleFile(path string, out io.Writer) error {
+	if filepath.Ext(path) != bytecode.Extension {
+		program, source, err := compileSource(path, false, ioutil.Discard)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(out, compiler.Disassemble(program, source))
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	program, err := bytecode.Decode(file)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	_, err = io.WriteString(out, compiler.Disassemble(program, ""))
+	return err
+}
+
+// lintFile writes the warnings of the program in path to out, it fails when there is any
+func lintFile(path string, out io.Writer) error {
+	source, err := readSource(path)
+	if err != nil {
+		return err
+	}
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return fmt.Errorf("%s: parsing failed:\n\t%s", path, strings.Join(p.Errors(), "\n\t"))
+	}
+	warnings := lint.Check(program)
+	for _, warning := range warnings {
+		fmt.Fprintf(out, "%s: %s\n", path, warning)
+	}
+	if len(warnings) > 0 {
+		return fmt.Errorf("%s: %d warnings", path, len(warnings))
+	}
+	return nil
+}
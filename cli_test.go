@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExamples(t *testing.T) {
+	examples, err := filepath.Glob(filepath.Join("examples", "*.xlang"))
+	if err != nil || len(examples) == 0 {
+		t.Fatalf("no examples found: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "xlang-examples")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, example := range examples {
+		name := strings.TrimSuffix(filepath.Base(example), filepath.Ext(example))
+		compiled := filepath.Join(dir, name+".xbc")
+		commands := [][]string{
+			{"compile", "--ir", example, "-o", compiled},
+			{"run", compiled},
+			{"compile", example, "-o", compiled},
+			{"run", compiled},
+			{"disasm", example},
+			{"disasm", compiled},
+			{"build", "--go", example, "-o", filepath.Join(dir, name+".go")},
+		}
+		for _, args := range commands {
+			var out, errOut bytes.Buffer
+			if code := runCommand(args, &out, &errOut); code != 0 {
+				t.Errorf("xlang %s exited with %d:\n%s", strings.Join(args, " "), code, errOut.String())
+			}
+		}
+		// The examples can have warnings, but they must parse
+		var out, errOut bytes.Buffer
+		if code := runCommand([]string{"lint", example}, &out, &errOut); code != 0 && strings.Contains(errOut.String(), "parsing failed") {
+			t.Errorf("xlang lint %s failed:\n%s", example, errOut.String())
+		}
+	}
+}
+
+func TestCompileWithoutIR(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xlang-ir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "shape.xlang")
+	program := "enum Shape { Circle(r), Square(s) }\nmatch (Shape.Square(4)) { Shape.Circle(r) => 3 * r * r, Shape.Square(s) => s * s }"
+	if err := ioutil.WriteFile(source, []byte(program), 0644); err != nil {
+		t.Fatal(err)
+	}
+	compiled := filepath.Join(dir, "shape.xbc")
+
+	// The IR doesn't support enums, the program is compiled by the compiler
+	var out, errOut bytes.Buffer
+	if code := runCommand([]string{"compile", "--ir", source, "-o", compiled}, &out, &errOut); code != 0 {
+		t.Fatalf("xlang compile --ir exited with %d:\n%s", code, errOut.String())
+	}
+	expected := source + ": line 1: the IR doesn't support EnumStatement yet, compiled without the IR\n"
+	if errOut.String() != expected {
+		t.Fatalf("wrong warning.\nwant=%q\ngot= %q", expected, errOut.String())
+	}
+	out.Reset()
+	if code := runCommand([]string{"run", compiled}, &out, &errOut); code != 0 || out.String() != "16\n" {
+		t.Fatalf("wrong result of the program compiled without the IR: %d %q", code, out.String())
+	}
+}
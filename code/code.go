@@ -73,6 +73,30 @@ const (
 	OpGetFree
 	// OpCurrentClosure tells the VM to push to the stack the current closure
 	OpCurrentClosure
+	// OpSetFree pops a value and a closure from the stack and sets the free variable X of the closure to that value,
+	// it is used to tie functions that reference each other before being defined
+	OpSetFree
+	// OpDup pushes again the element on top of the stack
+	OpDup
+	// OpMatchVariant pops a value and pushes true if it's a variant with the tag stored in the constant X
+	OpMatchVariant
+	// OpGetVariantField pops a variant and pushes its field X
+	OpGetVariantField
+	// OpTuple tells the VM to add X elements from the stack into a tuple
+	OpTuple
+	// OpDestructure pops a tuple that must have X elements and pushes them in order
+	OpDestructure
+	// OpAddLocalConstant pushes the local X plus the constant Y, it's OpGetLocal X, OpConstant Y, OpAdd in one instruction
+	OpAddLocalConstant
+	// OpSubLocalConstant pushes the local X minus the constant Y, it's OpGetLocal X, OpConstant Y, OpSub in one instruction
+	OpSubLocalConstant
+	// OpWide is a prefix, the operands of the instruction that follows it are twice as wide.
+	// It's used when an operand doesn't fit, like the local 300 or the constant 70000
+	OpWide
+	// OpSetIndex pops a value, an index and an array or a hashmap and pushes a copy of it with the value at the index.
+	// X is 0 for container[index] = value, for the compound forms like += it's the opcode of the operation, OpAdd,
+	// and the value is container[index] + value
+	OpSetIndex
 )
 
 // Definition is the definition of a operand
@@ -115,9 +139,102 @@ var definitions = map[Opcode]*Definition{
 	OpGetBuiltin: {"OpGetBuiltin", []int{1}},
 	// OpClosure before it's emmited must have all the free variables loaded into the stack
 	// with OpConstants/OpGet...
-	OpClosure:        {"OpClosure", []int{2, 1}},
-	OpGetFree:        {"OpGetFree", []int{1}},
-	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpClosure:         {"OpClosure", []int{2, 1}},
+	OpGetFree:         {"OpGetFree", []int{1}},
+	OpCurrentClosure:  {"OpCurrentClosure", []int{}},
+	OpSetFree:         {"OpSetFree", []int{1}},
+	OpDup:             {"OpDup", []int{}},
+	OpMatchVariant:    {"OpMatchVariant", []int{2}},
+	OpGetVariantField: {"OpGetVariantField", []int{1}},
+	OpTuple:           {"OpTuple", []int{2}},
+	OpDestructure:     {"OpDestructure", []int{2}},
+	// Superinstructions, only emitted by Optimize
+	OpAddLocalConstant: {"OpAddLocalConstant", []int{1, 2}},
+	OpSubLocalConstant: {"OpSubLocalConstant", []int{1, 2}},
+	OpWide:             {"OpWide", []int{}},
+	OpSetIndex:         {"OpSetIndex", []int{1}},
+}
+
+// Wide returns the definition of the instruction after OpWide, with operands twice as wide
+func (def *Definition) Wide() *Definition {
+	widths := make([]int, len(def.OperandWidths))
+	for i, w := range def.OperandWidths {
+		widths[i] = w * 2
+	}
+	return &Definition{Name: def.Name, OperandWidths: widths}
+}
+
+// fits returns if the operands can be written with the widths
+func fits(widths []int, operands []int) bool {
+	for i, o := range operands {
+		if i >= len(widths) || o < 0 || uint64(o) > maxOperand(widths[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func maxOperand(width int) uint64 {
+	return 1<<(8*uint(width)) - 1
+}
+
+// Fits returns if the operands fit in the instruction without the OpWide prefix
+func Fits(op Opcode, operands ...int) bool {
+	def, ok := definitions[op]
+	return ok && fits(def.OperandWidths, operands)
+}
+
+// CheckOperands returns an error if an operand doesn't fit in the instruction, not even in its wide form
+func CheckOperands(op Opcode, operands ...int) error {
+	def, ok := definitions[op]
+	if !ok {
+		return fmt.Errorf("opcode %d undefined", op)
+	}
+	wide := def.Wide()
+	for i, o := range operands {
+		if i >= len(wide.OperandWidths) {
+			return fmt.Errorf("%s expects %d operands, got %d", def.Name, len(def.OperandWidths), len(operands))
+		}
+		if o < 0 || uint64(o) > maxOperand(wide.OperandWidths[i]) {
+			return fmt.Errorf("%s can't have the operand %d, the limit is %d", def.Name, o, maxOperand(wide.OperandWidths[i]))
+		}
+	}
+	return nil
+}
+
+// StackEffect returns how many values the instruction pops and pushes
+func StackEffect(op Opcode, operands []int) (int, int) {
+	switch op {
+	case OpConstant, OpTrue, OpFalse, OpNull, OpGetGlobal, OpGetLocal,
+		OpGetBuiltin, OpGetFree, OpCurrentClosure, OpAddLocalConstant, OpSubLocalConstant:
+		return 0, 1
+	case OpAdd, OpSub, OpMul, OpDiv, OpEqual, OpNotEqual, OpGreaterThan, OpIndex:
+		return 2, 1
+	case OpMinus, OpBang, OpMatchVariant, OpGetVariantField:
+		return 1, 1
+	case OpPop, OpJumpNotTruthy, OpSetGlobal, OpSetLocal, OpReturnValue:
+		return 1, 0
+	case OpSetFree:
+		return 2, 0
+	case OpSetIndex:
+		return 3, 1
+	case OpDup:
+		return 1, 2
+	case OpArray, OpHash, OpTuple:
+		return operands[0], 1
+	case OpCall:
+		return operands[0] + 1, 1
+	case OpClosure:
+		return operands[1], 1
+	case OpDestructure:
+		return 1, operands[0]
+	}
+	return 0, 0
+}
+
+// IsJump returns if the first operand of op is a position in the instructions
+func IsJump(op Opcode) bool {
+	return op == OpJump || op == OpJumpNotTruthy
 }
 
 // Lookup an operand in the definition table
@@ -131,11 +248,28 @@ func Lookup(op byte) (*Definition, error) {
 }
 
 // Make returns (in big endian encoding) the byte slice of an operation with its operand, basically a instruction.
+// When an operand doesn't fit the instruction is made in its wide form, see OpWide
 func Make(op Opcode, operands ...int) []byte {
 	def, ok := definitions[op]
 	if !ok {
 		return []byte{}
 	}
+	if !fits(def.OperandWidths, operands) {
+		return MakeWide(op, operands...)
+	}
+	return makeWithDefinition(op, def, operands)
+}
+
+// MakeWide returns the instruction with the OpWide prefix
+func MakeWide(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+	return append([]byte{byte(OpWide)}, makeWithDefinition(op, def.Wide(), operands)...)
+}
+
+func makeWithDefinition(op Opcode, def *Definition, operands []int) []byte {
 	instructionLen := 1
 	for _, w := range def.OperandWidths {
 		instructionLen += w
@@ -147,6 +281,8 @@ func Make(op Opcode, operands ...int) []byte {
 	for i, o := range operands {
 		width := def.OperandWidths[i]
 		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
 		// [...offset, byte, byte]
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
@@ -164,15 +300,30 @@ func (ins Instructions) String() string {
 
 	i := 0
 	for i < len(ins) {
+		if i > 0 {
+			out.WriteByte('\n')
+		}
 		def, err := Lookup(ins[i])
 		if err != nil {
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			fmt.Fprintf(&out, "%04d ERROR: %s", i, err)
+			i++
 			continue
 		}
 
+		start := i
+		prefix := ""
+		if Opcode(ins[i]) == OpWide && i+1 < len(ins) {
+			if def, err = Lookup(ins[i+1]); err != nil {
+				fmt.Fprintf(&out, "%04d ERROR: %s", i, err)
+				break
+			}
+			def = def.Wide()
+			prefix = "OpWide "
+			i++
+		}
 		operands, read := ReadOperands(def, ins[i+1:])
 
-		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		fmt.Fprintf(&out, "%04d %s%s", start, prefix, ins.fmtInstruction(def, operands))
 
 		i += 1 + read
 	}
@@ -262,12 +413,43 @@ func opToString(start, end, width int, ins Instructions) string {
 	return ""
 }
 
+// ReadInstruction decodes the instruction at the start of ins and returns its opcode, operands and size in bytes.
+// For an instruction with the OpWide prefix it returns the opcode that follows the prefix
+func ReadInstruction(ins Instructions) (Opcode, []int, int, error) {
+	wide := len(ins) > 1 && Opcode(ins[0]) == OpWide
+	if wide {
+		ins = ins[1:]
+	}
+	def, err := Lookup(ins[0])
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	if wide {
+		def = def.Wide()
+	}
+	width := 0
+	for _, w := range def.OperandWidths {
+		width += w
+	}
+	if len(ins)-1 < width {
+		return 0, nil, 0, fmt.Errorf("%s is truncated, it needs %d bytes of operands and has %d", def.Name, width, len(ins)-1)
+	}
+	operands, read := ReadOperands(def, ins[1:])
+	size := 1 + read
+	if wide {
+		size++
+	}
+	return Opcode(ins[0]), operands, size, nil
+}
+
 // ReadOperands reverts the code of an operation
 func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	operands := make([]int, 0, len(def.OperandWidths))
 	offset := 0
 	for _, w := range def.OperandWidths {
 		switch w {
+		case 4:
+			operands = append(operands, int(binary.BigEndian.Uint32(ins[offset:])))
 		case 2:
 			operands = append(operands, int(binary.BigEndian.Uint16(ins[offset:])))
 		case 1:
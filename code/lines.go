@@ -0,0 +1,45 @@
+package code
+
+import "sort"
+
+// LineEntry tells that the instructions from Offset until the next entry come from the source line Line
+type LineEntry struct {
+	Offset int
+	Line   int
+}
+
+// LineTable maps the offsets of the instructions to the lines of the source, the entries are sorted by offset
+type LineTable []LineEntry
+
+// Line returns the source line of the instruction at offset, 0 if it isn't known
+func (t LineTable) Line(offset int) int {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset > offset })
+	if i == 0 {
+		return 0
+	}
+	return t[i-1].Line
+}
+
+// Add returns the table with the instructions from offset coming from line.
+// The entries at or after offset are dropped, they belong to instructions that were removed
+func (t LineTable) Add(offset int, line int) LineTable {
+	for len(t) > 0 && t[len(t)-1].Offset >= offset {
+		t = t[:len(t)-1]
+	}
+	if len(t) > 0 && t[len(t)-1].Line == line {
+		return t
+	}
+	return append(t, LineEntry{Offset: offset, Line: line})
+}
+
+// Move returns a copy of the table with the offsets changed to newOffsets[offset],
+// the entries whose offset isn't in newOffsets are dropped
+func (t LineTable) Move(newOffsets map[int]int) LineTable {
+	moved := LineTable{}
+	for _, entry := range t {
+		if offset, ok := newOffsets[entry.Offset]; ok {
+			moved = moved.Add(offset, entry.Line)
+		}
+	}
+	return moved
+}
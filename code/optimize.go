@@ -0,0 +1,258 @@
+package code
+
+// instruction is a decoded instruction, offset is its position in the instructions being optimised
+type instruction struct {
+	op       Opcode
+	operands []int
+	offset   int
+	line     int
+	removed  bool
+}
+
+// Optimize returns a copy of ins where the redundant sequences are removed and the common ones are
+// fused into superinstructions: jumps to the next instruction, values pushed just to be popped and the code
+// that can't be reached after a jump or a return are removed, jumps to jumps go directly to the last target,
+// and OpGetLocal + OpConstant + OpAdd/OpSub become OpAddLocalConstant/OpSubLocalConstant.
+// The last OpPop of ins is kept, as it's the value that LastPoppedStackElem returns
+func Optimize(ins Instructions) Instructions {
+	optimized, _ := OptimizeWithLines(ins, nil)
+	return optimized
+}
+
+// OptimizeWithLines is Optimize for instructions with a line table, it returns the table of the optimised instructions
+func OptimizeWithLines(ins Instructions, lines LineTable) (Instructions, LineTable) {
+	for {
+		list := decode(ins, lines)
+		if len(list) == 0 {
+			return ins, lines
+		}
+		changed := threadJumps(list)
+		changed = removeUnreachable(list) || changed
+		changed = removeJumpsToNext(list, len(ins)) || changed
+		changed = removeUnusedPushes(list) || changed
+		changed = fuseInstructions(list) || changed
+		if !changed {
+			return ins, lines
+		}
+		ins, lines = encode(list, len(ins))
+	}
+}
+
+func decode(ins Instructions, lines LineTable) []*instruction {
+	list := []*instruction{}
+	for i := 0; i < len(ins); {
+		op, operands, size, err := ReadInstruction(ins[i:])
+		if err != nil {
+			// Leave alone the instructions that we don't understand
+			return nil
+		}
+		list = append(list, &instruction{op: op, operands: operands, offset: i, line: lines.Line(i)})
+		i += size
+	}
+	return list
+}
+
+// encode writes the instructions that weren't removed, moving the targets of the jumps to the new positions.
+// A jump to a removed instruction goes to the next one that is kept, end is the offset after the last instruction
+func encode(list []*instruction, end int) (Instructions, LineTable) {
+	newOffsets := make(map[int]int, len(list)+1)
+	size := 0
+	for _, ins := range list {
+		newOffsets[ins.offset] = size
+		if !ins.removed {
+			size += len(Make(ins.op, ins.operands...))
+		}
+	}
+	newOffsets[end] = size
+	// The removed instructions take the position of the next instruction kept
+	next := size
+	for i := len(list) - 1; i >= 0; i-- {
+		if list[i].removed {
+			newOffsets[list[i].offset] = next
+			continue
+		}
+		next = newOffsets[list[i].offset]
+	}
+
+	out := make(Instructions, 0, size)
+	lines := LineTable{}
+	for _, ins := range list {
+		if ins.removed {
+			continue
+		}
+		lines = lines.Add(len(out), ins.line)
+		if IsJump(ins.op) {
+			// The jump keeps its size, the offsets were computed with it
+			target := newOffsets[ins.operands[0]]
+			if len(Make(ins.op, ins.operands...)) != len(Make(ins.op, target)) {
+				out = append(out, MakeWide(ins.op, target)...)
+				continue
+			}
+			out = append(out, Make(ins.op, target)...)
+			continue
+		}
+		out = append(out, Make(ins.op, ins.operands...)...)
+	}
+	return out, lines
+}
+
+// pushesOnly are the instructions that only push a value, without any other effect
+var pushesOnly = map[Opcode]bool{
+	OpConstant:       true,
+	OpTrue:           true,
+	OpFalse:          true,
+	OpNull:           true,
+	OpGetLocal:       true,
+	OpGetGlobal:      true,
+	OpGetFree:        true,
+	OpGetBuiltin:     true,
+	OpCurrentClosure: true,
+	OpDup:            true,
+}
+
+func jumpTargets(list []*instruction) map[int]bool {
+	targets := map[int]bool{}
+	for _, ins := range list {
+		if !ins.removed && IsJump(ins.op) {
+			targets[ins.operands[0]] = true
+		}
+	}
+	return targets
+}
+
+// nextKept returns the index of the first instruction after i that wasn't removed, or len(list)
+func nextKept(list []*instruction, i int) int {
+	for i++; i < len(list) && list[i].removed; i++ {
+	}
+	return i
+}
+
+func threadJumps(list []*instruction) bool {
+	byOffset := make(map[int]*instruction, len(list))
+	for _, ins := range list {
+		byOffset[ins.offset] = ins
+	}
+	changed := false
+	for _, ins := range list {
+		if !IsJump(ins.op) {
+			continue
+		}
+		// The limit stops loops of jumps
+		for steps := 0; steps < len(list); steps++ {
+			target, ok := byOffset[ins.operands[0]]
+			if !ok || target.op != OpJump || target == ins || target.operands[0] == ins.operands[0] {
+				break
+			}
+			ins.operands = []int{target.operands[0]}
+			changed = true
+		}
+	}
+	return changed
+}
+
+func removeUnreachable(list []*instruction) bool {
+	targets := jumpTargets(list)
+	changed := false
+	for i := 0; i < len(list); i++ {
+		op := list[i].op
+		if list[i].removed || (op != OpJump && op != OpReturnValue && op != OpReturn) {
+			continue
+		}
+		for j := i + 1; j < len(list) && !targets[list[j].offset]; j++ {
+			if !list[j].removed {
+				list[j].removed = true
+				changed = true
+			}
+			i = j
+		}
+	}
+	return changed
+}
+
+func removeJumpsToNext(list []*instruction, end int) bool {
+	changed := false
+	for i, ins := range list {
+		if ins.removed || !IsJump(ins.op) {
+			continue
+		}
+		next := end
+		if n := nextKept(list, i); n < len(list) {
+			next = list[n].offset
+		}
+		if ins.operands[0] != next {
+			continue
+		}
+		if ins.op == OpJump {
+			ins.removed = true
+		} else {
+			// The condition still has to leave the stack
+			ins.op = OpPop
+			ins.operands = nil
+		}
+		changed = true
+	}
+	return changed
+}
+
+func removeUnusedPushes(list []*instruction) bool {
+	targets := jumpTargets(list)
+	last := len(list) - 1
+	for last >= 0 && list[last].removed {
+		last--
+	}
+	changed := false
+	for i, ins := range list {
+		if ins.removed || !pushesOnly[ins.op] {
+			continue
+		}
+		n := nextKept(list, i)
+		if n >= last || list[n].op != OpPop || targets[list[n].offset] {
+			continue
+		}
+		ins.removed = true
+		list[n].removed = true
+		changed = true
+	}
+	return changed
+}
+
+// superinstructions are the sequences of opcodes that are fused and the opcode that replaces them
+var superinstructions = []struct {
+	sequence []Opcode
+	fused    Opcode
+}{
+	{[]Opcode{OpGetLocal, OpConstant, OpAdd}, OpAddLocalConstant},
+	{[]Opcode{OpGetLocal, OpConstant, OpSub}, OpSubLocalConstant},
+}
+
+func fuseInstructions(list []*instruction) bool {
+	targets := jumpTargets(list)
+	changed := false
+	for i := range list {
+		for _, super := range superinstructions {
+			matched := []*instruction{}
+			for j := i; j < len(list) && len(matched) < len(super.sequence); j = nextKept(list, j) {
+				ins := list[j]
+				if ins.removed || ins.op != super.sequence[len(matched)] || (len(matched) > 0 && targets[ins.offset]) {
+					break
+				}
+				matched = append(matched, ins)
+			}
+			if len(matched) != len(super.sequence) {
+				continue
+			}
+			operands := []int{}
+			for _, ins := range matched {
+				operands = append(operands, ins.operands...)
+				ins.removed = true
+			}
+			// The fused instruction keeps the position of the first one, so the jumps to it are still valid
+			matched[0].removed = false
+			matched[0].op = super.fused
+			matched[0].operands = operands
+			changed = true
+			break
+		}
+	}
+	return changed
+}
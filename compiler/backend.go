@@ -0,0 +1,63 @@
+package compiler
+
+import (
+	"fmt"
+	"xlang/ast"
+	"xlang/object"
+)
+
+// The other backends, the register VM, the ir package and the Go transpiler, compile the same ast
+// with the same symbol table, these are the parts of the compiler that they share
+
+// Reporter records the errors of a backend and the source line of the node that it compiles
+type Reporter struct {
+	Diagnostics Diagnostics
+	// Line is the source line of the node being compiled, the errors of the nodes without a line get it
+	Line int
+}
+
+// Errorf records an error at the line of node and lets the compilation go on
+func (r *Reporter) Errorf(node ast.Node, format string, a ...interface{}) {
+	line := r.Line
+	if node.Line() != 0 {
+		line = int(node.Line())
+	}
+	r.Diagnostics = append(r.Diagnostics, Diagnostic{
+		Severity: SeverityError,
+		Line:     line,
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+// SetLine makes the line of node the current one, it returns the function that restores the previous line
+func (r *Reporter) SetLine(node ast.Node) func() {
+	previous := r.Line
+	if node.Line() != 0 {
+		r.Line = int(node.Line())
+	}
+	return func() { r.Line = previous }
+}
+
+// Constants are the constants of a program, the same integer or string is only added once, like in the compiler
+type Constants struct {
+	Objects []object.Object
+	indexes map[constantKey]int
+}
+
+// Add returns the index of obj in the constants, it's added when it isn't there
+func (c *Constants) Add(obj object.Object) int {
+	key, ok := constantKeyOf(obj)
+	if ok {
+		if index, found := c.indexes[key]; found {
+			return index
+		}
+	}
+	c.Objects = append(c.Objects, obj)
+	if ok {
+		if c.indexes == nil {
+			c.indexes = map[constantKey]int{}
+		}
+		c.indexes[key] = len(c.Objects) - 1
+	}
+	return len(c.Objects) - 1
+}
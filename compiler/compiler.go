@@ -2,7 +2,8 @@ package compiler
 
 import (
 	"fmt"
-	"sort"
+	"strconv"
+	"strings"
 	"xlang/ast"
 	"xlang/code"
 	"xlang/object"
@@ -20,17 +21,66 @@ type CompilationScope struct {
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
 	name                string
+	// uninitialized are the local indexes of hoisted functions that haven't been set yet
+	uninitialized map[int]bool
+	// fixups are free variables of closures that were captured before being initialized
+	fixups []freeFixup
+	// jumps are the positions of the jumps emitted with emitJump, they are kept here
+	// because the instructions move when the jumps are widened
+	jumps []int
+	// wideJumps is set when a jump target didn't fit in 2 bytes, from then every jump of the scope is wide
+	wideJumps bool
+	// lines maps the instructions to the source lines that produced them
+	lines code.LineTable
+}
+
+// freeFixup tells that the free variable at index free of the closure stored in closure
+// has to be set to target once target is initialized
+type freeFixup struct {
+	closure Symbol
+	free    int
+	target  Symbol
+}
+
+// Options are the optimisations that the compiler can do, all of them are off by default
+type Options struct {
+	// FoldConstants computes at compile time the expressions that only use literals
+	// and compiles only the branch that runs of an if with a literal condition
+	FoldConstants bool
+	// Peephole removes redundant and unreachable instructions and fuses common sequences
+	// into superinstructions, see code.Optimize
+	Peephole bool
 }
 
 // Compiler contains the instructions and constants
 type Compiler struct {
 	constants []object.Object
+	// constantIndexes are the positions in constants of the values that are shared
+	constantIndexes map[constantKey]int
+	options         Options
 
 	symbolTable *SymbolTable
 	scopes      []CompilationScope
 	scopeIndex  int
+
+	// hoisted are the symbols that were declared before compiling the let statement
+	hoisted map[*ast.LetStatement]Symbol
+	// pending are the names of the lets that weren't hoisted and haven't been compiled yet,
+	// a reference to them is used before its definition
+	pending map[string]int
+	// letFunction is the function literal that is the value of the let being compiled
+	letFunction *ast.FunctionLiteral
+	// lastFreeSymbols are the free symbols of the last compiled function literal of a let
+	lastFreeSymbols []Symbol
+	// diagnostics are the problems found by the current call to Compile
+	diagnostics Diagnostics
+	// line is the source line of the node being compiled, the emitted instructions are mapped to it
+	line uint64
 }
 
+// maxGlobals is the number of globals that the VM has, vm.GlobalsSize
+const maxGlobals = 65536
+
 // New returns a new compiler
 func New() *Compiler {
 	mainScope := CompilationScope{
@@ -43,10 +93,13 @@ func New() *Compiler {
 		table.DefineBuiltin(i, fn.Name)
 	}
 	return &Compiler{
-		constants:   []object.Object{},
-		scopes:      []CompilationScope{mainScope},
-		scopeIndex:  0,
-		symbolTable: table,
+		constants:       []object.Object{},
+		constantIndexes: map[constantKey]int{},
+		scopes:          []CompilationScope{mainScope},
+		scopeIndex:      0,
+		symbolTable:     table,
+		hoisted:         map[*ast.LetStatement]Symbol{},
+		pending:         map[string]int{},
 	}
 }
 
@@ -55,9 +108,19 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 	compiler := New()
 	compiler.symbolTable = s
 	compiler.constants = constants
+	for i, constant := range constants {
+		if key, ok := constantKeyOf(constant); ok {
+			compiler.constantIndexes[key] = i
+		}
+	}
 	return compiler
 }
 
+// SetOptions changes the optimisations that the compiler does
+func (c *Compiler) SetOptions(options Options) {
+	c.options = options
+}
+
 func (c *Compiler) enterScope(name string) {
 	scope := CompilationScope{
 		instructions:        code.Instructions{},
@@ -102,10 +165,72 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 	}
 }
 
-func (c *Compiler) changeOperand(opPos, operand int) {
-	op := code.Opcode(c.currentInstructions()[opPos])
-	newInstruction := code.Make(op, operand)
-	c.replaceInstruction(opPos, newInstruction)
+// emitJump emits a jump without target and returns a handle to set it with patchJump
+func (c *Compiler) emitJump(op code.Opcode) int {
+	scope := c.currentScope()
+	var pos int
+	if scope.wideJumps {
+		pos = c.addInstruction(code.MakeWide(op, 9999))
+		c.setLastInstruction(op, pos)
+	} else {
+		pos = c.emit(op, 9999)
+	}
+	scope.jumps = append(scope.jumps, pos)
+	return len(scope.jumps) - 1
+}
+
+// patchJump sets the target of a jump emitted with emitJump
+func (c *Compiler) patchJump(jump int, target int) {
+	scope := c.currentScope()
+	if !scope.wideJumps && !code.Fits(code.OpJump, target) {
+		target = c.widenJumps()[target]
+	}
+	pos := scope.jumps[jump]
+	op, _, _, _ := code.ReadInstruction(scope.instructions[pos:])
+	if scope.wideJumps {
+		c.replaceInstruction(pos, code.MakeWide(op, target))
+		return
+	}
+	c.replaceInstruction(pos, code.Make(op, target))
+}
+
+// widenJumps rewrites the jumps of the current scope in their wide form, so any target fits in them.
+// It returns the new positions of the old ones
+func (c *Compiler) widenJumps() map[int]int {
+	scope := c.currentScope()
+	old := scope.instructions
+	newPositions := map[int]int{}
+	widened := code.Instructions{}
+	for i := 0; i < len(old); {
+		op, operands, size, _ := code.ReadInstruction(old[i:])
+		newPositions[i] = len(widened)
+		if code.IsJump(op) {
+			widened = append(widened, code.MakeWide(op, operands...)...)
+		} else {
+			widened = append(widened, old[i:i+size]...)
+		}
+		i += size
+	}
+	newPositions[len(old)] = len(widened)
+
+	for i := 0; i < len(widened); {
+		op, operands, size, _ := code.ReadInstruction(widened[i:])
+		if code.IsJump(op) {
+			if target, ok := newPositions[operands[0]]; ok {
+				copy(widened[i:], code.MakeWide(op, target))
+			}
+		}
+		i += size
+	}
+	for i, pos := range scope.jumps {
+		scope.jumps[i] = newPositions[pos]
+	}
+	scope.lines = scope.lines.Move(newPositions)
+	scope.lastInstruction.Position = newPositions[scope.lastInstruction.Position]
+	scope.previousInstruction.Position = newPositions[scope.previousInstruction.Position]
+	scope.instructions = widened
+	scope.wideJumps = true
+	return newPositions
 }
 
 func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
@@ -116,58 +241,102 @@ func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
 	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
 }
 
-// Compile saves in the compiler the instructions that the ast node produces
+// Compile saves in the compiler the instructions that the ast node produces.
+// It doesn't stop at the first problem, when it finds errors it returns all of them as Diagnostics
+// and the bytecode must not be run. Diagnostics returns the problems of the last call, warnings included
 func (c *Compiler) Compile(node ast.Node) error {
+	c.diagnostics = nil
+	// A program that failed in the REPL can leave lets that were never compiled
+	c.hoisted = map[*ast.LetStatement]Symbol{}
+	c.pending = map[string]int{}
+	if err := c.compile(node); err != nil {
+		return err
+	}
+	if c.diagnostics.HasErrors() {
+		return c.diagnostics
+	}
+	return nil
+}
+
+// Diagnostics returns the problems found by the last call to Compile
+func (c *Compiler) Diagnostics() Diagnostics {
+	return c.diagnostics
+}
+
+// errorf records an error at the position of node and lets the compilation go on
+func (c *Compiler) errorf(node ast.Node, format string, a ...interface{}) {
+	line := c.line
+	if node != nil && node.Line() != 0 {
+		line = node.Line()
+	}
+	c.diagnostics = append(c.diagnostics, Diagnostic{
+		Severity: SeverityError,
+		Line:     int(line),
+		Column:   column(node),
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+func (c *Compiler) compile(node ast.Node) error {
+	if node != nil && node.Line() != 0 && node.Line() != c.line {
+		defer func(line uint64) { c.line = line }(c.line)
+		c.line = node.Line()
+	}
 	switch node := node.(type) {
 	case *ast.IndexExpression:
 		{
-			if err := c.Compile(node.Left); err != nil {
+			if err := c.compile(node.Left); err != nil {
 				return err
 			}
-			if err := c.Compile(node.Right); err != nil {
+			if err := c.compile(node.Right); err != nil {
 				return err
 			}
 			c.emit(code.OpIndex)
 		}
 	case *ast.HashLiteral:
 		{
-			keys := make([]ast.Expression, 0, len(node.Pairs))
-			for k := range node.Pairs {
-				keys = append(keys, k)
-			}
-			// Make a consistent order in keys
-			sort.Slice(keys, func(i, j int) bool {
-				return keys[i].String() < keys[j].String()
-			})
-			for _, key := range keys {
+			// The keys are compiled in the order of the source, it's the order of the pairs of the hash
+			for _, key := range node.Keys {
 				value := node.Pairs[key]
-				err := c.Compile(key)
+				err := c.compile(key)
 				if err != nil {
 					return err
 				}
-				errVal := c.Compile(value)
+				errVal := c.compile(value)
 				if errVal != nil {
 					return errVal
 				}
 			}
-			c.emit(code.OpHash, len(node.Pairs)*2)
+			c.emit(code.OpHash, len(node.Keys)*2)
 		}
 	case *ast.ArrayLiteral:
 		{
 			for _, exp := range node.Elements {
-				if err := c.Compile(exp); err != nil {
+				if err := c.compile(exp); err != nil {
 					return err
 				}
 			}
 			c.emit(code.OpArray, len(node.Elements))
 		}
+	case *ast.TupleLiteral:
+		{
+			for _, exp := range node.Elements {
+				if err := c.compile(exp); err != nil {
+					return err
+				}
+			}
+			c.emit(code.OpTuple, len(node.Elements))
+		}
 	case *ast.CallExpression:
 		{
-			if err := c.Compile(node.Function); err != nil {
+			if c.isQuote(node) {
+				return c.compileQuote(node)
+			}
+			if err := c.compile(node.Function); err != nil {
 				return err
 			}
 			for _, a := range node.Arguments {
-				if err := c.Compile(a); err != nil {
+				if err := c.compile(a); err != nil {
 					return err
 				}
 			}
@@ -176,77 +345,171 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.StringLiteral:
 		{
 			str := node.Value
-			pos := c.addConstant(&object.String{Value: str})
+			pos := c.addConstant(object.InternString(str))
 			c.emit(code.OpConstant, pos)
 		}
 	case *ast.Identifier:
 		{
-			symbol, ok := c.symbolTable.Resolve(node.Value)
-			if node.Value == c.currentScope().name && symbol.Scope == FreeScope {
+			if c.isCurrentClosure(node.Value) {
 				c.emit(code.OpCurrentClosure)
 				return nil
 			}
+			symbol, ok := c.symbolTable.Resolve(node.Value)
+			if !ok && c.pending[node.Value] > 0 {
+				c.errorf(node, "%s used before definition", node.Value)
+				c.emit(code.OpNull)
+				return nil
+			}
 			if !ok {
-				return fmt.Errorf("undefined variable=%s que", node.Value)
+				c.errorf(node, "undefined variable %s", node.Value)
+				// The value takes its place, so the rest of the program compiles as usual
+				c.emit(code.OpNull)
+				return nil
 			}
 			c.emit(c.getCodeScope(&symbol), symbol.Index)
 		}
 	case *ast.LetStatement:
 		{
-			symbol := c.symbolTable.Define(node.Name.Value)
-			if err := c.Compile(node.Value); err != nil {
+			symbol, ok := c.hoisted[node]
+			if !ok {
+				symbol = c.symbolTable.declare(node.Name.Value)
+			}
+			delete(c.hoisted, node)
+			wasPending := ok && c.symbolTable.store[symbol.Name] != symbol
+			// A function can call itself, the other values see the previous definition of the name, like let a = push(a, 1)
+			function, isFunction := node.Value.(*ast.FunctionLiteral)
+			if isFunction {
+				c.symbolTable.bind(symbol)
+				c.letFunction = function
+				c.markUninitialized(symbol)
+			}
+			c.lastFreeSymbols = nil
+			if err := c.compile(node.Value); err != nil {
 				return err
 			}
+			if !isFunction {
+				c.symbolTable.bind(symbol)
+			}
+			if wasPending {
+				if c.pending[symbol.Name]--; c.pending[symbol.Name] == 0 {
+					delete(c.pending, symbol.Name)
+				}
+			}
+			if isFunction && symbol.Scope == LocalScope {
+				c.addFixups(symbol, c.lastFreeSymbols)
+			}
 
 			c.emit(c.setCodeScope(&symbol), symbol.Index)
+			if symbol.Scope == LocalScope {
+				c.initialize(symbol)
+			}
 		}
-	case *ast.IfExpression:
+	case *ast.DestructureStatement:
 		{
-			err := c.Compile(node.Condition)
-			if err != nil {
+			if err := c.compile(node.Value); err != nil {
 				return err
 			}
-			pos := c.emit(code.OpJumpNotTruthy, 9999)
-			err = c.Compile(node.Consequence)
+			c.emit(code.OpDestructure, len(node.Names))
+			symbols := make([]Symbol, len(node.Names))
+			for i, name := range node.Names {
+				symbols[i] = c.symbolTable.Define(name.Value)
+			}
+			// The last element is on top of the stack
+			for i := len(symbols) - 1; i >= 0; i-- {
+				c.emit(c.setCodeScope(&symbols[i]), symbols[i].Index)
+			}
+		}
+	case *ast.IndexAssignStatement:
+		{
+			return c.compileIndexAssign(node)
+		}
+	case *ast.IfExpression:
+		{
+			if truthy, ok := constantTruthiness(node.Condition); ok && c.options.FoldConstants {
+				return c.compileConstantIf(node, truthy)
+			}
+			err := c.compile(node.Condition)
 			if err != nil {
 				return err
 			}
-			scope := c.currentScope()
-			if scope.lastInstruction.Opcode == code.OpPop {
-				last := c.scopes[c.scopeIndex].lastInstruction
-				previous := c.scopes[c.scopeIndex].previousInstruction
-
-				old := c.currentInstructions()
-				new := old[:last.Position]
-
-				c.scopes[c.scopeIndex].instructions = new
-				c.scopes[c.scopeIndex].lastInstruction = previous
+			jumpNotTruthy := c.emitJump(code.OpJumpNotTruthy)
+			if err := c.compileBranch(node.Consequence); err != nil {
+				return err
 			}
-			posOfJump := c.emit(code.OpJump, 9999)
-			c.changeOperand(pos, len(scope.instructions))
+			jump := c.emitJump(code.OpJump)
+			c.patchJump(jumpNotTruthy, len(c.currentInstructions()))
 			if node.Alternative != nil {
-				c.Compile(node.Alternative)
-				if scope.lastInstruction.Opcode == code.OpPop {
-					last := c.scopes[c.scopeIndex].lastInstruction
-					previous := c.scopes[c.scopeIndex].previousInstruction
-
-					old := c.currentInstructions()
-					new := old[:last.Position]
-
-					c.scopes[c.scopeIndex].instructions = new
-					c.scopes[c.scopeIndex].lastInstruction = previous
+				if err := c.compileBranch(node.Alternative); err != nil {
+					return err
 				}
-				c.changeOperand(posOfJump, len(scope.instructions))
+				c.patchJump(jump, len(c.currentInstructions()))
 				return nil
 			}
 			// If there is no alternative, "fake" it
 			c.emit(code.OpNull)
-			c.changeOperand(posOfJump, len(scope.instructions))
+			c.patchJump(jump, len(c.currentInstructions()))
+		}
+	case *ast.MacroLiteral:
+		{
+			c.errorf(node, "macros can only be defined with let at the top level of the program")
+			c.emit(code.OpNull)
+		}
+	case *ast.EnumStatement:
+		{
+			enum := object.EnumFromStatement(node)
+			symbol := c.symbolTable.Define(node.Name.Value)
+			c.symbolTable.defineEnum(enum)
+			c.emit(code.OpConstant, c.addConstant(enum))
+			c.emit(c.setCodeScope(&symbol), symbol.Index)
+		}
+	case *ast.MatchExpression:
+		{
+			c.checkMatch(node)
+			if err := c.compile(node.Subject); err != nil {
+				return err
+			}
+			// The subject stays on the stack until an arm matches
+			endJumps := []int{}
+			hasWildcard := false
+			for _, arm := range node.Arms {
+				if arm.Pattern == nil {
+					c.emit(code.OpPop)
+					if err := c.compileBranch(arm.Body); err != nil {
+						return err
+					}
+					hasWildcard = true
+					break
+				}
+				c.emit(code.OpDup)
+				c.emit(code.OpMatchVariant, c.addConstant(object.InternString(arm.Pattern.Tag())))
+				nextArm := c.emitJump(code.OpJumpNotTruthy)
+				for i, binding := range arm.Pattern.Bindings {
+					symbol := c.symbolTable.Define(binding.Value)
+					c.emit(code.OpDup)
+					c.emit(code.OpGetVariantField, i)
+					c.emit(c.setCodeScope(&symbol), symbol.Index)
+				}
+				c.emit(code.OpPop)
+				if err := c.compileBranch(arm.Body); err != nil {
+					return err
+				}
+				endJumps = append(endJumps, c.emitJump(code.OpJump))
+				c.patchJump(nextArm, len(c.currentInstructions()))
+			}
+			if !hasWildcard {
+				// Nothing matched, same as an if without else
+				c.emit(code.OpPop)
+				c.emit(code.OpNull)
+			}
+			for _, jump := range endJumps {
+				c.patchJump(jump, len(c.currentInstructions()))
+			}
 		}
 	case *ast.BlockStatement:
 		{
+			c.hoist(node.Statements)
 			for _, s := range node.Statements {
-				if err := c.Compile(s); err != nil {
+				if err := c.compile(s); err != nil {
 					return err
 				}
 			}
@@ -254,8 +517,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 	case *ast.Program:
 		{
+			if c.options.FoldConstants {
+				node = Fold(node).(*ast.Program)
+			}
+			c.hoist(node.Statements)
 			for _, s := range node.Statements {
-				err := c.Compile(s)
+				err := c.compile(s)
 				if err != nil {
 					return err
 				}
@@ -264,7 +531,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 	case *ast.PrefixExpression:
 		{
-			if err := c.Compile(node.Right); err != nil {
+			if err := c.compile(node.Right); err != nil {
 				return err
 			}
 			switch node.Operator {
@@ -273,12 +540,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 			case "-":
 				c.emit(code.OpMinus)
 			default:
-				return fmt.Errorf("unknown prefix operator: %s", node.Operator)
+				c.errorf(node, "unknown prefix operator: %s", node.Operator)
 			}
 		}
 	case *ast.ExpressionStatement:
 		{
-			err := c.Compile(node.Expression)
+			err := c.compile(node.Expression)
 			if err != nil {
 				return err
 			}
@@ -301,11 +568,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 				nodeToUseForLeft = node.Right
 				nodeToUseForRight = node.Left
 			}
-			err := c.Compile(nodeToUseForLeft)
+			err := c.compile(nodeToUseForLeft)
 			if err != nil {
 				return err
 			}
-			err = c.Compile(nodeToUseForRight)
+			err = c.compile(nodeToUseForRight)
 			if err != nil {
 				return err
 			}
@@ -329,7 +596,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 				c.emit(code.OpNotEqual)
 
 			default:
-				return fmt.Errorf("unknown operator %s", node.Operator)
+				c.errorf(node, "unknown operator %s", node.Operator)
 			}
 		}
 	case *ast.IntegerLiteral:
@@ -342,7 +609,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 	case *ast.ReturnStatement:
 		{
-			if err := c.Compile(node.ReturnValue); err != nil {
+			if err := c.compile(node.ReturnValue); err != nil {
 				return err
 			}
 
@@ -359,7 +626,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 			for _, p := range node.Parameters {
 				c.symbolTable.Define(p.Value)
 			}
-			if err := c.Compile(node.Body); err != nil {
+			isLetValue := node == c.letFunction
+			if err := c.compile(node.Body); err != nil {
 				return err
 			}
 			if c.lastInstructionIs(code.OpPop) {
@@ -371,32 +639,330 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 			numLocals := c.symbolTable.numDefinitions
 			freeSymbols := c.symbolTable.FreeSymbols
+			lines := c.currentScope().lines
 			ins := c.leaveScope()
+			if c.options.Peephole {
+				ins, lines = code.OptimizeWithLines(ins, lines)
+			}
 			for _, s := range freeSymbols {
 				c.emit(c.getCodeScope(&s), s.Index)
 			}
-			compiledFn := &object.CompiledFunction{Instructions: ins, NumLocals: numLocals, NumParameters: len(node.Parameters)}
+			freeNames := make([]string, len(freeSymbols))
+			for i, s := range freeSymbols {
+				freeNames[i] = s.Name
+			}
+			compiledFn := &object.CompiledFunction{
+				Instructions:  ins,
+				NumLocals:     numLocals,
+				NumParameters: len(node.Parameters),
+				Name:          node.Name,
+				Lines:         lines,
+				Free:          freeNames,
+			}
 			c.emit(code.OpClosure, c.addConstant(compiledFn), len(freeSymbols))
+			if isLetValue {
+				c.lastFreeSymbols = freeSymbols
+			} else {
+				c.keepForFixups(freeSymbols)
+			}
 		}
 	}
 	return nil
 }
 
+// isQuote returns if the call is quote(...), macros are expanded before compiling
+// so a quote can only be code that the program uses as a value
+func (c *Compiler) isQuote(call *ast.CallExpression) bool {
+	ident, ok := call.Function.(*ast.Identifier)
+	if !ok || ident.Value != "quote" {
+		return false
+	}
+	_, defined := c.symbolTable.Resolve(ident.Value)
+	return !defined
+}
+
+func (c *Compiler) compileQuote(call *ast.CallExpression) error {
+	if len(call.Arguments) != 1 {
+		c.errorf(call, "expected 1 argument on quote() but got %d", len(call.Arguments))
+		c.emit(code.OpNull)
+		return nil
+	}
+	valid := true
+	ast.Modify(call.Arguments[0], func(node ast.Node) ast.Node {
+		if call, ok := node.(*ast.CallExpression); ok && call.Function.String() == "unquote" {
+			c.errorf(call, "unquote can only be used inside macros")
+			valid = false
+		}
+		return node
+	})
+	if !valid {
+		c.emit(code.OpNull)
+		return nil
+	}
+	c.emit(code.OpConstant, c.addConstant(&object.Quote{Node: call.Arguments[0]}))
+	return nil
+}
+
+// compileBranch compiles a block that must leave its value on the stack, like the arm of a match
+func (c *Compiler) compileBranch(block *ast.BlockStatement) error {
+	start := len(c.currentInstructions())
+	if err := c.compile(block); err != nil {
+		return err
+	}
+	if len(c.currentInstructions()) == start {
+		c.emit(code.OpNull)
+		return nil
+	}
+	if c.lastInstructionIs(code.OpPop) {
+		last := c.currentScope().lastInstruction
+		c.currentScope().instructions = c.currentInstructions()[:last.Position]
+		c.currentScope().lastInstruction = c.currentScope().previousInstruction
+		return nil
+	}
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// compileConstantIf compiles only the branch of the if that runs
+func (c *Compiler) compileConstantIf(node *ast.IfExpression, truthy bool) error {
+	if truthy {
+		return c.compileBranch(node.Consequence)
+	}
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+		return nil
+	}
+	return c.compileBranch(node.Alternative)
+}
+
+// checkMatch checks that the patterns of a match are variants of the same enum
+// and that every variant is handled when there is no wildcard, the problems are recorded as diagnostics
+func (c *Compiler) checkMatch(node *ast.MatchExpression) {
+	enumName := ""
+	hasWildcard := false
+	for _, arm := range node.Arms {
+		if arm.Pattern == nil {
+			hasWildcard = true
+			continue
+		}
+		if enumName == "" {
+			enumName = arm.Pattern.Enum.Value
+		} else if enumName != arm.Pattern.Enum.Value {
+			c.errorf(arm.Pattern.Enum, "match mixes variants of %s and %s", enumName, arm.Pattern.Enum.Value)
+			return
+		}
+	}
+	if enumName == "" {
+		return
+	}
+	enum, ok := c.symbolTable.resolveEnum(enumName)
+	if !ok {
+		c.errorf(node, "undefined enum=%s in match", enumName)
+		return
+	}
+	covered := map[string]bool{}
+	for _, arm := range node.Arms {
+		if arm.Pattern == nil {
+			continue
+		}
+		def, ok := enum.Variant(arm.Pattern.Variant.Value)
+		if !ok {
+			c.errorf(arm.Pattern.Variant, "enum %s doesn't have a variant %s", enum.Name, arm.Pattern.Variant.Value)
+			continue
+		}
+		if len(def.Fields) != len(arm.Pattern.Bindings) {
+			c.errorf(arm.Pattern.Variant, "%s has %d fields, got %d in the pattern", def.Tag(), len(def.Fields), len(arm.Pattern.Bindings))
+		}
+		covered[def.Name] = true
+	}
+	if hasWildcard {
+		return
+	}
+	missing := []string{}
+	for _, def := range enum.Variants {
+		if !covered[def.Name] {
+			missing = append(missing, def.Tag())
+		}
+	}
+	if len(missing) > 0 {
+		c.errorf(node, "match on %s is not exhaustive, missing: %s", enum.Name, strings.Join(missing, ", "))
+	}
+}
+
+// hoist declares the lets of these statements, every let gets its slot in order so the indexes are the same
+// as without hoisting. Only the functions returned by Hoisted are bound before their let, so they can be
+// referenced from the functions defined before them, the other names are pending until their let
+func (c *Compiler) hoist(statements []ast.Statement) {
+	for _, name := range Redefined(statements) {
+		c.errorf(name, RedefinedFormat, name.Value)
+	}
+	hoisted := Hoisted(statements)
+	for _, s := range statements {
+		let, ok := s.(*ast.LetStatement)
+		if !ok {
+			continue
+		}
+		symbol := c.symbolTable.declare(let.Name.Value)
+		c.hoisted[let] = symbol
+		if !hoisted[let] {
+			c.pending[symbol.Name]++
+			continue
+		}
+		c.symbolTable.bind(symbol)
+		c.markUninitialized(symbol)
+	}
+}
+
+// markUninitialized remembers that a local function is bound but not set yet, the closures that capture it
+// before its let ends are fixed by initialize
+func (c *Compiler) markUninitialized(symbol Symbol) {
+	if symbol.Scope != LocalScope {
+		return
+	}
+	scope := c.currentScope()
+	if scope.uninitialized == nil {
+		scope.uninitialized = map[int]bool{}
+	}
+	scope.uninitialized[symbol.Index] = true
+}
+
+// addFixups remembers the free variables of closure that were captured before being initialized
+func (c *Compiler) addFixups(closure Symbol, free []Symbol) {
+	scope := c.currentScope()
+	for i, s := range free {
+		if s.Scope == LocalScope && scope.uninitialized[s.Index] {
+			scope.fixups = append(scope.fixups, freeFixup{closure: closure, free: i, target: s})
+		}
+	}
+}
+
+// keepForFixups keeps the closure on top of the stack in a hidden local when it captured hoisted functions
+// that aren't initialized yet, so initialize can set its free variables
+func (c *Compiler) keepForFixups(free []Symbol) {
+	scope := c.currentScope()
+	for _, s := range free {
+		if s.Scope == LocalScope && scope.uninitialized[s.Index] {
+			// The name can't be referenced, the hidden local is never bound
+			closure := c.symbolTable.declare("")
+			c.emit(code.OpSetLocal, closure.Index)
+			c.emit(code.OpGetLocal, closure.Index)
+			c.addFixups(closure, free)
+			return
+		}
+	}
+}
+
+// initialize marks a local as set and patches the closures that captured it before
+func (c *Compiler) initialize(symbol Symbol) {
+	scope := c.currentScope()
+	if !scope.uninitialized[symbol.Index] {
+		return
+	}
+	delete(scope.uninitialized, symbol.Index)
+	pending := scope.fixups[:0]
+	for _, fixup := range scope.fixups {
+		if fixup.target != symbol {
+			pending = append(pending, fixup)
+			continue
+		}
+		c.emit(code.OpGetLocal, fixup.closure.Index)
+		c.emit(code.OpGetLocal, symbol.Index)
+		c.emit(code.OpSetFree, fixup.free)
+	}
+	c.currentScope().fixups = pending
+}
+
+// isCurrentClosure returns if name references the function that is being compiled
+func (c *Compiler) isCurrentClosure(name string) bool {
+	if c.currentScope().name != name || c.symbolTable.Outer == nil {
+		return false
+	}
+	if symbol, ok := c.symbolTable.store[name]; ok && symbol.Scope != FreeScope {
+		return false
+	}
+	_, ok := c.symbolTable.Outer.store[name]
+	return ok
+}
+
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	if err := c.checkLimits(op, operands); err != nil {
+		c.limitError(err)
+	}
 	ins := code.Make(op, operands...)
 	pos := c.addInstruction(ins)
 	c.setLastInstruction(op, pos)
 	return pos
 }
 
+// checkLimits returns an error when the operands are too big for the instruction or for the VM
+func (c *Compiler) checkLimits(op code.Opcode, operands []int) error {
+	if (op == code.OpGetGlobal || op == code.OpSetGlobal) && operands[0] >= maxGlobals {
+		return fmt.Errorf("too many global variables, the limit is %d", maxGlobals)
+	}
+	if err := code.CheckOperands(op, operands...); err != nil {
+		switch op {
+		case code.OpGetLocal, code.OpSetLocal:
+			return fmt.Errorf("too many local variables in a function: %s", err)
+		case code.OpCall:
+			return fmt.Errorf("too many arguments in a call: %s", err)
+		case code.OpGetFree, code.OpSetFree, code.OpClosure:
+			return fmt.Errorf("too many free variables in a function: %s", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// limitError records an operand over its limit, only once per message as a big program can hit the same limit many times
+func (c *Compiler) limitError(err error) {
+	for _, diagnostic := range c.diagnostics {
+		if diagnostic.Message == err.Error() {
+			return
+		}
+	}
+	c.errorf(nil, "%s", err)
+}
+
 func (c *Compiler) addInstruction(ins []byte) int {
 	posNewIns := len(c.currentInstructions())
 	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+	c.scopes[c.scopeIndex].lines = c.scopes[c.scopeIndex].lines.Add(posNewIns, int(c.line))
 	return posNewIns
 }
 
+// constantKey identifies a constant by its value, constants with the same key are stored only once
+type constantKey struct {
+	objectType object.ObjectType
+	value      string
+}
+
+func constantKeyOf(obj object.Object) (constantKey, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return constantKey{obj.Type(), strconv.FormatInt(obj.Value, 10)}, true
+	case *object.String:
+		return constantKey{obj.Type(), obj.Value}, true
+	case *object.CompiledFunction:
+		// The name, lines and free variables are part of the key, so errors and listings show the right function
+		value := fmt.Sprintf("%d:%d:%s:%v:%q:%s", obj.NumLocals, obj.NumParameters, obj.Name, obj.Lines, obj.Free, string(obj.Instructions))
+		return constantKey{obj.Type(), value}, true
+	}
+	return constantKey{}, false
+}
+
 func (c *Compiler) addConstant(obj object.Object) int {
+	key, ok := constantKeyOf(obj)
+	if ok {
+		if idx, ok := c.constantIndexes[key]; ok {
+			return idx
+		}
+	}
 	c.constants = append(c.constants, obj)
+	if ok {
+		c.constantIndexes[key] = len(c.constants) - 1
+	}
 	return len(c.constants) - 1
 }
 
@@ -405,15 +971,64 @@ type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
 	Table        *SymbolTable
+	// Lines maps Instructions to the lines of the source
+	Lines code.LineTable
 }
 
 // Bytecode returns the bytecode of the compiler
 func (c *Compiler) Bytecode() *Bytecode {
+	instructions := c.currentInstructions()
+	lines := c.currentScope().lines
+	if c.options.Peephole {
+		instructions, lines = code.OptimizeWithLines(instructions, lines)
+	}
 	return &Bytecode{
-		Instructions: c.currentInstructions(),
+		Instructions: instructions,
 		Constants:    c.constants,
 		Table:        c.symbolTable,
+		Lines:        lines,
+	}
+}
+
+// compoundOperations are the opcodes of the operators of the compound assignments like arr[i] += v
+var compoundOperations = map[string]code.Opcode{
+	"+": code.OpAdd,
+	"-": code.OpSub,
+	"*": code.OpMul,
+	"/": code.OpDiv,
+}
+
+// compileIndexAssign compiles name[index] = value, OpSetIndex makes the updated copy of the variable and it's
+// stored back. The variables of the enclosing functions can't be assigned, the closures have a copy of them
+func (c *Compiler) compileIndexAssign(node *ast.IndexAssignStatement) error {
+	name, ok := node.Target.Left.(*ast.Identifier)
+	if !ok {
+		c.errorf(node, "can't assign an index of %s, only the ones of variables", node.Target.Left)
+		return nil
+	}
+	symbol, ok := c.symbolTable.Resolve(name.Value)
+	if !ok {
+		c.errorf(name, "undefined variable %s", name.Value)
+		return nil
+	}
+	switch symbol.Scope {
+	case FreeScope:
+		c.errorf(name, "can't assign to %s, it's a variable of an enclosing function", name.Value)
+		return nil
+	case BuiltinScope:
+		c.errorf(name, "can't assign to the builtin %s", name.Value)
+		return nil
 	}
+	c.emit(c.getCodeScope(&symbol), symbol.Index)
+	if err := c.compile(node.Target.Right); err != nil {
+		return err
+	}
+	if err := c.compile(node.Value); err != nil {
+		return err
+	}
+	c.emit(code.OpSetIndex, int(compoundOperations[node.Operator]))
+	c.emit(c.setCodeScope(&symbol), symbol.Index)
+	return nil
 }
 
 func (c *Compiler) setCodeScope(symbol *Symbol) code.Opcode {
@@ -2,6 +2,8 @@ package compiler
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 	"xlang/ast"
 	"xlang/code"
@@ -20,6 +22,7 @@ type compilerTestCase struct {
 	input                string
 	expectedConstants    []interface{}
 	expectedInstructions []code.Instructions
+	options              Options
 }
 
 func BenchmarkIntegerArithmetic(t *testing.B) {
@@ -188,6 +191,7 @@ func runCompilerTests(t *testing.B, tests []compilerTestCase) {
 		program := parse(tt.input)
 
 		c := New()
+		c.SetOptions(tt.options)
 		err := c.Compile(program)
 		if err != nil {
 			t.Fatalf("compiler error: %s", err)
@@ -591,14 +595,14 @@ func BenchmarkIndexExpressions(t *testing.B) {
 	tests := []compilerTestCase{
 		{
 			input:             "[1, 2, 3][1 + 1]",
-			expectedConstants: []interface{}{1, 2, 3, 1, 1},
+			expectedConstants: []interface{}{1, 2, 3},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpConstant, 2),
 				code.Make(code.OpArray, 3),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpConstant, 4),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpAdd),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -606,13 +610,13 @@ func BenchmarkIndexExpressions(t *testing.B) {
 		},
 		{
 			input:             "{1: 2}[2 - 1]",
-			expectedConstants: []interface{}{1, 2, 2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpHash, 2),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpConstant, 3),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSub),
 				code.Make(code.OpIndex),
 				code.Make(code.OpPop),
@@ -623,6 +627,70 @@ func BenchmarkIndexExpressions(t *testing.B) {
 	runCompilerTests(t, tests)
 }
 
+func BenchmarkIndexAssignment(t *testing.B) {
+	tests := []compilerTestCase{
+		{
+			input:             "let a = [1]; a[0] = 2",
+			expectedConstants: []interface{}{1, 0, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpSetIndex, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: `fn(h) { h["k"] += 1; h }`,
+			expectedConstants: []interface{}{
+				"k",
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetIndex, int(code.OpAdd)),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{`let h = {}; fn() { h["a"] = 1 }`, ""},
+		{`fn(h) { fn() { h["a"] = 1 } }`, "can't assign to h, it's a variable of an enclosing function"},
+		{`len[0] = 1`, "can't assign to the builtin len"},
+		{`a[0] = 1`, "undefined variable a"},
+	}
+	for _, tt := range errors {
+		err := New().Compile(parse(tt.input))
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("unexpected error for %q: %s", tt.input, err)
+			}
+			continue
+		}
+		diagnostics, ok := err.(Diagnostics)
+		if !ok || len(diagnostics) != 1 || diagnostics[0].Message != tt.expected {
+			t.Errorf("wrong compiler error for %q. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
 func BenchmarkHashLiterals(t *testing.B) {
 	tests := []compilerTestCase{
 		{
@@ -663,6 +731,19 @@ func BenchmarkHashLiterals(t *testing.B) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			// The pairs are compiled in the order of the source
+			input:             "{5: 6, 1: 2}",
+			expectedConstants: []interface{}{5, 6, 1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpHash, 4),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -1223,13 +1304,12 @@ func BenchmarkRecursiveFunctions(t *testing.B) {
 					code.Make(code.OpCall, 1),
 					code.Make(code.OpReturnValue),
 				},
-				1,
 			},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpClosure, 1, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 0),
 				code.Make(code.OpCall, 1),
 				code.Make(code.OpPop),
 			},
@@ -1251,18 +1331,17 @@ func BenchmarkRecursiveFunctions(t *testing.B) {
 					code.Make(code.OpCall, 1),
 					code.Make(code.OpReturnValue),
 				},
-				1,
 				[]code.Instructions{
 					code.Make(code.OpClosure, 1, 0),
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpConstant, 2),
+					code.Make(code.OpConstant, 0),
 					code.Make(code.OpCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
 				code.Make(code.OpCall, 0),
@@ -1273,3 +1352,637 @@ func BenchmarkRecursiveFunctions(t *testing.B) {
 
 	runCompilerTests(t, tests)
 }
+
+func BenchmarkMutuallyRecursiveFunctions(t *testing.B) {
+	tests := []compilerTestCase{
+		{
+			input: `
+			let isEven = fn() { isOdd() };
+			let isOdd = fn() { isEven() };
+			`,
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 1),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let a = fn() { b() };
+				let b = fn() { a() };
+				a()
+			};
+			`,
+			expectedConstants: []interface{}{
+				// a and b have the same instructions, but not the same name and lines
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpSetLocal, 1),
+					// b is set now, so a's free variable can point to it
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func BenchmarkNonExhaustiveMatch(t *testing.B) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`enum Shape { Circle(r), Rect(w, h), Empty }
+			match (Shape.Empty) { Shape.Circle(r) => r, Shape.Empty => 0 }`,
+			"match on Shape is not exhaustive, missing: Shape.Rect",
+		},
+		{
+			`enum Shape { Circle(r) }
+			match (Shape.Circle(1)) { Shape.Circle(r, h) => r }`,
+			"Shape.Circle has 1 fields, got 2 in the pattern",
+		},
+		{
+			`enum Shape { Circle(r) }
+			match (Shape.Circle(1)) { Shape.Square(r) => r, _ => 0 }`,
+			"enum Shape doesn't have a variant Square",
+		},
+		{
+			`match (1) { Shape.Circle(r) => r }`,
+			"undefined enum=Shape in match",
+		},
+		// Only the functions referenced from function bodies are hoisted, these run before the let
+		{
+			`let w = fn() { let a = g; let g = fn() { 1 }; a() }; w()`,
+			"g used before definition",
+		},
+		{
+			`let f = fn() { let x = x + 1; x }; f()`,
+			"x used before definition",
+		},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error for %q", tt.input)
+		}
+		diagnostics, ok := err.(Diagnostics)
+		if !ok || len(diagnostics) != 1 || diagnostics[0].Message != tt.expected {
+			t.Errorf("wrong compiler error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func BenchmarkDiagnostics(t *testing.B) {
+	tests := []struct {
+		input    string
+		expected Diagnostics
+	}{
+		{
+			"let a = b;\nlet c = a + d;\nif (a) { 1 } else { e }",
+			Diagnostics{
+				{Severity: SeverityError, Line: 1, Column: 9, Message: "undefined variable b"},
+				{Severity: SeverityError, Line: 2, Column: 13, Message: "undefined variable d"},
+				{Severity: SeverityError, Line: 3, Column: 21, Message: "undefined variable e"},
+			},
+		},
+		{
+			"enum Shape { Circle(r), Empty }\nmatch (Shape.Empty) {\n  Shape.Square(r) => x,\n}",
+			Diagnostics{
+				{Severity: SeverityError, Line: 3, Column: 9, Message: "enum Shape doesn't have a variant Square"},
+				{Severity: SeverityError, Line: 2, Column: 1, Message: "match on Shape is not exhaustive, missing: Shape.Circle, Shape.Empty"},
+				{Severity: SeverityError, Line: 3, Column: 22, Message: "undefined variable x"},
+			},
+		},
+		{
+			"let g = fn() { f() };\nlet f = fn() { 1 };\nlet f = fn() { 2 };",
+			Diagnostics{
+				{Severity: SeverityError, Line: 3, Column: 5, Message: "can't define f again, the functions defined before its first let use it"},
+			},
+		},
+		{
+			"fn() { quote(1, 2) }",
+			Diagnostics{
+				{Severity: SeverityError, Line: 1, Column: 13, Message: "expected 1 argument on quote() but got 2"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		c := New()
+		err := c.Compile(parse(tt.input))
+		diagnostics, ok := err.(Diagnostics)
+		if !ok {
+			t.Fatalf("expected Diagnostics for %q, got=%T (%v)", tt.input, err, err)
+		}
+		if !reflect.DeepEqual(diagnostics, tt.expected) {
+			t.Fatalf("wrong diagnostics for %q.\nwant=%v\ngot= %v", tt.input, tt.expected, diagnostics)
+		}
+		if !reflect.DeepEqual(c.Diagnostics(), tt.expected) {
+			t.Fatalf("Diagnostics() doesn't return the errors of Compile, got=%v", c.Diagnostics())
+		}
+	}
+
+	expected := "line 1:9: error: undefined variable b\nline 2:13: error: undefined variable d"
+	if err := New().Compile(parse("let a = b;\nlet c = a + d;")); err == nil || err.Error() != expected {
+		t.Fatalf("wrong error message.\nwant=%q\ngot= %q", expected, err)
+	}
+
+	// A compiler that found errors can compile again
+	c := New()
+	c.Compile(parse("x"))
+	if err := c.Compile(parse("1")); err != nil || len(c.Diagnostics()) != 0 {
+		t.Fatalf("the diagnostics of the previous compilation are kept: %v", err)
+	}
+}
+
+func BenchmarkTuples(t *testing.B) {
+	tests := []compilerTestCase{
+		{
+			input:             `(1, 2)`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpTuple, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `let (a, b) = (1, 2); b`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpTuple, 2),
+				code.Make(code.OpDestructure, 2),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func BenchmarkConstantFolding(t *testing.B) {
+	fold := Options{FoldConstants: true}
+	tests := []compilerTestCase{
+		{
+			input:             `60 * 60 * 24`,
+			expectedConstants: []interface{}{86400},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+			options: fold,
+		},
+		{
+			input:             `60 * 60 * 24`,
+			expectedConstants: []interface{}{60, 24},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMul),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"a" + "b"; -(2 - 5)`,
+			expectedConstants: []interface{}{"ab", 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+			options: fold,
+		},
+		{
+			input:             `!true; 1 < 2; !(1 == 2)`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+			options: fold,
+		},
+		{
+			input:             `let x = 1; !!!x; !(x == 2)`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpBang),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpNotEqual),
+				code.Make(code.OpPop),
+			},
+			options: fold,
+		},
+		{
+			// Dividing by zero must still fail when the program runs
+			input:             `1 / 0`,
+			expectedConstants: []interface{}{1, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+			},
+			options: fold,
+		},
+		{
+			input:             `if (1 > 2) { 10 } else { 20 }; if (false) { 30 }`,
+			expectedConstants: []interface{}{20},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			},
+			options: fold,
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func BenchmarkPeephole(t *testing.B) {
+	peephole := Options{Peephole: true}
+	tests := []compilerTestCase{
+		{
+			input: `fn(x) { 1; return x + 1; 2 }`,
+			expectedConstants: []interface{}{1, 2, []code.Instructions{
+				code.Make(code.OpAddLocalConstant, 0, 0),
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+			options: peephole,
+		},
+		{
+			input: `fn(n) { if (n > 1) { return n }; n - 1 }`,
+			expectedConstants: []interface{}{1, []code.Instructions{
+				// 0000
+				code.Make(code.OpGetLocal, 0),
+				// 0002
+				code.Make(code.OpConstant, 0),
+				// 0005
+				code.Make(code.OpGreaterThan),
+				// 0006
+				code.Make(code.OpJumpNotTruthy, 12),
+				// 0009
+				code.Make(code.OpGetLocal, 0),
+				// 0011
+				code.Make(code.OpReturnValue),
+				// 0012
+				code.Make(code.OpSubLocalConstant, 0, 0),
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+			options: peephole,
+		},
+		{
+			// The last OpPop is kept because it's the result of the program
+			input:             `let x = 1; x; x`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+			options: peephole,
+		},
+		{
+			input:             `let x = true; if (x) { 1 } else { 2 }; 3`,
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpSetGlobal, 0),
+				// 0004
+				code.Make(code.OpGetGlobal, 0),
+				// 0007
+				code.Make(code.OpJumpNotTruthy, 16),
+				// 0010
+				code.Make(code.OpConstant, 0),
+				// 0013
+				code.Make(code.OpJump, 19),
+				// 0016
+				code.Make(code.OpConstant, 1),
+				// 0019
+				code.Make(code.OpPop),
+				// 0020
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpPop),
+			},
+			options: peephole,
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func BenchmarkConstantInterning(t *testing.B) {
+	tests := []compilerTestCase{
+		{
+			input:             `"key"; "key"; 1; 1`,
+			expectedConstants: []interface{}{"key", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `fn() { 1 }; fn() { 1 }`,
+			expectedConstants: []interface{}{1, []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	// The REPL shares the constants between lines, they must not grow with the same values
+	first := New()
+	if err := first.Compile(parse(`"key"; 1`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := first.Bytecode()
+	second := NewWithState(bytecode.Table, bytecode.Constants)
+	if err := second.Compile(parse(`"key"; 1; 2`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	if err := testConstants(t, []interface{}{"key", 1, 2}, second.Bytecode().Constants); err != nil {
+		t.Fatalf("testConstants failed: %s", err)
+	}
+}
+
+func BenchmarkWideOperands(t *testing.B) {
+	tests := []struct {
+		op       code.Opcode
+		operands []int
+		expected []byte
+		str      string
+	}{
+		{code.OpConstant, []int{70000}, []byte{byte(code.OpWide), byte(code.OpConstant), 0, 1, 17, 112}, "0000 OpWide OpConstant 70000"},
+		{code.OpGetLocal, []int{300}, []byte{byte(code.OpWide), byte(code.OpGetLocal), 1, 44}, "0000 OpWide OpGetLocal 300"},
+		{code.OpGetLocal, []int{255}, []byte{byte(code.OpGetLocal), 255}, "0000 OpGetLocal 255"},
+	}
+	for _, tt := range tests {
+		instruction := code.Make(tt.op, tt.operands...)
+		if string(instruction) != string(tt.expected) {
+			t.Fatalf("wrong instruction. want=%v, got=%v", tt.expected, instruction)
+		}
+		op, operands, size, err := code.ReadInstruction(instruction)
+		if err != nil {
+			t.Fatalf("error reading the instruction: %s", err)
+		}
+		if op != tt.op || operands[0] != tt.operands[0] || size != len(tt.expected) {
+			t.Errorf("wrong instruction read. want=%d %v %d, got=%d %v %d", tt.op, tt.operands, len(tt.expected), op, operands, size)
+		}
+		if str := code.Instructions(instruction).String(); str != tt.str {
+			t.Errorf("wrong string. want=%q, got=%q", tt.str, str)
+		}
+	}
+
+	// A function with 300 parameters needs wide locals and a wide argument count
+	params := make([]string, 300)
+	for i := range params {
+		params[i] = string([]byte{'a' + byte(i/26/26), 'a' + byte(i/26%26), 'a' + byte(i%26)})
+	}
+	input := fmt.Sprintf("fn(%s) { %s }(%s)", strings.Join(params, ", "), params[299], strings.Join(params, ", "))
+	c := New()
+	err := c.Compile(parse("let " + strings.Join(params, " = 1; let ") + " = 1; " + input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	fn := c.Bytecode().Constants[1].(*object.CompiledFunction)
+	if err := testInstructions([]code.Instructions{
+		code.Make(code.OpGetLocal, 299),
+		code.Make(code.OpReturnValue),
+	}, fn.Instructions); err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+
+	// Limits that are still hit are a compile error
+	args := make([]string, 70000)
+	for i := range args {
+		args[i] = "1"
+	}
+	err = New().Compile(parse(fmt.Sprintf("len(%s)", strings.Join(args, ", "))))
+	if diagnostics, ok := err.(Diagnostics); !ok || !strings.HasPrefix(diagnostics[0].Message, "too many arguments in a call") {
+		t.Fatalf("expected an error for too many arguments, got=%v", err)
+	}
+}
+
+func BenchmarkLineTables(t *testing.B) {
+	tests := []struct {
+		input           string
+		options         Options
+		expectedLines   code.LineTable
+		expectedFnLines code.LineTable
+	}{
+		{
+			input: `let x = 1;
+let f = fn(a) {
+  let b = a + x;
+  b * 2
+};
+if (x > 0) {
+  f(x)
+} else {
+  3
+}`,
+			expectedLines:   lineTable(0, 1, 6, 2, 13, 6, 23, 7, 31, 6, 34, 9, 37, 6),
+			expectedFnLines: lineTable(0, 3, 8, 4),
+		},
+		{
+			// The peephole removes the 2; of the second line
+			input: `let a = 1;
+2;
+a`,
+			options:       Options{Peephole: true},
+			expectedLines: lineTable(0, 1, 6, 3),
+		},
+	}
+
+	for _, tt := range tests {
+		c := New()
+		c.SetOptions(tt.options)
+		if err := c.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := c.Bytecode()
+		if !reflect.DeepEqual(bytecode.Lines, tt.expectedLines) {
+			t.Fatalf("wrong line table: want=%v, got=%v", tt.expectedLines, bytecode.Lines)
+		}
+		for _, constant := range bytecode.Constants {
+			fn, ok := constant.(*object.CompiledFunction)
+			if ok && !reflect.DeepEqual(fn.Lines, tt.expectedFnLines) {
+				t.Fatalf("wrong line table of %s: want=%v, got=%v", fn.Name, tt.expectedFnLines, fn.Lines)
+			}
+		}
+	}
+
+	lines := lineTable(0, 1, 6, 3)
+	for offset, expected := range map[int]int{0: 1, 5: 1, 6: 3, 100: 3} {
+		if line := lines.Line(offset); line != expected {
+			t.Fatalf("wrong line at %d: want=%d, got=%d", offset, expected, line)
+		}
+	}
+}
+
+// lineTable returns the table with the pairs offset, line
+func lineTable(pairs ...int) code.LineTable {
+	lines := code.LineTable{}
+	for i := 0; i < len(pairs); i += 2 {
+		lines = append(lines, code.LineEntry{Offset: pairs[i], Line: pairs[i+1]})
+	}
+	return lines
+}
+
+func BenchmarkDisassemble(t *testing.B) {
+	input := `let total = 10;
+let adder = fn(a) {
+  fn(b) { if (b > 0) { a + b + total } else { "none" } }
+};
+len(adder(1)(2))`
+	c := New()
+	if err := c.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := `constants:
+  0 INTEGER 10
+  1 INTEGER 0
+  2 STRING "none"
+  3 COMPILED FUNCTION <anonymous>(1 parameters)
+  4 COMPILED FUNCTION adder(1 parameters)
+  5 INTEGER 1
+  6 INTEGER 2
+
+main:
+     1 | let total = 10;
+  0000 OpConstant 0                   ; 10
+  0003 OpSetGlobal 0                  ; total
+     2 | let adder = fn(a) {
+  0006 OpClosure 4 0                  ; adder(1 parameters)
+  0010 OpSetGlobal 1                  ; adder
+     5 | len(adder(1)(2))
+  0013 OpGetBuiltin 0                 ; len
+  0015 OpGetGlobal 1                  ; adder
+  0018 OpConstant 5                   ; 1
+  0021 OpCall 1
+  0023 OpConstant 6                   ; 2
+  0026 OpCall 1
+  0028 OpCall 1
+  0030 OpPop
+
+function adder (constant 4): 1 parameters, 1 locals
+     3 | fn(b) { if (b > 0) { a + b + total } else { "none" } }
+  0000 OpGetLocal 0
+  0002 OpClosure 3 1                  ; <anonymous>(1 parameters)
+  0006 OpReturnValue
+
+function <anonymous> (constant 3): 1 parameters, 1 locals, free: a
+     3 | fn(b) { if (b > 0) { a + b + total } else { "none" } }
+  0000 OpGetLocal 0
+  0002 OpConstant 1                   ; 0
+  0005 OpGreaterThan
+  0006 OpJumpNotTruthy 21             ; -> 0021
+  0009 OpGetFree 0                    ; a
+  0011 OpGetLocal 0
+  0013 OpAdd
+  0014 OpGetGlobal 0                  ; total
+  0017 OpAdd
+  0018 OpJump 24                      ; -> 0024
+  0021 OpConstant 2                   ; "none"
+  0024 OpReturnValue
+`
+	if listing := Disassemble(c.Bytecode(), input); listing != expected {
+		t.Fatalf("wrong listing:\nwant=%s\ngot=%s", expected, listing)
+	}
+
+	// Without the source and the symbol table there are no lines and no names of globals
+	bytecode := c.Bytecode()
+	bytecode.Table = nil
+	listing := Disassemble(bytecode, "")
+	if strings.Contains(listing, " | ") || strings.Contains(listing, "; total") {
+		t.Fatalf("unexpected source lines or globals:\n%s", listing)
+	}
+	if !strings.Contains(listing, "0009 OpGetFree 0                    ; a\n") {
+		t.Fatalf("missing the free variable:\n%s", listing)
+	}
+}
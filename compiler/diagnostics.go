@@ -0,0 +1,87 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"xlang/ast"
+)
+
+// Severity tells if a diagnostic stops the program from running
+type Severity string
+
+const (
+	// SeverityError is a problem that makes the bytecode unusable
+	SeverityError Severity = "error"
+	// SeverityWarning is a problem that doesn't stop the program from running
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a problem found while compiling, Line and Column are 0 when the position isn't known
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Message  string   `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	switch {
+	case d.Line == 0:
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	case d.Column == 0:
+		return fmt.Sprintf("line %d: %s: %s", d.Line, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("line %d:%d: %s: %s", d.Line, d.Column, d.Severity, d.Message)
+}
+
+// Diagnostics are the problems found while compiling a program, in the order they were found.
+// Compile returns them as its error when one of them is an error
+type Diagnostics []Diagnostic
+
+func (d Diagnostics) Error() string {
+	lines := make([]string, len(d))
+	for i, diagnostic := range d {
+		lines[i] = diagnostic.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HasErrors returns if any of the diagnostics is an error
+func (d Diagnostics) HasErrors() bool {
+	for _, diagnostic := range d {
+		if diagnostic.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Messages returns the messages of the diagnostics, without their positions
+func (d Diagnostics) Messages() []string {
+	messages := make([]string, len(d))
+	for i, diagnostic := range d {
+		messages[i] = diagnostic.Message
+	}
+	return messages
+}
+
+// column returns the column of the token of the node, 0 for the nodes without one
+func column(node ast.Node) int {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		return node.Token.Column
+	case *ast.PrefixExpression:
+		return node.Token.Column
+	case *ast.InfixExpression:
+		return node.Token.Column
+	case *ast.CallExpression:
+		return node.Token.Column
+	case *ast.MatchExpression:
+		return node.Token.Column
+	case *ast.MacroLiteral:
+		return node.Token.Column
+	case *ast.FunctionLiteral:
+		return node.Token.Column
+	}
+	return 0
+}
@@ -0,0 +1,178 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"xlang/code"
+	"xlang/object"
+)
+
+// Disassemble returns a listing of the bytecode: the constants, the instructions of the program and the ones of
+// every function, with the names of the globals, builtins and free variables, the values of the constants and the
+// targets of the jumps next to the instructions that use them.
+// When source isn't empty, every line of the source is printed before the instructions it produced
+func Disassemble(bytecode *Bytecode, source string) string {
+	d := &disassembler{
+		out:       &strings.Builder{},
+		constants: bytecode.Constants,
+		globals:   globalNames(bytecode.Table),
+		done:      map[*object.CompiledFunction]bool{},
+	}
+	if source != "" {
+		d.source = strings.Split(source, "\n")
+	}
+
+	d.out.WriteString("constants:\n")
+	for i, constant := range d.constants {
+		fmt.Fprintf(d.out, "  %d %s %s\n", i, constant.Type(), d.describe(constant))
+	}
+	d.out.WriteString("\nmain:\n")
+	closures := d.function(&object.CompiledFunction{Instructions: bytecode.Instructions, Lines: bytecode.Lines})
+	for _, closure := range closures {
+		if fn, ok := d.constants[closure].(*object.CompiledFunction); ok {
+			d.nested(closure, fn)
+		}
+	}
+	// The functions that no closure uses
+	for i, constant := range d.constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			d.nested(i, fn)
+		}
+	}
+	return d.out.String()
+}
+
+type disassembler struct {
+	out       *strings.Builder
+	constants []object.Object
+	globals   map[int]string
+	source    []string
+	// done are the functions that are already listed
+	done map[*object.CompiledFunction]bool
+}
+
+// globalNames returns the names of the globals by their index
+func globalNames(table *SymbolTable) map[int]string {
+	names := map[int]string{}
+	for table != nil && table.Outer != nil {
+		table = table.Outer
+	}
+	if table == nil {
+		return names
+	}
+	for name, symbol := range table.store {
+		if symbol.Scope == GlobalScope {
+			names[symbol.Index] = name
+		}
+	}
+	return names
+}
+
+// describe returns a short description of a constant
+func (d *disassembler) describe(constant object.Object) string {
+	switch constant := constant.(type) {
+	case *object.String:
+		return fmt.Sprintf("%q", constant.Value)
+	case *object.CompiledFunction:
+		return fmt.Sprintf("%s(%d parameters)", object.FunctionName(constant.Name), constant.NumParameters)
+	}
+	return constant.Inspect()
+}
+
+// nested lists the function stored in the constant index and then the functions it makes closures of
+func (d *disassembler) nested(index int, fn *object.CompiledFunction) {
+	if d.done[fn] {
+		return
+	}
+	d.done[fn] = true
+	fmt.Fprintf(d.out, "\nfunction %s (constant %d): %d parameters, %d locals", object.FunctionName(fn.Name), index, fn.NumParameters, fn.NumLocals)
+	if len(fn.Free) > 0 {
+		fmt.Fprintf(d.out, ", free: %s", strings.Join(fn.Free, ", "))
+	}
+	d.out.WriteString("\n")
+	for _, closure := range d.function(fn) {
+		if inner, ok := d.constants[closure].(*object.CompiledFunction); ok {
+			d.nested(closure, inner)
+		}
+	}
+}
+
+// function lists the instructions of fn and returns the constants of the closures that it makes
+func (d *disassembler) function(fn *object.CompiledFunction) []int {
+	closures := []int{}
+	line := 0
+	ins := fn.Instructions
+	for i := 0; i < len(ins); {
+		op, operands, size, err := code.ReadInstruction(ins[i:])
+		if err != nil {
+			fmt.Fprintf(d.out, "  %04d ERROR: %s\n", i, err)
+			return closures
+		}
+		if l := fn.Lines.Line(i); l != line {
+			line = l
+			if line > 0 && line <= len(d.source) {
+				fmt.Fprintf(d.out, "%6d | %s\n", line, strings.TrimSpace(d.source[line-1]))
+			}
+		}
+		listing := formatInstruction(op, operands)
+		if code.Opcode(ins[i]) == code.OpWide {
+			listing = "OpWide " + listing
+		}
+		annotation := d.annotate(fn, op, operands)
+		if annotation == "" {
+			fmt.Fprintf(d.out, "  %04d %s\n", i, listing)
+		} else {
+			fmt.Fprintf(d.out, "  %04d %-30s ; %s\n", i, listing, annotation)
+		}
+		if op == code.OpClosure && operands[0] < len(d.constants) {
+			closures = append(closures, operands[0])
+		}
+		i += size
+	}
+	return closures
+}
+
+// annotate returns what the operands of the instruction reference
+func (d *disassembler) annotate(fn *object.CompiledFunction, op code.Opcode, operands []int) string {
+	switch op {
+	case code.OpConstant, code.OpClosure, code.OpMatchVariant:
+		return d.constant(operands[0])
+	case code.OpAddLocalConstant, code.OpSubLocalConstant:
+		return d.constant(operands[1])
+	case code.OpGetGlobal, code.OpSetGlobal:
+		return d.globals[operands[0]]
+	case code.OpGetBuiltin:
+		if builtins := object.GetBuiltins(); operands[0] < len(builtins) {
+			return builtins[operands[0]].Name
+		}
+	case code.OpGetFree:
+		if operands[0] < len(fn.Free) {
+			return fn.Free[operands[0]]
+		}
+	case code.OpJump, code.OpJumpNotTruthy:
+		return fmt.Sprintf("-> %04d", operands[0])
+	case code.OpSetIndex:
+		for operator, operation := range compoundOperations {
+			if int(operation) == operands[0] {
+				return operator + "="
+			}
+		}
+	}
+	return ""
+}
+
+func (d *disassembler) constant(index int) string {
+	if index >= len(d.constants) {
+		return "missing constant"
+	}
+	return d.describe(d.constants[index])
+}
+
+func formatInstruction(op code.Opcode, operands []int) string {
+	def, _ := code.Lookup(byte(op))
+	parts := []string{def.Name}
+	for _, operand := range operands {
+		parts = append(parts, fmt.Sprint(operand))
+	}
+	return strings.Join(parts, " ")
+}
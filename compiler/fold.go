@@ -0,0 +1,134 @@
+package compiler
+
+import (
+	"strconv"
+	"xlang/ast"
+	"xlang/token"
+)
+
+// Fold returns a copy of node where the expressions that only use literals are
+// replaced by their result, like 60 * 60 * 24 by 86400 or "a" + "b" by "ab".
+// Expressions that would fail at runtime, like 1 / 0, are left as they are so the error still happens
+func Fold(node ast.Node) ast.Node {
+	return ast.Modify(node, func(node ast.Node) ast.Node {
+		switch node := node.(type) {
+		case *ast.InfixExpression:
+			if folded := foldInfix(node); folded != nil {
+				return folded
+			}
+		case *ast.PrefixExpression:
+			if folded := foldPrefix(node); folded != nil {
+				return folded
+			}
+		}
+		return node
+	})
+}
+
+func foldInfix(node *ast.InfixExpression) ast.Expression {
+	switch left := node.Left.(type) {
+	case *ast.IntegerLiteral:
+		right, ok := node.Right.(*ast.IntegerLiteral)
+		if !ok {
+			return nil
+		}
+		switch node.Operator {
+		case "+":
+			return integerLiteral(left.Value+right.Value, node.Line())
+		case "-":
+			return integerLiteral(left.Value-right.Value, node.Line())
+		case "*":
+			return integerLiteral(left.Value*right.Value, node.Line())
+		case "/":
+			if right.Value == 0 {
+				return nil
+			}
+			return integerLiteral(left.Value/right.Value, node.Line())
+		case "<":
+			return booleanLiteral(left.Value < right.Value, node.Line())
+		case ">":
+			return booleanLiteral(left.Value > right.Value, node.Line())
+		case "==":
+			return booleanLiteral(left.Value == right.Value, node.Line())
+		case "!=":
+			return booleanLiteral(left.Value != right.Value, node.Line())
+		}
+	case *ast.StringLiteral:
+		right, ok := node.Right.(*ast.StringLiteral)
+		if !ok || node.Operator != "+" {
+			return nil
+		}
+		value := left.Value + right.Value
+		str := &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: value}, Value: value}
+		str.SetLine(node.Line())
+		return str
+	case *ast.Boolean:
+		right, ok := node.Right.(*ast.Boolean)
+		if !ok {
+			return nil
+		}
+		switch node.Operator {
+		case "==":
+			return booleanLiteral(left.Value == right.Value, node.Line())
+		case "!=":
+			return booleanLiteral(left.Value != right.Value, node.Line())
+		}
+	}
+	return nil
+}
+
+func foldPrefix(node *ast.PrefixExpression) ast.Expression {
+	switch node.Operator {
+	case "-":
+		if right, ok := node.Right.(*ast.IntegerLiteral); ok {
+			return integerLiteral(-right.Value, node.Line())
+		}
+	case "!":
+		if truthy, ok := constantTruthiness(node.Right); ok {
+			return booleanLiteral(!truthy, node.Line())
+		}
+		switch right := node.Right.(type) {
+		case *ast.PrefixExpression:
+			// !!!x is !x, the result of ! is always a boolean
+			if inner, ok := right.Right.(*ast.PrefixExpression); ok && right.Operator == "!" && inner.Operator == "!" {
+				return inner
+			}
+		case *ast.InfixExpression:
+			// !(a == b) is a != b
+			negated := map[string]string{"==": "!=", "!=": "=="}
+			if operator, ok := negated[right.Operator]; ok {
+				cp := *right
+				cp.Operator = operator
+				return &cp
+			}
+		}
+	}
+	return nil
+}
+
+// constantTruthiness returns if a literal is truthy, the second value is false when it isn't a literal
+func constantTruthiness(node ast.Expression) (bool, bool) {
+	switch node := node.(type) {
+	case *ast.Boolean:
+		return node.Value, true
+	case *ast.IntegerLiteral, *ast.StringLiteral:
+		return true, true
+	}
+	return false, false
+}
+
+func integerLiteral(value int64, line uint64) *ast.IntegerLiteral {
+	literal := &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: strconv.FormatInt(value, 10)}, Value: value}
+	literal.SetLine(line)
+	return literal
+}
+
+func booleanLiteral(value bool, line uint64) *ast.Boolean {
+	t := token.Token{Type: token.FALSE, Literal: "false"}
+	if value {
+		t = token.Token{Type: token.TRUE, Literal: "true"}
+	}
+	boolean := &ast.Boolean{Token: t, Value: value}
+	boolean.SetLine(line)
+	return boolean
+}
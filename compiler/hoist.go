@@ -0,0 +1,177 @@
+package compiler
+
+import "xlang/ast"
+
+// Hoisted returns the lets of these statements that are hoisted: the ones whose value is a function literal
+// and whose name is only referenced from inside the bodies of the functions defined before them, like two
+// functions that call each other. If a name is defined more than once only its first let can be hoisted.
+// The references before the other lets are to the previous definition of the name, like let a = push(a, 1)
+func Hoisted(statements []ast.Statement) map[*ast.LetStatement]bool {
+	hoisted := map[*ast.LetStatement]bool{}
+	defined := map[string]bool{}
+	for i, s := range statements {
+		let, ok := s.(*ast.LetStatement)
+		if !ok || defined[let.Name.Value] {
+			continue
+		}
+		defined[let.Name.Value] = true
+		if _, ok := let.Value.(*ast.FunctionLiteral); !ok {
+			continue
+		}
+		referenced, outsideFunction := false, false
+		referencesIn(statements[:i], let.Name.Value, false, func(inFunction bool) {
+			referenced = true
+			outsideFunction = outsideFunction || !inFunction
+		})
+		if referenced && !outsideFunction {
+			hoisted[let] = true
+		}
+	}
+	return hoisted
+}
+
+// HoistedLets returns the lets that Hoisted returns in the order of the statements, the backends declare
+// them before compiling the statements
+func HoistedLets(statements []ast.Statement) []*ast.LetStatement {
+	hoisted := Hoisted(statements)
+	lets := []*ast.LetStatement{}
+	for _, s := range statements {
+		if let, ok := s.(*ast.LetStatement); ok && hoisted[let] {
+			lets = append(lets, let)
+		}
+	}
+	return lets
+}
+
+// referencesIn calls found for every reference to name in the statements, the statements after a
+// definition of name reference that definition, so they aren't checked
+func referencesIn(statements []ast.Statement, name string, inFunction bool, found func(inFunction bool)) {
+	for _, s := range statements {
+		references(s, name, inFunction, found)
+		switch s := s.(type) {
+		case *ast.LetStatement:
+			if s.Name.Value == name {
+				return
+			}
+		case *ast.DestructureStatement:
+			for _, n := range s.Names {
+				if n.Value == name {
+					return
+				}
+			}
+		}
+	}
+}
+
+// references calls found for every reference to name in node, inFunction is set inside the body of a function
+func references(node ast.Node, name string, inFunction bool, found func(inFunction bool)) {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		if node.Value == name {
+			found(inFunction)
+		}
+	case *ast.LetStatement:
+		// A function defined with the name references itself
+		if _, ok := node.Value.(*ast.FunctionLiteral); ok && node.Name.Value == name {
+			return
+		}
+		references(node.Value, name, inFunction, found)
+	case *ast.DestructureStatement:
+		references(node.Value, name, inFunction, found)
+	case *ast.IndexAssignStatement:
+		references(node.Target, name, inFunction, found)
+		references(node.Value, name, inFunction, found)
+	case *ast.ReturnStatement:
+		references(node.ReturnValue, name, inFunction, found)
+	case *ast.ExpressionStatement:
+		references(node.Expression, name, inFunction, found)
+	case *ast.BlockStatement:
+		referencesIn(node.Statements, name, inFunction, found)
+	case *ast.PrefixExpression:
+		references(node.Right, name, inFunction, found)
+	case *ast.InfixExpression:
+		references(node.Left, name, inFunction, found)
+		references(node.Right, name, inFunction, found)
+	case *ast.IndexExpression:
+		references(node.Left, name, inFunction, found)
+		references(node.Right, name, inFunction, found)
+	case *ast.IfExpression:
+		references(node.Condition, name, inFunction, found)
+		references(node.Consequence, name, inFunction, found)
+		if node.Alternative != nil {
+			references(node.Alternative, name, inFunction, found)
+		}
+	case *ast.CallExpression:
+		references(node.Function, name, inFunction, found)
+		for _, argument := range node.Arguments {
+			references(argument, name, inFunction, found)
+		}
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			references(element, name, inFunction, found)
+		}
+	case *ast.TupleLiteral:
+		for _, element := range node.Elements {
+			references(element, name, inFunction, found)
+		}
+	case *ast.HashLiteral:
+		for _, key := range node.Keys {
+			references(key, name, inFunction, found)
+			references(node.Pairs[key], name, inFunction, found)
+		}
+	case *ast.MatchExpression:
+		references(node.Subject, name, inFunction, found)
+		for _, arm := range node.Arms {
+			if arm.Pattern != nil && hasIdentifier(arm.Pattern.Bindings, name) {
+				continue
+			}
+			references(arm.Body, name, inFunction, found)
+		}
+	case *ast.FunctionLiteral:
+		if hasIdentifier(node.Parameters, name) {
+			return
+		}
+		references(node.Body, name, true, found)
+	}
+}
+
+func hasIdentifier(identifiers []*ast.Identifier, name string) bool {
+	for _, ident := range identifiers {
+		if ident.Value == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Redefined returns the names of the lets and destructurings of these statements that define again a function
+// that Hoisted returns. The functions defined before its let reference that function, after the name is defined
+// again they would call the first definition in the compiled backends and the last one in the evaluator, so the
+// backends report it as an error
+func Redefined(statements []ast.Statement) []*ast.Identifier {
+	hoisted := map[string]bool{}
+	for let := range Hoisted(statements) {
+		hoisted[let.Name.Value] = true
+	}
+	redefined := []*ast.Identifier{}
+	defined := map[string]bool{}
+	for _, s := range statements {
+		var names []*ast.Identifier
+		switch s := s.(type) {
+		case *ast.LetStatement:
+			names = []*ast.Identifier{s.Name}
+		case *ast.DestructureStatement:
+			names = s.Names
+		}
+		for _, name := range names {
+			if defined[name.Value] && hoisted[name.Value] {
+				redefined = append(redefined, name)
+			}
+			defined[name.Value] = true
+		}
+	}
+	return redefined
+}
+
+// RedefinedFormat is the format of the errors for the names that Redefined returns
+const RedefinedFormat = "can't define %s again, the functions defined before its first let use it"
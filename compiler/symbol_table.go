@@ -1,5 +1,7 @@
 package compiler
 
+import "xlang/object"
+
 // SymbolScope .
 type SymbolScope string
 
@@ -28,6 +30,7 @@ type SymbolTable struct {
 
 	store          map[string]Symbol
 	numDefinitions int
+	enums          map[string]*object.Enum
 }
 
 // NewSymbolTable returns a new table
@@ -62,17 +65,28 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 
 // Define a new symbol
 func (s *SymbolTable) Define(name string) Symbol {
+	symbol := s.declare(name)
+	s.bind(symbol)
+	return symbol
+}
+
+// declare reserves a slot for a symbol without making it visible, bind makes it visible
+// when the compiler reaches its definition
+func (s *SymbolTable) declare(name string) Symbol {
 	symbol := Symbol{Name: name, Index: s.numDefinitions}
 	if s.Outer == nil {
 		symbol.Scope = GlobalScope
 	} else {
 		symbol.Scope = LocalScope
 	}
-	s.store[name] = symbol
 	s.numDefinitions++
 	return symbol
 }
 
+func (s *SymbolTable) bind(symbol Symbol) {
+	s.store[symbol.Name] = symbol
+}
+
 // DefineBuiltin defines a builtin function
 func (s *SymbolTable) DefineBuiltin(index int, name string) {
 	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
@@ -86,3 +100,18 @@ func (s *SymbolTable) defineFree(original Symbol) Symbol {
 	s.store[original.Name] = symbol
 	return symbol
 }
+
+func (s *SymbolTable) defineEnum(enum *object.Enum) {
+	if s.enums == nil {
+		s.enums = map[string]*object.Enum{}
+	}
+	s.enums[enum.Name] = enum
+}
+
+func (s *SymbolTable) resolveEnum(name string) (*object.Enum, bool) {
+	enum, ok := s.enums[name]
+	if !ok && s.Outer != nil {
+		return s.Outer.resolveEnum(name)
+	}
+	return enum, ok
+}
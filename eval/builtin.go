@@ -24,4 +24,20 @@ var builtins = map[string]*object.Builtin{
 	"keys": object.GetBuiltinByName("keys"),
 
 	"delete": object.GetBuiltinByName("delete"),
+
+	"map": object.GetBuiltinByName("map"),
+
+	"filter": object.GetBuiltinByName("filter"),
+
+	"reduce": object.GetBuiltinByName("reduce"),
+
+	"sort": object.GetBuiltinByName("sort"),
+
+	"find": object.GetBuiltinByName("find"),
+
+	"any": object.GetBuiltinByName("any"),
+
+	"all": object.GetBuiltinByName("all"),
+
+	"values": object.GetBuiltinByName("values"),
 }
@@ -2,13 +2,14 @@ package eval
 
 import (
 	"xlang/ast"
+	"xlang/compiler"
 	"xlang/object"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
 )
 
 func booleanToObject(boolean bool) *object.Boolean {
@@ -24,6 +25,10 @@ type Evaluator struct {
 	Line   uint64
 	Log    []object.Object
 	LogRef *[]object.Object
+	// caller is the evaluator that called the function this one runs, nil outside of the functions
+	caller *Evaluator
+	// function is the name of the function this evaluator runs
+	function string
 }
 
 // NewEval returns a new evaluator of AST
@@ -38,7 +43,7 @@ func ExtendEval(env *object.Environment, log []object.Object, line uint64) *Eval
 
 // Eval evals an ast node.
 func (e *Evaluator) Eval(node ast.Node) object.Object {
-	if node != nil {
+	if node != nil && node.Line() != 0 {
 		e.Line = node.Line()
 	}
 
@@ -66,7 +71,15 @@ func (e *Evaluator) Eval(node ast.Node) object.Object {
 			if len(elements) == 1 && object.IsError(elements[0]) {
 				return elements[0]
 			}
-			return &object.Array{Elements: elements}
+			return object.NewArray(elements)
+		}
+	case *ast.TupleLiteral:
+		{
+			elements := e.evalExpressions(node.Elements)
+			if len(elements) == 1 && object.IsError(elements[0]) {
+				return elements[0]
+			}
+			return &object.Tuple{Elements: elements}
 		}
 
 	case *ast.StringLiteral:
@@ -76,6 +89,12 @@ func (e *Evaluator) Eval(node ast.Node) object.Object {
 
 	case *ast.CallExpression:
 		{
+			if isCall(node, "quote") {
+				if len(node.Arguments) != 1 {
+					return object.NewError("Expected 1 argument on quote() but got %d", len(node.Arguments))
+				}
+				return e.quote(node.Arguments[0])
+			}
 			function := e.Eval(node.Function)
 			if object.IsError(function) {
 				return function
@@ -88,9 +107,13 @@ func (e *Evaluator) Eval(node ast.Node) object.Object {
 		}
 	case *ast.FunctionLiteral:
 		{
-			f := &object.Function{Parameters: node.Parameters, Body: node.Body, Env: e.env}
+			f := &object.Function{Parameters: node.Parameters, Body: node.Body, Env: e.env, Name: node.Name}
 			return f
 		}
+	case *ast.MacroLiteral:
+		{
+			return object.NewError("Macros can only be defined with let at the top level of the program")
+		}
 
 	case *ast.LetStatement:
 		{
@@ -100,6 +123,27 @@ func (e *Evaluator) Eval(node ast.Node) object.Object {
 			}
 			e.env.Set(node.Name.Value, val)
 		}
+	case *ast.IndexAssignStatement:
+		{
+			return e.evalIndexAssign(node)
+		}
+	case *ast.DestructureStatement:
+		{
+			val := e.Eval(node.Value)
+			if object.IsError(val) {
+				return val
+			}
+			tuple, ok := val.(*object.Tuple)
+			if !ok {
+				return object.NewError("Can't destructure a value of type %s, expected a TUPLE", val.Type())
+			}
+			if len(tuple.Elements) != len(node.Names) {
+				return object.NewError("Can't destructure a tuple of %d elements into %d variables", len(tuple.Elements), len(node.Names))
+			}
+			for i, name := range node.Names {
+				e.env.Set(name.Value, tuple.Elements[i])
+			}
+		}
 	case *ast.Identifier:
 		{
 			return e.evalIdentifier(node)
@@ -120,6 +164,14 @@ func (e *Evaluator) Eval(node ast.Node) object.Object {
 		{
 			return e.evalIf(node)
 		}
+	case *ast.EnumStatement:
+		{
+			e.env.Set(node.Name.Value, object.EnumFromStatement(node))
+		}
+	case *ast.MatchExpression:
+		{
+			return e.evalMatch(node)
+		}
 	case *ast.PrefixExpression:
 		{
 			value := e.Eval(node.Right)
@@ -130,6 +182,9 @@ func (e *Evaluator) Eval(node ast.Node) object.Object {
 		}
 	case *ast.Program:
 		{
+			if err := e.checkRedefined(node); err != nil {
+				return err
+			}
 			return e.evalProgramStatements(node.Statements)
 		}
 
@@ -167,7 +222,13 @@ func (e *Evaluator) applyFunction(fn object.Object, params []object.Object) obje
 		if !ok {
 			return object.NewError("Expected function, got %s instead", fn.Type())
 		}
-		fnRes := builtin.Fn(params...)
+		fnRes, err := builtin.Call(e, params...)
+		if err != nil {
+			if errorValue, ok := err.(*object.Error); ok {
+				return errorValue
+			}
+			return object.NewError("%s", err)
+		}
 		if fnRes == nil {
 			return NULL
 		}
@@ -185,7 +246,12 @@ func (e *Evaluator) applyFunction(fn object.Object, params []object.Object) obje
 
 	extendedEnvironment := e.newEnvironmentForFunction(function, params)
 	eval := ExtendEval(extendedEnvironment, e.Log, e.Line)
+	eval.caller = e
+	eval.function = object.FunctionName(function.Name)
 	returnValue := eval.Eval(function.Body)
+	if err, ok := returnValue.(*object.Error); ok && err.Trace == nil {
+		err.Trace = eval.stackTrace()
+	}
 	e.Log = eval.Log
 	e.Line = eval.Line
 	tryUnwrapReturnValue, ok := returnValue.(*object.ReturnValue)
@@ -195,6 +261,59 @@ func (e *Evaluator) applyFunction(fn object.Object, params []object.Object) obje
 	return tryUnwrapReturnValue.Value
 }
 
+// CallFunction calls fn with the arguments, it's how the builtins like map call the functions of the program.
+// The error is the *object.Error that fn returned
+func (e *Evaluator) CallFunction(fn object.Object, args ...object.Object) (object.Object, error) {
+	// The builtin is still running on the line that called it
+	line := e.Line
+	result := e.applyFunction(fn, args)
+	e.Line = line
+	if errorValue, ok := result.(*object.Error); ok {
+		return nil, errorValue
+	}
+	return result, nil
+}
+
+// evalIndexAssign evaluates name[index] = value, the variable gets a copy of its array or hashmap with the value
+func (e *Evaluator) evalIndexAssign(node *ast.IndexAssignStatement) object.Object {
+	name, ok := node.Target.Left.(*ast.Identifier)
+	if !ok {
+		return object.NewError("Can't assign an index of %s, only the ones of variables", node.Target.Left)
+	}
+	container := e.evalIdentifier(name)
+	if object.IsError(container) {
+		return container
+	}
+	index := e.Eval(node.Target.Right)
+	if object.IsError(index) {
+		return index
+	}
+	value := e.Eval(node.Value)
+	if object.IsError(value) {
+		return value
+	}
+	if node.Operator != "" {
+		current := e.evaluateIndex(container, index)
+		if object.IsError(current) {
+			return current
+		}
+		value = e.evalInfixExpression(current, value, node.Operator)
+		if object.IsError(value) {
+			return value
+		}
+	}
+	updated, err := object.SetIndex(container, index, value)
+	if err != nil {
+		return object.NewError("%s", err)
+	}
+	env, ok := e.env.Assign(name.Value, updated)
+	if !ok {
+		return object.NewError("Can't assign to %s, it's a variable of an enclosing function", name.Value)
+	}
+	e.env = env
+	return nil
+}
+
 func (e *Evaluator) evaluateIndex(left object.Object, right object.Object) object.Object {
 	switch obj := left.(type) {
 	case *object.Array:
@@ -205,35 +324,46 @@ func (e *Evaluator) evaluateIndex(left object.Object, right object.Object) objec
 		{
 			return e.evaluateHashIndex(obj, right)
 		}
+	case *object.Enum:
+		{
+			name, ok := right.(*object.String)
+			if !ok {
+				return object.NewError("Unsupported index on enum of type: %s", right.Type())
+			}
+			return obj.Get(name.Value)
+		}
+	case *object.Tuple:
+		{
+			idx, ok := right.(*object.Integer)
+			if !ok {
+				return object.NewError("Unsupported index on tuple of type: %s", right.Type())
+			}
+			if int(idx.Value) >= len(obj.Elements) || int(idx.Value) < 0 {
+				return object.NewError("index %d out of range, the tuple has %d elements", idx.Value, len(obj.Elements))
+			}
+			return obj.Elements[idx.Value]
+		}
 	}
 	return object.NewError("Unsupported index operation on type: %s", left.Type())
 }
 
 func (e *Evaluator) evaluateHashIndex(hash *object.HashMap, right object.Object) object.Object {
-	switch objRight := right.(type) {
-	case object.Hashable:
-		val := hash.Pairs[objRight.HashKey()]
-		if val.Value == nil {
-			return NULL
-		}
-		return val.Value
-	}
-	val := hash.UnhashablePairs[right]
-	if val.Value != nil {
-		return val.Value
+	val, ok := hash.Get(right)
+	if !ok {
+		return NULL
 	}
-	return NULL
+	return val
 }
 
 func (e *Evaluator) evaluateArrayIndex(array *object.Array, right object.Object) object.Object {
 	idx, ok := right.(*object.Integer)
 	if !ok {
-		return object.NewError("Unsupported index on array of type: ", right.Type())
+		return object.NewError("Unsupported index on array of type: %s", right.Type())
 	}
-	if int(idx.Value) >= len(array.Elements) || int(idx.Value) < 0 {
-		return object.NewError("Array out of bounds, array size: %d, passed index: %d", len(array.Elements), idx.Value)
+	if int(idx.Value) >= array.Len() || int(idx.Value) < 0 {
+		return object.NewError("Array out of bounds, array size: %d, passed index: %d", array.Len(), idx.Value)
 	}
-	return array.Elements[idx.Value]
+	return array.Get(int(idx.Value))
 }
 
 func (e *Evaluator) newEnvironmentForFunction(fn *object.Function, params []object.Object) *object.Environment {
@@ -276,14 +406,68 @@ func (e *Evaluator) evalIf(ifStatement *ast.IfExpression) object.Object {
 	if object.IsError(condition) {
 		return condition
 	}
+	branch := ifStatement.Consequence
 	if NULL == condition || FALSE == condition {
 		if ifStatement.Alternative == nil {
 			return NULL
 		}
-		return e.Eval(ifStatement.Alternative)
+		branch = ifStatement.Alternative
 	}
+	// A branch that ends with a statement like a let has no value
+	if result := e.Eval(branch); result != nil {
+		return result
+	}
+	return NULL
+}
 
-	return e.Eval(ifStatement.Consequence)
+// evalMatch runs the body of the first arm whose pattern matches the subject,
+// the fields of the variant are bound to the names of the pattern
+func (e *Evaluator) evalMatch(match *ast.MatchExpression) object.Object {
+	subject := e.Eval(match.Subject)
+	if object.IsError(subject) {
+		return subject
+	}
+	variant, _ := subject.(*object.Variant)
+	for _, arm := range match.Arms {
+		if arm.Pattern == nil {
+			return e.Eval(arm.Body)
+		}
+		if variant == nil || !variant.Is(arm.Pattern.Tag()) {
+			continue
+		}
+		if len(arm.Pattern.Bindings) != len(variant.Values) {
+			return object.NewError("%s has %d fields, got %d in the pattern", arm.Pattern.Tag(), len(variant.Values), len(arm.Pattern.Bindings))
+		}
+		for i, binding := range arm.Pattern.Bindings {
+			e.env.Set(binding.Value, variant.Values[i])
+		}
+		return e.Eval(arm.Body)
+	}
+	return NULL
+}
+
+// checkRedefined returns an error when the program defines again a function that is used before its first let,
+// the compiled backends reject it too, their functions would still call the first definition
+func (e *Evaluator) checkRedefined(program *ast.Program) object.Object {
+	var redefined *ast.Identifier
+	ast.Inspect(program, func(node ast.Node) bool {
+		var statements []ast.Statement
+		switch node := node.(type) {
+		case *ast.Program:
+			statements = node.Statements
+		case *ast.BlockStatement:
+			statements = node.Statements
+		}
+		if names := compiler.Redefined(statements); redefined == nil && len(names) > 0 {
+			redefined = names[0]
+		}
+		return redefined == nil
+	})
+	if redefined == nil {
+		return nil
+	}
+	e.Line = redefined.Line()
+	return object.NewError(compiler.RedefinedFormat, redefined.Value)
 }
 
 func (e *Evaluator) evalProgramStatements(statements []ast.Statement) object.Object {
@@ -294,12 +478,28 @@ func (e *Evaluator) evalProgramStatements(statements []ast.Statement) object.Obj
 			return resultValue.Value
 		}
 		if errorValue, isErr := result.(*object.Error); isErr {
+			if errorValue.Trace == nil {
+				errorValue.Trace = e.stackTrace()
+			}
 			return errorValue
 		}
 	}
 	return result
 }
 
+// stackTrace returns the calls that led to the line that e is running
+func (e *Evaluator) stackTrace() object.StackTrace {
+	trace := object.StackTrace{}
+	for ev := e; ev != nil; ev = ev.caller {
+		function := ev.function
+		if ev.caller == nil {
+			function = "<main>"
+		}
+		trace = append(object.StackTrace{{Function: function, Line: int(ev.Line)}}, trace...)
+	}
+	return trace
+}
+
 func (e *Evaluator) evalBlockStatement(block *ast.BlockStatement) object.Object {
 	var result object.Object
 	for _, statement := range block.Statements {
@@ -316,8 +516,6 @@ func (e *Evaluator) evalStringExpression(left *object.String, right *object.Stri
 	switch operator {
 	case "+":
 		return &object.String{Value: left.Value + right.Value}
-	case "==":
-		return booleanToObject(left.Value == right.Value)
 	}
 	return object.NewError("Error, unknown operator for strings '%s'", operator)
 }
@@ -325,6 +523,14 @@ func (e *Evaluator) evalStringExpression(left *object.String, right *object.Stri
 func (e *Evaluator) evalInfixExpression(left object.Object, right object.Object, operator string) object.Object {
 
 	switch {
+	case operator == "==":
+		{
+			return booleanToObject(object.Equal(left, right))
+		}
+	case operator == "!=":
+		{
+			return booleanToObject(!object.Equal(left, right))
+		}
 	case left.Type() != right.Type():
 		{
 			return object.NewError("Type mismatch: %s %s %s", left.Type(), operator, right.Type())
@@ -337,14 +543,6 @@ func (e *Evaluator) evalInfixExpression(left object.Object, right object.Object,
 		{
 			return e.evalIntegerExpression(left.(*object.Integer), right.(*object.Integer), operator)
 		}
-	case operator == "==":
-		{
-			return booleanToObject(left == right)
-		}
-	case operator == "!=":
-		{
-			return booleanToObject(left != right)
-		}
 	}
 	return object.NewError("Unknown operator: %s", operator)
 }
@@ -357,6 +555,9 @@ func (e *Evaluator) evalIntegerExpression(left *object.Integer, right *object.In
 		}
 	case "/":
 		{
+			if right.Value == 0 {
+				return object.NewError("division by zero")
+			}
 			return &object.Integer{Value: left.Value / right.Value}
 		}
 	case "+":
@@ -390,14 +591,6 @@ func (e *Evaluator) evalIntegerExpression(left *object.Integer, right *object.In
 		{
 			return booleanToObject(left.Value >= right.Value)
 		}
-	case "==":
-		{
-			return booleanToObject(left.Value == right.Value)
-		}
-	case "!=":
-		{
-			return booleanToObject(left.Value != right.Value)
-		}
 	}
 	// todo: Throw err
 	return object.NewError("Unknown operator: %s", operator)
@@ -441,26 +634,18 @@ func (e *Evaluator) evalBangOperatorRight(right object.Object) object.Object {
 }
 
 func (e *Evaluator) evalHash(node *ast.HashLiteral) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair, len(node.Pairs))
-	unhashablePairs := make(map[object.Object]object.HashPair, len(node.Pairs))
-	for keyNode, valueNode := range node.Pairs {
+	hash := object.NewHashMap()
+	for _, keyNode := range node.Keys {
 		key := e.Eval(keyNode)
 		if object.IsError(key) {
 			return key
 		}
-		value := e.Eval(valueNode)
+		value := e.Eval(node.Pairs[keyNode])
 		if object.IsError(value) {
 			return value
 		}
-		hashKey, ok := key.(object.Hashable)
-
-		if !ok {
-			unhashablePairs[key] = object.HashPair{Value: value, Key: key}
-			continue
-		}
-		k := hashKey.HashKey()
-		pairs[k] = object.HashPair{Value: value, Key: key}
+		hash.Put(key, value)
 	}
 
-	return &object.HashMap{Pairs: pairs, UnhashablePairs: unhashablePairs}
+	return hash
 }
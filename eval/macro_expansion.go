@@ -0,0 +1,130 @@
+package eval
+
+import (
+	"fmt"
+	"xlang/ast"
+	"xlang/object"
+)
+
+// maxExpansionDepth stops macros that expand into calls of themselves forever
+const maxExpansionDepth = 100
+
+// Expand defines the macros of the program in env and replaces their calls with the code they return,
+// it must run after parsing and before evaluating or compiling the program
+func Expand(program *ast.Program, env *object.Environment) (*ast.Program, error) {
+	DefineMacros(program, env)
+	return ExpandMacros(program, env)
+}
+
+// DefineMacros removes the top level let statements that define macros from the program and stores them in env
+func DefineMacros(program *ast.Program, env *object.Environment) {
+	statements := make([]ast.Statement, 0, len(program.Statements))
+	for _, statement := range program.Statements {
+		let, ok := statement.(*ast.LetStatement)
+		if !ok {
+			statements = append(statements, statement)
+			continue
+		}
+		macro, ok := let.Value.(*ast.MacroLiteral)
+		if !ok {
+			statements = append(statements, statement)
+			continue
+		}
+		env.Set(let.Name.Value, &object.Macro{Parameters: macro.Parameters, Body: macro.Body, Env: env})
+	}
+	program.Statements = statements
+}
+
+// ExpandMacros replaces the calls of the macros stored in env with the code they return
+func ExpandMacros(program *ast.Program, env *object.Environment) (*ast.Program, error) {
+	expanded, err := expandNode(program, env, 0)
+	if err != nil {
+		return nil, err
+	}
+	return expanded.(*ast.Program), nil
+}
+
+func expandNode(node ast.Node, env *object.Environment, depth int) (ast.Node, error) {
+	if depth > maxExpansionDepth {
+		return nil, fmt.Errorf("macro expansion is too deep, there are more than %d nested expansions", maxExpansionDepth)
+	}
+	var err error
+	expanded := ast.Modify(node, func(node ast.Node) ast.Node {
+		if err != nil {
+			return node
+		}
+		call, ok := node.(*ast.CallExpression)
+		if !ok {
+			return node
+		}
+		macro, ok := macroOfCall(call, env)
+		if !ok {
+			return node
+		}
+		var code ast.Node
+		code, err = expandMacroCall(call, macro)
+		if err != nil {
+			return node
+		}
+		// The code returned can have calls to other macros
+		code, err = expandNode(code, env, depth+1)
+		if err != nil {
+			return node
+		}
+		return code
+	})
+	return expanded, err
+}
+
+func macroOfCall(call *ast.CallExpression, env *object.Environment) (*object.Macro, bool) {
+	ident, ok := call.Function.(*ast.Identifier)
+	if !ok {
+		return nil, false
+	}
+	obj, ok := env.Get(ident.Value)
+	if !ok {
+		return nil, false
+	}
+	macro, ok := obj.(*object.Macro)
+	return macro, ok
+}
+
+func expandMacroCall(call *ast.CallExpression, macro *object.Macro) (ast.Node, error) {
+	if len(call.Arguments) != len(macro.Parameters) {
+		return nil, fmt.Errorf("macro %s expected %d arguments, got %d", call.Function.String(), len(macro.Parameters), len(call.Arguments))
+	}
+	env := object.NewEnclosedEnvironment(macro.Env)
+	for i, param := range macro.Parameters {
+		env.Set(param.Value, &object.Quote{Node: call.Arguments[i]})
+	}
+	env.Set("source", &object.Builtin{Fn: source})
+
+	evaluator := ExtendEval(env, []object.Object{}, call.Line())
+	evaluated := evaluator.Eval(macro.Body)
+	if returnValue, ok := evaluated.(*object.ReturnValue); ok {
+		evaluated = returnValue.Value
+	}
+	if object.IsError(evaluated) {
+		return nil, fmt.Errorf("macro %s failed: %s", call.Function.String(), evaluated.Inspect())
+	}
+	quote, ok := evaluated.(*object.Quote)
+	if !ok {
+		if evaluated == nil {
+			evaluated = NULL
+		}
+		return nil, fmt.Errorf("macro %s must return a quote, got %s", call.Function.String(), evaluated.Type())
+	}
+	return quote.Node, nil
+}
+
+// source is only available inside macros, it returns the code of a quoted argument as a string
+func source(args ...object.Object) object.Object {
+	if len(args) != 1 {
+		return object.NewError("Expected 1 argument on source() but got %d", len(args))
+	}
+	quote, ok := args[0].(*object.Quote)
+	if !ok {
+		return object.NewError("Expected QUOTE as argument on source() but got %s", args[0].Type())
+	}
+	return &object.String{Value: quote.Node.String()}
+}
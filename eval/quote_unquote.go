@@ -0,0 +1,222 @@
+package eval
+
+import (
+	"fmt"
+	"sync/atomic"
+	"xlang/ast"
+	"xlang/object"
+	"xlang/token"
+)
+
+// gensymCounter makes the names of the bindings created inside a quote unique
+var gensymCounter uint64
+
+func (e *Evaluator) quote(node ast.Node) object.Object {
+	node = renameBindings(node)
+
+	var err object.Object
+	node = ast.Modify(node, func(node ast.Node) ast.Node {
+		if err != nil || !isCall(node, "unquote") {
+			return node
+		}
+		call := node.(*ast.CallExpression)
+		if len(call.Arguments) != 1 {
+			err = object.NewError("Expected 1 argument on unquote() but got %d", len(call.Arguments))
+			return node
+		}
+		unquoted := e.Eval(call.Arguments[0])
+		if object.IsError(unquoted) {
+			err = unquoted
+			return node
+		}
+		converted := objectToNode(unquoted)
+		if converted == nil {
+			err = object.NewError("Can't unquote a value of type %s", unquoted.Type())
+			return node
+		}
+		return converted
+	})
+	if err != nil {
+		return err
+	}
+
+	return &object.Quote{Node: node}
+}
+
+// renameBindings gives a unique name to the variables declared inside quoted code, so the code
+// that a macro returns can't shadow or be shadowed by the variables of the place where it's expanded.
+// Only the names that are bound inside the quote are renamed, the others are free variables of the code
+// that belong to the place where it's expanded, like the code inside unquote(...)
+func renameBindings(node ast.Node) ast.Node {
+	h := &hygiene{renamed: map[*ast.Identifier]string{}, names: map[string]string{}}
+	h.visit(node, &bindingScope{declared: declarations(node), bound: map[string]bool{}})
+	if len(h.renamed) == 0 {
+		return node
+	}
+
+	return ast.Modify(node, func(node ast.Node) ast.Node {
+		switch node := node.(type) {
+		case *ast.Identifier:
+			if name, ok := h.renamed[node]; ok {
+				return &ast.Identifier{Token: node.Token, Value: name}
+			}
+		case *ast.LetStatement:
+			if fn, ok := node.Value.(*ast.FunctionLiteral); ok {
+				fn.Name = node.Name.Value
+			}
+		}
+		return node
+	})
+}
+
+// bindingScope is a function of quoted code, or the quote itself, while its bindings are renamed
+type bindingScope struct {
+	outer *bindingScope
+	// declared are all the names that the scope binds, the functions inside it run after they are bound
+	declared map[string]bool
+	// bound are the names bound before the code that is visited
+	bound map[string]bool
+}
+
+// resolves returns if name is a variable bound inside the quote at this point of the code
+func (s *bindingScope) resolves(name string) bool {
+	if s.bound[name] {
+		return true
+	}
+	for outer := s.outer; outer != nil; outer = outer.outer {
+		if outer.declared[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// hygiene finds the identifiers of quoted code to rename, every name bound in the quote gets one new name
+type hygiene struct {
+	renamed map[*ast.Identifier]string
+	names   map[string]string
+}
+
+func (h *hygiene) rename(ident *ast.Identifier) {
+	name, ok := h.names[ident.Value]
+	if !ok {
+		name = fmt.Sprintf("%s__%d", ident.Value, atomic.AddUint64(&gensymCounter, 1))
+		h.names[ident.Value] = name
+	}
+	h.renamed[ident] = name
+}
+
+func (h *hygiene) bind(ident *ast.Identifier, s *bindingScope) {
+	s.bound[ident.Value] = true
+	h.rename(ident)
+}
+
+// visit renames the bindings of node and the identifiers that reference them, a let binds its name after its value
+func (h *hygiene) visit(node ast.Node, s *bindingScope) {
+	ast.Inspect(node, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.CallExpression:
+			return !isCall(node, "unquote")
+		case *ast.Identifier:
+			if s.resolves(node.Value) {
+				h.rename(node)
+			}
+		case *ast.LetStatement:
+			h.visit(node.Value, s)
+			h.bind(node.Name, s)
+			return false
+		case *ast.DestructureStatement:
+			h.visit(node.Value, s)
+			for _, name := range node.Names {
+				h.bind(name, s)
+			}
+			return false
+		case *ast.FunctionLiteral:
+			h.function(node.Parameters, node.Body, s)
+			return false
+		case *ast.MacroLiteral:
+			h.function(node.Parameters, node.Body, s)
+			return false
+		case *ast.MatchExpression:
+			h.visit(node.Subject, s)
+			for _, arm := range node.Arms {
+				if arm.Pattern != nil {
+					for _, binding := range arm.Pattern.Bindings {
+						h.bind(binding, s)
+					}
+				}
+				h.visit(arm.Body, s)
+			}
+			return false
+		}
+		return true
+	})
+}
+
+func (h *hygiene) function(parameters []*ast.Identifier, body *ast.BlockStatement, outer *bindingScope) {
+	inner := &bindingScope{outer: outer, declared: declarations(body), bound: map[string]bool{}}
+	for _, param := range parameters {
+		inner.declared[param.Value] = true
+		h.bind(param, inner)
+	}
+	h.visit(body, inner)
+}
+
+// declarations returns the names that the lets and the match arms of node bind, without the ones of the
+// functions inside it and of the code inside unquote(...)
+func declarations(node ast.Node) map[string]bool {
+	declared := map[string]bool{}
+	ast.Inspect(node, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.CallExpression:
+			return !isCall(node, "unquote")
+		case *ast.FunctionLiteral, *ast.MacroLiteral:
+			return false
+		case *ast.LetStatement:
+			declared[node.Name.Value] = true
+		case *ast.DestructureStatement:
+			for _, name := range node.Names {
+				declared[name.Value] = true
+			}
+		case *ast.MatchExpression:
+			for _, arm := range node.Arms {
+				if arm.Pattern != nil {
+					for _, binding := range arm.Pattern.Bindings {
+						declared[binding.Value] = true
+					}
+				}
+			}
+		}
+		return true
+	})
+	return declared
+}
+
+func isCall(node ast.Node, name string) bool {
+	call, ok := node.(*ast.CallExpression)
+	if !ok {
+		return false
+	}
+	ident, ok := call.Function.(*ast.Identifier)
+	return ok && ident.Value == name
+}
+
+// objectToNode converts back a value to the code that produces it
+func objectToNode(obj object.Object) ast.Node {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		t := token.Token{Type: token.INT, Literal: fmt.Sprintf("%d", obj.Value)}
+		return &ast.IntegerLiteral{Token: t, Value: obj.Value}
+	case *object.Boolean:
+		t := token.Token{Type: token.FALSE, Literal: "false"}
+		if obj.Value {
+			t = token.Token{Type: token.TRUE, Literal: "true"}
+		}
+		return &ast.Boolean{Token: t, Value: obj.Value}
+	case *object.String:
+		return &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: obj.Value}, Value: obj.Value}
+	case *object.Quote:
+		return obj.Node
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package ir
+
+import (
+	"xlang/code"
+	"xlang/compiler"
+	"xlang/object"
+)
+
+// emitter writes the bytecode of a program, the functions are added to the constants after the ones of the program
+type emitter struct {
+	constants []object.Object
+	functions map[*Function]int
+}
+
+// Emit writes the bytecode of the program that the VM runs. It returns an error when an operand
+// doesn't fit in its instruction, not even in the wide form
+func Emit(p *Program) (*compiler.Bytecode, error) {
+	e := &emitter{
+		constants: append([]object.Object{}, p.Constants...),
+		functions: map[*Function]int{},
+	}
+	instructions, lines, err := e.function(p.Main)
+	if err != nil {
+		return nil, err
+	}
+	return &compiler.Bytecode{Instructions: instructions, Constants: e.constants, Lines: lines}, nil
+}
+
+// jumpFixup is a jump whose target is set once the position of every block is known
+type jumpFixup struct {
+	position int
+	op       code.Opcode
+	// target is nil for the end of the function
+	target *Block
+}
+
+// function writes the instructions of fn, the blocks go in their order and a jump to the block that follows is left out.
+// The jumps are in their wide form only when the function is too big for the others
+func (e *emitter) function(fn *Function) (code.Instructions, code.LineTable, error) {
+	for _, wide := range []bool{false, true} {
+		ins, lines, err := e.blocks(fn, wide)
+		if err != nil || wide || code.Fits(code.OpJump, len(ins)) {
+			return ins, lines, err
+		}
+	}
+	panic("unreachable")
+}
+
+func (e *emitter) blocks(fn *Function, wide bool) (code.Instructions, code.LineTable, error) {
+	ins := code.Instructions{}
+	lines := code.LineTable{}
+	positions := map[*Block]int{}
+	fixups := []jumpFixup{}
+	jump := func(op code.Opcode, target *Block, line int) {
+		lines = lines.Add(len(ins), line)
+		fixups = append(fixups, jumpFixup{position: len(ins), op: op, target: target})
+		if wide {
+			ins = append(ins, code.MakeWide(op, 0)...)
+		} else {
+			ins = append(ins, code.Make(op, 0)...)
+		}
+	}
+	add := func(op code.Opcode, operands []int, line int) error {
+		if err := code.CheckOperands(op, operands...); err != nil {
+			return err
+		}
+		lines = lines.Add(len(ins), line)
+		ins = append(ins, code.Make(op, operands...)...)
+		return nil
+	}
+
+	for i, b := range fn.Blocks {
+		var next *Block
+		if i+1 < len(fn.Blocks) {
+			next = fn.Blocks[i+1]
+		}
+		positions[b] = len(ins)
+		for _, instruction := range b.Instructions {
+			operands := instruction.Operands
+			if instruction.Fn != nil {
+				constant, err := e.closure(instruction.Fn)
+				if err != nil {
+					return nil, nil, err
+				}
+				operands = []int{constant, operands[1]}
+			}
+			if err := add(instruction.Op, operands, instruction.Line); err != nil {
+				return nil, nil, err
+			}
+		}
+		switch b.Exit {
+		case ExitJump:
+			if b.Successors[0] != next {
+				jump(code.OpJump, b.Successors[0], b.Line)
+			}
+		case ExitBranch:
+			jump(code.OpJumpNotTruthy, b.Successors[1], b.Line)
+			if b.Successors[0] != next {
+				jump(code.OpJump, b.Successors[0], b.Line)
+			}
+		case ExitReturn:
+			add(code.OpReturnValue, nil, b.Line)
+		case ExitReturnNull:
+			add(code.OpReturn, nil, b.Line)
+		case ExitHalt:
+			// The program stops when it runs out of instructions
+			if next != nil {
+				jump(code.OpJump, nil, b.Line)
+			}
+		}
+	}
+
+	for _, fixup := range fixups {
+		target := len(ins)
+		if fixup.target != nil {
+			target = positions[fixup.target]
+		}
+		if wide {
+			copy(ins[fixup.position:], code.MakeWide(fixup.op, target))
+		} else {
+			copy(ins[fixup.position:], code.Make(fixup.op, target))
+		}
+	}
+	return ins, lines, nil
+}
+
+// closure returns the constant of the compiled fn, it's emitted the first time
+func (e *emitter) closure(fn *Function) (int, error) {
+	if index, ok := e.functions[fn]; ok {
+		return index, nil
+	}
+	ins, lines, err := e.function(fn)
+	if err != nil {
+		return 0, err
+	}
+	e.constants = append(e.constants, &object.CompiledFunction{
+		Instructions:  ins,
+		NumLocals:     fn.NumLocals,
+		NumParameters: fn.NumParameters,
+		Name:          fn.Name,
+		Lines:         lines,
+		Free:          fn.Free,
+	})
+	e.functions[fn] = len(e.constants) - 1
+	return e.functions[fn], nil
+}
@@ -0,0 +1,249 @@
+// Package ir is an intermediate representation between the ast and the bytecode. A function is a list of
+// basic blocks, instructions of the stack VM that run one after the other and end in an explicit jump,
+// branch or return, so the passes can move and remove code without patching the offsets of the jumps.
+// Lower builds it from the ast, Optimize runs the passes on it and Emit writes the code.Instructions that
+// the VM runs. Only the core of the language is lowered, Supported tells if a program uses something else,
+// like an enum or a match, and the compiler is still the one that compiles every program
+package ir
+
+import (
+	"fmt"
+	"strings"
+	"xlang/ast"
+	"xlang/code"
+	"xlang/compiler"
+	"xlang/object"
+)
+
+// Compile lowers the program, optimizes it and emits its bytecode, the errors are compiler.Diagnostics
+func Compile(program *ast.Program) (*compiler.Bytecode, error) {
+	p, err := Lower(program)
+	if err != nil {
+		return nil, err
+	}
+	Optimize(p)
+	return Emit(p)
+}
+
+// Exit is the way a block ends
+type Exit int
+
+const (
+	// ExitJump goes to the first successor
+	ExitJump Exit = iota
+	// ExitBranch pops a value and goes to the first successor when it's truthy, to the second when it isn't
+	ExitBranch
+	// ExitReturn returns the value on top of the stack
+	ExitReturn
+	// ExitReturnNull returns null
+	ExitReturnNull
+	// ExitHalt stops the program, only the blocks of the main function end with it
+	ExitHalt
+)
+
+// Instruction is an instruction of the stack VM with its operands, see code.Opcode.
+// The jumps aren't instructions, they are the exits of the blocks
+type Instruction struct {
+	Op       code.Opcode
+	Operands []int
+	// Fn is the function of an OpClosure, its constant is added when the code is emitted
+	Fn *Function
+	// Callee is the instruction that pushed the function called by an OpCall, if it's known
+	Callee *Instruction
+	Line   int
+}
+
+func (ins *Instruction) String() string {
+	def, err := code.Lookup(byte(ins.Op))
+	if err != nil {
+		return fmt.Sprintf("OPCODE(%d)", ins.Op)
+	}
+	parts := []string{def.Name}
+	for i, operand := range ins.Operands {
+		if i == 0 && ins.Fn != nil {
+			parts = append(parts, functionName(ins.Fn))
+			continue
+		}
+		parts = append(parts, fmt.Sprint(operand))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Block is a basic block, the instructions always run from the first to the last and then the exit
+type Block struct {
+	ID           int
+	Instructions []*Instruction
+	Exit         Exit
+	Successors   []*Block
+	Predecessors []*Block
+	// Line is the source line of the exit
+	Line int
+}
+
+func (b *Block) String() string {
+	return fmt.Sprintf("b%d", b.ID)
+}
+
+func link(from, to *Block) {
+	from.Successors = append(from.Successors, to)
+	to.Predecessors = append(to.Predecessors, from)
+}
+
+// jump ends b with a jump to target
+func (b *Block) jump(target *Block) {
+	b.Exit = ExitJump
+	link(b, target)
+}
+
+// branch ends b with a branch on the value on top of the stack
+func (b *Block) branch(truthy, falsy *Block) {
+	b.Exit = ExitBranch
+	link(b, truthy)
+	link(b, falsy)
+}
+
+// Function is a function of the program, the main function is the program itself
+type Function struct {
+	Name          string
+	NumParameters int
+	// NumLocals is the number of local variables, the parameters included
+	NumLocals int
+	// Free are the names of the free variables, the closures of the function get their values
+	Free []string
+	// Blocks are in the order they are emitted, the first one is the entry
+	Blocks []*Block
+	// main is set for the program, its variables are globals
+	main   bool
+	nextID int
+}
+
+// newBlock adds an empty block after the last one
+func (f *Function) newBlock() *Block {
+	b := &Block{ID: f.nextID}
+	f.nextID++
+	f.Blocks = append(f.Blocks, b)
+	return b
+}
+
+// newBlockAfter adds an empty block right after previous, so it's emitted after it
+func (f *Function) newBlockAfter(previous *Block) *Block {
+	b := f.newBlock()
+	f.Blocks = f.Blocks[:len(f.Blocks)-1]
+	for i, block := range f.Blocks {
+		if block == previous {
+			f.Blocks = append(f.Blocks[:i+1], append([]*Block{b}, f.Blocks[i+1:]...)...)
+			return b
+		}
+	}
+	f.Blocks = append(f.Blocks, b)
+	return b
+}
+
+// variableOps returns the opcodes that read and write the variables of the function, the variables of the program are globals
+func (f *Function) variableOps() (get, set code.Opcode) {
+	if f.main {
+		return code.OpGetGlobal, code.OpSetGlobal
+	}
+	return code.OpGetLocal, code.OpSetLocal
+}
+
+// removeUnreachable removes the blocks that no path from the entry reaches, like the code after a return
+func (f *Function) removeUnreachable() {
+	reachable := map[*Block]bool{}
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		if reachable[b] {
+			return
+		}
+		reachable[b] = true
+		for _, s := range b.Successors {
+			visit(s)
+		}
+	}
+	visit(f.Blocks[0])
+	blocks := f.Blocks[:0]
+	for _, b := range f.Blocks {
+		if !reachable[b] {
+			continue
+		}
+		predecessors := b.Predecessors[:0]
+		for _, p := range b.Predecessors {
+			if reachable[p] {
+				predecessors = append(predecessors, p)
+			}
+		}
+		b.Predecessors = predecessors
+		blocks = append(blocks, b)
+	}
+	f.Blocks = blocks
+}
+
+func (f *Function) String() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "fn %s(parameters=%d, locals=%d, free=%d)\n", functionName(f), f.NumParameters, f.NumLocals, len(f.Free))
+	for _, b := range f.Blocks {
+		fmt.Fprintf(&out, "%s:\n", b)
+		for _, ins := range b.Instructions {
+			fmt.Fprintf(&out, "  %s\n", ins)
+		}
+		switch b.Exit {
+		case ExitJump:
+			fmt.Fprintf(&out, "  jump %s\n", b.Successors[0])
+		case ExitBranch:
+			fmt.Fprintf(&out, "  branch %s %s\n", b.Successors[0], b.Successors[1])
+		case ExitReturn:
+			out.WriteString("  return\n")
+		case ExitReturnNull:
+			out.WriteString("  return null\n")
+		case ExitHalt:
+			out.WriteString("  halt\n")
+		}
+	}
+	return out.String()
+}
+
+func functionName(f *Function) string {
+	if f.main {
+		return "<main>"
+	}
+	return object.FunctionName(f.Name)
+}
+
+// Program is the IR of a program, the constants are the ones of the main function and every function in it
+type Program struct {
+	Main      *Function
+	Constants []object.Object
+	// NumGlobals is the number of globals that the program uses
+	NumGlobals int
+}
+
+// Functions returns the main function and every function made into a closure in it, the outer ones first
+func (p *Program) Functions() []*Function {
+	functions := []*Function{}
+	seen := map[*Function]bool{}
+	var visit func(f *Function)
+	visit = func(f *Function) {
+		if seen[f] {
+			return
+		}
+		seen[f] = true
+		functions = append(functions, f)
+		for _, b := range f.Blocks {
+			for _, ins := range b.Instructions {
+				if ins.Fn != nil {
+					visit(ins.Fn)
+				}
+			}
+		}
+	}
+	visit(p.Main)
+	return functions
+}
+
+func (p *Program) String() string {
+	parts := []string{}
+	for _, f := range p.Functions() {
+		parts = append(parts, f.String())
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,362 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"xlang/ast"
+	"xlang/compiler"
+	"xlang/lexer"
+	"xlang/object"
+	"xlang/parser"
+	"xlang/vm"
+)
+
+func parse(input string) *ast.Program {
+	return parser.New(lexer.New(input)).ParseProgram()
+}
+
+// runCompiler runs the program compiled by the compiler, the reference of what the code emitted from the IR must return
+func runCompiler(t *testing.T, input string) object.Object {
+	t.Helper()
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error for %q: %s", input, err)
+	}
+	machine := vm.New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		t.Fatalf("VM error for %q: %s", input, err)
+	}
+	return machine.LastPoppedStackElem()
+}
+
+func runIR(t *testing.T, input string) object.Object {
+	t.Helper()
+	bytecode, err := Compile(parse(input))
+	if err != nil {
+		t.Fatalf("IR error for %q: %s", input, err)
+	}
+	if err := vm.Verify(bytecode); err != nil {
+		t.Fatalf("the bytecode of %q doesn't verify: %s", input, err)
+	}
+	machine := vm.New(bytecode)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("VM error for %q: %s", input, err)
+	}
+	return machine.LastPoppedStackElem()
+}
+
+func lower(t *testing.T, input string) *Program {
+	t.Helper()
+	program, err := Lower(parse(input))
+	if err != nil {
+		t.Fatalf("IR error for %q: %s", input, err)
+	}
+	return program
+}
+
+func TestSameResultsAsCompiler(t *testing.T) {
+	tests := []string{
+		`1 + 2 * 3 - 4 / 2`,
+		`-5 + 10; !true; !!5; !(1 > 2)`,
+		`1 < 2; 2 > 1; 1 == 1; 1 != 1; true == true; true != false`,
+		`"mon" + "key" + "!"`,
+		`if (1 > 2) { 10 } else { 20 }`,
+		`if (false) { 10 }`,
+		`let x = 5; if (x > 1) { if (x > 3) { 1 } else { 2 } } else { 3 }`,
+		`[1, 2 + 3, "a"][1]`,
+		`{"a": 1, 2: "b", true: 3}["a"]`,
+		`let h = set({}, "k", 5); [h["k"], set(set(h, "a", 1), "k", 2)["k"], h["a"]]`,
+		`let f = fn(a, b) { a * b }; f(3, 4) + f(1, 1)`,
+		`let f = fn() { }; f()`,
+		`let f = fn(n) { if (n > 0) { return 1; } return 2; }; f(1) + f(-1) * 10`,
+		`let add = fn(a) { fn(b) { fn(c) { a + b + c } } }; add(1)(2)(3)`,
+		`let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(15)`,
+		`let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; even(10)`,
+		`let outer = fn() { let count = fn(n) { if (n == 0) { 0 } else { 1 + count(n - 1) } }; count(5) }; outer()`,
+		`let outer = fn(x) { let y = x * 2; let inner = fn(z) { x + y + z }; inner(1) }; outer(10)`,
+		`let map = fn(arr, f) { let iter = fn(arr, acc) { if (len(arr) == 0) { acc } else { iter(shift(arr), push(acc, f(first(arr)))) } }; iter(arr, []) }; map([1, 2, 3], fn(x) { x * 2 })`,
+		`let x = 10; let f = fn(x) { x + 1 }; f(1) + x`,
+		`let f = fn(a) { let b = a + 1; let c = b + 1; [a, b, c] }; f(1)`,
+		// Local functions called before their let
+		`let w = fn() { let a = fn(n) { if (n < 1) { 0 } else { b(n - 1) } }; let b = fn(n) { a(n) + 1 }; a(4) }; w()`,
+		`let w = fn() { let mk = fn(n) { fn() { helper(n) } }; let helper = fn(x) { x * 2 }; mk(4)() }; w()`,
+		`let w = fn(k) { if (k > 0) { let a = fn() { b() + k }; let b = fn() { 7 }; a() } else { 0 } }; w(1)`,
+		// Inlined calls
+		`let add = fn(a, b) { a + b }; let twice = fn(x) { add(x, x) }; twice(4) + add(1, 2)`,
+		`let max = fn(a, b) { if (a > b) { a } else { b } }; max(3, 7) + max(9, 2)`,
+		`let sign = fn(n) { if (n < 0) { return -1; } if (n > 0) { return 1; } 0 }; [sign(-5), sign(0), sign(8)]`,
+		`let nothing = fn(a) { let b = a; }; nothing(1)`,
+		`let pair = fn(a, b) { [b, a] }; let a = 1; let b = 2; pair(a, b)`,
+		`let log = fn(h, k) { set(h, k, true) }; let h = {}; let unused = log(h, "a"); h["a"]`,
+		`let f = fn(x) { if (x) { return 5; } 6 }; 10 + f(true) + f(false)`,
+		`let f = fn(x) { 1 + if (x) { return 5; } else { 2 } }; 10 + f(true) + f(false)`,
+	}
+	for _, input := range tests {
+		expected := runCompiler(t, input)
+		got := runIR(t, input)
+		if expected.Inspect() != got.Inspect() {
+			t.Errorf("wrong result for %q. compiler=%s, IR=%s", input, expected.Inspect(), got.Inspect())
+		}
+	}
+}
+
+func TestLower(t *testing.T) {
+	program := lower(t, `let f = fn(n) { if (n > 1) { return n; } 0 }; f(2)`)
+	expected := strings.Join([]string{
+		"fn <main>(parameters=0, locals=0, free=0)",
+		"b0:",
+		"  OpClosure f 0",
+		"  OpSetGlobal 0",
+		"  OpGetGlobal 0",
+		"  OpConstant 2",
+		"  OpCall 1",
+		"  OpPop",
+		"  halt",
+		"",
+		"fn f(parameters=1, locals=1, free=0)",
+		"b0:",
+		"  OpGetLocal 0",
+		"  OpConstant 0",
+		"  OpGreaterThan",
+		"  branch b1 b3",
+		"b1:",
+		"  OpGetLocal 0",
+		"  return",
+		"b3:",
+		"  OpNull",
+		"  jump b4",
+		"b4:",
+		"  OpPop",
+		"  OpConstant 1",
+		"  return",
+		"",
+	}, "\n")
+	if program.String() != expected {
+		t.Fatalf("wrong IR.\nwant=\n%s\ngot=\n%s", expected, program)
+	}
+}
+
+func TestPasses(t *testing.T) {
+	copies := func(p *Program) {
+		for _, fn := range p.Functions() {
+			propagateCopies(fn)
+		}
+		removeDeadStores(p)
+	}
+	inlining := func(p *Program) {
+		known := knownFunctions(p)
+		for _, fn := range p.Functions() {
+			inline(p, fn, known)
+		}
+	}
+	tests := []struct {
+		name     string
+		input    string
+		passes   func(p *Program)
+		function string
+		expected []string
+	}{
+		{
+			"copies",
+			`let f = fn(a) { let b = a; let c = 2; b + c }; f(1)`,
+			copies,
+			"f",
+			[]string{
+				"fn f(parameters=1, locals=3, free=0)",
+				"b0:",
+				"  OpGetLocal 0",
+				"  OpConstant 0",
+				"  OpAdd",
+				"  return",
+			},
+		},
+		{
+			"dead stores",
+			`let f = fn(a) { let unused = a * 2; let called = len([a]); a }; f(1)`,
+			removeDeadStores,
+			"f",
+			[]string{
+				"fn f(parameters=1, locals=3, free=0)",
+				"b0:",
+				"  OpGetLocal 0",
+				"  OpConstant 0",
+				"  OpMul",
+				"  OpPop",
+				"  OpGetBuiltin 0",
+				"  OpGetLocal 0",
+				"  OpArray 1",
+				"  OpCall 1",
+				"  OpPop",
+				"  OpGetLocal 0",
+				"  return",
+			},
+		},
+		{
+			"inlining",
+			`let add = fn(a, b) { a + b }; let f = fn(x, y) { add(x * 2, y) }; f(1, 2)`,
+			inlining,
+			"f",
+			[]string{
+				"fn f(parameters=2, locals=4, free=0)",
+				"b0:",
+				"  OpGetLocal 0",
+				"  OpConstant 0",
+				"  OpMul",
+				"  OpGetLocal 1",
+				"  OpSetLocal 3",
+				"  OpSetLocal 2",
+				"  jump b3",
+				"b3:",
+				"  OpGetLocal 2",
+				"  OpGetLocal 3",
+				"  OpAdd",
+				"  jump b2",
+				"b2:",
+				"  return",
+			},
+		},
+		{
+			"functions that return with values under the result aren't inlined",
+			`let f = fn(x) { 1 + if (x) { return 5; } else { 2 } }; let g = fn() { 10 + f(true) }; g()`,
+			inlining,
+			"g",
+			[]string{
+				"fn g(parameters=0, locals=0, free=0)",
+				"b0:",
+				"  OpConstant 3",
+				"  OpGetGlobal 0",
+				"  OpTrue",
+				"  OpCall 1",
+				"  OpAdd",
+				"  return",
+			},
+		},
+		{
+			"calls that can run before the function is stored aren't inlined",
+			`let g = fn() { f(1) }; let r = g(); let f = fn(x) { x }; r`,
+			inlining,
+			"g",
+			[]string{
+				"fn g(parameters=0, locals=0, free=0)",
+				"b0:",
+				"  OpGetGlobal 0",
+				"  OpConstant 0",
+				"  OpCall 1",
+				"  return",
+			},
+		},
+		{
+			"recursive functions aren't inlined",
+			`let f = fn(n) { if (n == 0) { 0 } else { f(n - 1) } }; let g = fn() { f(3) }; g()`,
+			inlining,
+			"g",
+			[]string{
+				"fn g(parameters=0, locals=0, free=0)",
+				"b0:",
+				"  OpGetGlobal 0",
+				"  OpConstant 2",
+				"  OpCall 1",
+				"  return",
+			},
+		},
+	}
+	for _, tt := range tests {
+		program := lower(t, tt.input)
+		tt.passes(program)
+		var fn *Function
+		for _, candidate := range program.Functions() {
+			if candidate.Name == tt.function {
+				fn = candidate
+			}
+		}
+		if fn == nil {
+			t.Fatalf("%s: function %s not found in\n%s", tt.name, tt.function, program)
+		}
+		expected := strings.Join(tt.expected, "\n") + "\n"
+		if fn.String() != expected {
+			t.Errorf("%s: wrong IR.\nwant=\n%s\ngot=\n%s", tt.name, expected, fn)
+		}
+		bytecode, err := Emit(program)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if err := vm.Verify(bytecode); err != nil {
+			t.Errorf("%s: the bytecode doesn't verify: %s", tt.name, err)
+		}
+	}
+}
+
+func TestEmit(t *testing.T) {
+	bytecode, err := Compile(parse(`let f = fn(n) { if (n > 1) { f(n - 1) } else { 0 } }; f(len("ab"))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.Verify(bytecode); err != nil {
+		t.Fatalf("the bytecode doesn't verify: %s", err)
+	}
+	fn, ok := bytecode.Constants[len(bytecode.Constants)-1].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("the last constant isn't the function, got=%T", bytecode.Constants[len(bytecode.Constants)-1])
+	}
+	// The falsy branch is followed by the return, only the truthy one jumps to it
+	expected := strings.Join([]string{
+		"0000 OpGetLocal 0",
+		"0002 OpConstant 0",
+		"0005 OpGreaterThan",
+		"0006 OpJumpNotTruthy 23",
+		"0009 OpGetGlobal 0",
+		"0012 OpGetLocal 0",
+		"0014 OpConstant 0",
+		"0017 OpSub",
+		"0018 OpCall 1",
+		"0020 OpJump 26",
+		"0023 OpConstant 1",
+		"0026 OpReturnValue",
+	}, "\n")
+	if fn.Instructions.String() != expected {
+		t.Fatalf("wrong instructions.\nwant=%q\ngot= %q", expected, fn.Instructions)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, err := Compile(parse("let a = b;\nmatch (a) { _ => 1 }"))
+	expected := "line 1: error: undefined variable b\nline 2: error: the IR doesn't support MatchExpression yet"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("wrong error.\nwant=%q\ngot= %q", expected, err)
+	}
+
+	// The constructs that the IR doesn't support are rejected before lowering
+	unsupported := []struct {
+		input    string
+		expected string
+	}{
+		{"enum Shape { Empty }", "EnumStatement"},
+		{"let f = fn(x) {\n  match (x) { _ => 1 } }", "MatchExpression"},
+		{"let t = (1, 2)", "TupleLiteral"},
+		{"let (a, b) = [1, 2]", "DestructureStatement"},
+		{"let a = [1];\na[0] = 2", "IndexAssignStatement"},
+		{"let code = quote(1 + 2)", "quote"},
+	}
+	for _, tt := range unsupported {
+		program := parse(tt.input)
+		err := Supported(program)
+		lines := strings.Count(tt.input, "\n") + 1
+		expected := fmt.Sprintf("line %d: error: the IR doesn't support %s yet", lines, tt.expected)
+		if err == nil || err.Error() != expected {
+			t.Errorf("wrong error of Supported for %q.\nwant=%q\ngot= %q", tt.input, expected, err)
+		}
+		if _, err := Compile(program); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("wrong error of Compile for %q.\nwant=%q\ngot= %q", tt.input, expected, err)
+		}
+	}
+	if err := Supported(parse("let f = fn(a) { if (a > 1) { [a, {\"k\": -a}][0] } else { f(a + 1) } }; f(1)")); err != nil {
+		t.Errorf("Supported rejects a program that the IR lowers: %s", err)
+	}
+
+	_, err = Compile(parse("let g = fn() { f() };\nlet f = fn() { 1 };\nlet f = fn() { 2 };\ng()"))
+	expected = "line 3: error: can't define f again, the functions defined before its first let use it"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("wrong error.\nwant=%q\ngot= %q", expected, err)
+	}
+}
@@ -0,0 +1,458 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+	"xlang/ast"
+	"xlang/code"
+	"xlang/compiler"
+	"xlang/object"
+)
+
+// lowerer builds the IR of a program, it uses the symbol table of the compiler so the variables
+// resolve the same way as in the bytecode that the compiler makes
+type lowerer struct {
+	program     *Program
+	constants   compiler.Constants
+	symbolTable *compiler.SymbolTable
+	fn          *Function
+	// current is the block where the instructions are added
+	current *Block
+	// hoisted are the symbols of the functions declared before their let
+	hoisted map[*ast.LetStatement]compiler.Symbol
+	// uninitialized are the locals of the current function that are hoisted functions not set yet
+	uninitialized map[int]bool
+	// fixups are the free variables of closures that captured a hoisted function before its let
+	fixups []fixup
+	report compiler.Reporter
+}
+
+// fixup is the free variable free of the closure kept in the local closure, it's set when the local
+// function target is
+type fixup struct {
+	closure compiler.Symbol
+	free    int
+	target  compiler.Symbol
+}
+
+// Lower builds the IR of program, like the compiler it returns every error as compiler.Diagnostics
+func Lower(program *ast.Program) (*Program, error) {
+	table := compiler.NewSymbolTable()
+	for i, fn := range object.GetBuiltins() {
+		table.DefineBuiltin(i, fn.Name)
+	}
+	main := &Function{main: true}
+	l := &lowerer{
+		program:       &Program{Main: main},
+		symbolTable:   table,
+		fn:            main,
+		current:       main.newBlock(),
+		hoisted:       map[*ast.LetStatement]compiler.Symbol{},
+		uninitialized: map[int]bool{},
+	}
+	l.hoist(program.Statements)
+	for _, s := range program.Statements {
+		l.statement(s)
+	}
+	l.current.Exit = ExitHalt
+	main.removeUnreachable()
+	if l.report.Diagnostics.HasErrors() {
+		return nil, l.report.Diagnostics
+	}
+	l.program.Constants = l.constants.Objects
+	return l.program, nil
+}
+
+func (l *lowerer) emit(op code.Opcode, operands ...int) *Instruction {
+	ins := &Instruction{Op: op, Operands: operands, Line: l.report.Line}
+	l.current.Instructions = append(l.current.Instructions, ins)
+	return ins
+}
+
+// exit ends the current block, the instructions that follow go to a new block that nothing reaches
+func (l *lowerer) exit(exit Exit) {
+	l.current.Exit = exit
+	l.current.Line = l.report.Line
+	l.current = l.fn.newBlock()
+}
+
+func (l *lowerer) unsupported(node ast.Node) {
+	l.report.Errorf(node, "the IR doesn't support %s yet", nodeName(node))
+}
+
+// nodeName is the name of the kind of node in the errors
+func nodeName(node ast.Node) string {
+	if isQuote(node) {
+		return "quote"
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+}
+
+// isQuote returns if node is a call to quote, the compiler makes a constant of its code
+func isQuote(node ast.Node) bool {
+	call, ok := node.(*ast.CallExpression)
+	if !ok {
+		return false
+	}
+	ident, ok := call.Function.(*ast.Identifier)
+	return ok && ident.Value == "quote"
+}
+
+// Supported returns nil when Lower supports every node of program, or the error that Lower would return
+// for the first one that it doesn't support, like an enum or a match. The IR only lowers the core of the
+// language, so the callers that can compile the program without it, like xlang compile --ir, check it first
+func Supported(program *ast.Program) error {
+	var unsupported ast.Node
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node.(type) {
+		case *ast.Program, *ast.LetStatement, *ast.ReturnStatement, *ast.ExpressionStatement, *ast.BlockStatement,
+			*ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean, *ast.Identifier, *ast.PrefixExpression,
+			*ast.InfixExpression, *ast.IfExpression, *ast.ArrayLiteral, *ast.HashLiteral, *ast.IndexExpression,
+			*ast.FunctionLiteral, *ast.CallExpression:
+			if !isQuote(node) {
+				return unsupported == nil
+			}
+		}
+		if unsupported == nil {
+			unsupported = node
+		}
+		return false
+	})
+	if unsupported == nil {
+		return nil
+	}
+	var report compiler.Reporter
+	report.Errorf(unsupported, "the IR doesn't support %s yet", nodeName(unsupported))
+	return report.Diagnostics
+}
+
+// Symbols
+
+// hoist declares the functions defined with let that compiler.Hoisted returns, so they can call each other.
+// A closure copies its free variables when it's created, so the ones that capture a local function before
+// its let are fixed by initialize, like the compiler does
+func (l *lowerer) hoist(statements []ast.Statement) {
+	for _, name := range compiler.Redefined(statements) {
+		l.report.Errorf(name, compiler.RedefinedFormat, name.Value)
+	}
+	for _, let := range compiler.HoistedLets(statements) {
+		symbol := l.define(let.Name.Value)
+		l.hoisted[let] = symbol
+		if symbol.Scope == compiler.LocalScope {
+			l.uninitialized[symbol.Index] = true
+		}
+	}
+}
+
+// keepForFixups keeps the closure on top of the stack in a hidden local when it captured local functions
+// that aren't set yet, so initialize can set its free variables
+func (l *lowerer) keepForFixups(free []compiler.Symbol) {
+	var closure *compiler.Symbol
+	for i, symbol := range free {
+		if symbol.Scope != compiler.LocalScope || !l.uninitialized[symbol.Index] {
+			continue
+		}
+		if closure == nil {
+			// The name can't be referenced by the program
+			hidden := l.define("")
+			l.store(hidden)
+			l.load(hidden)
+			closure = &hidden
+		}
+		l.fixups = append(l.fixups, fixup{closure: *closure, free: i, target: symbol})
+	}
+}
+
+// initialize marks a local function as set and sets the free variables of the closures that captured it before
+func (l *lowerer) initialize(symbol compiler.Symbol) {
+	if symbol.Scope != compiler.LocalScope || !l.uninitialized[symbol.Index] {
+		return
+	}
+	delete(l.uninitialized, symbol.Index)
+	pending := l.fixups[:0]
+	for _, fixup := range l.fixups {
+		if fixup.target != symbol {
+			pending = append(pending, fixup)
+			continue
+		}
+		l.load(fixup.closure)
+		l.load(symbol)
+		l.emit(code.OpSetFree, fixup.free)
+	}
+	l.fixups = pending
+}
+
+// define adds a variable to the current function
+func (l *lowerer) define(name string) compiler.Symbol {
+	symbol := l.symbolTable.Define(name)
+	if symbol.Scope == compiler.GlobalScope && symbol.Index >= l.program.NumGlobals {
+		l.program.NumGlobals = symbol.Index + 1
+	}
+	if symbol.Scope == compiler.LocalScope && symbol.Index >= l.fn.NumLocals {
+		l.fn.NumLocals = symbol.Index + 1
+	}
+	return symbol
+}
+
+func (l *lowerer) load(symbol compiler.Symbol) *Instruction {
+	switch symbol.Scope {
+	case compiler.GlobalScope:
+		return l.emit(code.OpGetGlobal, symbol.Index)
+	case compiler.BuiltinScope:
+		return l.emit(code.OpGetBuiltin, symbol.Index)
+	case compiler.FreeScope:
+		return l.emit(code.OpGetFree, symbol.Index)
+	}
+	return l.emit(code.OpGetLocal, symbol.Index)
+}
+
+func (l *lowerer) store(symbol compiler.Symbol) {
+	if symbol.Scope == compiler.GlobalScope {
+		l.emit(code.OpSetGlobal, symbol.Index)
+		return
+	}
+	l.emit(code.OpSetLocal, symbol.Index)
+}
+
+// Statements
+
+func (l *lowerer) statement(s ast.Statement) {
+	defer l.report.SetLine(s)()
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		symbol, hoisted := l.hoisted[s]
+		// A function can call itself, the other values are lowered before the name is defined,
+		// so they use the previous definition
+		_, isFunction := s.Value.(*ast.FunctionLiteral)
+		if !hoisted && isFunction {
+			symbol = l.define(s.Name.Value)
+		}
+		l.expression(s.Value)
+		if !hoisted && !isFunction {
+			symbol = l.define(s.Name.Value)
+		}
+		l.store(symbol)
+		l.initialize(symbol)
+	case *ast.ReturnStatement:
+		l.expression(s.ReturnValue)
+		if l.fn.main {
+			// A return in the program stops it with its value
+			l.emit(code.OpPop)
+			l.exit(ExitHalt)
+			return
+		}
+		l.exit(ExitReturn)
+	case *ast.ExpressionStatement:
+		l.expression(s.Expression)
+		l.emit(code.OpPop)
+	case *ast.BlockStatement:
+		l.hoist(s.Statements)
+		for _, inner := range s.Statements {
+			l.statement(inner)
+		}
+	default:
+		l.unsupported(s)
+	}
+}
+
+// block lowers the statements of a block and leaves the value of the last expression on the stack
+func (l *lowerer) block(block *ast.BlockStatement) {
+	if len(block.Statements) == 0 {
+		l.emit(code.OpNull)
+		return
+	}
+	l.hoist(block.Statements)
+	last := len(block.Statements) - 1
+	for _, s := range block.Statements[:last] {
+		l.statement(s)
+	}
+	if s, ok := block.Statements[last].(*ast.ExpressionStatement); ok {
+		defer l.report.SetLine(s)()
+		l.expression(s.Expression)
+		return
+	}
+	l.statement(block.Statements[last])
+	l.emit(code.OpNull)
+}
+
+// Expressions
+
+func (l *lowerer) expression(node ast.Expression) {
+	defer l.report.SetLine(node)()
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		l.emit(code.OpConstant, l.constants.Add(&object.Integer{Value: node.Value}))
+	case *ast.StringLiteral:
+		l.emit(code.OpConstant, l.constants.Add(object.InternString(node.Value)))
+	case *ast.Boolean:
+		if node.Value {
+			l.emit(code.OpTrue)
+		} else {
+			l.emit(code.OpFalse)
+		}
+	case *ast.Identifier:
+		symbol, ok := l.symbolTable.Resolve(node.Value)
+		if !ok {
+			l.report.Errorf(node, "undefined variable %s", node.Value)
+			l.emit(code.OpNull)
+			return
+		}
+		l.load(symbol)
+	case *ast.PrefixExpression:
+		l.expression(node.Right)
+		switch node.Operator {
+		case "-":
+			l.emit(code.OpMinus)
+		case "!":
+			l.emit(code.OpBang)
+		default:
+			l.report.Errorf(node, "unknown prefix operator: %s", node.Operator)
+		}
+	case *ast.InfixExpression:
+		l.infix(node)
+	case *ast.IfExpression:
+		l.expression(node.Condition)
+		condition := l.current
+		truthy := l.fn.newBlock()
+		l.current = truthy
+		l.block(node.Consequence)
+		truthyEnd := l.current
+		falsy := l.fn.newBlock()
+		condition.branch(truthy, falsy)
+		condition.Line = l.report.Line
+		l.current = falsy
+		if node.Alternative != nil {
+			l.block(node.Alternative)
+		} else {
+			l.emit(code.OpNull)
+		}
+		falsyEnd := l.current
+		merge := l.fn.newBlock()
+		truthyEnd.jump(merge)
+		falsyEnd.jump(merge)
+		l.current = merge
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			l.expression(element)
+		}
+		l.emit(code.OpArray, len(node.Elements))
+	case *ast.HashLiteral:
+		for _, key := range node.Keys {
+			l.expression(key)
+			l.expression(node.Pairs[key])
+		}
+		l.emit(code.OpHash, 2*len(node.Keys))
+	case *ast.IndexExpression:
+		l.expression(node.Left)
+		l.expression(node.Right)
+		l.emit(code.OpIndex)
+	case *ast.FunctionLiteral:
+		l.function(node)
+	case *ast.CallExpression:
+		if isQuote(node) {
+			l.unsupported(node)
+			l.emit(code.OpNull)
+			return
+		}
+		block, start := l.current, len(l.current.Instructions)
+		l.expression(node.Function)
+		var callee *Instruction
+		if l.current == block && len(block.Instructions) == start+1 {
+			callee = block.Instructions[start]
+		}
+		for _, argument := range node.Arguments {
+			l.expression(argument)
+		}
+		l.emit(code.OpCall, len(node.Arguments)).Callee = callee
+	default:
+		l.unsupported(node)
+		l.emit(code.OpNull)
+	}
+}
+
+func (l *lowerer) infix(node *ast.InfixExpression) {
+	left, right := node.Left, node.Right
+	if node.Operator == "<" {
+		left, right = right, left
+	}
+	l.expression(left)
+	l.expression(right)
+	switch node.Operator {
+	case "+":
+		l.emit(code.OpAdd)
+	case "-":
+		l.emit(code.OpSub)
+	case "*":
+		l.emit(code.OpMul)
+	case "/":
+		l.emit(code.OpDiv)
+	case "==":
+		l.emit(code.OpEqual)
+	case "!=":
+		l.emit(code.OpNotEqual)
+	case ">", "<":
+		l.emit(code.OpGreaterThan)
+	default:
+		l.report.Errorf(node, "unknown operator %s", node.Operator)
+	}
+}
+
+// function lowers the function literal to a new function and leaves a closure of it on the stack
+func (l *lowerer) function(node *ast.FunctionLiteral) {
+	outer, outerBlock := l.fn, l.current
+	uninitialized, fixups := l.uninitialized, l.fixups
+	l.uninitialized, l.fixups = map[int]bool{}, nil
+	// A function defined with let inside another function references itself through a local,
+	// the variable of the outer function is only set after the closure is made
+	selfReference := node.Name != "" && !outer.main
+	fn := &Function{Name: node.Name, NumParameters: len(node.Parameters)}
+	l.fn = fn
+	l.current = fn.newBlock()
+	l.symbolTable = compiler.NewEnclosedSymbolTable(l.symbolTable)
+	for _, parameter := range node.Parameters {
+		l.define(parameter.Value)
+		if parameter.Value == node.Name {
+			selfReference = false
+		}
+	}
+	if selfReference {
+		l.emit(code.OpCurrentClosure)
+		l.store(l.define(node.Name))
+	}
+	l.body(node.Body)
+	fn.removeUnreachable()
+
+	free := l.symbolTable.FreeSymbols
+	for _, symbol := range free {
+		fn.Free = append(fn.Free, symbol.Name)
+	}
+	l.symbolTable = l.symbolTable.Outer
+	l.fn, l.current = outer, outerBlock
+	l.uninitialized, l.fixups = uninitialized, fixups
+	for _, symbol := range free {
+		l.load(symbol)
+	}
+	l.emit(code.OpClosure, 0, len(free)).Fn = fn
+	l.keepForFixups(free)
+}
+
+// body lowers the statements of a function, the value of the last expression is returned
+func (l *lowerer) body(block *ast.BlockStatement) {
+	if len(block.Statements) == 0 {
+		l.exit(ExitReturnNull)
+		return
+	}
+	l.hoist(block.Statements)
+	last := len(block.Statements) - 1
+	for _, s := range block.Statements[:last] {
+		l.statement(s)
+	}
+	if s, ok := block.Statements[last].(*ast.ExpressionStatement); ok {
+		defer l.report.SetLine(s)()
+		l.expression(s.Expression)
+		l.exit(ExitReturn)
+		return
+	}
+	l.statement(block.Statements[last])
+	l.exit(ExitReturnNull)
+}
@@ -0,0 +1,457 @@
+package ir
+
+import "xlang/code"
+
+// Optimize runs the passes on every function of the program: the copies are propagated first so the
+// inlined functions are smaller, then the calls are inlined, which stores the arguments in variables that
+// can be copies again, and at last the stores that nothing reads are removed
+func Optimize(p *Program) {
+	for _, fn := range p.Functions() {
+		propagateCopies(fn)
+	}
+	known := knownFunctions(p)
+	// The inner functions go first, so the functions are inlined with the calls in them already inlined
+	functions := p.Functions()
+	for i := len(functions) - 1; i >= 0; i-- {
+		inline(p, functions[i], known)
+	}
+	for _, fn := range p.Functions() {
+		propagateCopies(fn)
+	}
+	removeDeadStores(p)
+}
+
+// position is the place of an instruction in a function
+type position struct {
+	block *Block
+	index int
+}
+
+// dominators returns the blocks that every path from the entry to each block goes through, the block included
+func dominators(fn *Function) map[*Block]map[*Block]bool {
+	all := map[*Block]bool{}
+	for _, b := range fn.Blocks {
+		all[b] = true
+	}
+	dom := map[*Block]map[*Block]bool{}
+	for _, b := range fn.Blocks {
+		dom[b] = all
+	}
+	dom[fn.Blocks[0]] = map[*Block]bool{fn.Blocks[0]: true}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range fn.Blocks[1:] {
+			set := map[*Block]bool{b: true}
+			for candidate := range dom[b] {
+				inAll := len(b.Predecessors) > 0
+				for _, p := range b.Predecessors {
+					if !dom[p][candidate] {
+						inAll = false
+						break
+					}
+				}
+				if inAll {
+					set[candidate] = true
+				}
+			}
+			if len(set) != len(dom[b]) {
+				dom[b] = set
+				changed = true
+			}
+		}
+	}
+	return dom
+}
+
+// dominates returns if the instruction at a always runs before the one at b
+func dominates(dom map[*Block]map[*Block]bool, a, b position) bool {
+	if a.block == b.block {
+		return a.index < b.index
+	}
+	return dom[b.block][a.block]
+}
+
+// stores returns the positions of the instructions of fn that write each variable
+func stores(fn *Function) map[int][]position {
+	_, set := fn.variableOps()
+	result := map[int][]position{}
+	for _, b := range fn.Blocks {
+		for i, ins := range b.Instructions {
+			if ins.Op == set {
+				result[ins.Operands[0]] = append(result[ins.Operands[0]], position{b, i})
+			}
+		}
+	}
+	return result
+}
+
+// pushedBy returns the index of the instruction of b that pushed the value on top of the stack when the
+// instruction at index runs, -1 when it was pushed before the block or it isn't the only value pushed by it
+func pushedBy(b *Block, index int) int {
+	depth := 0
+	for i := index - 1; i >= 0; i-- {
+		ins := b.Instructions[i]
+		pops, pushes := code.StackEffect(ins.Op, ins.Operands)
+		if depth < pushes {
+			if pushes == 1 {
+				return i
+			}
+			return -1
+		}
+		depth += pops - pushes
+	}
+	return -1
+}
+
+// propagateCopies replaces the reads of a variable that holds a constant or a copy of another variable
+// by the value itself, like the b of let b = a; b + 1. The variables are written once, so wherever the store
+// has run the variable has the same value, and the one copied has it too as it was written before the copy
+func propagateCopies(fn *Function) {
+	get, _ := fn.variableOps()
+	written := stores(fn)
+	dom := dominators(fn)
+	for variable, positions := range written {
+		if len(positions) != 1 {
+			continue
+		}
+		store := positions[0]
+		pushed := pushedBy(store.block, store.index)
+		if pushed < 0 {
+			continue
+		}
+		value := store.block.Instructions[pushed]
+		if !isCopy(fn, value, position{store.block, pushed}, written, dom) {
+			continue
+		}
+		for _, b := range fn.Blocks {
+			for i, ins := range b.Instructions {
+				if ins.Op == get && ins.Operands[0] == variable && dominates(dom, store, position{b, i}) {
+					// Changed in place, an OpCall can point to the instruction
+					ins.Op = value.Op
+					ins.Operands = append([]int{}, value.Operands...)
+				}
+			}
+		}
+	}
+}
+
+// isCopy returns if the instruction at pos pushes a value that is the same whenever it's read later
+func isCopy(fn *Function, value *Instruction, pos position, written map[int][]position, dom map[*Block]map[*Block]bool) bool {
+	get, _ := fn.variableOps()
+	switch value.Op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetBuiltin, code.OpGetFree, code.OpCurrentClosure:
+		return true
+	case code.OpGetGlobal:
+		if !fn.main {
+			// Only the program writes the globals, they don't change while a function runs
+			return true
+		}
+	}
+	if value.Op != get {
+		return false
+	}
+	source := written[value.Operands[0]]
+	if len(source) == 0 {
+		return !fn.main && value.Operands[0] < fn.NumParameters
+	}
+	return len(source) == 1 && dominates(dom, source[0], pos)
+}
+
+// inlineLimit is the number of instructions of the biggest function that is inlined
+const inlineLimit = 16
+
+// knownFunctions returns the functions stored in the globals that are written only once
+func knownFunctions(p *Program) map[int]*Function {
+	known := map[int]*Function{}
+	for variable, positions := range stores(p.Main) {
+		if len(positions) != 1 {
+			continue
+		}
+		pushed := pushedBy(positions[0].block, positions[0].index)
+		if pushed < 0 {
+			continue
+		}
+		value := positions[0].block.Instructions[pushed]
+		if value.Op == code.OpClosure && value.Operands[1] == 0 {
+			known[variable] = value.Fn
+		}
+	}
+	return known
+}
+
+// inlinable returns if the calls of the function in the global can be replaced by its body: it's small,
+// it doesn't make closures that could take its variables, it doesn't call itself and it returns with
+// only its result on the stack, as the values left under it would stay in the caller
+func inlinable(callee *Function, global int) bool {
+	size := 0
+	for _, b := range callee.Blocks {
+		size += len(b.Instructions)
+		for _, ins := range b.Instructions {
+			switch {
+			case ins.Op == code.OpClosure, ins.Op == code.OpCurrentClosure, ins.Op == code.OpGetFree:
+				return false
+			case ins.Op == code.OpGetGlobal && ins.Operands[0] == global:
+				return false
+			}
+		}
+	}
+	if size > inlineLimit {
+		return false
+	}
+	heights, ok := exitHeights(callee)
+	if !ok {
+		return false
+	}
+	for _, b := range callee.Blocks {
+		if b.Exit == ExitReturn && heights[b] != 1 || b.Exit == ExitReturnNull && heights[b] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// exitHeights returns the number of values on the stack of fn at the exit of each block, before a branch pops
+// its condition. ok is false when the paths that reach a block don't have the same number
+func exitHeights(fn *Function) (heights map[*Block]int, ok bool) {
+	entries := map[*Block]int{fn.Blocks[0]: 0}
+	heights = map[*Block]int{}
+	work := []*Block{fn.Blocks[0]}
+	for len(work) > 0 {
+		b := work[len(work)-1]
+		work = work[:len(work)-1]
+		height := entries[b]
+		for _, ins := range b.Instructions {
+			pops, pushes := code.StackEffect(ins.Op, ins.Operands)
+			height += pushes - pops
+		}
+		heights[b] = height
+		if b.Exit == ExitBranch {
+			height--
+		}
+		for _, s := range b.Successors {
+			entry, seen := entries[s]
+			if !seen {
+				entries[s] = height
+				work = append(work, s)
+			} else if entry != height {
+				return nil, false
+			}
+		}
+	}
+	return heights, true
+}
+
+// createdAt returns the position in the main function after which the code of fn can run: the instruction
+// itself in the main function, for the others the closure of the function or of the one that has it in main
+func createdAt(p *Program, fn *Function, ins *Instruction) position {
+	parents := map[*Function]*Function{}
+	closures := map[*Function]*Instruction{}
+	for _, f := range p.Functions() {
+		for _, b := range f.Blocks {
+			for _, candidate := range b.Instructions {
+				if candidate.Fn != nil {
+					parents[candidate.Fn] = f
+					closures[candidate.Fn] = candidate
+				}
+			}
+		}
+	}
+	for ; fn != p.Main; fn = parents[fn] {
+		ins = closures[fn]
+	}
+	return find(p.Main, ins)
+}
+
+// inline replaces the calls of fn to the small functions of globals written once by their body.
+// The arguments are stored in new variables of fn and the returns jump to the code after the call.
+// The calls that can run before the global is written aren't inlined, they fail as the variable isn't
+// defined yet: the call must come after the store in the main function, or the closure of fn must
+func inline(p *Program, fn *Function, known map[int]*Function) {
+	written := stores(p.Main)
+	dom := dominators(p.Main)
+	calls := []*Instruction{}
+	for _, b := range fn.Blocks {
+		for _, ins := range b.Instructions {
+			if ins.Op != code.OpCall || ins.Callee == nil || ins.Callee.Op != code.OpGetGlobal {
+				continue
+			}
+			global := ins.Callee.Operands[0]
+			callee, ok := known[global]
+			if !ok || callee == fn || callee.NumParameters != ins.Operands[0] || !inlinable(callee, global) {
+				continue
+			}
+			if dominates(dom, written[global][0], createdAt(p, fn, ins)) {
+				calls = append(calls, ins)
+			}
+		}
+	}
+	for _, call := range calls {
+		inlineCall(p, fn, call, known[call.Callee.Operands[0]])
+	}
+	if len(calls) > 0 {
+		fn.removeUnreachable()
+	}
+}
+
+// find returns the position of ins in fn
+func find(fn *Function, ins *Instruction) position {
+	for _, b := range fn.Blocks {
+		for i, candidate := range b.Instructions {
+			if candidate == ins {
+				return position{b, i}
+			}
+		}
+	}
+	panic("ir: instruction not found in its function")
+}
+
+func inlineCall(p *Program, fn *Function, call *Instruction, callee *Function) {
+	get, set := fn.variableOps()
+	base := fn.NumLocals
+	if fn.main {
+		base = p.NumGlobals
+		p.NumGlobals += callee.NumLocals
+	} else {
+		fn.NumLocals += callee.NumLocals
+	}
+
+	// The function isn't pushed anymore, the arguments are stored in its variables
+	pushed := find(fn, call.Callee)
+	pushed.block.Instructions = append(pushed.block.Instructions[:pushed.index], pushed.block.Instructions[pushed.index+1:]...)
+	at := find(fn, call)
+	b := at.block
+	after := fn.newBlockAfter(b)
+	after.Instructions = append(after.Instructions, b.Instructions[at.index+1:]...)
+	after.Exit, after.Line = b.Exit, b.Line
+	after.Successors = b.Successors
+	for _, s := range after.Successors {
+		for i, predecessor := range s.Predecessors {
+			if predecessor == b {
+				s.Predecessors[i] = after
+			}
+		}
+	}
+	b.Instructions = b.Instructions[:at.index]
+	b.Successors = nil
+	for i := callee.NumParameters - 1; i >= 0; i-- {
+		b.Instructions = append(b.Instructions, &Instruction{Op: set, Operands: []int{base + i}, Line: call.Line})
+	}
+
+	// The blocks of the callee go between the call and the code after it
+	blocks := map[*Block]*Block{}
+	previous := b
+	for _, original := range callee.Blocks {
+		blocks[original] = fn.newBlockAfter(previous)
+		previous = blocks[original]
+	}
+	instructions := map[*Instruction]*Instruction{}
+	for _, original := range callee.Blocks {
+		clone := blocks[original]
+		for _, ins := range original.Instructions {
+			copied := &Instruction{Op: ins.Op, Operands: append([]int{}, ins.Operands...), Fn: ins.Fn, Callee: instructions[ins.Callee], Line: ins.Line}
+			switch ins.Op {
+			case code.OpGetLocal:
+				copied.Op, copied.Operands[0] = get, base+ins.Operands[0]
+			case code.OpSetLocal:
+				copied.Op, copied.Operands[0] = set, base+ins.Operands[0]
+			}
+			instructions[ins] = copied
+			clone.Instructions = append(clone.Instructions, copied)
+		}
+		clone.Line = original.Line
+		switch original.Exit {
+		case ExitJump:
+			clone.jump(blocks[original.Successors[0]])
+		case ExitBranch:
+			clone.branch(blocks[original.Successors[0]], blocks[original.Successors[1]])
+		case ExitReturn:
+			clone.jump(after)
+		case ExitReturnNull:
+			clone.Instructions = append(clone.Instructions, &Instruction{Op: code.OpNull, Line: original.Line})
+			clone.jump(after)
+		}
+	}
+	b.jump(blocks[callee.Blocks[0]])
+}
+
+// pureOperands returns how many values ins pops when it has no other effect than pushing its result,
+// ok is false for the instructions that can fail or change something
+func pureOperands(ins *Instruction) (n int, ok bool) {
+	switch ins.Op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull, code.OpGetLocal, code.OpGetGlobal,
+		code.OpGetBuiltin, code.OpGetFree, code.OpCurrentClosure:
+		return 0, true
+	case code.OpArray, code.OpHash:
+		return ins.Operands[0], true
+	case code.OpClosure:
+		return ins.Operands[1], true
+	}
+	return 0, false
+}
+
+// removeDeadStores removes the stores of the variables that nothing reads. The value is still computed
+// if it can have effects, like a call, and then popped
+func removeDeadStores(p *Program) {
+	functions := p.Functions()
+	readGlobals := map[int]bool{}
+	for _, fn := range functions {
+		for _, b := range fn.Blocks {
+			for _, ins := range b.Instructions {
+				if ins.Op == code.OpGetGlobal {
+					readGlobals[ins.Operands[0]] = true
+				}
+			}
+		}
+	}
+	for _, fn := range functions {
+		read := readGlobals
+		if !fn.main {
+			read = map[int]bool{}
+			for _, b := range fn.Blocks {
+				for _, ins := range b.Instructions {
+					if ins.Op == code.OpGetLocal {
+						read[ins.Operands[0]] = true
+					}
+				}
+			}
+		}
+		_, set := fn.variableOps()
+		for _, b := range fn.Blocks {
+			dropped := map[*Instruction]bool{}
+			for _, ins := range b.Instructions {
+				if ins.Op == set && !read[ins.Operands[0]] {
+					ins.Op, ins.Operands = code.OpPop, nil
+					dropped[ins] = true
+				}
+			}
+			b.Instructions = removeDropped(b.Instructions, dropped)
+		}
+	}
+}
+
+// removeDropped removes the pops in dropped together with the instructions without effects that pushed the value,
+// the values that those instructions popped are dropped too
+func removeDropped(instructions []*Instruction, dropped map[*Instruction]bool) []*Instruction {
+	for i := 1; i < len(instructions); i++ {
+		if !dropped[instructions[i]] {
+			continue
+		}
+		n, ok := pureOperands(instructions[i-1])
+		if !ok {
+			continue
+		}
+		pops := make([]*Instruction, n)
+		for j := range pops {
+			pops[j] = &Instruction{Op: code.OpPop, Line: instructions[i].Line}
+			dropped[pops[j]] = true
+		}
+		rest := append(pops, instructions[i+1:]...)
+		instructions = append(instructions[:i-1], rest...)
+		// The pops take the place of the instruction removed, they can drop the one before it
+		i = i - 2
+		if i < 0 {
+			i = 0
+		}
+	}
+	return instructions
+}
@@ -13,6 +13,7 @@ type Lexer struct {
 	readPosition int  // next position after current char
 	ch           byte // current char
 	Line         uint64
+	lineStart    int // position of the first char of the current line
 }
 
 // New Returns a new Lexer
@@ -40,6 +41,7 @@ func (l *Lexer) skipWhiteSpace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\r' || l.ch == '\n' {
 		if l.ch == '\n' {
 			l.Line++
+			l.lineStart = l.position + 1
 		}
 		l.readChar()
 	}
@@ -66,6 +68,9 @@ func (l *Lexer) peekerForTwoChars(expect byte, otherwise token.Token, t token.Ty
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 	l.skipWhiteSpace()
+	// The line of the token, before reading it
+	line := l.Line
+	column := l.position - l.lineStart + 1
 	switch l.ch {
 	case ':':
 		tok = newToken(token.COLON, l.ch)
@@ -80,20 +85,27 @@ func (l *Lexer) NextToken() token.Token {
 		tok.Literal = l.readString()
 	case '=':
 		tok = l.peekerForTwoChars('=', newToken(token.ASSIGN, '='), token.EQ)
+		if tok.Type == token.ASSIGN {
+			tok = l.peekerForTwoChars('>', tok, token.ARROW)
+		}
+	case '|':
+		tok = l.peekerForTwoChars('>', newToken(token.ILLEGAL, '|'), token.PIPE)
 	case '!':
 		tok = l.peekerForTwoChars('=', newToken(token.BANG, '!'), token.NOTEQ)
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
+	case '.':
+		tok = newToken(token.DOT, l.ch)
 	case '(':
 		tok = newToken(token.LPAREN, l.ch)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.peekerForTwoChars('=', newToken(token.PLUS, l.ch), token.PLUSASSIGN)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok = l.peekerForTwoChars('=', newToken(token.MINUS, l.ch), token.MINUSASSIGN)
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		tok = l.peekerForTwoChars('=', newToken(token.SLASH, l.ch), token.SLASHASSIGN)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		tok = l.peekerForTwoChars('=', newToken(token.ASTERISK, l.ch), token.ASTERISKASSIGN)
 	case '<':
 		tok = newToken(token.LT, l.ch)
 	case '>':
@@ -115,16 +127,22 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Literal = l.readIndentifier()
 			// lookup the literal in the keyword table, if it doesn't exist it's a IDENT.
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Line = line
+			tok.Column = column
 			return tok
 		}
 		if isDigit(l.ch) {
 			tok.Type = token.INT
 			tok.Literal = l.readNumber()
+			tok.Line = line
+			tok.Column = column
 			return tok
 		}
 		tok = newToken(token.ILLEGAL, l.ch)
 	}
 	l.readChar()
+	tok.Line = line
+	tok.Column = column
 
 	return tok
 }
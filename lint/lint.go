@@ -0,0 +1,315 @@
+// Package lint finds the code that is valid but probably wrong: bindings that are never used,
+// names that hide other bindings or builtins, statements after a return, calls with the wrong number
+// of arguments to the functions of the program and comparisons between literals of different types
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"xlang/ast"
+	"xlang/compiler"
+	"xlang/object"
+)
+
+// Check returns the warnings of the program sorted by position
+func Check(program *ast.Program) compiler.Diagnostics {
+	l := &linter{hoisted: map[*ast.LetStatement]*binding{}, builtins: map[string]bool{}}
+	for _, builtin := range object.GetBuiltins() {
+		l.builtins[builtin.Name] = true
+	}
+	l.scope = newScope(nil)
+	l.statements(program.Statements)
+	l.leaveScope()
+	sort.SliceStable(l.diagnostics, func(i, j int) bool {
+		a, b := l.diagnostics[i], l.diagnostics[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	return l.diagnostics
+}
+
+// binding is a name defined with let, a parameter or a binding of a match pattern
+type binding struct {
+	name *ast.Identifier
+	used bool
+	// parameter is set for the parameters, which don't have to be used
+	parameter bool
+	// parameters is the number of parameters when the value is a function literal, -1 otherwise
+	parameters int
+}
+
+// scope is the bindings of a function or of the program, blocks don't have their own scope
+type scope struct {
+	outer *scope
+	store map[string]*binding
+	// hoisted are the functions defined later in the scope, which can be referenced before their let
+	hoisted map[string]*binding
+	// all are the bindings in the order they were defined, including the ones hidden by a later let
+	all []*binding
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{outer: outer, store: map[string]*binding{}, hoisted: map[string]*binding{}}
+}
+
+type linter struct {
+	scope       *scope
+	hoisted     map[*ast.LetStatement]*binding
+	builtins    map[string]bool
+	diagnostics compiler.Diagnostics
+}
+
+func (l *linter) warnf(node *ast.Identifier, format string, a ...interface{}) {
+	l.diagnostics = append(l.diagnostics, compiler.Diagnostic{
+		Severity: compiler.SeverityWarning,
+		Line:     int(node.Token.Line),
+		Column:   node.Token.Column,
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+// resolve returns the binding of name, nil for the builtins and the undefined names
+func (l *linter) resolve(name string) *binding {
+	for s := l.scope; s != nil; s = s.outer {
+		if b, ok := s.store[name]; ok {
+			return b
+		}
+		if b, ok := s.hoisted[name]; ok {
+			return b
+		}
+	}
+	return nil
+}
+
+// define adds the binding to the current scope, warning when it hides another name
+func (l *linter) define(b *binding) {
+	name := b.name.Value
+	if previous, ok := l.scope.store[name]; ok {
+		l.warnf(b.name, "%s is already defined on line %d, this definition hides it", name, previous.name.Token.Line)
+	} else if outer := l.resolveOuter(name); outer != nil {
+		l.warnf(b.name, "%s shadows the %s defined on line %d", name, kind(outer), outer.name.Token.Line)
+	} else if l.builtins[name] {
+		l.warnf(b.name, "%s shadows the builtin function %s", name, name)
+	}
+	delete(l.scope.hoisted, name)
+	l.scope.store[name] = b
+	l.scope.all = append(l.scope.all, b)
+}
+
+// resolveOuter returns the binding of name in the scopes around the current one
+func (l *linter) resolveOuter(name string) *binding {
+	for s := l.scope.outer; s != nil; s = s.outer {
+		if b, ok := s.store[name]; ok {
+			return b
+		}
+	}
+	return nil
+}
+
+func kind(b *binding) string {
+	if b.parameter {
+		return "parameter"
+	}
+	return "variable"
+}
+
+func (l *linter) enterScope() {
+	l.scope = newScope(l.scope)
+}
+
+// leaveScope warns about the bindings of the scope that were never used
+func (l *linter) leaveScope() {
+	for _, b := range l.scope.all {
+		if !b.used && !b.parameter && !strings.HasPrefix(b.name.Value, "_") {
+			l.warnf(b.name, "%s is never used", b.name.Value)
+		}
+	}
+	l.scope = l.scope.outer
+}
+
+// statements checks a list of statements that run in order, like a block or the program
+func (l *linter) statements(statements []ast.Statement) {
+	l.hoist(statements)
+	returned := false
+	for _, s := range statements {
+		if returned {
+			if line := s.Line(); line != 0 {
+				l.diagnostics = append(l.diagnostics, compiler.Diagnostic{
+					Severity: compiler.SeverityWarning,
+					Line:     int(line),
+					Message:  "unreachable code after return",
+				})
+			}
+			returned = false
+		}
+		l.node(s)
+		if _, ok := s.(*ast.ReturnStatement); ok {
+			returned = true
+		}
+	}
+}
+
+// hoist declares the functions that compiler.Hoisted returns, like the compiler does, so they can be used before their definition
+func (l *linter) hoist(statements []ast.Statement) {
+	for _, let := range compiler.HoistedLets(statements) {
+		fn := let.Value.(*ast.FunctionLiteral)
+		b := &binding{name: let.Name, parameters: len(fn.Parameters)}
+		l.hoisted[let] = b
+		l.scope.hoisted[let.Name.Value] = b
+	}
+}
+
+func (l *linter) node(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		b, ok := l.hoisted[node]
+		if !ok {
+			b = &binding{name: node.Name, parameters: -1}
+			if fn, ok := node.Value.(*ast.FunctionLiteral); ok {
+				b.parameters = len(fn.Parameters)
+			}
+			// The value uses the previous binding of the name
+			l.node(node.Value)
+			l.define(b)
+			return
+		}
+		l.define(b)
+		l.node(node.Value)
+	case *ast.DestructureStatement:
+		l.node(node.Value)
+		for _, name := range node.Names {
+			l.define(&binding{name: name, parameters: -1})
+		}
+	case *ast.IndexAssignStatement:
+		l.node(node.Target)
+		l.node(node.Value)
+	case *ast.EnumStatement:
+		l.define(&binding{name: node.Name, parameters: -1})
+	case *ast.ReturnStatement:
+		l.node(node.ReturnValue)
+	case *ast.ExpressionStatement:
+		l.node(node.Expression)
+	case *ast.BlockStatement:
+		l.statements(node.Statements)
+	case *ast.Identifier:
+		if b := l.resolve(node.Value); b != nil {
+			b.used = true
+		}
+	case *ast.PrefixExpression:
+		l.node(node.Right)
+	case *ast.InfixExpression:
+		l.node(node.Left)
+		l.node(node.Right)
+		l.comparison(node)
+	case *ast.IfExpression:
+		l.node(node.Condition)
+		l.node(node.Consequence)
+		if node.Alternative != nil {
+			l.node(node.Alternative)
+		}
+	case *ast.FunctionLiteral:
+		l.function(node.Parameters, node.Body)
+	case *ast.MacroLiteral:
+		l.function(node.Parameters, node.Body)
+	case *ast.CallExpression:
+		l.node(node.Function)
+		for _, argument := range node.Arguments {
+			l.node(argument)
+		}
+		l.arity(node)
+	case *ast.ArrayLiteral:
+		for _, element := range node.Elements {
+			l.node(element)
+		}
+	case *ast.TupleLiteral:
+		for _, element := range node.Elements {
+			l.node(element)
+		}
+	case *ast.HashLiteral:
+		for _, key := range node.Keys {
+			l.node(key)
+			l.node(node.Pairs[key])
+		}
+	case *ast.IndexExpression:
+		l.node(node.Left)
+		l.node(node.Right)
+	case *ast.MatchExpression:
+		l.node(node.Subject)
+		for _, arm := range node.Arms {
+			if arm.Pattern != nil {
+				l.node(arm.Pattern.Enum)
+				for _, name := range arm.Pattern.Bindings {
+					l.define(&binding{name: name, parameters: -1})
+				}
+			}
+			l.node(arm.Body)
+		}
+	}
+}
+
+func (l *linter) function(parameters []*ast.Identifier, body *ast.BlockStatement) {
+	l.enterScope()
+	for _, parameter := range parameters {
+		l.define(&binding{name: parameter, parameter: true, parameters: -1})
+	}
+	l.node(body)
+	l.leaveScope()
+}
+
+// arity warns about the calls to a function of the program with a different number of arguments than its parameters
+func (l *linter) arity(call *ast.CallExpression) {
+	ident, ok := call.Function.(*ast.Identifier)
+	if !ok {
+		return
+	}
+	b := l.resolve(ident.Value)
+	if b == nil || b.parameters < 0 || b.parameters == len(call.Arguments) {
+		return
+	}
+	l.warnf(ident, "%s takes %d arguments but is called with %d", ident.Value, b.parameters, len(call.Arguments))
+}
+
+// literalType returns the type of the value of a literal, "" for the other expressions
+func literalType(exp ast.Expression) object.ObjectType {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return object.IntegerObject
+	case *ast.StringLiteral:
+		return object.StringObject
+	case *ast.Boolean:
+		return object.BooleanObject
+	case *ast.ArrayLiteral:
+		return object.ArrayObject
+	case *ast.HashLiteral:
+		return object.HashObject
+	case *ast.FunctionLiteral:
+		return object.FunctionObject
+	case *ast.PrefixExpression:
+		if exp.Operator == "!" {
+			return object.BooleanObject
+		}
+		if exp.Operator == "-" && literalType(exp.Right) == object.IntegerObject {
+			return object.IntegerObject
+		}
+	}
+	return ""
+}
+
+// comparison warns about the comparisons between literals of different types, which have always the same result or fail
+func (l *linter) comparison(node *ast.InfixExpression) {
+	switch node.Operator {
+	case "==", "!=", "<", ">":
+	default:
+		return
+	}
+	left, right := literalType(node.Left), literalType(node.Right)
+	if left == "" || right == "" || left == right {
+		return
+	}
+	l.diagnostics = append(l.diagnostics, compiler.Diagnostic{
+		Severity: compiler.SeverityWarning,
+		Line:     int(node.Token.Line),
+		Column:   node.Token.Column,
+		Message:  fmt.Sprintf("comparison of %s and %s with %s", left, right, node.Operator),
+	})
+}
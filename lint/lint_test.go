@@ -0,0 +1,75 @@
+package lint
+
+import (
+	"reflect"
+	"testing"
+	"xlang/lexer"
+	"xlang/parser"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			"let a = 1;\nlet b = 2;\nlog(a)",
+			[]string{"line 2:5: warning: b is never used"},
+		},
+		{
+			// The value of a let uses the previous definition
+			"let a = [1];\nlet a = push(a, 2);\nlog(a)",
+			[]string{"line 2:5: warning: a is already defined on line 1, this definition hides it"},
+		},
+		{
+			"let len = fn(x) { 0 };\nlen([1])",
+			[]string{"line 1:5: warning: len shadows the builtin function len"},
+		},
+		{
+			"let x = 1;\nlet f = fn(x) { let y = x; y };\nf(x)",
+			[]string{"line 2:12: warning: x shadows the variable defined on line 1"},
+		},
+		{
+			"let f = fn(n) {\n  return n;\n  n + 1\n};\nf(1)",
+			[]string{"line 3: warning: unreachable code after return"},
+		},
+		{
+			// Functions can be called before their definition
+			"let a = fn(n) { b(n, 1) };\nlet b = fn(n) { a() };\na(1)",
+			[]string{
+				"line 1:17: warning: b takes 1 arguments but is called with 2",
+				"line 2:17: warning: a takes 1 arguments but is called with 0",
+			},
+		},
+		{
+			"true == \"false\";\n1 < [1];\n-1 != 2;\n!true == false",
+			[]string{
+				"line 1:6: warning: comparison of BOOL and STRING with ==",
+				"line 2:3: warning: comparison of INTEGER and ARRAY with <",
+			},
+		},
+		{
+			"enum Shape { Circle(r), Empty }\nlet area = fn(s) { match (s) { Shape.Circle(r) => 3 * r, Shape.Empty => 0 } };\nlet (_, unused) = (1, 2);\narea(Shape.Empty)",
+			[]string{"line 3:9: warning: unused is never used"},
+		},
+		{
+			"let m = macro(a) { quote(unquote(a) + 1) };\nm(1)",
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("parser errors for %q: %v", tt.input, p.Errors())
+		}
+		var got []string
+		for _, diagnostic := range Check(program) {
+			got = append(got, diagnostic.String())
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("wrong warnings for %q.\nwant=%q\ngot= %q", tt.input, tt.expected, got)
+		}
+	}
+}
@@ -7,6 +7,9 @@ import (
 
 func main() {
 	// http.RunServer()
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:], os.Stdout, os.Stderr))
+	}
 
 	repl.StartVM(os.Stdin, os.Stdout)
 	// repl.Start(os.Stdin, os.Stdout)
@@ -2,9 +2,15 @@ package object
 
 import "strings"
 
-// Array stores inside an array objects
+// Array stores inside an array objects. Its elements are a persistent vector, so push, set, pop,
+// shift and unshift return a new array that shares most of its elements with the original one
 type Array struct {
-	Elements []Object
+	elements vector
+}
+
+// NewArray returns an array with the elements, the slice isn't used after it returns
+func NewArray(elements []Object) *Array {
+	return &Array{elements: newVector(elements)}
 }
 
 // Type .
@@ -14,13 +20,60 @@ func (a *Array) Type() ObjectType { return ArrayObject }
 func (a *Array) Inspect() string {
 	var builder strings.Builder
 	builder.WriteByte('[')
-	for idx, obj := range a.Elements {
-		builder.WriteString(obj.Inspect())
-		if idx == len(a.Elements)-1 {
-			break
+	for idx, obj := range a.Elements() {
+		if idx > 0 {
+			builder.WriteByte(',')
 		}
-		builder.WriteByte(',')
+		builder.WriteString(obj.Inspect())
 	}
 	builder.WriteByte(']')
 	return builder.String()
 }
+
+// Len returns the number of elements
+func (a *Array) Len() int {
+	return a.elements.len()
+}
+
+// Get returns the element i, it must be between 0 and Len() - 1
+func (a *Array) Get(i int) Object {
+	return a.elements.get(i)
+}
+
+// Elements returns the elements in a new slice
+func (a *Array) Elements() []Object {
+	return a.elements.slice()
+}
+
+// Set returns a copy of the array with value as the element i, it must be between 0 and Len() - 1
+func (a *Array) Set(i int, value Object) *Array {
+	return &Array{elements: a.elements.set(i, value)}
+}
+
+// Push returns a copy of the array with the values after its elements
+func (a *Array) Push(values ...Object) *Array {
+	elements := a.elements
+	for _, value := range values {
+		elements = elements.push(value)
+	}
+	return &Array{elements: elements}
+}
+
+// Unshift returns a copy of the array with the values before its elements, in the same order
+func (a *Array) Unshift(values ...Object) *Array {
+	elements := a.elements
+	for i := len(values) - 1; i >= 0; i-- {
+		elements = elements.unshift(values[i])
+	}
+	return &Array{elements: elements}
+}
+
+// Pop returns a copy of the array without the last element, the array can't be empty
+func (a *Array) Pop() *Array {
+	return &Array{elements: a.elements.pop()}
+}
+
+// Shift returns a copy of the array without the first element, the array can't be empty
+func (a *Array) Shift() *Array {
+	return &Array{elements: a.elements.shiftFirst()}
+}
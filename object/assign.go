@@ -0,0 +1,25 @@
+package object
+
+import "fmt"
+
+// SetIndex returns a copy of container with value at index, it's what container[index] = value assigns.
+// Arrays and hashmaps are values, they are copied when they are written, so the variables and the
+// other arrays or hashmaps that hold the original one don't see the change
+func SetIndex(container, index, value Object) (Object, error) {
+	switch container := container.(type) {
+	case *Array:
+		integer, ok := index.(*Integer)
+		if !ok {
+			return nil, fmt.Errorf("expected integer got=%s", index.Type())
+		}
+		if integer.Value < 0 || integer.Value >= int64(container.Len()) {
+			return nil, fmt.Errorf("index %d out of range, the array has %d elements", integer.Value, container.Len())
+		}
+		return container.Set(int(integer.Value), value), nil
+	case *HashMap:
+		hash := container.copy()
+		hash.Put(index, value)
+		return hash, nil
+	}
+	return nil, fmt.Errorf("can't assign an index of %s, expected an ARRAY or a HASH", container.Type())
+}
@@ -18,3 +18,28 @@ func (b *Boolean) Type() ObjectType {
 func (b *Boolean) Inspect() string {
 	return fmt.Sprintf("%t", b.Value)
 }
+
+// TRUE and FALSE are the booleans of the programs, they are compared by pointer
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+)
+
+// NativeToBoolean returns TRUE or FALSE
+func NativeToBoolean(b bool) *Boolean {
+	if b {
+		return TRUE
+	}
+	return FALSE
+}
+
+// IsTruthy returns if o is true for an if, only false and null aren't
+func IsTruthy(o Object) bool {
+	switch o := o.(type) {
+	case *Boolean:
+		return o.Value
+	case *Null:
+		return false
+	}
+	return true
+}
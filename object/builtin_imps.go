@@ -13,6 +13,8 @@ func Len(args ...Object) Object {
 	case *String:
 		return &Integer{Value: int64(len(newObject.Value))}
 	case *Array:
+		return &Integer{Value: int64(newObject.Len())}
+	case *Tuple:
 		return &Integer{Value: int64(len(newObject.Elements))}
 	}
 	return NewError("Unexpected type: %s for function len()", args[0].Type())
@@ -25,8 +27,7 @@ func Push(args ...Object) Object {
 	}
 	switch newObject := args[0].(type) {
 	case *Array:
-		newElements := append(newObject.Elements[:], args[1:]...)
-		return &Array{Elements: newElements}
+		return newObject.Push(args[1:]...)
 	}
 	return NewError("Unexpected type for push(); got %s", args[0].Type())
 }
@@ -56,10 +57,10 @@ func First(args ...Object) Object {
 	if !ok {
 		return NewError("Unexpected type for first(); got %s", args[0].Type())
 	}
-	if len(arr.Elements) == 0 {
+	if arr.Len() == 0 {
 		return nil
 	}
-	return arr.Elements[0]
+	return arr.Get(0)
 }
 
 // Shift deletes first element
@@ -71,10 +72,10 @@ func Shift(args ...Object) Object {
 	if !ok {
 		return NewError("Unexpected type for shift(); got %s", args[0].Type())
 	}
-	if len(arr.Elements) == 0 {
+	if arr.Len() == 0 {
 		return nil
 	}
-	return &Array{Elements: arr.Elements[1:]}
+	return arr.Shift()
 }
 
 // Pop deletes last element of array
@@ -86,10 +87,10 @@ func Pop(args ...Object) Object {
 	if !ok {
 		return NewError("Unexpected type for pop(); got %s", args[0].Type())
 	}
-	if len(arr.Elements) == 0 {
+	if arr.Len() == 0 {
 		return arr
 	}
-	return &Array{Elements: arr.Elements[:len(arr.Elements)-1]}
+	return arr.Pop()
 }
 
 // Unshift adds an element from the beginning of the array
@@ -102,11 +103,11 @@ func Unshift(args ...Object) Object {
 	if !ok {
 		return NewError("Unexpected type for unshift(); got %s", args[0].Type())
 	}
-	elements := append(args[1:], arr.Elements[:]...)
-	return &Array{Elements: elements}
+	return arr.Unshift(args[1:]...)
 }
 
-// Set sets a specific item of the array to the third parameter of the function
+// Set returns a copy of the array or the hashmap with the item of the second parameter set to the third one,
+// like arr[i] = v it doesn't change the original one
 func Set(args ...Object) Object {
 	if len(args) < 3 {
 		return NewError("Expected 3 arguments or more, got %d", len(args))
@@ -123,30 +124,20 @@ func Set(args ...Object) Object {
 	if !ok {
 		return NewError("Unexpected type for set(); got %s", args[0].Type())
 	}
-	if int(number.Value) >= len(arr.Elements) || int(number.Value) < 0 {
+	if int(number.Value) >= arr.Len() || int(number.Value) < 0 {
 		return nil
 	}
-	arrElements := make([]Object, len(arr.Elements))
-	copy(arrElements, arr.Elements)
-	newArr := &Array{Elements: arrElements}
-
-	newArr.Elements[number.Value] = args[2]
-	return newArr
+	return arr.Set(int(number.Value), args[2])
 }
 
-// SetHash creates a new entry in the hashmap
+// SetHash returns a copy of the hashmap with a new entry
 func SetHash(arg *HashMap, newKey Object, setVal Object) Object {
-	hashable, ok := newKey.(Hashable)
-	if !ok {
-		arg.UnhashablePairs[newKey] = HashPair{Key: newKey, Value: setVal}
-		return setVal
-	}
-	hashed := hashable.HashKey()
-	arg.Pairs[hashed] = HashPair{Key: newKey, Value: setVal}
-	return setVal
+	hash := arg.copy()
+	hash.Put(newKey, setVal)
+	return hash
 }
 
-// Keys is the keys() function, accepts one hashtable and returns the keys of this
+// Keys is the keys() function, accepts one hashtable and returns the keys of this in the order they were added
 func Keys(args ...Object) Object {
 	if len(args) != 1 {
 		return NewError("Error: Expected 1 argument on keys() but got %d", len(args))
@@ -155,19 +146,30 @@ func Keys(args ...Object) Object {
 	if !ok {
 		return NewError("Error: Expected object of type HashTable on keys(), got: %s", args[0].Type())
 	}
-	arr := Array{Elements: make([]Object, 0, len(hashTable.Pairs)+len(hashTable.UnhashablePairs))}
-	for _, obj := range hashTable.Pairs {
-		key := obj.Key
-		arr.Elements = append(arr.Elements, key)
+	keys := make([]Object, 0, hashTable.Len())
+	for _, obj := range hashTable.Pairs() {
+		keys = append(keys, obj.Key)
 	}
-	for _, obj := range hashTable.UnhashablePairs {
-		key := obj.Key
-		arr.Elements = append(arr.Elements, key)
+	return NewArray(keys)
+}
+
+// Values is the values() function, it returns the values of a hashtable in the same order as keys()
+func Values(args ...Object) Object {
+	if len(args) != 1 {
+		return NewError("Error: Expected 1 argument on values() but got %d", len(args))
 	}
-	return &arr
+	hashTable, ok := args[0].(*HashMap)
+	if !ok {
+		return NewError("Error: Expected object of type HashTable on values(), got: %s", args[0].Type())
+	}
+	values := make([]Object, 0, hashTable.Len())
+	for _, obj := range hashTable.Pairs() {
+		values = append(values, obj.Value)
+	}
+	return NewArray(values)
 }
 
-// Delete a key from a hash table
+// Delete returns a copy of the hash table without the key, like set() the hash table that it gets doesn't change
 func Delete(args ...Object) Object {
 	if len(args) != 2 {
 		return NewError("Error: Expected 2 argument on delete() but got %d", len(args))
@@ -176,22 +178,9 @@ func Delete(args ...Object) Object {
 	if !ok {
 		return NewError("Error: Expected HashMap as firs argument on delete() but got %s", args[0].Type())
 	}
-	hashable, ok := args[1].(Hashable)
-	if ok {
-		hashed := hashable.HashKey()
-		h, ok := hash.Pairs[hashed]
-		if !ok {
-			return nil
-		}
-		delete(hash.Pairs, hashed)
-		return h.Value
-	}
-	h, ok := hash.UnhashablePairs[args[1]]
-	if !ok {
-		return nil
-	}
-	delete(hash.UnhashablePairs, args[1])
-	return h.Value
+	copied := hash.copy()
+	copied.remove(args[1])
+	return copied
 }
 
 func newError(format string, a ...interface{}) *Error {
@@ -217,8 +206,8 @@ func Last(args ...Object) Object {
 	if !k {
 		return NewError("Error: Expected Array as first argument on last() but got %s", args[0].Type())
 	}
-	if len(arr.Elements) == 0 {
+	if arr.Len() == 0 {
 		return nil
 	}
-	return arr.Elements[len(arr.Elements)-1]
+	return arr.Get(arr.Len() - 1)
 }
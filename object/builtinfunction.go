@@ -3,9 +3,19 @@ package object
 // BuiltinFunction is built in code inside Xlang
 type BuiltinFunction func(args ...Object) Object
 
-// Builtin is a builtin function in Xlang
+// Caller calls the functions of a program, the VMs and the evaluator implement it so the builtins can call them back
+type Caller interface {
+	// CallFunction calls fn with the arguments and returns its result, a runtime error of fn is returned as the error
+	CallFunction(fn Object, args ...Object) (Object, error)
+}
+
+// CallerFunction is a builtin that calls the functions that it gets, like map
+type CallerFunction func(caller Caller, args ...Object) (Object, error)
+
+// Builtin is a builtin function in Xlang, it has Fn or CallerFn
 type Builtin struct {
-	Fn BuiltinFunction
+	Fn       BuiltinFunction
+	CallerFn CallerFunction
 }
 
 // Type .
@@ -13,3 +23,11 @@ func (b *Builtin) Type() ObjectType { return BuiltinObject }
 
 // Inspect .
 func (b *Builtin) Inspect() string { return "builtin function" }
+
+// Call calls the builtin, caller runs the functions that it gets as arguments
+func (b *Builtin) Call(caller Caller, args ...Object) (Object, error) {
+	if b.CallerFn != nil {
+		return b.CallerFn(caller, args...)
+	}
+	return b.Fn(args...), nil
+}
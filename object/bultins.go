@@ -46,11 +46,7 @@ var builtins = []struct {
 				if len(args) == 1 {
 					return &Log{Message: args[0]}
 				}
-				arr := Array{Elements: make([]Object, 0, len(args))}
-				for _, arg := range args {
-					arr.Elements = append(arr.Elements, arg)
-				}
-				return &Log{Message: &arr}
+				return &Log{Message: NewArray(args)}
 			},
 		},
 	},
@@ -62,6 +58,31 @@ var builtins = []struct {
 	{"delete",
 		&Builtin{Fn: Delete},
 	},
+
+	{"map",
+		&Builtin{CallerFn: Map},
+	},
+	{"filter",
+		&Builtin{CallerFn: Filter},
+	},
+	{"reduce",
+		&Builtin{CallerFn: Reduce},
+	},
+	{"sort",
+		&Builtin{CallerFn: Sort},
+	},
+	{"find",
+		&Builtin{CallerFn: Find},
+	},
+	{"any",
+		&Builtin{CallerFn: Any},
+	},
+	{"all",
+		&Builtin{CallerFn: All},
+	},
+	{"values",
+		&Builtin{Fn: Values},
+	},
 }
 
 // GetBuiltins objects
@@ -10,6 +10,12 @@ type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	// Name is the name of the variable the function was assigned to, empty if it's anonymous
+	Name string
+	// Lines maps the instructions to the lines of the source that produced them
+	Lines code.LineTable
+	// Free are the names of the free variables of the closures of the function
+	Free []string
 }
 
 // Type .
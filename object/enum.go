@@ -0,0 +1,130 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+	"xlang/ast"
+)
+
+// Enum is a tagged union declared with enum Name { Variant(fields...), ... }
+type Enum struct {
+	Name     string
+	Variants []*VariantDefinition
+}
+
+// VariantDefinition is one of the cases of an enum
+type VariantDefinition struct {
+	Enum   *Enum
+	Name   string
+	Fields []string
+	// Constructor is a builtin that returns a new variant, or the variant itself if it doesn't have fields
+	Constructor Object
+}
+
+// Variant is a value of an enum, like Shape.Circle(3)
+type Variant struct {
+	Definition *VariantDefinition
+	Values     []Object
+}
+
+// NewEnum returns an enum with the constructors of its variants, fields[i] are the fields of the variant names[i]
+func NewEnum(name string, names []string, fields [][]string) *Enum {
+	enum := &Enum{Name: name, Variants: make([]*VariantDefinition, 0, len(names))}
+	for i, variantName := range names {
+		def := &VariantDefinition{Enum: enum, Name: variantName, Fields: fields[i]}
+		if len(def.Fields) == 0 {
+			def.Constructor = &Variant{Definition: def}
+		} else {
+			def.Constructor = &Builtin{Fn: def.construct}
+		}
+		enum.Variants = append(enum.Variants, def)
+	}
+	return enum
+}
+
+// EnumFromStatement returns the enum declared in an enum statement
+func EnumFromStatement(node *ast.EnumStatement) *Enum {
+	names := make([]string, 0, len(node.Variants))
+	fields := make([][]string, 0, len(node.Variants))
+	for _, variant := range node.Variants {
+		names = append(names, variant.Name.Value)
+		variantFields := make([]string, 0, len(variant.Fields))
+		for _, field := range variant.Fields {
+			variantFields = append(variantFields, field.Value)
+		}
+		fields = append(fields, variantFields)
+	}
+	return NewEnum(node.Name.Value, names, fields)
+}
+
+// Type .
+func (e *Enum) Type() ObjectType { return EnumObject }
+
+// Inspect .
+func (e *Enum) Inspect() string {
+	variants := make([]string, 0, len(e.Variants))
+	for _, def := range e.Variants {
+		variants = append(variants, def.String())
+	}
+	return fmt.Sprintf("enum %s { %s }", e.Name, strings.Join(variants, ", "))
+}
+
+// Variant returns the definition of the variant with that name
+func (e *Enum) Variant(name string) (*VariantDefinition, bool) {
+	for _, def := range e.Variants {
+		if def.Name == name {
+			return def, true
+		}
+	}
+	return nil, false
+}
+
+// Get returns the constructor of a variant, it's what Shape.Circle returns
+func (e *Enum) Get(name string) Object {
+	def, ok := e.Variant(name)
+	if !ok {
+		return NewError("Enum %s doesn't have a variant %s", e.Name, name)
+	}
+	return def.Constructor
+}
+
+// Tag is the name of the variant with its enum like Shape.Circle
+func (vd *VariantDefinition) Tag() string {
+	return vd.Enum.Name + "." + vd.Name
+}
+
+func (vd *VariantDefinition) String() string {
+	if len(vd.Fields) == 0 {
+		return vd.Name
+	}
+	return fmt.Sprintf("%s(%s)", vd.Name, strings.Join(vd.Fields, ", "))
+}
+
+func (vd *VariantDefinition) construct(args ...Object) Object {
+	if len(args) != len(vd.Fields) {
+		return NewError("%s expects %d arguments, got %d", vd.Tag(), len(vd.Fields), len(args))
+	}
+	values := make([]Object, len(args))
+	copy(values, args)
+	return &Variant{Definition: vd, Values: values}
+}
+
+// Type .
+func (v *Variant) Type() ObjectType { return VariantObject }
+
+// Inspect .
+func (v *Variant) Inspect() string {
+	if len(v.Values) == 0 {
+		return v.Definition.Tag()
+	}
+	values := make([]string, 0, len(v.Values))
+	for _, value := range v.Values {
+		values = append(values, value.Inspect())
+	}
+	return fmt.Sprintf("%s(%s)", v.Definition.Tag(), strings.Join(values, ", "))
+}
+
+// Is returns if the variant has the tag passed, like Shape.Circle
+func (v *Variant) Is(tag string) bool {
+	return v.Definition.Tag() == tag
+}
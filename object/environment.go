@@ -4,6 +4,8 @@ package object
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+	// rebound is set on the environments that Assign adds, the other names are set in the outer one
+	rebound bool
 }
 
 // NewEnvironment returns a new environment ref
@@ -29,6 +31,34 @@ func (e *Environment) Get(name string) (Object, bool) {
 
 // Set sets a new value into the environment
 func (e *Environment) Set(name string, val Object) Object {
+	if _, ok := e.store[name]; e.rebound && !ok {
+		return e.outer.Set(name, val)
+	}
 	e.store[name] = val
 	return val
 }
+
+// Assign changes a variable of the function of this environment or a global one, it returns the environment
+// that the function must use from now and if there was a variable. The variables of the enclosing functions
+// can't be assigned. Like in the VM, where the closures have a copy of the variables, a variable of the function
+// is rebound in a new environment, so the closures created before keep seeing the previous value
+func (e *Environment) Assign(name string, val Object) (*Environment, bool) {
+	local := e
+	for ; local.outer != nil; local = local.outer {
+		if _, ok := local.store[name]; ok {
+			return &Environment{store: map[string]Object{name: val}, outer: e, rebound: true}, true
+		}
+		if !local.rebound {
+			break
+		}
+	}
+	global := e
+	for global.outer != nil {
+		global = global.outer
+	}
+	if _, ok := global.store[name]; ok {
+		global.store[name] = val
+		return e, true
+	}
+	return e, false
+}
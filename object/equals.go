@@ -0,0 +1,138 @@
+package object
+
+// comparison is a pair of values that is being compared
+type comparison struct {
+	left, right Object
+}
+
+// Equal returns if left and right are the same value. The integers, strings, booleans, arrays, hashmaps,
+// tuples and variants are compared by their contents and the rest of the objects, like the functions,
+// by their identity
+func Equal(left, right Object) bool {
+	return equal(left, right, nil)
+}
+
+// Equals returns if other is an integer with the same value
+func (i *Integer) Equals(other Object) bool {
+	otherInteger, ok := other.(*Integer)
+	return ok && otherInteger.Value == i.Value
+}
+
+// Equals returns if other is a string with the same text
+func (s *String) Equals(other Object) bool {
+	otherString, ok := other.(*String)
+	return ok && otherString.Value == s.Value
+}
+
+// Equals returns if other is a boolean with the same value
+func (b *Boolean) Equals(other Object) bool {
+	otherBoolean, ok := other.(*Boolean)
+	return ok && otherBoolean.Value == b.Value
+}
+
+// Equals returns if other is null
+func (n *Null) Equals(other Object) bool {
+	_, ok := other.(*Null)
+	return ok
+}
+
+// Equals returns if other is an array with equal elements in the same order
+func (a *Array) Equals(other Object) bool {
+	return equal(a, other, nil)
+}
+
+// Equals returns if other is a hashmap with equal keys that have equal values
+func (h *HashMap) Equals(other Object) bool {
+	return equal(h, other, nil)
+}
+
+// Equals returns if other is a tuple with the same values
+func (t *Tuple) Equals(other Object) bool {
+	return equal(t, other, nil)
+}
+
+// Equals returns if other is the same variant with the same values
+func (v *Variant) Equals(other Object) bool {
+	return equal(v, other, nil)
+}
+
+// equal compares left and right, seen are the arrays, hashmaps, tuples and variants that are being compared
+// so a value that contains itself doesn't compare forever
+func equal(left, right Object, seen map[comparison]bool) bool {
+	if left == right {
+		return true
+	}
+	switch left := left.(type) {
+	case *Integer:
+		return left.Equals(right)
+	case *String:
+		return left.Equals(right)
+	case *Boolean:
+		return left.Equals(right)
+	case *Null:
+		return left.Equals(right)
+	case *Array:
+		otherArray, ok := right.(*Array)
+		if !ok || otherArray.Len() != left.Len() {
+			return false
+		}
+		return equalElements(left, otherArray, left.Elements(), otherArray.Elements(), seen)
+	case *Tuple:
+		otherTuple, ok := right.(*Tuple)
+		if !ok || len(otherTuple.Elements) != len(left.Elements) {
+			return false
+		}
+		return equalElements(left, otherTuple, left.Elements, otherTuple.Elements, seen)
+	case *Variant:
+		otherVariant, ok := right.(*Variant)
+		if !ok || otherVariant.Definition != left.Definition || len(otherVariant.Values) != len(left.Values) {
+			return false
+		}
+		return equalElements(left, otherVariant, left.Values, otherVariant.Values, seen)
+	case *HashMap:
+		otherHash, ok := right.(*HashMap)
+		if !ok || otherHash.Len() != left.Len() {
+			return false
+		}
+		seen, compared := enter(seen, left, otherHash)
+		if compared {
+			return true
+		}
+		for _, pair := range left.Pairs() {
+			otherPair, ok := otherHash.pair(pair.Key, seen)
+			if !ok || !equal(pair.Value, otherPair.Value, seen) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// equalElements compares the elements of the arrays, tuples or variants left and right
+func equalElements(left, right Object, leftElements, rightElements []Object, seen map[comparison]bool) bool {
+	seen, compared := enter(seen, left, right)
+	if compared {
+		return true
+	}
+	for i, element := range leftElements {
+		if !equal(element, rightElements[i], seen) {
+			return false
+		}
+	}
+	return true
+}
+
+// enter adds the comparison of left and right to seen, compared is true if they were already being compared,
+// then left contains itself and the comparison that is still running decides if they are equal
+func enter(seen map[comparison]bool, left, right Object) (map[comparison]bool, bool) {
+	if seen == nil {
+		seen = map[comparison]bool{}
+	}
+	key := comparison{left: left, right: right}
+	if seen[key] {
+		return seen, true
+	}
+	seen[key] = true
+	return seen, false
+}
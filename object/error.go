@@ -5,6 +5,8 @@ import "fmt"
 // Error represents an error running the AST
 type Error struct {
 	Message string
+	// Trace are the calls that were running when the error happened
+	Trace StackTrace
 }
 
 // Type .
@@ -13,6 +15,9 @@ func (e *Error) Type() ObjectType { return ErrorObject }
 // Inspect .
 func (e *Error) Inspect() string { return fmt.Sprintf("Error: %s", e.Message) }
 
+// Error returns the message, so the error of a function that a builtin called can be returned as a Go error
+func (e *Error) Error() string { return e.Message }
+
 // NewError returns a new error
 func NewError(format string, a ...interface{}) *Error {
 	return &Error{Message: fmt.Sprintf(format, a...)}
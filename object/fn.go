@@ -10,6 +10,8 @@ type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
+	// Name is the name of the variable the function was assigned to, empty if it's anonymous
+	Name string
 }
 
 // Type returns interface type
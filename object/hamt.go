@@ -0,0 +1,156 @@
+package object
+
+import "math/bits"
+
+const hamtBits = 5
+
+// hamtNode is a node of a hash array mapped trie, the bits of the hash choose a slot in each level and
+// bitmap has the slots that are used, so slots only has room for them
+type hamtNode struct {
+	bitmap uint32
+	slots  []hamtSlot
+}
+
+// hamtSlot has a node when its keys have different hashes, otherwise it has the entries of the keys with hash
+type hamtSlot struct {
+	node    *hamtNode
+	hash    uint64
+	entries []hamtEntry
+}
+
+// hamtEntry is a key and the position of its pair
+type hamtEntry struct {
+	key      Object
+	position int
+}
+
+// mixHashKey turns the key in the hash of the trie, the type is in it so an integer and a boolean with the
+// same value don't share a slot
+func mixHashKey(key HashKey) uint64 {
+	hash := key.Value
+	for i := 0; i < len(key.Type); i++ {
+		hash = (hash ^ uint64(key.Type[i])) * 1099511628211
+	}
+	return hash
+}
+
+func hamtIndex(hash uint64, shift uint) (uint32, uint32) {
+	bit := uint32(1) << ((hash >> shift) & (1<<hamtBits - 1))
+	return bit, bit - 1
+}
+
+// find returns the position of the key, seen are the values that are being compared
+func (n *hamtNode) find(hash uint64, shift uint, key Object, seen map[comparison]bool) (int, bool) {
+	for n != nil {
+		bit, below := hamtIndex(hash, shift)
+		if n.bitmap&bit == 0 {
+			return 0, false
+		}
+		slot := n.slots[bits.OnesCount32(n.bitmap&below)]
+		if slot.node == nil {
+			if slot.hash != hash {
+				return 0, false
+			}
+			for _, entry := range slot.entries {
+				if equal(entry.key, key, seen) {
+					return entry.position, true
+				}
+			}
+			return 0, false
+		}
+		n = slot.node
+		shift += hamtBits
+	}
+	return 0, false
+}
+
+// insert returns a copy of n with the entry, the key can't be in n
+func (n *hamtNode) insert(hash uint64, shift uint, entry hamtEntry) *hamtNode {
+	if n == nil {
+		n = &hamtNode{}
+	}
+	bit, below := hamtIndex(hash, shift)
+	i := bits.OnesCount32(n.bitmap & below)
+	updated := &hamtNode{bitmap: n.bitmap | bit}
+	if n.bitmap&bit == 0 {
+		updated.slots = make([]hamtSlot, 0, len(n.slots)+1)
+		updated.slots = append(updated.slots, n.slots[:i]...)
+		updated.slots = append(updated.slots, hamtSlot{hash: hash, entries: []hamtEntry{entry}})
+		updated.slots = append(updated.slots, n.slots[i:]...)
+		return updated
+	}
+	updated.slots = make([]hamtSlot, len(n.slots))
+	copy(updated.slots, n.slots)
+	slot := n.slots[i]
+	switch {
+	case slot.node != nil:
+		updated.slots[i] = hamtSlot{node: slot.node.insert(hash, shift+hamtBits, entry)}
+	case slot.hash == hash:
+		entries := make([]hamtEntry, 0, len(slot.entries)+1)
+		entries = append(append(entries, slot.entries...), entry)
+		updated.slots[i] = hamtSlot{hash: hash, entries: entries}
+	default:
+		// The keys have different hashes, they go to a new level where their bits are different
+		var node *hamtNode
+		for _, other := range slot.entries {
+			node = node.insert(slot.hash, shift+hamtBits, other)
+		}
+		updated.slots[i] = hamtSlot{node: node.insert(hash, shift+hamtBits, entry)}
+	}
+	return updated
+}
+
+// remove returns a copy of n without the key and the position that the key had, the node is nil if it's empty
+func (n *hamtNode) remove(hash uint64, shift uint, key Object) (*hamtNode, int, bool) {
+	if n == nil {
+		return nil, 0, false
+	}
+	bit, below := hamtIndex(hash, shift)
+	if n.bitmap&bit == 0 {
+		return n, 0, false
+	}
+	i := bits.OnesCount32(n.bitmap & below)
+	slot := n.slots[i]
+	var replacement hamtSlot
+	var position int
+	switch {
+	case slot.node != nil:
+		node, removed, ok := slot.node.remove(hash, shift+hamtBits, key)
+		if !ok {
+			return n, 0, false
+		}
+		replacement, position = hamtSlot{node: node}, removed
+	case slot.hash == hash:
+		found := -1
+		for j, entry := range slot.entries {
+			if Equal(entry.key, key) {
+				found = j
+				break
+			}
+		}
+		if found == -1 {
+			return n, 0, false
+		}
+		position = slot.entries[found].position
+		entries := make([]hamtEntry, 0, len(slot.entries)-1)
+		entries = append(append(entries, slot.entries[:found]...), slot.entries[found+1:]...)
+		replacement = hamtSlot{hash: hash, entries: entries}
+	default:
+		return n, 0, false
+	}
+
+	updated := &hamtNode{bitmap: n.bitmap}
+	if replacement.node == nil && len(replacement.entries) == 0 {
+		updated.bitmap &^= bit
+		if updated.bitmap == 0 {
+			return nil, position, true
+		}
+		updated.slots = make([]hamtSlot, 0, len(n.slots)-1)
+		updated.slots = append(append(updated.slots, n.slots[:i]...), n.slots[i+1:]...)
+		return updated, position, true
+	}
+	updated.slots = make([]hamtSlot, len(n.slots))
+	copy(updated.slots, n.slots)
+	updated.slots[i] = replacement
+	return updated, position, true
+}
@@ -1,12 +1,15 @@
 package object
 
-import "hash/fnv"
+import (
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+)
 
 // HashKey hashes the 3 main types in Xlang
 type HashKey struct {
-	Type     ObjectType
-	Value    uint64
-	ValuePtr Object
+	Type  ObjectType
+	Value uint64
 }
 
 // HashKey Hashing method of a boolean
@@ -29,7 +32,112 @@ func (i *Integer) HashKey() HashKey {
 
 // HashKey is a hash mehod of a string
 func (s *String) HashKey() HashKey {
+	if s.hashed {
+		return HashKey{Type: s.Type(), Value: s.hash}
+	}
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
 	return HashKey{Type: s.Type(), Value: h.Sum64()}
 }
+
+// HashOf returns the hash key of any value. The arrays, hashmaps, tuples and variants are hashed by their
+// contents, so the values that are Equal have the same key. The values that are compared by identity, like
+// the functions, get the key of their type and the buckets of the hashmaps tell them apart
+func HashOf(o Object) HashKey {
+	return hashOf(o, nil)
+}
+
+// HashKey hashes the elements of the array
+func (a *Array) HashKey() HashKey {
+	return hashOf(a, nil)
+}
+
+// HashKey hashes the values of the tuple, so (1, "a") can be used as a key
+func (t *Tuple) HashKey() HashKey {
+	return hashOf(t, nil)
+}
+
+// HashKey hashes the tag and the values of the variant
+func (v *Variant) HashKey() HashKey {
+	return hashOf(v, nil)
+}
+
+// HashKey hashes the pairs of the hashmap, it doesn't depend on their order.
+// The hash is kept until the hashmap changes, so a hashmap that is used as a key is only hashed once
+func (h *HashMap) HashKey() HashKey {
+	if h.hashed {
+		return HashKey{Type: h.Type(), Value: h.hash}
+	}
+	key := hashOf(h, nil)
+	h.hash, h.hashed = key.Value, true
+	return key
+}
+
+// hashOf hashes o, seen are the arrays, hashmaps, tuples and variants that contain o, a value that
+// contains itself is hashed as its type the second time
+func hashOf(o Object, seen map[Object]bool) HashKey {
+	switch o := o.(type) {
+	case *Array:
+		return hashElements(o, "", o.Elements(), seen)
+	case *Tuple:
+		return hashElements(o, "", o.Elements, seen)
+	case *Variant:
+		return hashElements(o, o.Definition.Tag(), o.Values, seen)
+	case *HashMap:
+		if seen[o] {
+			return HashKey{Type: o.Type()}
+		}
+		seen = enterHash(seen, o)
+		defer delete(seen, o)
+		// The hashes of the pairs are added so the order of the pairs doesn't matter
+		var sum uint64
+		for _, pair := range o.Pairs() {
+			h := fnv.New64a()
+			writeHashKey(h, hashOf(pair.Key, seen))
+			writeHashKey(h, hashOf(pair.Value, seen))
+			sum += h.Sum64()
+		}
+		h := fnv.New64a()
+		writeUint64(h, uint64(o.Len()))
+		writeUint64(h, sum)
+		return HashKey{Type: o.Type(), Value: h.Sum64()}
+	case Hashable:
+		return o.HashKey()
+	}
+	return HashKey{Type: o.Type()}
+}
+
+// hashElements hashes the tag and the values of an array, a tuple or a variant
+func hashElements(o Object, tag string, values []Object, seen map[Object]bool) HashKey {
+	if seen[o] {
+		return HashKey{Type: o.Type()}
+	}
+	seen = enterHash(seen, o)
+	defer delete(seen, o)
+	h := fnv.New64a()
+	h.Write([]byte(tag))
+	writeUint64(h, uint64(len(values)))
+	for _, value := range values {
+		writeHashKey(h, hashOf(value, seen))
+	}
+	return HashKey{Type: o.Type(), Value: h.Sum64()}
+}
+
+func enterHash(seen map[Object]bool, o Object) map[Object]bool {
+	if seen == nil {
+		seen = map[Object]bool{}
+	}
+	seen[o] = true
+	return seen
+}
+
+func writeHashKey(h hash.Hash64, key HashKey) {
+	h.Write([]byte(key.Type))
+	writeUint64(h, key.Value)
+}
+
+func writeUint64(h hash.Hash64, value uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], value)
+	h.Write(buf[:])
+}
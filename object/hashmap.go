@@ -16,10 +16,24 @@ type Hashable interface {
 	HashKey() HashKey
 }
 
-// HashMap is a hash map in xlang
+// HashMap is a hash map in xlang, every value can be a key. The pairs are kept in the order that they
+// were added, in the vectors keys and values, so the hashmaps are inspected and iterated the same way every
+// time. index is a hash array mapped trie with the position of every key, the keys with the same hash
+// share a slot and they are told apart with Equal, so two arrays with the same elements are the same key.
+// All of them are persistent, so a copy of the hashmap that is changed shares most of it with the original one
 type HashMap struct {
-	Pairs           map[HashKey]HashPair
-	UnhashablePairs map[Object]HashPair
+	keys   vector
+	values vector
+	index  *hamtNode
+	size   int
+	// hash is the one of HashKey, hashed is cleared when the hashmap changes
+	hash   uint64
+	hashed bool
+}
+
+// NewHashMap returns an empty hashmap
+func NewHashMap() *HashMap {
+	return &HashMap{}
 }
 
 // Type returns the type of the hash map
@@ -29,11 +43,8 @@ func (h *HashMap) Type() ObjectType { return HashObject }
 func (h *HashMap) Inspect() string {
 	var out strings.Builder
 
-	pairs := make([]string, 0, len(h.Pairs))
-	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
-	}
-	for _, pair := range h.UnhashablePairs {
+	pairs := make([]string, 0, h.Len())
+	for _, pair := range h.Pairs() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
 	}
 
@@ -41,3 +52,84 @@ func (h *HashMap) Inspect() string {
 
 	return out.String()
 }
+
+// Len returns the number of pairs of the hashmap
+func (h *HashMap) Len() int {
+	return h.size
+}
+
+// Pairs returns the pairs in the order that they were added
+func (h *HashMap) Pairs() []HashPair {
+	pairs := make([]HashPair, 0, h.size)
+	keys, values := h.keys.slice(), h.values.slice()
+	for i, key := range keys {
+		// The keys that were deleted are nil
+		if key != nil {
+			pairs = append(pairs, HashPair{Key: key, Value: values[i]})
+		}
+	}
+	return pairs
+}
+
+// Get returns the value of the key, the keys are compared with Equal so two arrays with the same
+// elements are the same key
+func (h *HashMap) Get(key Object) (Object, bool) {
+	pair, ok := h.pair(key, nil)
+	if !ok {
+		return nil, false
+	}
+	return pair.Value, true
+}
+
+// Put sets the value of the key, it changes h so it's used on the hashmaps that are being built or copied.
+// A new key goes after the others and a key that is already in the hashmap keeps its place
+func (h *HashMap) Put(key, value Object) {
+	h.hashed = false
+	hash := mixHashKey(HashOf(key))
+	if position, ok := h.index.find(hash, 0, key, nil); ok {
+		h.keys = h.keys.set(position, key)
+		h.values = h.values.set(position, value)
+		return
+	}
+	h.index = h.index.insert(hash, 0, hamtEntry{key: key, position: h.keys.len()})
+	h.keys = h.keys.push(key)
+	h.values = h.values.push(value)
+	h.size++
+}
+
+// remove deletes the key from the hashmap and returns the pair that it had. The position of the pair is
+// left empty and the hashmap is built again when most of the positions are empty
+func (h *HashMap) remove(key Object) (HashPair, bool) {
+	index, position, ok := h.index.remove(mixHashKey(HashOf(key)), 0, key)
+	if !ok {
+		return HashPair{}, false
+	}
+	pair := HashPair{Key: h.keys.get(position), Value: h.values.get(position)}
+	h.hashed = false
+	h.index = index
+	h.keys = h.keys.set(position, nil)
+	h.values = h.values.set(position, nil)
+	h.size--
+	if empty := h.keys.len() - h.size; empty > vectorWidth && empty > h.size {
+		compacted := NewHashMap()
+		for _, pair := range h.Pairs() {
+			compacted.Put(pair.Key, pair.Value)
+		}
+		h.keys, h.values, h.index = compacted.keys, compacted.values, compacted.index
+	}
+	return pair, true
+}
+
+// pair returns the pair with a key equal to key, seen are the values that are being compared
+func (h *HashMap) pair(key Object, seen map[comparison]bool) (HashPair, bool) {
+	position, ok := h.index.find(mixHashKey(hashOf(key, nil)), 0, key, seen)
+	if !ok {
+		return HashPair{}, false
+	}
+	return HashPair{Key: h.keys.get(position), Value: h.values.get(position)}, true
+}
+
+// copy returns a hashmap with the same pairs that can be changed without changing h
+func (h *HashMap) copy() *HashMap {
+	return &HashMap{keys: h.keys, values: h.values, index: h.index, size: h.size}
+}
@@ -0,0 +1,231 @@
+package object
+
+import "sort"
+
+// The builtins that call a function stop at the first error value that it returns and return it, like the
+// evaluator does, where an error ends the program
+
+// isFunction returns if o can be called by a builtin, the closures of the VMs, the functions of the evaluator and the builtins
+func isFunction(o Object) bool {
+	switch o.Type() {
+	case ClosureObject, FunctionObject, BuiltinObject:
+		return true
+	}
+	return false
+}
+
+// arrayAndFunction returns the arguments of the builtins like map(arr, f), the error is the value that the builtin returns
+func arrayAndFunction(name string, args []Object) (*Array, Object, *Error) {
+	if len(args) != 2 {
+		return nil, nil, NewError("Error: Expected 2 arguments on %s() but got %d", name, len(args))
+	}
+	arr, ok := array(args[0])
+	if !ok {
+		return nil, nil, NewError("Unexpected type for %s(); got %s", name, args[0].Type())
+	}
+	if !isFunction(args[1]) {
+		return nil, nil, NewError("Unexpected type for %s(); expected a function, got %s", name, args[1].Type())
+	}
+	return arr, args[1], nil
+}
+
+// Map returns the array with the results of calling f with each element, map(arr, f)
+func Map(caller Caller, args ...Object) (Object, error) {
+	arr, f, err := arrayAndFunction("map", args)
+	if err != nil {
+		return err, nil
+	}
+	elements := make([]Object, arr.Len())
+	for i, element := range arr.Elements() {
+		result, err := caller.CallFunction(f, element)
+		if err != nil {
+			return nil, err
+		}
+		if IsError(result) {
+			return result, nil
+		}
+		elements[i] = result
+	}
+	return NewArray(elements), nil
+}
+
+// Filter returns the elements of the array that f returns true for, filter(arr, f)
+func Filter(caller Caller, args ...Object) (Object, error) {
+	arr, f, err := arrayAndFunction("filter", args)
+	if err != nil {
+		return err, nil
+	}
+	elements := []Object{}
+	for _, element := range arr.Elements() {
+		result, err := caller.CallFunction(f, element)
+		if err != nil {
+			return nil, err
+		}
+		if IsError(result) {
+			return result, nil
+		}
+		if IsTruthy(result) {
+			elements = append(elements, element)
+		}
+	}
+	return NewArray(elements), nil
+}
+
+// Reduce calls f with the accumulated value and each element, reduce(arr, initial, f)
+func Reduce(caller Caller, args ...Object) (Object, error) {
+	if len(args) != 3 {
+		return NewError("Error: Expected 3 arguments on reduce() but got %d", len(args)), nil
+	}
+	arr, f, err := arrayAndFunction("reduce", []Object{args[0], args[2]})
+	if err != nil {
+		return err, nil
+	}
+	result := args[1]
+	for _, element := range arr.Elements() {
+		accumulated, err := caller.CallFunction(f, result, element)
+		if err != nil {
+			return nil, err
+		}
+		if IsError(accumulated) {
+			return accumulated, nil
+		}
+		result = accumulated
+	}
+	return result, nil
+}
+
+// Find returns the first element that f returns true for, null if there isn't one, find(arr, f)
+func Find(caller Caller, args ...Object) (Object, error) {
+	arr, f, err := arrayAndFunction("find", args)
+	if err != nil {
+		return err, nil
+	}
+	for _, element := range arr.Elements() {
+		result, err := caller.CallFunction(f, element)
+		if err != nil {
+			return nil, err
+		}
+		if IsError(result) {
+			return result, nil
+		}
+		if IsTruthy(result) {
+			return element, nil
+		}
+	}
+	return nil, nil
+}
+
+// Any returns if f returns true for one of the elements, any(arr, f)
+func Any(caller Caller, args ...Object) (Object, error) {
+	arr, f, err := arrayAndFunction("any", args)
+	if err != nil {
+		return err, nil
+	}
+	for _, element := range arr.Elements() {
+		result, err := caller.CallFunction(f, element)
+		if err != nil {
+			return nil, err
+		}
+		if IsError(result) {
+			return result, nil
+		}
+		if IsTruthy(result) {
+			return TRUE, nil
+		}
+	}
+	return FALSE, nil
+}
+
+// All returns if f returns true for all the elements, all(arr, f)
+func All(caller Caller, args ...Object) (Object, error) {
+	arr, f, err := arrayAndFunction("all", args)
+	if err != nil {
+		return err, nil
+	}
+	for _, element := range arr.Elements() {
+		result, err := caller.CallFunction(f, element)
+		if err != nil {
+			return nil, err
+		}
+		if IsError(result) {
+			return result, nil
+		}
+		if !IsTruthy(result) {
+			return FALSE, nil
+		}
+	}
+	return TRUE, nil
+}
+
+// Sort returns the array sorted, sort(arr) sorts arrays of integers or of strings and
+// sort(arr, less) sorts with a function that returns if its first argument goes before the second one.
+// The sort is stable, the elements that are equal keep their order
+func Sort(caller Caller, args ...Object) (Object, error) {
+	if len(args) == 1 {
+		return sortValues(args[0]), nil
+	}
+	arr, less, err := arrayAndFunction("sort", args)
+	if err != nil {
+		return err, nil
+	}
+	elements := arr.Elements()
+	var callErr error
+	var errorValue Object
+	sort.SliceStable(elements, func(i, j int) bool {
+		if callErr != nil || errorValue != nil {
+			return false
+		}
+		result, err := caller.CallFunction(less, elements[i], elements[j])
+		if err != nil {
+			callErr = err
+			return false
+		}
+		if IsError(result) {
+			errorValue = result
+			return false
+		}
+		return IsTruthy(result)
+	})
+	if callErr != nil {
+		return nil, callErr
+	}
+	if errorValue != nil {
+		return errorValue, nil
+	}
+	return NewArray(elements), nil
+}
+
+// sortValues sorts an array of integers or of strings
+func sortValues(o Object) Object {
+	arr, ok := array(o)
+	if !ok {
+		return NewError("Unexpected type for sort(); got %s", o.Type())
+	}
+	elements := arr.Elements()
+	if len(elements) == 0 {
+		return NewArray(elements)
+	}
+	switch elements[0].(type) {
+	case *Integer:
+		for _, element := range elements {
+			if _, ok := element.(*Integer); !ok {
+				return NewError("Error: sort() without a function expects integers or strings, got %s and %s", elements[0].Type(), element.Type())
+			}
+		}
+		sort.SliceStable(elements, func(i, j int) bool {
+			return elements[i].(*Integer).Value < elements[j].(*Integer).Value
+		})
+	case *String:
+		for _, element := range elements {
+			if _, ok := element.(*String); !ok {
+				return NewError("Error: sort() without a function expects integers or strings, got %s and %s", elements[0].Type(), element.Type())
+			}
+		}
+		sort.SliceStable(elements, func(i, j int) bool {
+			return elements[i].(*String).Value < elements[j].(*String).Value
+		})
+	default:
+		return NewError("Error: sort() without a function expects integers or strings, got %s", elements[0].Type())
+	}
+	return NewArray(elements)
+}
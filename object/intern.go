@@ -0,0 +1,53 @@
+package object
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+const (
+	// maxInternedLength is the length of the longest string that is interned
+	maxInternedLength = 64
+	// maxInternedStrings stops the table from growing forever with the strings that the programs create,
+	// the table keeps at most maxInternedStrings * maxInternedLength bytes of strings
+	maxInternedStrings = 1 << 16
+)
+
+var (
+	internedMutex   sync.RWMutex
+	internedStrings = map[string]*String{}
+)
+
+// InternString returns the same *String every time that it's called with the same value, so interned
+// strings can be compared by pointer and their hash is only computed once. The string constants and the
+// strings that the programs make when they run, like the result of "a" + "b", are interned.
+// Long strings and the strings asked for after the table is full aren't interned, they are just a new *String
+// and the table isn't locked for them
+func InternString(value string) *String {
+	if len(value) > maxInternedLength {
+		return &String{Value: value}
+	}
+	internedMutex.RLock()
+	str, ok := internedStrings[value]
+	full := len(internedStrings) >= maxInternedStrings
+	internedMutex.RUnlock()
+	if ok {
+		return str
+	}
+	if full {
+		return &String{Value: value}
+	}
+
+	internedMutex.Lock()
+	defer internedMutex.Unlock()
+	if str, ok := internedStrings[value]; ok {
+		return str
+	}
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	str = &String{Value: value, hash: h.Sum64(), hashed: true}
+	if len(internedStrings) < maxInternedStrings {
+		internedStrings[value] = str
+	}
+	return str
+}
@@ -0,0 +1,33 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestInternString(t *testing.T) {
+	saved := internedStrings
+	internedStrings = map[string]*String{}
+	defer func() { internedStrings = saved }()
+
+	if InternString("a") != InternString("a") {
+		t.Fatalf("the same string was interned twice")
+	}
+	long := strings.Repeat("a", maxInternedLength+1)
+	if InternString(long) == InternString(long) {
+		t.Fatalf("a string longer than %d bytes was interned", maxInternedLength)
+	}
+	for i := len(internedStrings); i < maxInternedStrings; i++ {
+		InternString(fmt.Sprint(i))
+	}
+	if InternString("full") == InternString("full") {
+		t.Fatalf("a string was interned after the table was full")
+	}
+	if len(internedStrings) != maxInternedStrings {
+		t.Fatalf("the table has %d strings, the most is %d", len(internedStrings), maxInternedStrings)
+	}
+	if InternString("a") != InternString("a") {
+		t.Fatalf("the strings interned before the table was full aren't returned anymore")
+	}
+}
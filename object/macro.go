@@ -0,0 +1,26 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+	"xlang/ast"
+)
+
+// Macro is a function that receives quoted code and returns the code that replaces its call
+type Macro struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+// Type .
+func (m *Macro) Type() ObjectType { return MacroObject }
+
+// Inspect .
+func (m *Macro) Inspect() string {
+	params := make([]string, 0, len(m.Parameters))
+	for _, p := range m.Parameters {
+		params = append(params, p.Value)
+	}
+	return fmt.Sprintf("macro (%s) { \n %s \n}", strings.Join(params, ","), m.Body.String())
+}
@@ -8,3 +8,6 @@ func (n *Null) Type() ObjectType { return NullObject }
 
 // Inspect null
 func (n *Null) Inspect() string { return "null" }
+
+// NULL is the null of the programs, it's compared by pointer
+var NULL = &Null{}
@@ -31,6 +31,16 @@ const (
 	CompiledFunctionObject = "COMPILED FUNCTION"
 	// ClosureObject is a function that stores a function and the freevariables
 	ClosureObject = "CLOSURE"
+	// EnumObject is the declaration of a tagged union
+	EnumObject = "ENUM"
+	// VariantObject is a value of an enum
+	VariantObject = "VARIANT"
+	// TupleObject is a fixed group of values like (1, "a")
+	TupleObject = "TUPLE"
+	// QuoteObject is code that hasn't been evaluated
+	QuoteObject = "QUOTE"
+	// MacroObject is a macro, it's only used before evaluating the program
+	MacroObject = "MACRO"
 )
 
 // Object is a xlang object.
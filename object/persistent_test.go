@@ -0,0 +1,321 @@
+package object
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func integers(n int) []Object {
+	elements := make([]Object, n)
+	for i := range elements {
+		elements[i] = &Integer{Value: int64(i)}
+	}
+	return elements
+}
+
+func testArray(t *testing.T, expected []Object, actual *Array) {
+	t.Helper()
+	if actual.Len() != len(expected) {
+		t.Fatalf("wrong length. want=%d, got=%d", len(expected), actual.Len())
+	}
+	elements := actual.Elements()
+	for i, element := range expected {
+		if actual.Get(i) != element || elements[i] != element {
+			t.Fatalf("wrong element %d. want=%s, got=%s and %s", i, element.Inspect(), actual.Get(i).Inspect(), elements[i].Inspect())
+		}
+	}
+}
+
+func TestArrayOperations(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	type version struct {
+		expected []Object
+		array    *Array
+	}
+	versions := []version{{expected: []Object{}, array: NewArray(nil)}}
+	for i := 0; i < 5000; i++ {
+		from := versions[random.Intn(len(versions))]
+		value := &Integer{Value: int64(i)}
+		expected := append([]Object{}, from.expected...)
+		var array *Array
+		switch operation := random.Intn(6); {
+		case operation <= 1:
+			expected = append(expected, value)
+			array = from.array.Push(value)
+		case operation == 2:
+			expected = append([]Object{value}, expected...)
+			array = from.array.Unshift(value)
+		case operation == 3 && len(expected) > 0:
+			expected = expected[:len(expected)-1]
+			array = from.array.Pop()
+		case operation == 4 && len(expected) > 0:
+			expected = expected[1:]
+			array = from.array.Shift()
+		case operation == 5 && len(expected) > 0:
+			position := random.Intn(len(expected))
+			expected[position] = value
+			array = from.array.Set(position, value)
+		default:
+			continue
+		}
+		testArray(t, expected, array)
+		versions = append(versions, version{expected: expected, array: array})
+	}
+	// The arrays that were changed are still the same
+	for _, v := range versions {
+		testArray(t, v.expected, v.array)
+	}
+}
+
+// countNodes returns the number of nodes of the trie of a vector
+func countNodes(node *vectorNode) int {
+	if node == nil {
+		return 0
+	}
+	count := 1
+	for _, child := range node.children {
+		count += countNodes(child)
+	}
+	return count
+}
+
+func TestQueue(t *testing.T) {
+	// A queue that is emptied on one side while it grows on the other keeps the same few nodes
+	for _, length := range []int{1, 2, 40, 1100} {
+		queue := NewArray(integers(length))
+		reversed := NewArray(integers(length))
+		for i := 0; i < 100000; i++ {
+			queue = queue.Shift().Push(&Integer{Value: int64(i)})
+			reversed = reversed.Pop().Unshift(&Integer{Value: int64(i)})
+		}
+		for _, array := range []*Array{queue, reversed} {
+			if array.Len() != length {
+				t.Fatalf("wrong length. want=%d, got=%d", length, array.Len())
+			}
+			if nodes := countNodes(array.elements.root); nodes > 2*(length/vectorWidth+2) {
+				t.Fatalf("the queue of %d elements has %d nodes, with shift %d", length, nodes, array.elements.shift)
+			}
+		}
+		if last := queue.Get(length - 1).(*Integer).Value; last != 99999 {
+			t.Fatalf("wrong last element, got=%d", last)
+		}
+		if first := reversed.Get(0).(*Integer).Value; first != 99999 {
+			t.Fatalf("wrong first element, got=%d", first)
+		}
+	}
+}
+
+func TestNewArray(t *testing.T) {
+	for _, n := range []int{0, 1, 31, 32, 33, 1024, 1025, 40000} {
+		elements := integers(n)
+		testArray(t, elements, NewArray(elements))
+	}
+}
+
+func TestHashMapOperations(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	hash := NewHashMap()
+	var order []int64
+	values := map[int64]int64{}
+	var snapshots []*HashMap
+	var expectedSnapshots []string
+	for i := 0; i < 3000; i++ {
+		key := int64(random.Intn(500))
+		if random.Intn(4) == 0 {
+			length := hash.Len()
+			hash = Delete(hash, &Integer{Value: key}).(*HashMap)
+			removed := hash.Len() == length-1
+			if _, ok := values[key]; ok != removed || !removed && hash.Len() != length {
+				t.Fatalf("delete(%d) left %d pairs of %d", key, hash.Len(), length)
+			}
+			if removed {
+				delete(values, key)
+				for j, k := range order {
+					if k == key {
+						order = append(order[:j], order[j+1:]...)
+						break
+					}
+				}
+			}
+		} else {
+			if _, ok := values[key]; !ok {
+				order = append(order, key)
+			}
+			values[key] = int64(i)
+			hash = SetHash(hash, &Integer{Value: key}, &Integer{Value: int64(i)}).(*HashMap)
+		}
+		if i%100 == 0 {
+			snapshots = append(snapshots, hash.copy())
+			expectedSnapshots = append(expectedSnapshots, hash.Inspect())
+		}
+	}
+	pairs := hash.Pairs()
+	if len(pairs) != len(order) || hash.Len() != len(order) {
+		t.Fatalf("wrong number of pairs. want=%d, got=%d and %d", len(order), len(pairs), hash.Len())
+	}
+	for i, key := range order {
+		if pairs[i].Key.(*Integer).Value != key || pairs[i].Value.(*Integer).Value != values[key] {
+			t.Fatalf("wrong pair %d. want=%d: %d, got=%s: %s", i, key, values[key], pairs[i].Key.Inspect(), pairs[i].Value.Inspect())
+		}
+		value, ok := hash.Get(&Integer{Value: key})
+		if !ok || value.(*Integer).Value != values[key] {
+			t.Fatalf("wrong value of %d", key)
+		}
+	}
+	for i, snapshot := range snapshots {
+		if snapshot.Inspect() != expectedSnapshots[i] {
+			t.Fatalf("the copy %d changed", i)
+		}
+	}
+}
+
+// collidingKey has the same hash as the other ones, so all of them share a slot of the trie
+type collidingKey struct{ name string }
+
+func (c *collidingKey) Type() ObjectType { return "COLLIDING" }
+func (c *collidingKey) Inspect() string  { return c.name }
+func (c *collidingKey) HashKey() HashKey { return HashKey{Type: c.Type(), Value: 7} }
+
+func TestHashMapCollisions(t *testing.T) {
+	a, b, c := &collidingKey{"a"}, &collidingKey{"b"}, &collidingKey{"c"}
+	hash := NewHashMap()
+	hash.Put(a, &Integer{Value: 1})
+	hash.Put(b, &Integer{Value: 2})
+	hash.Put(c, &Integer{Value: 3})
+	hash.remove(b)
+	for key, expected := range map[Object]int64{a: 1, c: 3} {
+		value, ok := hash.Get(key)
+		if !ok || value.(*Integer).Value != expected {
+			t.Fatalf("wrong value of %s", key.Inspect())
+		}
+	}
+	if _, ok := hash.Get(b); ok || hash.Len() != 2 {
+		t.Fatalf("b wasn't deleted")
+	}
+}
+
+// pushCopy is how push() worked before the arrays were persistent vectors, it copies every element
+func pushCopy(elements []Object, value Object) []Object {
+	copied := make([]Object, 0, len(elements)+1)
+	return append(append(copied, elements...), value)
+}
+
+// setCopy is how set() worked on hashmaps before they were tries, it copies every pair
+func setCopy(pairs map[HashKey]HashPair, key, value Object) map[HashKey]HashPair {
+	copied := make(map[HashKey]HashPair, len(pairs)+1)
+	for k, pair := range pairs {
+		copied[k] = pair
+	}
+	copied[HashOf(key)] = HashPair{Key: key, Value: value}
+	return copied
+}
+
+func BenchmarkPush(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		elements := integers(n)
+		b.Run(fmt.Sprintf("vector-%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				array := NewArray(nil)
+				for _, element := range elements {
+					array = array.Push(element)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("copy-%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var array []Object
+				for _, element := range elements {
+					array = pushCopy(array, element)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkUnshift(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000} {
+		elements := integers(n)
+		b.Run(fmt.Sprintf("vector-%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				array := NewArray(nil)
+				for _, element := range elements {
+					array = array.Unshift(element)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("copy-%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var array []Object
+				for _, element := range elements {
+					array = append([]Object{element}, array...)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkIndex(b *testing.B) {
+	elements := integers(10000)
+	array := NewArray(elements)
+	b.Run("vector", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < len(elements); j++ {
+				array.Get(j)
+			}
+		}
+	})
+	b.Run("slice", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < len(elements); j++ {
+				_ = elements[j]
+			}
+		}
+	})
+}
+
+func BenchmarkSetHash(b *testing.B) {
+	for _, n := range []int{100, 1000, 3000} {
+		keys := integers(n)
+		b.Run(fmt.Sprintf("trie-%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var hash Object = NewHashMap()
+				for _, key := range keys {
+					hash = SetHash(hash.(*HashMap), key, key)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("copy-%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				pairs := map[HashKey]HashPair{}
+				for _, key := range keys {
+					pairs = setCopy(pairs, key, key)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetHash(b *testing.B) {
+	keys := integers(10000)
+	hash := NewHashMap()
+	pairs := map[HashKey]HashPair{}
+	for _, key := range keys {
+		hash.Put(key, key)
+		pairs[HashOf(key)] = HashPair{Key: key, Value: key}
+	}
+	b.Run("trie", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, key := range keys {
+				hash.Get(key)
+			}
+		}
+	})
+	b.Run("map", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, key := range keys {
+				_ = pairs[HashOf(key)]
+			}
+		}
+	})
+}
@@ -0,0 +1,19 @@
+package object
+
+import (
+	"fmt"
+	"xlang/ast"
+)
+
+// Quote is an unevaluated piece of code returned by quote(...)
+type Quote struct {
+	Node ast.Node
+}
+
+// Type .
+func (q *Quote) Type() ObjectType { return QuoteObject }
+
+// Inspect .
+func (q *Quote) Inspect() string {
+	return fmt.Sprintf("QUOTE(%s)", q.Node.String())
+}
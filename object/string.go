@@ -3,6 +3,9 @@ package object
 // String is a string object
 type String struct {
 	Value string
+	// hash is only set by InternString
+	hash   uint64
+	hashed bool
 }
 
 // Type .
@@ -0,0 +1,43 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TraceFrame is a function that was running when an error happened
+type TraceFrame struct {
+	// Function is the name of the function, <main> outside of the functions
+	// and <anonymous> for the functions that weren't assigned to a variable
+	Function string `json:"function"`
+	// Line is the line that was running in the function, 0 if it isn't known
+	Line int `json:"line"`
+}
+
+// StackTrace is the chain of calls that led to an error, the first frame is the outermost one
+type StackTrace []TraceFrame
+
+// String formats the trace as a traceback, with the call that failed at the end
+func (s StackTrace) String() string {
+	if len(s) == 0 {
+		return ""
+	}
+	out := strings.Builder{}
+	out.WriteString("Traceback (most recent call last):\n")
+	for _, frame := range s {
+		if frame.Line == 0 {
+			out.WriteString(fmt.Sprintf("  in %s\n", frame.Function))
+			continue
+		}
+		out.WriteString(fmt.Sprintf("  line %d, in %s\n", frame.Line, frame.Function))
+	}
+	return out.String()
+}
+
+// FunctionName returns the name that a trace shows for a function called name
+func FunctionName(name string) string {
+	if name == "" {
+		return "<anonymous>"
+	}
+	return name
+}
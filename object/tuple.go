@@ -0,0 +1,20 @@
+package object
+
+import "strings"
+
+// Tuple is an immutable group of values, (a, b) or what return a, b gives
+type Tuple struct {
+	Elements []Object
+}
+
+// Type .
+func (t *Tuple) Type() ObjectType { return TupleObject }
+
+// Inspect .
+func (t *Tuple) Inspect() string {
+	elements := make([]string, 0, len(t.Elements))
+	for _, element := range t.Elements {
+		elements = append(elements, element.Inspect())
+	}
+	return "(" + strings.Join(elements, ", ") + ")"
+}
@@ -0,0 +1,210 @@
+package object
+
+const (
+	vectorBits  = 5
+	vectorWidth = 1 << vectorBits
+	vectorMask  = vectorWidth - 1
+)
+
+// vectorNode is a node of a vector, the leaves keep 32 values and the other nodes 32 nodes
+type vectorNode struct {
+	children []*vectorNode
+	values   []Object
+}
+
+// vector is a persistent vector, a trie where every node has 32 children and the bits of a position
+// choose the child in each level. The elements are the positions [start, end) of the trie, so the
+// vector grows on both sides: when it's full on the right the old root is the first child of a new
+// root and when it's full on the left the old root is the second one.
+// The changes copy the nodes on the way to the position and share the rest with the original vector,
+// so they take O(log32 n) and the original vector doesn't change
+type vector struct {
+	root *vectorNode
+	// shift is the bits of the positions that the root uses, the root has 1 << (shift + 5) positions
+	shift      uint
+	start, end int
+}
+
+// newVector builds the trie of the elements a level at a time
+func newVector(elements []Object) vector {
+	if len(elements) == 0 {
+		return vector{}
+	}
+	nodes := make([]*vectorNode, 0, (len(elements)+vectorMask)/vectorWidth)
+	for from := 0; from < len(elements); from += vectorWidth {
+		values := make([]Object, vectorWidth)
+		copy(values, elements[from:])
+		nodes = append(nodes, &vectorNode{values: values})
+	}
+	var shift uint
+	for len(nodes) > 1 {
+		parents := make([]*vectorNode, 0, (len(nodes)+vectorMask)/vectorWidth)
+		for from := 0; from < len(nodes); from += vectorWidth {
+			children := make([]*vectorNode, vectorWidth)
+			copy(children, nodes[from:])
+			parents = append(parents, &vectorNode{children: children})
+		}
+		nodes = parents
+		shift += vectorBits
+	}
+	return vector{root: nodes[0], shift: shift, end: len(elements)}
+}
+
+func (v vector) len() int {
+	return v.end - v.start
+}
+
+// capacity is the number of positions of the trie
+func (v vector) capacity() int {
+	return 1 << (v.shift + vectorBits)
+}
+
+// leaf returns the leaf that has the position
+func (v vector) leaf(position int) *vectorNode {
+	node := v.root
+	for level := v.shift; level > 0; level -= vectorBits {
+		node = node.children[(position>>level)&vectorMask]
+	}
+	return node
+}
+
+// get returns the element i, it must be in the vector
+func (v vector) get(i int) Object {
+	position := v.start + i
+	return v.leaf(position).values[position&vectorMask]
+}
+
+// set returns a vector with value as the element i, it must be in the vector
+func (v vector) set(i int, value Object) vector {
+	v.root = setVectorNode(v.root, v.shift, v.start+i, value)
+	return v
+}
+
+// push returns a vector with value after the last element
+func (v vector) push(value Object) vector {
+	if v.root == nil {
+		return newVector([]Object{value})
+	}
+	if v.end == v.capacity() {
+		children := make([]*vectorNode, vectorWidth)
+		children[0] = v.root
+		v.root = &vectorNode{children: children}
+		v.shift += vectorBits
+	}
+	v.root = setVectorNode(v.root, v.shift, v.end, value)
+	v.end++
+	return v
+}
+
+// unshift returns a vector with value before the first element
+func (v vector) unshift(value Object) vector {
+	if v.root == nil {
+		return newVector([]Object{value})
+	}
+	if v.start == 0 {
+		moved := v.capacity()
+		children := make([]*vectorNode, vectorWidth)
+		children[1] = v.root
+		v.root = &vectorNode{children: children}
+		v.shift += vectorBits
+		v.start += moved
+		v.end += moved
+	}
+	v.start--
+	v.root = setVectorNode(v.root, v.shift, v.start, value)
+	return v
+}
+
+// pop returns the vector without the last element, the vector can't be empty
+func (v vector) pop() vector {
+	if v.len() == 1 {
+		return vector{}
+	}
+	v.end--
+	v.root = clearVectorNode(v.root, v.shift, v.end, v.start, v.end)
+	return v.shrink()
+}
+
+// shiftFirst returns the vector without the first element, the vector can't be empty
+func (v vector) shiftFirst() vector {
+	if v.len() == 1 {
+		return vector{}
+	}
+	v.start++
+	v.root = clearVectorNode(v.root, v.shift, v.start-1, v.start, v.end)
+	return v.shrink()
+}
+
+// shrink makes the only child of the root that has elements the new root, so a vector that is used as
+// a queue keeps the same few nodes instead of growing on the right while it's emptied on the left
+func (v vector) shrink() vector {
+	for v.shift > 0 {
+		child := v.start >> v.shift
+		if (v.end-1)>>v.shift != child {
+			break
+		}
+		moved := child << v.shift
+		v.root = v.root.children[child]
+		v.shift -= vectorBits
+		v.start -= moved
+		v.end -= moved
+	}
+	return v
+}
+
+// slice returns the elements in a new slice
+func (v vector) slice() []Object {
+	elements := make([]Object, 0, v.len())
+	for position := v.start; position < v.end; {
+		from := position & vectorMask
+		to := vectorWidth
+		if rest := v.end - (position - from); rest < to {
+			to = rest
+		}
+		elements = append(elements, v.leaf(position).values[from:to]...)
+		position += to - from
+	}
+	return elements
+}
+
+// clearVectorNode returns a copy of node without the value at the position, the elements left are the positions
+// [from, to) and the nodes that don't have any of them are dropped
+func clearVectorNode(node *vectorNode, shift uint, position, from, to int) *vectorNode {
+	size := 1 << (shift + vectorBits)
+	first := position &^ (size - 1)
+	if to <= first || from >= first+size {
+		return nil
+	}
+	updated := &vectorNode{}
+	if shift == 0 {
+		updated.values = make([]Object, vectorWidth)
+		copy(updated.values, node.values)
+		updated.values[position&vectorMask] = nil
+		return updated
+	}
+	updated.children = make([]*vectorNode, vectorWidth)
+	copy(updated.children, node.children)
+	child := (position >> shift) & vectorMask
+	updated.children[child] = clearVectorNode(updated.children[child], shift-vectorBits, position, from, to)
+	return updated
+}
+
+// setVectorNode returns a copy of node with value at the position, the nodes that don't exist yet are created
+func setVectorNode(node *vectorNode, shift uint, position int, value Object) *vectorNode {
+	updated := &vectorNode{}
+	if shift == 0 {
+		updated.values = make([]Object, vectorWidth)
+		if node != nil {
+			copy(updated.values, node.values)
+		}
+		updated.values[position&vectorMask] = value
+		return updated
+	}
+	updated.children = make([]*vectorNode, vectorWidth)
+	if node != nil {
+		copy(updated.children, node.children)
+	}
+	child := (position >> shift) & vectorMask
+	updated.children[child] = setVectorNode(updated.children[child], shift-vectorBits, position, value)
+	return updated
+}
@@ -7,7 +7,7 @@ import (
 
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
-	array.SetLine(p.l.Line)
+	array.SetLine(p.curToken.Line)
 	array.Elements = p.parseExpressionList(token.RBRACKET)
 	return array
 }
@@ -0,0 +1,42 @@
+package parser
+
+import (
+	"fmt"
+	"xlang/ast"
+	"xlang/token"
+)
+
+// compoundAssignments are the operators of arr[i] += v and the like, with the infix operator that they apply
+var compoundAssignments = map[token.TypeToken]string{
+	token.PLUSASSIGN:     "+",
+	token.MINUSASSIGN:    "-",
+	token.ASTERISKASSIGN: "*",
+	token.SLASHASSIGN:    "/",
+}
+
+func (p *Parser) peekIsAssignment() bool {
+	_, compound := compoundAssignments[p.peekToken.Type]
+	return compound || p.peekTokenIs(token.ASSIGN)
+}
+
+// parseIndexAssignStatement parses h[k] = v and arr[i] += v, target is what was parsed before the operator
+func (p *Parser) parseIndexAssignStatement(target ast.Expression) *ast.IndexAssignStatement {
+	p.nextToken()
+	stmt := &ast.IndexAssignStatement{Token: p.curToken, Operator: compoundAssignments[p.curToken.Type]}
+	index, ok := target.(*ast.IndexExpression)
+	if !ok {
+		p.errors = append(p.errors, fmt.Sprintf("Expected an index like a[i] before %s, got %s", p.curToken.Literal, target))
+		return nil
+	}
+	if _, ok := index.Left.(*ast.Identifier); !ok {
+		p.errors = append(p.errors, fmt.Sprintf("Expected a variable to assign in %s, got %s", index, index.Left))
+		return nil
+	}
+	stmt.Target = index
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
@@ -11,6 +11,7 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	PIPELINE    // |>
 	EQUALS      // ==
 	LESSGREATER // > or <
 	SUM         //+
@@ -37,9 +38,18 @@ func (p *Parser) noPrefixParseFnError(t token.TypeToken) {
 	p.errors = append(p.errors, fmt.Sprintf("no prefix parse function for %s found", t))
 }
 
-func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
+func (p *Parser) parseExpressionStatement() ast.Statement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	stmt.SetLine(p.curToken.Line)
 	stmt.Expression = p.parseExpression(LOWEST)
+	if stmt.Expression != nil && p.peekIsAssignment() {
+		assign := p.parseIndexAssignStatement(stmt.Expression)
+		if assign == nil {
+			return nil
+		}
+		assign.SetLine(stmt.Line())
+		return assign
+	}
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
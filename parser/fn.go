@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"fmt"
 	"xlang/ast"
 	"xlang/token"
 )
@@ -52,6 +53,52 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
+	exp.SetLine(p.curToken.Line)
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
 	return exp
 }
+
+// parseArrowFunction desugars (x, y) => x + y into fn(x, y) { x + y }, the parameters
+// are already parsed as expressions and the current token is the closing ')'
+func (p *Parser) parseArrowFunction(params []ast.Expression) ast.Expression {
+	lit := &ast.FunctionLiteral{Token: token.Token{Type: token.FUNCTION, Literal: "fn", Line: p.curToken.Line}}
+	lit.SetLine(p.curToken.Line)
+	lit.Parameters = make([]*ast.Identifier, 0, len(params))
+	for _, param := range params {
+		ident, ok := param.(*ast.Identifier)
+		if !ok {
+			p.errors = append(p.errors, fmt.Sprintf("Expected identifier as parameter of arrow function, got %s", param.String()))
+			return nil
+		}
+		lit.Parameters = append(lit.Parameters, ident)
+	}
+
+	// Skip the =>
+	p.nextToken()
+	lit.Body = p.parseArrowBody()
+	if lit.Body == nil {
+		return nil
+	}
+	return lit
+}
+
+// parseArrowBody parses what comes after a =>, which is either a block or a single expression,
+// the expression is wrapped in a block so it's the same as writing { expression }
+func (p *Parser) parseArrowBody() *ast.BlockStatement {
+	arrow := p.curToken
+	if p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		return p.parseBlockStatement()
+	}
+
+	p.nextToken()
+	body := &ast.BlockStatement{Token: arrow, Statements: []ast.Statement{}}
+	body.SetLine(p.curToken.Line)
+	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	stmt.Expression = p.parseExpression(LOWEST)
+	if stmt.Expression == nil {
+		return nil
+	}
+	body.Statements = append(body.Statements, stmt)
+	return body
+}
@@ -7,10 +7,20 @@ import (
 )
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
-	p.nextToken()
-	exp := p.parseExpression(LOWEST)
-	if !p.expectPeek(token.RPAREN) {
+	tok := p.curToken
+	// We can't know yet if this is (x + 1), the tuple (x, 1) or the parameters of (x) => x + 1,
+	// so we parse a list and decide when we see what comes after the ')'
+	list := p.parseExpressionList(token.RPAREN)
+	if list == nil {
 		return nil
 	}
-	return exp
+	if p.peekTokenIs(token.ARROW) {
+		return p.parseArrowFunction(list)
+	}
+	if len(list) != 1 {
+		tuple := &ast.TupleLiteral{Token: tok, Elements: list}
+		tuple.SetLine(tok.Line)
+		return tuple
+	}
+	return list[0]
 }
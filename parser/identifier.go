@@ -6,6 +6,6 @@ import (
 
 func (p *Parser) parseIdentifier() ast.Expression {
 	i := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	i.SetLine(p.l.Line)
+	i.SetLine(p.curToken.Line)
 	return i
 }
@@ -16,3 +16,14 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 
 	return exp
 }
+
+// parseMemberExpression desugars left.name into left["name"]
+func (p *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
+	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+	exp.SetLine(p.curToken.Line)
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Right = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	return exp
+}
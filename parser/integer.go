@@ -8,7 +8,7 @@ import (
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
-	lit.SetLine(p.l.Line)
+	lit.SetLine(p.curToken.Line)
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 
 	if err != nil {
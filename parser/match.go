@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"xlang/ast"
+	"xlang/token"
+)
+
+// parseMatchExpression parses match (<subject>) { Shape.Circle(r) => r, _ => 0 }
+func (p *Parser) parseMatchExpression() ast.Expression {
+	exp := &ast.MatchExpression{Token: p.curToken}
+	exp.SetLine(p.curToken.Line)
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	exp.Subject = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		arm := &ast.MatchArm{}
+		if p.curToken.Literal != "_" {
+			arm.Pattern = p.parseVariantPattern()
+			if arm.Pattern == nil {
+				return nil
+			}
+		}
+		if !p.expectPeek(token.ARROW) {
+			return nil
+		}
+		arm.Body = p.parseArrowBody()
+		if arm.Body == nil {
+			return nil
+		}
+		exp.Arms = append(exp.Arms, arm)
+
+		if p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return exp
+}
+
+// parseVariantPattern parses Shape.Circle(r), the current token is the name of the enum
+func (p *Parser) parseVariantPattern() *ast.VariantPattern {
+	pattern := &ast.VariantPattern{Enum: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+	if !p.expectPeek(token.DOT) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	pattern.Variant = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.LPAREN) {
+		p.nextToken()
+		pattern.Bindings = p.parseFunctionParameters()
+		if pattern.Bindings == nil {
+			return nil
+		}
+	}
+	return pattern
+}
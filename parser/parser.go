@@ -35,8 +35,11 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.GT, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.PIPE, p.parsePipeExpression)
+	p.registerInfix(token.DOT, p.parseMemberExpression)
 
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
@@ -47,6 +50,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 
@@ -94,6 +98,13 @@ func (p *Parser) parseStatement() ast.Statement {
 		}
 	case token.LET:
 		{
+			if p.peekTokenIs(token.LPAREN) {
+				destructure := p.parseDestructureStatement()
+				if destructure == nil {
+					return nil
+				}
+				return destructure
+			}
 			// We do this messy stuff because if we returned directly we wouldn't be able to check fast enough if it's nil.
 			let := p.parseLetStatement()
 			if let == nil {
@@ -102,6 +113,14 @@ func (p *Parser) parseStatement() ast.Statement {
 			return let
 		}
 
+	case token.ENUM:
+		{
+			enum := p.parseEnumStatement()
+			if enum == nil {
+				return nil
+			}
+			return enum
+		}
 	case token.RETURN:
 		{
 			r := p.parseReturn()
@@ -118,6 +137,7 @@ func (p *Parser) parseStatement() ast.Statement {
 // Parses a let statement (logic)
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	stmt := &ast.LetStatement{Token: p.curToken}
+	stmt.SetLine(p.curToken.Line)
 	if !p.expectPeek(token.IDENT) {
 		return nil
 	}
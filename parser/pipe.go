@@ -0,0 +1,25 @@
+package parser
+
+import (
+	"xlang/ast"
+)
+
+// parsePipeExpression desugars x |> f(y) into f(x, y), and x |> f into f(x)
+func (p *Parser) parsePipeExpression(left ast.Expression) ast.Expression {
+	pipe := p.curToken
+	precedence := p.currPrecedence()
+	p.nextToken()
+	right := p.parseExpression(precedence)
+	if right == nil {
+		return nil
+	}
+
+	if call, ok := right.(*ast.CallExpression); ok {
+		call.Arguments = append([]ast.Expression{left}, call.Arguments...)
+		return call
+	}
+
+	call := &ast.CallExpression{Token: pipe, Function: right, Arguments: []ast.Expression{left}}
+	call.SetLine(pipe.Line)
+	return call
+}
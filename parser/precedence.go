@@ -3,6 +3,7 @@ package parser
 import "xlang/token"
 
 var precedences = map[token.TypeToken]int{
+	token.PIPE:     PIPELINE,
 	token.EQ:       EQUALS,
 	token.NOTEQ:    EQUALS,
 	token.LT:       LESSGREATER,
@@ -13,6 +14,7 @@ var precedences = map[token.TypeToken]int{
 	token.ASTERISK: PRODUCT,
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
+	token.DOT:      INDEX,
 }
 
 func (p *Parser) peekPrecedence() int {
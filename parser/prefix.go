@@ -10,7 +10,7 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 		return nil
 	}
 	expression := &ast.PrefixExpression{Token: p.curToken, Operator: p.curToken.Literal}
-	expression.SetLine(p.l.Line)
+	expression.SetLine(p.curToken.Line)
 	p.nextToken()
 	expression.Right = p.parseExpression(PREFIX)
 	return expression
@@ -7,8 +7,27 @@ import (
 
 func (p *Parser) parseReturn() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{Token: p.curToken}
+	stmt.SetLine(p.curToken.Line)
 	p.nextToken()
 	stmt.ReturnValue = p.parseExpression(LOWEST)
+	if stmt.ReturnValue == nil {
+		return nil
+	}
+	if p.peekTokenIs(token.COMMA) {
+		// return a, b returns the tuple (a, b)
+		tuple := &ast.TupleLiteral{Token: token.Token{Type: token.LPAREN, Literal: "("}, Elements: []ast.Expression{stmt.ReturnValue}}
+		tuple.SetLine(p.curToken.Line)
+		for p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+			p.nextToken()
+			element := p.parseExpression(LOWEST)
+			if element == nil {
+				return nil
+			}
+			tuple.Elements = append(tuple.Elements, element)
+		}
+		stmt.ReturnValue = tuple
+	}
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
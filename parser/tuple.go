@@ -0,0 +1,29 @@
+package parser
+
+import (
+	"xlang/ast"
+	"xlang/token"
+)
+
+// parseDestructureStatement parses let (a, b) = value;
+func (p *Parser) parseDestructureStatement() *ast.DestructureStatement {
+	stmt := &ast.DestructureStatement{Token: p.curToken}
+	stmt.SetLine(p.curToken.Line)
+	p.nextToken()
+	stmt.Names = p.parseFunctionParameters()
+	if stmt.Names == nil {
+		return nil
+	}
+	if !p.expectPeek(token.ASSIGN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
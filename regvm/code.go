@@ -0,0 +1,206 @@
+// Package regvm is a register based backend for the language: a compiler from the ast to three-address
+// instructions like ADD r0, r1, r2 and the VM that runs them. The functions keep their values in a window of
+// registers instead of pushing and popping them on a stack, so an operation reads its operands and writes its
+// result in place. The stack VM of the vm package is still the reference implementation. This backend supports
+// integers, strings, booleans, arrays, hashmaps, closures and the builtins, the programs with enums, match,
+// tuples, destructuring, index assignment or quote fail to compile with an error that names what isn't supported
+package regvm
+
+import (
+	"fmt"
+	"strings"
+	"xlang/code"
+	"xlang/object"
+)
+
+// Opcode is the operation of an instruction
+type Opcode byte
+
+// The instructions, R[x] is the register x of the current function, K[x] is the constant x and G[x] the global x
+const (
+	// OpMove does R[A] = R[B]
+	OpMove Opcode = iota
+	// OpLoadConstant does R[A] = K[B]
+	OpLoadConstant
+	// OpLoadTrue does R[A] = true
+	OpLoadTrue
+	// OpLoadFalse does R[A] = false
+	OpLoadFalse
+	// OpLoadNull does R[A] = null
+	OpLoadNull
+	// OpGetGlobal does R[A] = G[B]
+	OpGetGlobal
+	// OpSetGlobal does G[B] = R[A]
+	OpSetGlobal
+	// OpGetBuiltin does R[A] = the builtin B
+	OpGetBuiltin
+	// OpGetFree does R[A] = the free variable B of the closure that is running
+	OpGetFree
+	// OpSetFree does the free variable B of the closure R[A] = R[C]
+	OpSetFree
+	// OpCurrentClosure does R[A] = the closure that is running
+	OpCurrentClosure
+	// OpAdd does R[A] = R[B] + R[C]
+	OpAdd
+	// OpAddConstant does R[A] = R[B] + K[C]
+	OpAddConstant
+	// OpSub does R[A] = R[B] - R[C]
+	OpSub
+	// OpSubConstant does R[A] = R[B] - K[C]
+	OpSubConstant
+	// OpMul does R[A] = R[B] * R[C]
+	OpMul
+	// OpDiv does R[A] = R[B] / R[C]
+	OpDiv
+	// OpEqual does R[A] = R[B] == R[C]
+	OpEqual
+	// OpNotEqual does R[A] = R[B] != R[C]
+	OpNotEqual
+	// OpGreaterThan does R[A] = R[B] > R[C]
+	OpGreaterThan
+	// OpMinus does R[A] = -R[B]
+	OpMinus
+	// OpBang does R[A] = !R[B]
+	OpBang
+	// OpJump goes to the instruction A
+	OpJump
+	// OpJumpNotTruthy goes to the instruction B when R[A] isn't truthy
+	OpJumpNotTruthy
+	// OpArray does R[A] = [R[B], ..., R[B+C-1]]
+	OpArray
+	// OpHash does R[A] = {R[B]: R[B+1], ..., R[B+C-2]: R[B+C-1]}
+	OpHash
+	// OpIndex does R[A] = R[B][R[C]]
+	OpIndex
+	// OpClosure does R[A] = a closure of the function K[B] with the free variables R[C], R[C+1]...
+	OpClosure
+	// OpCall does R[A] = R[B](R[B+1], ..., R[B+C]), the arguments are the first registers of the function called
+	OpCall
+	// OpReturn returns R[A]
+	OpReturn
+	// OpReturnNull returns null
+	OpReturnNull
+	// OpHalt stops the program
+	OpHalt
+)
+
+var opcodeNames = map[Opcode]string{
+	OpMove:           "MOVE",
+	OpLoadConstant:   "LOADK",
+	OpLoadTrue:       "LOADTRUE",
+	OpLoadFalse:      "LOADFALSE",
+	OpLoadNull:       "LOADNULL",
+	OpGetGlobal:      "GETGLOBAL",
+	OpSetGlobal:      "SETGLOBAL",
+	OpGetBuiltin:     "GETBUILTIN",
+	OpGetFree:        "GETFREE",
+	OpSetFree:        "SETFREE",
+	OpCurrentClosure: "CURRENTCLOSURE",
+	OpAdd:            "ADD",
+	OpAddConstant:    "ADDK",
+	OpSub:            "SUB",
+	OpSubConstant:    "SUBK",
+	OpMul:            "MUL",
+	OpDiv:            "DIV",
+	OpEqual:          "EQ",
+	OpNotEqual:       "NEQ",
+	OpGreaterThan:    "GT",
+	OpMinus:          "MINUS",
+	OpBang:           "NOT",
+	OpJump:           "JUMP",
+	OpJumpNotTruthy:  "JUMPNOT",
+	OpArray:          "ARRAY",
+	OpHash:           "HASH",
+	OpIndex:          "INDEX",
+	OpClosure:        "CLOSURE",
+	OpCall:           "CALL",
+	OpReturn:         "RETURN",
+	OpReturnNull:     "RETURNNULL",
+	OpHalt:           "HALT",
+}
+
+// operandCounts are the number of operands that each opcode uses, the rest are 0
+var operandCounts = map[Opcode]int{
+	OpLoadTrue: 1, OpLoadFalse: 1, OpLoadNull: 1, OpCurrentClosure: 1, OpJump: 1, OpReturn: 1,
+	OpReturnNull: 0, OpHalt: 0,
+	OpMove: 2, OpLoadConstant: 2, OpGetGlobal: 2, OpSetGlobal: 2, OpGetBuiltin: 2, OpGetFree: 2,
+	OpMinus: 2, OpBang: 2, OpJumpNotTruthy: 2,
+}
+
+func (op Opcode) String() string {
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("OPCODE(%d)", byte(op))
+}
+
+// Instruction is an opcode with its three operands, the opcodes that need less leave the rest at 0
+type Instruction struct {
+	Op      Opcode
+	A, B, C int
+}
+
+func (ins Instruction) String() string {
+	n, ok := operandCounts[ins.Op]
+	if !ok {
+		n = 3
+	}
+	operands := []int{ins.A, ins.B, ins.C}[:n]
+	parts := []string{ins.Op.String()}
+	for _, operand := range operands {
+		parts = append(parts, fmt.Sprint(operand))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Instructions are the instructions of a function
+type Instructions []Instruction
+
+func (ins Instructions) String() string {
+	lines := make([]string, len(ins))
+	for i, instruction := range ins {
+		lines[i] = fmt.Sprintf("%04d %s", i, instruction)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Function is a compiled function, its registers start with the parameters
+type Function struct {
+	Instructions  Instructions
+	NumRegisters  int
+	NumParameters int
+	// NumFree is the number of free variables that its closures take
+	NumFree int
+	Name    string
+	// Lines maps the index of each instruction to its source line
+	Lines code.LineTable
+}
+
+// Type .
+func (f *Function) Type() object.ObjectType { return object.CompiledFunctionObject }
+
+// Inspect .
+func (f *Function) Inspect() string {
+	return fmt.Sprintf("RegisterFunction[%p]", f)
+}
+
+// Closure is a function with the values of its free variables
+type Closure struct {
+	Fn   *Function
+	Free []object.Object
+}
+
+// Type .
+func (c *Closure) Type() object.ObjectType { return object.ClosureObject }
+
+// Inspect .
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// Program is the output of the compiler, the instructions of Main run first
+type Program struct {
+	Main       *Function
+	Constants  []object.Object
+	NumGlobals int
+}
@@ -0,0 +1,548 @@
+package regvm
+
+import (
+	"fmt"
+	"strings"
+	"xlang/ast"
+	"xlang/compiler"
+	"xlang/object"
+)
+
+// ResultRegister is the register of the program where the value of each top level expression is left
+const ResultRegister = 0
+
+// Compiler compiles an ast to register instructions, it uses the symbol table of the stack compiler
+// so the variables resolve the same way in both backends
+type Compiler struct {
+	constants   compiler.Constants
+	symbolTable *compiler.SymbolTable
+	scope       *functionScope
+	// hoisted are the symbols of the functions declared before their let
+	hoisted map[*ast.LetStatement]compiler.Symbol
+	// numGlobals is the number of globals that the program uses
+	numGlobals int
+	report     compiler.Reporter
+}
+
+// functionScope is the function being compiled and the registers that it uses
+type functionScope struct {
+	fn   *Function
+	name string
+	// used are the registers that hold a value, the locals are never released
+	used   []bool
+	locals map[int]int
+	// isLocal are the registers of the locals
+	isLocal map[int]bool
+	// uninitialized are the locals that are hoisted functions not set yet
+	uninitialized map[int]bool
+	// fixups are the free variables of closures that captured a hoisted function before its let
+	fixups []fixup
+}
+
+// fixup is the free variable free of the closure kept in the register closure, it's set when the local
+// function target is
+type fixup struct {
+	closure int
+	free    int
+	target  compiler.Symbol
+}
+
+// NewCompiler returns a new compiler
+func NewCompiler() *Compiler {
+	table := compiler.NewSymbolTable()
+	for i, fn := range object.GetBuiltins() {
+		table.DefineBuiltin(i, fn.Name)
+	}
+	return &Compiler{
+		symbolTable: table,
+		hoisted:     map[*ast.LetStatement]compiler.Symbol{},
+	}
+}
+
+// Compile compiles the program, like the stack compiler it returns every error as compiler.Diagnostics
+func (c *Compiler) Compile(program *ast.Program) (*Program, error) {
+	c.scope = newFunctionScope("<main>")
+	c.reserve(ResultRegister)
+	c.hoist(program.Statements)
+	for _, s := range program.Statements {
+		c.statement(s)
+	}
+	c.emit(OpHalt, 0, 0, 0)
+	if c.report.Diagnostics.HasErrors() {
+		return nil, c.report.Diagnostics
+	}
+	return &Program{Main: c.scope.fn, Constants: c.constants.Objects, NumGlobals: c.numGlobals}, nil
+}
+
+func newFunctionScope(name string) *functionScope {
+	return &functionScope{
+		fn:            &Function{Name: name},
+		name:          name,
+		locals:        map[int]int{},
+		isLocal:       map[int]bool{},
+		uninitialized: map[int]bool{},
+	}
+}
+
+func (c *Compiler) emit(op Opcode, a, b, cc int) int {
+	fn := c.scope.fn
+	pos := len(fn.Instructions)
+	fn.Instructions = append(fn.Instructions, Instruction{Op: op, A: a, B: b, C: cc})
+	fn.Lines = fn.Lines.Add(pos, c.report.Line)
+	return pos
+}
+
+// Registers
+
+func (c *Compiler) reserve(register int) {
+	for len(c.scope.used) <= register {
+		c.scope.used = append(c.scope.used, false)
+	}
+	c.scope.used[register] = true
+	if register >= c.scope.fn.NumRegisters {
+		c.scope.fn.NumRegisters = register + 1
+	}
+}
+
+// allocate returns the lowest register that is free
+func (c *Compiler) allocate() int {
+	for i, used := range c.scope.used {
+		if !used {
+			c.reserve(i)
+			return i
+		}
+	}
+	register := len(c.scope.used)
+	c.reserve(register)
+	return register
+}
+
+// allocateRun returns the first of n consecutive registers after every register in use,
+// a call needs it as the function called uses the registers after its arguments
+func (c *Compiler) allocateRun(n int) int {
+	base := len(c.scope.used)
+	for base > 0 && !c.scope.used[base-1] {
+		base--
+	}
+	for i := 0; i < n; i++ {
+		c.reserve(base + i)
+	}
+	return base
+}
+
+func (c *Compiler) release(register int) {
+	if !c.scope.isLocal[register] {
+		c.scope.used[register] = false
+	}
+}
+
+func (c *Compiler) releaseRun(base, n int) {
+	for i := 0; i < n; i++ {
+		c.release(base + i)
+	}
+}
+
+// Symbols
+
+// hoist declares the functions defined with let that compiler.Hoisted returns, so they can call each
+// other. A local function gets its register before its let, the closures copy their free variables
+// when they are created, so the ones that capture it before it's set are fixed by initialize
+func (c *Compiler) hoist(statements []ast.Statement) {
+	for _, name := range compiler.Redefined(statements) {
+		c.report.Errorf(name, compiler.RedefinedFormat, name.Value)
+	}
+	for _, let := range compiler.HoistedLets(statements) {
+		if c.symbolTable.Outer == nil {
+			c.hoisted[let] = c.define(let.Name.Value, -1)
+			continue
+		}
+		register := c.allocate()
+		c.emit(OpLoadNull, register, 0, 0)
+		symbol := c.define(let.Name.Value, register)
+		c.hoisted[let] = symbol
+		c.scope.uninitialized[symbol.Index] = true
+	}
+}
+
+// keepForFixups keeps the closure in register in a register of its own when it captured local functions
+// that aren't set yet, so initialize can set its free variables
+func (c *Compiler) keepForFixups(register int, free []compiler.Symbol) {
+	closure := -1
+	for i, symbol := range free {
+		if symbol.Scope != compiler.LocalScope || !c.scope.uninitialized[symbol.Index] {
+			continue
+		}
+		if closure < 0 {
+			closure = c.allocate()
+			// Never released, like the registers of the locals
+			c.scope.isLocal[closure] = true
+			c.emit(OpMove, closure, register, 0)
+		}
+		c.scope.fixups = append(c.scope.fixups, fixup{closure: closure, free: i, target: symbol})
+	}
+}
+
+// initialize marks a local function as set and sets the free variables of the closures that captured it before
+func (c *Compiler) initialize(symbol compiler.Symbol) {
+	if !c.scope.uninitialized[symbol.Index] {
+		return
+	}
+	delete(c.scope.uninitialized, symbol.Index)
+	pending := c.scope.fixups[:0]
+	for _, fixup := range c.scope.fixups {
+		if fixup.target != symbol {
+			pending = append(pending, fixup)
+			continue
+		}
+		c.emit(OpSetFree, fixup.closure, fixup.free, c.scope.locals[symbol.Index])
+	}
+	c.scope.fixups = pending
+}
+
+// define adds a variable to the current scope, the locals live in register
+func (c *Compiler) define(name string, register int) compiler.Symbol {
+	symbol := c.symbolTable.Define(name)
+	if symbol.Scope == compiler.GlobalScope {
+		if symbol.Index >= c.numGlobals {
+			c.numGlobals = symbol.Index + 1
+		}
+		return symbol
+	}
+	c.scope.locals[symbol.Index] = register
+	c.scope.isLocal[register] = true
+	return symbol
+}
+
+// load writes the value of symbol in target
+func (c *Compiler) load(symbol compiler.Symbol, target int) {
+	switch symbol.Scope {
+	case compiler.GlobalScope:
+		c.emit(OpGetGlobal, target, symbol.Index, 0)
+	case compiler.BuiltinScope:
+		c.emit(OpGetBuiltin, target, symbol.Index, 0)
+	case compiler.FreeScope:
+		c.emit(OpGetFree, target, symbol.Index, 0)
+	case compiler.LocalScope:
+		if register := c.scope.locals[symbol.Index]; register != target {
+			c.emit(OpMove, target, register, 0)
+		}
+	}
+}
+
+// Statements
+
+func (c *Compiler) statement(s ast.Statement) {
+	defer c.report.SetLine(s)()
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		c.let(s)
+	case *ast.ReturnStatement:
+		register, temporary := c.operand(s.ReturnValue)
+		if c.symbolTable.Outer == nil {
+			// A return in the program stops it with its value
+			c.emit(OpMove, ResultRegister, register, 0)
+			c.emit(OpHalt, 0, 0, 0)
+		} else {
+			c.emit(OpReturn, register, 0, 0)
+		}
+		if temporary {
+			c.release(register)
+		}
+	case *ast.ExpressionStatement:
+		if c.symbolTable.Outer == nil {
+			c.expression(s.Expression, ResultRegister)
+			return
+		}
+		register := c.allocate()
+		c.expression(s.Expression, register)
+		c.release(register)
+	case *ast.BlockStatement:
+		c.hoist(s.Statements)
+		for _, inner := range s.Statements {
+			c.statement(inner)
+		}
+	default:
+		c.unsupported(s)
+	}
+}
+
+func (c *Compiler) let(s *ast.LetStatement) {
+	if symbol, ok := c.hoisted[s]; ok && symbol.Scope == compiler.LocalScope {
+		c.expression(s.Value, c.scope.locals[symbol.Index])
+		c.initialize(symbol)
+		return
+	}
+	if symbol, ok := c.hoisted[s]; ok {
+		register := c.allocate()
+		c.expression(s.Value, register)
+		c.emit(OpSetGlobal, register, symbol.Index, 0)
+		c.release(register)
+		return
+	}
+	if c.symbolTable.Outer == nil {
+		// A function can call itself like in the stack VM, the other values are compiled before the name is
+		// defined, so they use the previous definition
+		register := c.allocate()
+		_, isFunction := s.Value.(*ast.FunctionLiteral)
+		var symbol compiler.Symbol
+		if isFunction {
+			symbol = c.define(s.Name.Value, -1)
+		}
+		c.expression(s.Value, register)
+		if !isFunction {
+			symbol = c.define(s.Name.Value, -1)
+		}
+		c.emit(OpSetGlobal, register, symbol.Index, 0)
+		c.release(register)
+		return
+	}
+	register := c.allocate()
+	c.expression(s.Value, register)
+	c.define(s.Name.Value, register)
+}
+
+// block compiles the statements of a block and leaves the value of the last expression in target
+func (c *Compiler) block(block *ast.BlockStatement, target int) {
+	if len(block.Statements) == 0 {
+		c.emit(OpLoadNull, target, 0, 0)
+		return
+	}
+	c.hoist(block.Statements)
+	last := len(block.Statements) - 1
+	for _, s := range block.Statements[:last] {
+		c.statement(s)
+	}
+	if s, ok := block.Statements[last].(*ast.ExpressionStatement); ok {
+		defer c.report.SetLine(s)()
+		c.expression(s.Expression, target)
+		return
+	}
+	c.statement(block.Statements[last])
+	c.emit(OpLoadNull, target, 0, 0)
+}
+
+func (c *Compiler) unsupported(node ast.Node) {
+	c.report.Errorf(node, "the register VM doesn't support %s yet", strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast."))
+}
+
+// Expressions
+
+// operand returns a register with the value of node, temporary is set when the caller has to release it
+func (c *Compiler) operand(node ast.Expression) (register int, temporary bool) {
+	if ident, ok := node.(*ast.Identifier); ok {
+		if symbol, ok := c.symbolTable.Resolve(ident.Value); ok && symbol.Scope == compiler.LocalScope {
+			return c.scope.locals[symbol.Index], false
+		}
+	}
+	register = c.allocate()
+	c.expression(node, register)
+	return register, true
+}
+
+// expression compiles node and leaves its value in target
+func (c *Compiler) expression(node ast.Expression, target int) {
+	defer c.report.SetLine(node)()
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		c.emit(OpLoadConstant, target, c.constants.Add(&object.Integer{Value: node.Value}), 0)
+	case *ast.StringLiteral:
+		c.emit(OpLoadConstant, target, c.constants.Add(object.InternString(node.Value)), 0)
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(OpLoadTrue, target, 0, 0)
+		} else {
+			c.emit(OpLoadFalse, target, 0, 0)
+		}
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			c.report.Errorf(node, "undefined variable %s", node.Value)
+			return
+		}
+		c.load(symbol, target)
+	case *ast.PrefixExpression:
+		right, temporary := c.operand(node.Right)
+		switch node.Operator {
+		case "-":
+			c.emit(OpMinus, target, right, 0)
+		case "!":
+			c.emit(OpBang, target, right, 0)
+		default:
+			c.report.Errorf(node, "unknown prefix operator: %s", node.Operator)
+		}
+		if temporary {
+			c.release(right)
+		}
+	case *ast.InfixExpression:
+		c.infix(node, target)
+	case *ast.IfExpression:
+		condition, temporary := c.operand(node.Condition)
+		jumpNotTruthy := c.emit(OpJumpNotTruthy, condition, 0, 0)
+		if temporary {
+			c.release(condition)
+		}
+		c.block(node.Consequence, target)
+		jump := c.emit(OpJump, 0, 0, 0)
+		c.scope.fn.Instructions[jumpNotTruthy].B = len(c.scope.fn.Instructions)
+		if node.Alternative != nil {
+			c.block(node.Alternative, target)
+		} else {
+			c.emit(OpLoadNull, target, 0, 0)
+		}
+		c.scope.fn.Instructions[jump].A = len(c.scope.fn.Instructions)
+	case *ast.ArrayLiteral:
+		base := c.allocateRun(len(node.Elements))
+		for i, element := range node.Elements {
+			c.expression(element, base+i)
+		}
+		c.emit(OpArray, target, base, len(node.Elements))
+		c.releaseRun(base, len(node.Elements))
+	case *ast.HashLiteral:
+		keys := node.Keys
+		base := c.allocateRun(2 * len(keys))
+		for i, key := range keys {
+			c.expression(key, base+2*i)
+			c.expression(node.Pairs[key], base+2*i+1)
+		}
+		c.emit(OpHash, target, base, 2*len(keys))
+		c.releaseRun(base, 2*len(keys))
+	case *ast.IndexExpression:
+		left, leftTemporary := c.operand(node.Left)
+		index, indexTemporary := c.operand(node.Right)
+		c.emit(OpIndex, target, left, index)
+		if indexTemporary {
+			c.release(index)
+		}
+		if leftTemporary {
+			c.release(left)
+		}
+	case *ast.FunctionLiteral:
+		c.function(node, target)
+	case *ast.CallExpression:
+		if ident, ok := node.Function.(*ast.Identifier); ok && ident.Value == "quote" {
+			c.report.Errorf(node, "the register VM doesn't support quote yet")
+			return
+		}
+		base := c.allocateRun(1 + len(node.Arguments))
+		c.expression(node.Function, base)
+		for i, argument := range node.Arguments {
+			c.expression(argument, base+1+i)
+		}
+		c.emit(OpCall, target, base, len(node.Arguments))
+		c.releaseRun(base, 1+len(node.Arguments))
+	default:
+		c.unsupported(node)
+	}
+}
+
+func (c *Compiler) infix(node *ast.InfixExpression, target int) {
+	left, right := node.Left, node.Right
+	if node.Operator == "<" {
+		left, right = right, left
+	}
+	if integer, ok := right.(*ast.IntegerLiteral); ok && (node.Operator == "+" || node.Operator == "-") {
+		register, temporary := c.operand(left)
+		constant := c.constants.Add(&object.Integer{Value: integer.Value})
+		if node.Operator == "+" {
+			c.emit(OpAddConstant, target, register, constant)
+		} else {
+			c.emit(OpSubConstant, target, register, constant)
+		}
+		if temporary {
+			c.release(register)
+		}
+		return
+	}
+	leftRegister, leftTemporary := c.operand(left)
+	rightRegister, rightTemporary := c.operand(right)
+	switch node.Operator {
+	case "+":
+		c.emit(OpAdd, target, leftRegister, rightRegister)
+	case "-":
+		c.emit(OpSub, target, leftRegister, rightRegister)
+	case "*":
+		c.emit(OpMul, target, leftRegister, rightRegister)
+	case "/":
+		c.emit(OpDiv, target, leftRegister, rightRegister)
+	case "==":
+		c.emit(OpEqual, target, leftRegister, rightRegister)
+	case "!=":
+		c.emit(OpNotEqual, target, leftRegister, rightRegister)
+	case ">", "<":
+		c.emit(OpGreaterThan, target, leftRegister, rightRegister)
+	default:
+		c.report.Errorf(node, "unknown operator %s", node.Operator)
+	}
+	if rightTemporary {
+		c.release(rightRegister)
+	}
+	if leftTemporary {
+		c.release(leftRegister)
+	}
+}
+
+// function compiles the function literal in its own scope and leaves a closure of it in target
+func (c *Compiler) function(node *ast.FunctionLiteral, target int) {
+	outerScope := c.scope
+	// A function defined with let inside another function references itself through its own register,
+	// the variable of the outer function is set after the closure is made
+	selfReference := node.Name != "" && c.symbolTable.Outer != nil
+	c.scope = newFunctionScope(node.Name)
+	c.symbolTable = compiler.NewEnclosedSymbolTable(c.symbolTable)
+	for i, parameter := range node.Parameters {
+		c.reserve(i)
+		c.define(parameter.Value, i)
+	}
+	for _, parameter := range node.Parameters {
+		if parameter.Value == node.Name {
+			selfReference = false
+		}
+	}
+	if selfReference {
+		register := c.allocate()
+		c.define(node.Name, register)
+		c.emit(OpCurrentClosure, register, 0, 0)
+	}
+	c.body(node.Body)
+
+	fn := c.scope.fn
+	fn.NumParameters = len(node.Parameters)
+	free := c.symbolTable.FreeSymbols
+	fn.NumFree = len(free)
+	c.symbolTable = c.symbolTable.Outer
+	c.scope = outerScope
+
+	base := c.allocateRun(len(free))
+	for i, symbol := range free {
+		c.load(symbol, base+i)
+	}
+	c.emit(OpClosure, target, c.constants.Add(fn), base)
+	c.releaseRun(base, len(free))
+	c.keepForFixups(target, free)
+}
+
+// body compiles the statements of a function, the value of the last expression is returned
+func (c *Compiler) body(block *ast.BlockStatement) {
+	if len(block.Statements) == 0 {
+		c.emit(OpReturnNull, 0, 0, 0)
+		return
+	}
+	c.hoist(block.Statements)
+	last := len(block.Statements) - 1
+	for _, s := range block.Statements[:last] {
+		c.statement(s)
+	}
+	if s, ok := block.Statements[last].(*ast.ExpressionStatement); ok {
+		defer c.report.SetLine(s)()
+		register, temporary := c.operand(s.Expression)
+		c.emit(OpReturn, register, 0, 0)
+		if temporary {
+			c.release(register)
+		}
+		return
+	}
+	c.statement(block.Statements[last])
+	if n := len(c.scope.fn.Instructions); n == 0 || c.scope.fn.Instructions[n-1].Op != OpReturn {
+		c.emit(OpReturnNull, 0, 0, 0)
+	}
+}
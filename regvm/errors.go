@@ -0,0 +1,29 @@
+package regvm
+
+import (
+	"xlang/object"
+	"xlang/vm"
+)
+
+// runtimeError wraps err with the instruction that the current frame was running, the errors are the
+// same type as in the stack VM
+func (v *VM) runtimeError(err error) *vm.RuntimeError {
+	trace := make(object.StackTrace, 0, len(v.frames))
+	for i, f := range v.frames {
+		function := object.FunctionName(f.closure.Fn.Name)
+		if i == 0 {
+			function = "<main>"
+		}
+		// ip is already on the next instruction
+		trace = append(trace, object.TraceFrame{Function: function, Line: f.closure.Fn.Lines.Line(f.ip - 1)})
+	}
+	current := v.frames[len(v.frames)-1]
+	last := trace[len(trace)-1]
+	return &vm.RuntimeError{
+		Line:        last.Line,
+		Function:    last.Function,
+		Instruction: current.closure.Fn.Instructions[current.ip-1].Op.String(),
+		Err:         err,
+		Trace:       trace,
+	}
+}
@@ -0,0 +1,101 @@
+package regvm
+
+import (
+	"fmt"
+	"xlang/object"
+	"xlang/vm"
+)
+
+// The operations behave like the ones of the stack VM, the programs must give the same results in both
+
+func isTruthy(o object.Object) bool {
+	switch o := o.(type) {
+	case *object.Boolean:
+		return o.Value
+	case *object.Null:
+		return false
+	}
+	return true
+}
+
+func nativeToBooleanObject(b bool) *object.Boolean {
+	if b {
+		return vm.True
+	}
+	return vm.False
+}
+
+// add returns left + right, for integers and strings
+func add(left, right object.Object) (object.Object, error) {
+	switch rightObject := right.(type) {
+	case *object.Integer:
+		leftObject, ok := left.(*object.Integer)
+		if !ok {
+			return nil, fmt.Errorf("expected %s, got: %s", right.Type(), left.Type())
+		}
+		return &object.Integer{Value: leftObject.Value + rightObject.Value}, nil
+	case *object.String:
+		leftStr, ok := left.(*object.String)
+		if !ok {
+			return nil, fmt.Errorf("expected %s, got: %s", right.Type(), left.Type())
+		}
+		return object.InternString(leftStr.Value + rightObject.Value), nil
+	}
+	return nil, fmt.Errorf("can't add %s and %s", left.Type(), right.Type())
+}
+
+// integerOperation returns the result of the operations that only work with integers
+func integerOperation(op Opcode, left, right object.Object) (object.Object, error) {
+	leftInteger, ok := left.(*object.Integer)
+	if !ok {
+		return nil, fmt.Errorf("expected integer object, got=%s", left.Type())
+	}
+	rightInteger, ok := right.(*object.Integer)
+	if !ok {
+		return nil, fmt.Errorf("expected integer object, got=%s", right.Type())
+	}
+	switch op {
+	case OpSub, OpSubConstant:
+		return &object.Integer{Value: leftInteger.Value - rightInteger.Value}, nil
+	case OpMul:
+		return &object.Integer{Value: leftInteger.Value * rightInteger.Value}, nil
+	case OpDiv:
+		if rightInteger.Value == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return &object.Integer{Value: leftInteger.Value / rightInteger.Value}, nil
+	case OpGreaterThan:
+		return nativeToBooleanObject(leftInteger.Value > rightInteger.Value), nil
+	}
+	return nil, fmt.Errorf("unknown integer operation %s", op)
+}
+
+// buildHash makes a hash of the keys and values that alternate in elements
+func buildHash(elements []object.Object) *object.HashMap {
+	hash := object.NewHashMap()
+	for i := 0; i < len(elements); i += 2 {
+		hash.Put(elements[i], elements[i+1])
+	}
+	return hash
+}
+
+func index(left, index object.Object) (object.Object, error) {
+	switch left := left.(type) {
+	case *object.Array:
+		integer, ok := index.(*object.Integer)
+		if !ok {
+			return nil, fmt.Errorf("expected integer got=%s", index.Type())
+		}
+		if integer.Value < 0 || integer.Value >= int64(left.Len()) {
+			return vm.Null, nil
+		}
+		return left.Get(int(integer.Value)), nil
+	case *object.HashMap:
+		value, ok := left.Get(index)
+		if !ok {
+			return vm.Null, nil
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("invalid index operation on %s", left.Type())
+}
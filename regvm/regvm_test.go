@@ -0,0 +1,257 @@
+package regvm
+
+import (
+	"strings"
+	"testing"
+	"xlang/ast"
+	"xlang/compiler"
+	"xlang/lexer"
+	"xlang/object"
+	"xlang/parser"
+	"xlang/vm"
+)
+
+func parse(input string) *ast.Program {
+	return parser.New(lexer.New(input)).ParseProgram()
+}
+
+// runStack runs the program on the stack VM, the reference of what the register VM must return
+func runStack(t *testing.B, input string) object.Object {
+	t.Helper()
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("stack compiler error for %q: %s", input, err)
+	}
+	machine := vm.New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		t.Fatalf("stack VM error for %q: %s", input, err)
+	}
+	return machine.LastPoppedStackElem()
+}
+
+func compile(t *testing.B, input string) *Program {
+	t.Helper()
+	program, err := NewCompiler().Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error for %q: %s", input, err)
+	}
+	return program
+}
+
+func runRegister(t *testing.B, input string) object.Object {
+	t.Helper()
+	machine := New(compile(t, input))
+	if err := machine.Run(); err != nil {
+		t.Fatalf("register VM error for %q: %s", input, err)
+	}
+	return machine.Result()
+}
+
+func BenchmarkSameResultsAsStackVM(t *testing.B) {
+	tests := []string{
+		`1 + 2 * 3 - 4 / 2`,
+		`-5 + 10; !true; !!5; !(1 > 2)`,
+		`1 < 2; 2 > 1; 1 == 1; 1 != 1; true == true; true != false`,
+		`[[1, [2, "a"]] == [1, [2, "a"]], {"a": [1]} == {"a": [1]}, "a" + "b" != "ab", {[1, 2]: "x"}[[1, 2]]]`,
+		`[[1] == 1, "a" != 1, true == [true], 1 == "a", "a" == 1, 1 != "a"]`,
+		`[{[1, 2]: "x"}[[1, 2]], {{"a": 1, "b": 2}: "x"}[{"b": 2, "a": 1}]]`,
+		`let h = set({"b": 1, "a": [2], 3: "c"}, "d", 4); [h, keys(h), values(h)]`,
+		`"mon" + "key" + "!"`,
+		`if (1 > 2) { 10 } else { 20 }`,
+		`if (false) { 10 }`,
+		`[1, 2 + 3, "a"][1]`,
+		`[1, 2, 3][5]`,
+		`{"a": 1, 2: "b", true: 3}["a"]`,
+		`{"a": 1, 2: "b", true: 3}[true]`,
+		`let h = {"one": 1}; h["two"]`,
+		`let f = fn(a, b) { a * b }; f(3, 4) + f(1, 1)`,
+		`let f = fn() { }; f()`,
+		`let f = fn(n) { if (n > 0) { return 1; } return 2; }; f(1) + f(-1) * 10`,
+		`let add = fn(a) { fn(b) { fn(c) { a + b + c } } }; add(1)(2)(3)`,
+		`let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(15)`,
+		`let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; even(10)`,
+		`let outer = fn() { let count = fn(n) { if (n == 0) { 0 } else { 1 + count(n - 1) } }; count(5) }; outer()`,
+		`let outer = fn(x) { let y = x * 2; let inner = fn(z) { x + y + z }; inner(1) }; outer(10)`,
+		`len([1, 2, 3]) + len("four")`,
+		`push([1, 2], 3)`,
+		`first(shift([1, 2, 3]))`,
+		`let h = set({}, "k", 5); [h["k"], set(set(h, "a", 1), "k", 2)["k"], h["a"]]`,
+		`let map = fn(arr, f) { let iter = fn(arr, acc) { if (len(arr) == 0) { acc } else { iter(shift(arr), push(acc, f(first(arr)))) } }; iter(arr, []) }; map([1, 2, 3], fn(x) { x * 2 })`,
+		`let x = 10; let f = fn(x) { x + 1 }; f(1) + x`,
+		`let f = fn(n) { n }; let f = fn(n) { if (n == 0) { 100 } else { f(n - 1) + 1 } }; f(3)`,
+		`let a = if (true) { let b = 5; b * 2 } else { 0 }; a`,
+		`let f = fn(a) { let b = a + 1; let c = b + 1; [a, b, c] }; f(1)`,
+		`let w = fn() { let a = fn(n) { if (n < 1) { 0 } else { b(n - 1) } }; let b = fn(n) { a(n) + 1 }; a(4) }; w()`,
+		`let w = fn() { let mk = fn(n) { fn() { helper(n) } }; let helper = fn(x) { x * 2 }; mk(4)() }; w()`,
+		`let w = fn(k) { if (k > 0) { let a = fn() { b() + k }; let b = fn() { 7 }; a() } else { 0 } }; w(1)`,
+		`let scale = 3; let f = fn(arr) { let n = len(arr); map(arr, fn(x) { x * scale + n }) }; [f([1, 2]), filter([1, 2, 3], fn(x) { x != 2 })]`,
+		`let total = fn(arrays) { map(arrays, fn(arr) { reduce(arr, 0, fn(a, b) { a + b }) }) }; total([[1, 2], [3, 4, 5]])`,
+		`[sort([3, 1, 2], fn(a, b) { a > b }), find([1, 2, 3], fn(x) { x > 1 }), any([1], fn(x) { false }), all([1], fn(x) { true })]`,
+	}
+
+	for _, input := range tests {
+		expected := runStack(t, input)
+		got := runRegister(t, input)
+		if expected.Inspect() != got.Inspect() {
+			t.Errorf("wrong result for %q. stack VM=%s, register VM=%s", input, expected.Inspect(), got.Inspect())
+		}
+	}
+}
+
+func BenchmarkInstructions(t *testing.B) {
+	program := compile(t, `let f = fn(n) { n - 1 + n }; f(2)`)
+	var fn *Function
+	for _, constant := range program.Constants {
+		if constant, ok := constant.(*Function); ok {
+			fn = constant
+		}
+	}
+	expected := strings.Join([]string{
+		"0000 SUBK 2 0 0",
+		"0001 ADD 1 2 0",
+		"0002 RETURN 1",
+	}, "\n")
+	if fn.Instructions.String() != expected {
+		t.Fatalf("wrong instructions.\nwant=%s\ngot= %s", expected, fn.Instructions)
+	}
+	if fn.NumRegisters != 3 || fn.NumParameters != 1 {
+		t.Fatalf("wrong registers, got=%d, parameters=%d", fn.NumRegisters, fn.NumParameters)
+	}
+}
+
+func BenchmarkRegisterErrors(t *testing.B) {
+	runtimeTests := []struct {
+		input    string
+		expected string
+	}{
+		{`1 + true`, "line 1, in <main>, ADD: can't add INTEGER and BOOL"},
+		{"let f = fn(a) {\n  a - \"x\"\n};\nf(1)", "line 2, in f, SUB: expected integer object, got=STRING"},
+		{`let f = fn(a) { a }; f(1, 2)`, "line 1, in <main>, CALL: wrong number of parameters, expected=1, got=2"},
+		{`let f = fn(n) { f(n + 1) }; f(0)`, "line 1, in f, CALL: stack overflow"},
+		// A redefined function calls itself like in the stack VM, not the previous definition
+		{`let f = fn(x) { x }; let f = fn(x) { f(x) + 1 }; f(1)`, "line 1, in f, CALL: stack overflow"},
+		{`10 / 0`, "line 1, in <main>, DIV: division by zero"},
+	}
+	for _, tt := range runtimeTests {
+		err := New(compile(t, tt.input)).Run()
+		runtimeErr, ok := err.(*vm.RuntimeError)
+		if !ok {
+			t.Fatalf("expected a *vm.RuntimeError for %q, got=%T (%v)", tt.input, err, err)
+		}
+		if runtimeErr.Error() != tt.expected {
+			t.Errorf("wrong error for %q.\nwant=%q\ngot= %q", tt.input, tt.expected, runtimeErr.Error())
+		}
+	}
+
+	_, err := NewCompiler().Compile(parse("let a = b;\nmatch (a) { _ => 1 }"))
+	expected := "line 1: error: undefined variable b\nline 2: error: the register VM doesn't support MatchExpression yet"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("wrong compiler error.\nwant=%q\ngot= %q", expected, err)
+	}
+
+	// The constructs that the register VM doesn't support
+	unsupported := []struct {
+		input    string
+		expected string
+	}{
+		{"enum Shape { Empty }", "line 1: error: the register VM doesn't support EnumStatement yet"},
+		{"let f = fn(x) {\n  match (x) { _ => 1 } }", "line 2: error: the register VM doesn't support MatchExpression yet"},
+		{"let t = (1, 2)", "line 1: error: the register VM doesn't support TupleLiteral yet"},
+		{"let (a, b) = [1, 2]", "line 1: error: the register VM doesn't support DestructureStatement yet"},
+		{"let a = [1];\na[0] = 2", "line 2: error: the register VM doesn't support IndexAssignStatement yet"},
+		{"let code = quote(1 + 2)", "line 1: error: the register VM doesn't support quote yet"},
+	}
+	for _, tt := range unsupported {
+		_, err := NewCompiler().Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong compiler error for %q.\nwant=%q\ngot= %q", tt.input, tt.expected, err)
+		}
+	}
+
+	_, err = NewCompiler().Compile(parse("let w = fn() {\n  let f = fn() { g() };\n  let g = fn() { 1 };\n  let g = fn() { 2 };\n  f()\n}"))
+	expected = "line 4: error: can't define g again, the functions defined before its first let use it"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("wrong compiler error.\nwant=%q\ngot= %q", expected, err)
+	}
+}
+
+// The workloads run on both VMs, go test -bench . ./regvm compares them
+var workloads = []struct {
+	name     string
+	input    string
+	expected string
+}{
+	{
+		"fib",
+		`let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(20)`,
+		"6765",
+	},
+	{
+		// The array is split in halves so the recursion stays shallow
+		"reduce",
+		`let range = fn(from, to) { if (from == to) { [] } else { if (to - from == 1) { [from] } else { let middle = from + (to - from) / 2; concat(range(from, middle), range(middle, to)) } } };
+		let concat = fn(a, b) { if (len(b) == 0) { a } else { concat(push(a, first(b)), shift(b)) } };
+		let reduce = fn(arr, from, to, initial, f) {
+			if (to - from == 1) { f(initial, arr[from]) } else {
+				let middle = from + (to - from) / 2;
+				reduce(arr, middle, to, reduce(arr, from, middle, initial, f), f)
+			}
+		};
+		let numbers = range(0, 1000);
+		reduce(numbers, 0, len(numbers), 0, fn(acc, n) { acc + n * 2 })`,
+		"999000",
+	},
+	{
+		"hash",
+		`let fill = fn(h, from, to) {
+			if (to - from == 1) { set(h, from, {"value": from, "double": from * 2}) } else {
+				let middle = from + (to - from) / 2;
+				fill(fill(h, from, middle), middle, to)
+			}
+		};
+		let sum = fn(h, from, to) {
+			if (to - from == 1) { h[from]["value"] + h[from]["double"] } else {
+				let middle = from + (to - from) / 2;
+				sum(h, from, middle) + sum(h, middle, to)
+			}
+		};
+		let h = fill({}, 0, 2000);
+		sum(h, 0, 2000)`,
+		"5997000",
+	},
+}
+
+func BenchmarkWorkloads(t *testing.B) {
+	for _, workload := range workloads {
+		comp := compiler.New()
+		comp.SetOptions(compiler.Options{FoldConstants: true, Peephole: true})
+		if err := comp.Compile(parse(workload.input)); err != nil {
+			t.Fatalf("stack compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+		program := compile(t, workload.input)
+
+		t.Run(workload.name+"/stack", func(t *testing.B) {
+			for i := 0; i < t.N; i++ {
+				machine := vm.New(bytecode)
+				if err := machine.Run(); err != nil {
+					t.Fatalf("vm error: %s", err)
+				}
+				if result := machine.LastPoppedStackElem().Inspect(); result != workload.expected {
+					t.Fatalf("wrong result, want=%s, got=%s", workload.expected, result)
+				}
+			}
+		})
+		t.Run(workload.name+"/register", func(t *testing.B) {
+			for i := 0; i < t.N; i++ {
+				machine := New(program)
+				if err := machine.Run(); err != nil {
+					t.Fatalf("vm error: %s", err)
+				}
+				if result := machine.Result().Inspect(); result != workload.expected {
+					t.Fatalf("wrong result, want=%s, got=%s", workload.expected, result)
+				}
+			}
+		})
+	}
+}
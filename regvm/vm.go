@@ -0,0 +1,258 @@
+package regvm
+
+import (
+	"fmt"
+	"xlang/object"
+	"xlang/vm"
+)
+
+// MaxRegisters is the size that the registers of all the running functions can take
+const MaxRegisters = 1 << 20
+
+// MaxFrames is the number of calls that can be running at the same time
+const MaxFrames = vm.MaxFrames
+
+// frame is a call that is running, its registers start at base
+type frame struct {
+	closure *Closure
+	ip      int
+	base    int
+	// result is the register of the caller, counted from the first register, that gets the returned value
+	result int
+}
+
+// VM runs the programs of the register compiler
+type VM struct {
+	constants []object.Object
+	globals   []object.Object
+	registers []object.Object
+	frames    []frame
+}
+
+// New returns a VM that runs program
+func New(program *Program) *VM {
+	main := &Closure{Fn: program.Main}
+	return &VM{
+		constants: program.Constants,
+		globals:   make([]object.Object, program.NumGlobals),
+		registers: make([]object.Object, max(1024, program.Main.NumRegisters)),
+		frames:    append(make([]frame, 0, 64), frame{closure: main}),
+	}
+}
+
+// Result returns the value of the last expression of the program that ran
+func (v *VM) Result() object.Object {
+	return v.registers[ResultRegister]
+}
+
+// Run runs the program, the errors are *vm.RuntimeError with the place where they happened
+func (v *VM) Run() error {
+	if err := v.run(0); err != nil {
+		return v.runtimeError(err)
+	}
+	return nil
+}
+
+// run runs the instructions until there are stop frames after a return, 0 runs the program until its halt
+func (v *VM) run(stop int) error {
+	f := &v.frames[len(v.frames)-1]
+	ins := f.closure.Fn.Instructions
+	r := v.registers[f.base:]
+	for {
+		in := ins[f.ip]
+		f.ip++
+		switch in.Op {
+		case OpMove:
+			r[in.A] = r[in.B]
+		case OpLoadConstant:
+			r[in.A] = v.constants[in.B]
+		case OpLoadTrue:
+			r[in.A] = vm.True
+		case OpLoadFalse:
+			r[in.A] = vm.False
+		case OpLoadNull:
+			r[in.A] = vm.Null
+		case OpGetGlobal:
+			if v.globals[in.B] == nil {
+				return fmt.Errorf("variable used before its definition")
+			}
+			r[in.A] = v.globals[in.B]
+		case OpSetGlobal:
+			v.globals[in.B] = r[in.A]
+		case OpGetBuiltin:
+			r[in.A] = object.GetBuiltins()[in.B].Builtin
+		case OpGetFree:
+			r[in.A] = f.closure.Free[in.B]
+		case OpSetFree:
+			r[in.A].(*Closure).Free[in.B] = r[in.C]
+		case OpCurrentClosure:
+			r[in.A] = f.closure
+		case OpAdd, OpAddConstant:
+			right := v.constantOrRegister(in, r)
+			if left, ok := r[in.B].(*object.Integer); ok {
+				if right, ok := right.(*object.Integer); ok {
+					r[in.A] = &object.Integer{Value: left.Value + right.Value}
+					continue
+				}
+			}
+			result, err := add(r[in.B], right)
+			if err != nil {
+				return err
+			}
+			r[in.A] = result
+		case OpSub, OpSubConstant, OpMul, OpDiv, OpGreaterThan:
+			result, err := integerOperation(in.Op, r[in.B], v.constantOrRegister(in, r))
+			if err != nil {
+				return err
+			}
+			r[in.A] = result
+		case OpEqual, OpNotEqual:
+			r[in.A] = nativeToBooleanObject(object.Equal(r[in.B], r[in.C]) == (in.Op == OpEqual))
+		case OpMinus:
+			integer, ok := r[in.B].(*object.Integer)
+			if !ok {
+				return fmt.Errorf("expected integer object, got=%s", r[in.B].Type())
+			}
+			r[in.A] = &object.Integer{Value: -integer.Value}
+		case OpBang:
+			r[in.A] = nativeToBooleanObject(!isTruthy(r[in.B]))
+		case OpJump:
+			f.ip = in.A
+		case OpJumpNotTruthy:
+			if !isTruthy(r[in.A]) {
+				f.ip = in.B
+			}
+		case OpArray:
+			r[in.A] = object.NewArray(r[in.B : in.B+in.C])
+		case OpHash:
+			r[in.A] = buildHash(r[in.B : in.B+in.C])
+		case OpIndex:
+			result, err := index(r[in.B], r[in.C])
+			if err != nil {
+				return err
+			}
+			r[in.A] = result
+		case OpClosure:
+			fn := v.constants[in.B].(*Function)
+			free := make([]object.Object, fn.NumFree)
+			copy(free, r[in.C:in.C+fn.NumFree])
+			r[in.A] = &Closure{Fn: fn, Free: free}
+		case OpCall:
+			switch callee := r[in.B].(type) {
+			case *Closure:
+				if callee.Fn.NumParameters != in.C {
+					return fmt.Errorf("wrong number of parameters, expected=%d, got=%d", callee.Fn.NumParameters, in.C)
+				}
+				if len(v.frames) >= MaxFrames {
+					return fmt.Errorf("stack overflow")
+				}
+				base := f.base + in.B + 1
+				if err := v.ensureRegisters(base + callee.Fn.NumRegisters); err != nil {
+					return err
+				}
+				v.frames = append(v.frames, frame{closure: callee, base: base, result: f.base + in.A})
+				f = &v.frames[len(v.frames)-1]
+				ins = callee.Fn.Instructions
+				r = v.registers[base:]
+			case *object.Builtin:
+				result, err := callee.Call(v, r[in.B+1:in.B+1+in.C]...)
+				if err != nil {
+					return err
+				}
+				if result == nil {
+					result = vm.Null
+				}
+				// The functions that the builtin called can grow the frames and the registers
+				f = &v.frames[len(v.frames)-1]
+				r = v.registers[f.base:]
+				r[in.A] = result
+			case nil:
+				return fmt.Errorf("unexpected call of a function")
+			default:
+				return fmt.Errorf("can't call type=%s, expected a function", callee.Type())
+			}
+		case OpReturn, OpReturnNull:
+			var result object.Object = vm.Null
+			if in.Op == OpReturn {
+				result = r[in.A]
+			}
+			v.registers[f.result] = result
+			v.frames = v.frames[:len(v.frames)-1]
+			if len(v.frames) == stop {
+				return nil
+			}
+			f = &v.frames[len(v.frames)-1]
+			ins = f.closure.Fn.Instructions
+			r = v.registers[f.base:]
+		case OpHalt:
+			return nil
+		default:
+			return fmt.Errorf("unknown opcode %d", in.Op)
+		}
+	}
+}
+
+// CallFunction calls fn with the arguments, it's how the builtins like map call the closures of the program.
+// The closure gets the registers after the ones of the function that is running and its result goes in the first of them
+func (v *VM) CallFunction(fn object.Object, args ...object.Object) (object.Object, error) {
+	switch fn := fn.(type) {
+	case *Closure:
+		if fn.Fn.NumParameters != len(args) {
+			return nil, fmt.Errorf("wrong number of parameters, expected=%d, got=%d", fn.Fn.NumParameters, len(args))
+		}
+		if len(v.frames) >= MaxFrames {
+			return nil, fmt.Errorf("stack overflow")
+		}
+		current := v.frames[len(v.frames)-1]
+		result := current.base + current.closure.Fn.NumRegisters
+		base := result + 1
+		if err := v.ensureRegisters(base + max(fn.Fn.NumRegisters, len(args))); err != nil {
+			return nil, err
+		}
+		copy(v.registers[base:], args)
+		stop := len(v.frames)
+		v.frames = append(v.frames, frame{closure: fn, base: base, result: result})
+		if err := v.run(stop); err != nil {
+			return nil, err
+		}
+		return v.registers[result], nil
+	case *object.Builtin:
+		result, err := fn.Call(v, args...)
+		if result == nil && err == nil {
+			result = vm.Null
+		}
+		return result, err
+	case nil:
+		return nil, fmt.Errorf("unexpected call of a function")
+	}
+	return nil, fmt.Errorf("can't call type=%s, expected a function", fn.Type())
+}
+
+// constantOrRegister returns the right operand of an instruction that can take it from a constant
+func (v *VM) constantOrRegister(in Instruction, r []object.Object) object.Object {
+	if in.Op == OpAddConstant || in.Op == OpSubConstant {
+		return v.constants[in.C]
+	}
+	return r[in.C]
+}
+
+// ensureRegisters grows the registers so there are at least n
+func (v *VM) ensureRegisters(n int) error {
+	if n <= len(v.registers) {
+		return nil
+	}
+	if n > MaxRegisters {
+		return fmt.Errorf("stack overflow")
+	}
+	registers := make([]object.Object, max(n, 2*len(v.registers)))
+	copy(registers, v.registers)
+	v.registers = registers
+	return nil
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
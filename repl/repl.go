@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"xlang/eval"
 	"xlang/lexer"
+	"xlang/object"
 	"xlang/parser"
 )
 
@@ -29,6 +30,7 @@ func Start(in io.Reader, out io.Writer) {
 	`)
 	evaluator := eval.NewEval()
 	AddToStandardFunctions(evaluator)
+	macros := object.NewEnvironment()
 	for {
 		fmt.Printf(PROMPT)
 		scanned := scanner.Scan()
@@ -47,6 +49,11 @@ func Start(in io.Reader, out io.Writer) {
 			}
 			continue
 		}
+		program, err := eval.Expand(program, macros)
+		if err != nil {
+			io.WriteString(out, ERROR_MSG+" Error expanding macros: "+err.Error()+"\n")
+			continue
+		}
 		io.WriteString(out, "Built AST succesfully, running...\n")
 		evaluatedProgram := evaluator.Eval(program)
 		if evaluatedProgram == nil {
@@ -54,33 +61,13 @@ func Start(in io.Reader, out io.Writer) {
 		}
 		io.WriteString(out, evaluatedProgram.Inspect())
 		io.WriteString(out, "\n")
+		if err, ok := evaluatedProgram.(*object.Error); ok {
+			io.WriteString(out, err.Trace.String())
+		}
 	}
 }
 
-// let reduce = fn(arr, initial, f) {
-// 	let iter = fn(arr, result) {
-// 		 if (len(arr) == 0) {
-// 				return result
-// 		 }
-// 		 iter(shift(arr), f(result, first(arr)));
-// 	 }
-// 	iter(arr, initial)
-// }
 const standardLibrary = `
-
-
-let map = fn(arr, f) {
-  let iter = fn(arr, accumulated) {
-    if (len(arr) == 0) {
-      accumulated
-    } else {
-      iter(shift(arr), push(accumulated, f(first(arr))));
-    }
-  };
-
-  iter(arr, []);
-};
-
 let wrapper = fn() {
 	let countDown = fn(x) {
 			if (x == 0) {
@@ -6,36 +6,13 @@ import (
 	"io"
 	"strconv"
 	"xlang/compiler"
+	"xlang/eval"
 	"xlang/lexer"
 	"xlang/object"
 	"xlang/parser"
 	"xlang/vm"
 )
 
-const standardLibraryVM = `
-let reduce = fn(arr, initial, f) {
-	 let iter = fn(arr, result) {
-		  if (len(arr) == 0) {
-				 return result 
-			}
-			iter(shift(arr), f(result, first(arr)));
-		}
-	 iter(arr, initial) 
-}
-
-let map = fn(arr, f) {
-  let iter = fn(arr, accumulated) {
-    if (len(arr) == 0) {
-      accumulated
-    } else {
-      iter(rest(arr), push(accumulated, f(first(arr))));
-    }
-  };
-
-  iter(arr, []);
-};
-`
-
 // StartVM starts the REPL with the VM version of xlang
 func StartVM(in io.Reader, out io.Writer) {
 	fmt.Println(`
@@ -53,6 +30,7 @@ func StartVM(in io.Reader, out io.Writer) {
 	globals := make([]object.Object, vm.GlobalsSize)
 	constants := []object.Object{}
 	addedStandard := false
+	macros := object.NewEnvironment()
 	var currentSymbolTable *compiler.SymbolTable
 	for {
 		fmt.Fprintf(out, PROMPT)
@@ -76,15 +54,28 @@ func StartVM(in io.Reader, out io.Writer) {
 			}
 			continue
 		}
+		program, err := eval.Expand(program, macros)
+		if err != nil {
+			fmt.Fprintf(out, "Woops! Expanding macros failed:\n %s\n", err)
+			continue
+		}
 		var comp *compiler.Compiler
 		if currentSymbolTable == nil {
 			comp = compiler.New()
 		} else {
 			comp = compiler.NewWithState(currentSymbolTable, constants)
 		}
-		err := comp.Compile(program)
+		comp.SetOptions(compiler.Options{FoldConstants: true, Peephole: true})
+		err = comp.Compile(program)
 		if err != nil {
-			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
+			fmt.Fprintf(out, "Woops! Compilation failed:\n")
+			if diagnostics, ok := err.(compiler.Diagnostics); ok {
+				for _, diagnostic := range diagnostics {
+					fmt.Fprintf(out, " %s\n", diagnostic)
+				}
+			} else {
+				fmt.Fprintf(out, " %s\n", err)
+			}
 			continue
 		}
 		bytecode := comp.Bytecode()
@@ -95,6 +86,9 @@ func StartVM(in io.Reader, out io.Writer) {
 		err = machine.Run()
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
+			if err, ok := err.(*vm.RuntimeError); ok {
+				io.WriteString(out, err.Trace.String())
+			}
 			continue
 		}
 
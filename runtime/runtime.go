@@ -8,6 +8,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"xlang/ast"
+	"xlang/compiler"
 	"xlang/eval"
 	"xlang/lexer"
 	"xlang/object"
@@ -42,6 +44,10 @@ type Output struct {
 	ParseError Message   `json:"parse_error"`
 	Error      Message   `json:"error"`
 	Output     []Message `json:"output"`
+	// Trace are the calls that led to Error
+	Trace object.StackTrace `json:"trace"`
+	// Diagnostics are all the problems that the compiler finds in the program
+	Diagnostics compiler.Diagnostics `json:"diagnostics"`
 }
 
 // Print the output of the program
@@ -50,7 +56,11 @@ func (o *Output) Print() {
 	for _, msg := range o.Output {
 		logMsg.WriteString(msg.Prettify(true))
 	}
-	log.Printf("\nParsing errors: %d\n%sNumber Of Errors: %d\n%sOutput:\n%s", len(o.ParseError.Message), o.ParseError.Prettify(true), len(o.Error.Message), o.Error.Prettify(true), logMsg.String())
+	diagnostics := strings.Builder{}
+	for _, diagnostic := range o.Diagnostics {
+		diagnostics.WriteString(fmt.Sprintf("\t%s\n", diagnostic))
+	}
+	log.Printf("\nParsing errors: %d\n%sCompile errors: %d\n%sNumber Of Errors: %d\n%s%sOutput:\n%s", len(o.ParseError.Message), o.ParseError.Prettify(true), len(o.Diagnostics), diagnostics.String(), len(o.Error.Message), o.Error.Prettify(true), o.Trace.String(), logMsg.String())
 }
 
 // OpenFileAndParse parses the program
@@ -76,53 +86,65 @@ func OpenFileAndParse(filePath string) (*Output, error) {
 
 // Parse .
 func Parse(code string) *Output {
-	code = strings.TrimSpace(code)
-	betterCode := strings.Builder{}
-	nOfComments := 0
-	for _, s := range strings.Split(code, "\n") {
-		s2 := strings.TrimSpace(s)
-		if len(s2) < 2 || s2[:2] != "//" {
-			betterCode.WriteString(s + string('\n'))
-		} else {
-			nOfComments++
-			continue
-		}
-
-	}
-
-	code = betterCode.String()
+	code = StripComments(code)
 	fmt.Println(code)
-	eval := eval.NewEval()
-	AddToStandardFunctions(eval)
+	evaluator := eval.NewEval()
 	output := Output{}
 
 	l := lexer.New(code)
 	parser := parser.New(l)
 	program := parser.ParseProgram()
 	if len(parser.Errors()) > 0 {
-		return &Output{ParseError: Message{Line: uint64(program.Line()) + uint64(nOfComments), Message: parser.Errors()}}
+		return &Output{ParseError: Message{Line: uint64(program.Line()), Message: parser.Errors()}}
 	}
 	if program == nil {
 		return &Output{ParseError: Message{Line: 0, Message: []string{"Error parsing program"}}}
 	}
-	message := eval.Eval(program)
+	program, err := eval.Expand(program, object.NewEnvironment())
+	if err != nil {
+		return &Output{ParseError: Message{Line: 0, Message: []string{err.Error()}}}
+	}
+	// The evaluator still runs the program, it allows things that the compiler doesn't, like unquote outside of macros
+	output.Diagnostics = check(program)
+	message := evaluator.Eval(program)
 	if message == nil {
 		return &output
 	}
-	for _, message := range eval.Log {
+	for _, message := range evaluator.Log {
 		if message.Type() == object.LogObject {
 			message := message.(*object.Log)
-			output.Output = append(output.Output, Message{Line: message.Line + uint64(nOfComments), Message: []string{message.Inspect()}})
+			output.Output = append(output.Output, Message{Line: message.Line, Message: []string{message.Inspect()}})
 		}
 	}
 	if message.Type() == object.ErrorObject {
-		output.Error = Message{Line: uint64(program.Line()) + uint64(nOfComments), Message: []string{message.Inspect()}}
+		output.Error = Message{Line: uint64(program.Line()), Message: []string{message.Inspect()}}
+		output.Trace = message.(*object.Error).Trace
 		return &output
 	}
 
 	return &output
 }
 
+// StripComments blanks the lines that are comments, the lexer doesn't know them. The lines are kept empty
+// so the lines of the code stay the same
+func StripComments(code string) string {
+	lines := strings.Split(code, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "//") {
+			lines[i] = ""
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// check compiles the program, without running it, to find all the problems that the compiler sees at once,
+// like the undefined variables in branches that don't run
+func check(program *ast.Program) compiler.Diagnostics {
+	comp := compiler.New()
+	comp.Compile(program)
+	return comp.Diagnostics()
+}
+
 // Start stars the REPL of Xlang.
 func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
@@ -138,7 +160,7 @@ func Start(in io.Reader, out io.Writer) {
 	Made by Gabriel Villalonga in Golang. Followed a book and made research to make an interpreter.
 	`)
 	evaluator := eval.NewEval()
-	AddToStandardFunctions(evaluator)
+	macros := object.NewEnvironment()
 	for {
 		scanned := scanner.Scan()
 		if !scanned {
@@ -156,6 +178,11 @@ func Start(in io.Reader, out io.Writer) {
 			}
 			continue
 		}
+		program, err := eval.Expand(program, macros)
+		if err != nil {
+			io.WriteString(out, ERROR_MSG+" Error expanding macros: "+err.Error()+"\n")
+			continue
+		}
 		io.WriteString(out, "Built AST succesfully, running...\n")
 		evaluatedProgram := evaluator.Eval(program)
 		if evaluatedProgram == nil {
@@ -163,35 +190,8 @@ func Start(in io.Reader, out io.Writer) {
 		}
 		io.WriteString(out, evaluatedProgram.Inspect())
 		io.WriteString(out, "\n")
-	}
-}
-
-const standardLibrary = `
-let reduce = fn(arr, initial, f) {
-	 let iter = fn(arr, result) {
-		  if (len(arr) == 0) {
-				 return result 
-			}
-			iter(shift(arr), f(result, first(arr)));
+		if err, ok := evaluatedProgram.(*object.Error); ok {
+			io.WriteString(out, err.Trace.String())
 		}
-	 iter(arr, initial) 
-}
-
-let map = fn(x, f) {
-  let iter = fn (arr, result) {
-    if (len(arr) == 0) {
-      return result;
-    }
-    iter(shift(arr), push(result, f(first(arr))));
-  }
-  iter(x, []);
-}
-`
-
-// AddToStandardFunctions adds util functions into the language
-func AddToStandardFunctions(ev *eval.Evaluator) {
-	l := lexer.New(standardLibrary)
-	p := parser.New(l)
-	program := p.ParseProgram()
-	ev.Eval(program)
+	}
 }
@@ -42,6 +42,16 @@ func testIntegerObjectEval(t *testing.T, obj object.Object, expected int64) bool
 	return true
 }
 
+func TestDivisionByZero(t *testing.T) {
+	for _, input := range []string{"10 / 0", "let f = fn(x) { 10 / x }; f(0)"} {
+		evaluated := testEval(input)
+		err, ok := evaluated.(*object.Error)
+		if !ok || err.Message != "division by zero" {
+			t.Errorf("wrong result for %q. got=%s", input, evaluated.Inspect())
+		}
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input string
@@ -87,3 +97,413 @@ func TestBangOperator(t *testing.T) {
 		testBooleanObject(t, evaluated, tt.expected)
 	}
 }
+
+func TestArrowFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let add = (a, b) => a + b; add(1, 2)", 3},
+		{"let one = () => 1; one()", 1},
+		{"let double = (x) => { let y = x * 2; y }; double(4)", 8},
+		{"((x) => x + 1)(1)", 2},
+		{"(1 + 2) * 3", 9},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testIntegerObjectEval(t, evaluated, tt.expected)
+	}
+}
+
+func TestPipeOperator(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let double = (x) => x * 2; 2 |> double", 4},
+		{"let add = (a, b) => a + b; 1 |> add(2) |> add(3)", 6},
+		{"let sub = (a, b) => a - b; 10 |> sub(3)", 7},
+		{"let double = (x) => x * 2; 1 + 2 |> double", 6},
+		{"[1, 2, 3] |> len", 3},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testIntegerObjectEval(t, evaluated, tt.expected)
+	}
+}
+
+func TestMutuallyRecursiveFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`
+		let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+		let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+		isEven(10)
+		`, true},
+		{`
+		let wrapper = fn() {
+			let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+			let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+			isOdd(4)
+		};
+		wrapper()
+		`, false},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestEnums(t *testing.T) {
+	shape := `
+	enum Shape { Circle(r), Rect(w, h), Empty }
+	let area = fn(s) {
+		match (s) {
+			Shape.Circle(r) => 3 * r * r,
+			Shape.Rect(w, h) => w * h,
+			_ => 0
+		}
+	};
+	`
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{shape + "area(Shape.Circle(2))", int64(12)},
+		{shape + "area(Shape.Rect(2, 5))", int64(10)},
+		{shape + "area(Shape.Empty)", int64(0)},
+		{shape + "Shape.Rect(1, 2) == Shape.Rect(1, 2)", true},
+		{shape + "Shape.Rect(1, 2) != Shape.Rect(1, 2)", false},
+		{shape + `{Shape.Empty: 7}[Shape.Empty]`, int64(7)},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int64:
+			testIntegerObjectEval(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+	if inspected := testEval(shape + "Shape.Rect(1, 2)").Inspect(); inspected != "Shape.Rect(1, 2)" {
+		t.Errorf("wrong inspect. want=%q, got=%q", "Shape.Rect(1, 2)", inspected)
+	}
+}
+
+func testExpandEval(t *testing.T, input string) object.Object {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	program, err := eval.Expand(program, object.NewEnvironment())
+	if err != nil {
+		t.Fatalf("expand error: %s", err)
+	}
+	return eval.NewEval().Eval(program)
+}
+
+func TestMacros(t *testing.T) {
+	unless := `
+	let unless = macro(cond, consequence, alternative) {
+		quote(if (!(unquote(cond))) { unquote(consequence) } else { unquote(alternative) })
+	};
+	`
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`quote(1 + 2)`, "QUOTE((1 + 2))"},
+		{`let x = 8; quote(unquote(x) + 2)`, "QUOTE((8 + 2))"},
+		{`quote(unquote(quote(4 + 4)) * 2)`, "QUOTE(((4 + 4) * 2))"},
+		{unless + `unless(10 > 5, 1, 2)`, int64(2)},
+		{unless + `unless(10 < 5, 1, 2)`, int64(1)},
+		// The let inside the macro must not clobber the caller's variable
+		{`
+		let double = macro(x) { quote(if (true) { let tmp = unquote(x); tmp * 2 }) };
+		let tmp = 5;
+		double(tmp + 1);
+		tmp
+		`, int64(5)},
+		// Only the names bound inside the quote are renamed, the others are the caller's variables
+		{`
+		let x = 10;
+		let m = macro() { quote(x + fn(x) { x * 2 }(1)) };
+		m()
+		`, int64(12)},
+		{`
+		let n = 1;
+		let m = macro() { quote(if (true) { let n = n + 1; n * 10 }) };
+		m() + n
+		`, int64(21)},
+		{`
+		let m = macro() { quote(if (true) { let count = fn(k) { if (k == 0) { 0 } else { count(k - 1) + 1 } }; count(3) }) };
+		m()
+		`, int64(3)},
+		{`
+		let code = macro(x) { quote(unquote(source(x))) };
+		code(1 + 2 * 3)
+		`, "(1 + (2 * 3))"},
+	}
+	for _, tt := range tests {
+		evaluated := testExpandEval(t, tt.input)
+		switch expected := tt.expected.(type) {
+		case int64:
+			testIntegerObjectEval(t, evaluated, expected)
+		case string:
+			if evaluated.Inspect() != expected {
+				t.Errorf("wrong result. want=%q, got=%q", expected, evaluated.Inspect())
+			}
+		}
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let m = macro(a) { quote(unquote(a)) }; m(1, 2)`, "macro m expected 1 arguments, got 2"},
+		{`let m = macro(a) { 1 }; m(1)`, "macro m must return a quote, got INTEGER"},
+	}
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		_, err := eval.Expand(program, object.NewEnvironment())
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestTuples(t *testing.T) {
+	divide := `
+	let divide = fn(a, b) {
+		if (b == 0) { return 0, "division by zero" }
+		return a / b, ""
+	};
+	`
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`(1, 2)`, "(1, 2)"},
+		{`()`, "()"},
+		{`(1 + 1, "a", true)[1]`, "a"},
+		{`(1, 2)[2]`, "index 2 out of range, the tuple has 2 elements"},
+		{`(1, 2)[-1]`, "index -1 out of range, the tuple has 2 elements"},
+		{`len((1, 2, 3))`, int64(3)},
+		{divide + `let (v, err) = divide(10, 2); v`, int64(5)},
+		{divide + `let (v, err) = divide(10, 0); err`, "division by zero"},
+		{`(1, "a") == (1, "a")`, true},
+		{`(1, 2) != (2, 1)`, true},
+		{`{(1, 2): "point"}[(1, 2)]`, "point"},
+		{`let (a, b) = (1, 2, 3)`, "Can't destructure a tuple of 3 elements into 2 variables"},
+		{`let (a, b) = [1, 2]`, "Can't destructure a value of type ARRAY, expected a TUPLE"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int64:
+			testIntegerObjectEval(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			got := evaluated.Inspect()
+			if err, ok := evaluated.(*object.Error); ok {
+				got = err.Message
+			}
+			if str, ok := evaluated.(*object.String); ok {
+				got = str.Value
+			}
+			if got != expected {
+				t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, expected, got)
+			}
+		}
+	}
+}
+
+func TestStackTraces(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected object.StackTrace
+	}{
+		{
+			`1 + true`,
+			object.StackTrace{{Function: "<main>", Line: 1}},
+		},
+		{
+			`let add = fn(a, b) {
+				a + b
+			};
+			let twice = fn(x) {
+				add(x, x)
+			};
+			twice(1);
+			twice(true);`,
+			object.StackTrace{{Function: "<main>", Line: 8}, {Function: "twice", Line: 5}, {Function: "add", Line: 2}},
+		},
+		{
+			`let apply = fn(f) {
+				f()
+			};
+			apply(fn() {
+				-"five"
+			})`,
+			object.StackTrace{{Function: "<main>", Line: 4}, {Function: "apply", Line: 2}, {Function: "<anonymous>", Line: 5}},
+		},
+		{
+			`let double = fn(x) {
+				x * 2
+			};
+			map([1, "two"], double)`,
+			object.StackTrace{{Function: "<main>", Line: 4}, {Function: "double", Line: 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		err, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("expected an error, got=%T (%+v)", evaluated, evaluated)
+		}
+		if len(err.Trace) != len(tt.expected) {
+			t.Fatalf("wrong trace for %q: want=%v, got=%v", tt.input, tt.expected, err.Trace)
+		}
+		for i, frame := range tt.expected {
+			if err.Trace[i] != frame {
+				t.Fatalf("wrong frame %d for %q: want=%v, got=%v", i, tt.input, frame, err.Trace[i])
+			}
+		}
+	}
+
+	trace := object.StackTrace{{Function: "<main>", Line: 3}, {Function: "add", Line: 1}}
+	expected := "Traceback (most recent call last):\n  line 3, in <main>\n  line 1, in add\n"
+	if trace.String() != expected {
+		t.Fatalf("wrong traceback: want=%q, got=%q", expected, trace.String())
+	}
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`map([1, 2, 3], fn(x) { x * 2 })`, "[2,4,6]"},
+		{`filter([1, 2, 3, 4], fn(x) { x > 2 })`, "[3,4]"},
+		{`reduce([1, 2, 3, 4], 10, fn(acc, x) { acc + x })`, "20"},
+		{`sort([3, 1, 2])`, "[1,2,3]"},
+		{`sort([3, 1, 2], fn(a, b) { a > b })`, "[3,2,1]"},
+		{`find([1, 2, 3], fn(x) { x > 1 })`, "2"},
+		{`find([1, 2, 3], fn(x) { x > 5 })`, "null"},
+		{`if (any([1, 2], fn(x) { x == 2 })) { "yes" } else { "no" }`, "yes"},
+		{`if (all([1, 2], fn(x) { x == 2 })) { "yes" } else { "no" }`, "no"},
+		{`map([[1], [1, 2]], len)`, "[1,2]"},
+		{`map([1, 2], len)`, "Error: Unexpected type: INTEGER for function len()"},
+		{`filter([[1], 2], fn(x) { len(x) })`, "Error: Unexpected type: INTEGER for function len()"},
+		{`sort([2, 1], fn(a, b) { len(a) })`, "Error: Unexpected type: INTEGER for function len()"},
+		{`map([1, "a"], fn(x) { -x })`, "Error: Mismatch type left operator -a"},
+	}
+	for _, tt := range tests {
+		got := testEval(tt.input).Inspect()
+		if got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestIndexAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let a = [1, 2, 3]; a[1] = 20; a`, "[1,20,3]"},
+		{`let a = [1, 2, 3]; a[0] += 10; a[2] *= 3; a`, "[11,2,9]"},
+		{`let h = {"a": 1}; h["a"] -= 1; h["b"] = 2; h["a"] + h["b"]`, "2"},
+		{`let a = [1, 2]; let b = a; a[0] = 5; b`, "[1,2]"},
+		{`let h = {"a": 1}; let g = set(h, "a", 2); h["a"]`, "1"},
+		{`let counts = {}; let count = fn(k) { counts[k] = 1 }; count("a"); count("b"); len(keys(counts))`, "2"},
+		{`let f = fn(a) { a[0] = 0; a }; let a = [1, 2]; f(a); a`, "[1,2]"},
+		{`let f = fn(h) { fn() { h["a"] = 1 } }; f({})()`, "Error: Can't assign to h, it's a variable of an enclosing function"},
+		{`let a = [1]; a[3] = 2`, "Error: index 3 out of range, the array has 1 elements"},
+	}
+	for _, tt := range tests {
+		got := testEval(tt.input).Inspect()
+		if got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestStructuralEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" != "b"`, true},
+		{`[1, 2, 3] == [1, 2, 3]`, true},
+		{`[1, 2, 3] == [1, 2]`, false},
+		{`[1, [2, "a"]] != [1, [2, "b"]]`, true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{[1, 2]: "x"} == {[1, 2]: "x"}`, true},
+		{`let f = fn() { 1 }; [f] == [f]`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
+		{`[1] == 1`, false},
+		{`"a" != 1`, true},
+		{`true == [true]`, false},
+		{`1 == "a"`, false},
+		{`"a" == 1`, false},
+		{`1 != "a"`, true},
+		{`let h = {[1, 2]: "x"}; h[[1, 2]] == "x"`, true},
+		{`let h = set(set({}, [1], 1), [1], 2); len(keys(h)) == 1`, true},
+	}
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestHashKeys(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{[1, 2]: "x"}[[1, 2]]`, "x"},
+		{`{[1, 2]: "x"}[[2, 1]]`, "null"},
+		{`{{"a": 1, "b": 2}: "x"}[{"b": 2, "a": 1}]`, "x"},
+		{`{(1, [2]): "x"}[(1, [2])]`, "x"},
+		{`let f = fn() { 1 }; let g = fn() { 2 }; let h = {f: 1, g: 2}; [h[f], h[g], len(keys(h))]`, "[1,2,2]"},
+		{`let h = {[1]: 1, [2]: 2}; let d = delete(h, [1]); [len(keys(d)), d[[2]], len(keys(h))]`, "[1,2,2]"},
+		{`let k = {"a": 1}; let h = {k: 1}; let k = delete(k, "a"); [h[{"a": 1}], h[k]]`, "[1,null]"},
+	}
+	for _, tt := range tests {
+		got := testEval(tt.input).Inspect()
+		if got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestHashOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b": 1, "a": 2, "c": 3}`, "{ b: 1, a: 2, c: 3 }"},
+		{`keys({"b": 1, "a": 2, "c": 3})`, "[b,a,c]"},
+		{`values({"b": 1, "a": 2, "c": 3})`, "[1,2,3]"},
+		{`let h = {"b": 1, "a": 2}; h["c"] = 3; h["b"] = 4; h`, "{ b: 4, a: 2, c: 3 }"},
+		{`let h = {"c": 1, "b": 2, "a": 3}; let h = delete(h, "b"); set(h, "b", 4)`, "{ c: 1, a: 3, b: 4 }"},
+	}
+	for _, tt := range tests {
+		got := testEval(tt.input).Inspect()
+		if got != tt.expected {
+			t.Errorf("wrong result for %q. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
@@ -8,6 +8,8 @@ type Token struct {
 	Type    TypeToken
 	Literal string
 	Line    uint64
+	// Column is the position of the first character of the token in its line, starting at 1
+	Column int
 }
 
 const (
@@ -33,6 +35,13 @@ const (
 	GT       = TypeToken(">")
 	EQ       = TypeToken("==")
 	NOTEQ    = TypeToken("!=")
+	PIPE     = TypeToken("|>")
+	ARROW    = TypeToken("=>")
+
+	PLUSASSIGN     = TypeToken("+=")
+	MINUSASSIGN    = TypeToken("-=")
+	ASTERISKASSIGN = TypeToken("*=")
+	SLASHASSIGN    = TypeToken("/=")
 
 	// Delimiters
 
@@ -41,6 +50,7 @@ const (
 	LBRACE = TypeToken("{")
 	RBRACE = TypeToken("}")
 
+	DOT       = TypeToken(".")
 	SEMICOLON = TypeToken(";")
 	COLON     = TypeToken(":")
 	ASSIGN    = TypeToken("=")
@@ -54,6 +64,9 @@ const (
 	IF       = TypeToken("IF")
 	ELSE     = TypeToken("ELSE")
 	RETURN   = TypeToken("RETURN")
+	ENUM     = TypeToken("ENUM")
+	MATCH    = TypeToken("MATCH")
+	MACRO    = TypeToken("MACRO")
 
 	STRING   = TypeToken("STRING")
 	LBRACKET = TypeToken("[")
@@ -69,6 +82,9 @@ var keywords = map[string]TypeToken{
 	"if":     IF,
 	"else":   ELSE,
 	"return": RETURN,
+	"enum":   ENUM,
+	"match":  MATCH,
+	"macro":  MACRO,
 }
 
 // LookupIdent Looks up in the keywords table if its a keyword, if its not it will return IDENT as a TypeToken
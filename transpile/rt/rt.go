@@ -0,0 +1,349 @@
+// Package rt is the runtime of the Go programs written by the transpile package. The values are the ones
+// of the object package and the operations behave like the ones of the stack VM, a program must print
+// the same when it's built with go build and when it's run by xlang run
+package rt
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"xlang/object"
+	"xlang/vm"
+)
+
+// True, False and Null are the values of the VM, booleans and null are compared by pointer
+var (
+	True  = vm.True
+	False = vm.False
+	Null  = vm.Null
+)
+
+// MaxDepth is the number of calls that can be running at the same time
+const MaxDepth = vm.MaxFrames
+
+// depth is the number of calls that are running
+var depth int
+
+// Error is a runtime error, the operations panic with it and Run reports it
+type Error struct {
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func fail(format string, a ...interface{}) {
+	panic(&Error{Message: fmt.Sprintf(format, a...)})
+}
+
+// Function is a function of the program, its free variables are the ones that Fn captured
+type Function struct {
+	Name       string
+	Parameters int
+	Fn         func(args []object.Object) object.Object
+}
+
+// Type .
+func (f *Function) Type() object.ObjectType { return object.ClosureObject }
+
+// Inspect .
+func (f *Function) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", f)
+}
+
+// Run runs program and prints the value that it returns, like xlang run does with the last value of a program.
+// A runtime error is printed to the standard error and the process exits with 1
+func Run(program func() object.Object) {
+	if err := run(program, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(program func() object.Object, out io.Writer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			runtimeError, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			err = runtimeError
+		}
+	}()
+	if last := program(); last != nil {
+		fmt.Fprintln(out, last.Inspect())
+	}
+	return nil
+}
+
+// Builtin returns the builtin function with that name
+func Builtin(name string) object.Object {
+	for _, builtin := range object.GetBuiltins() {
+		if builtin.Name == name {
+			return builtin.Builtin
+		}
+	}
+	panic(fmt.Sprintf("unknown builtin %s", name))
+}
+
+// Defined returns o, it fails when the variable that has it isn't set yet, like a hoisted function
+// called before its let
+func Defined(o object.Object) object.Object {
+	if o == nil {
+		fail("variable used before its definition")
+	}
+	return o
+}
+
+// Truthy returns if o is true for an if, only false and null aren't
+func Truthy(o object.Object) bool {
+	switch o := o.(type) {
+	case *object.Boolean:
+		return o.Value
+	case *object.Null:
+		return false
+	}
+	return true
+}
+
+// Bool returns the boolean of the VM for b
+func Bool(b bool) object.Object {
+	if b {
+		return True
+	}
+	return False
+}
+
+// Add returns left + right, for integers and strings
+func Add(left, right object.Object) object.Object {
+	switch rightObject := right.(type) {
+	case *object.Integer:
+		leftObject, ok := left.(*object.Integer)
+		if !ok {
+			fail("expected %s, got: %s", right.Type(), left.Type())
+		}
+		return &object.Integer{Value: leftObject.Value + rightObject.Value}
+	case *object.String:
+		leftStr, ok := left.(*object.String)
+		if !ok {
+			fail("expected %s, got: %s", right.Type(), left.Type())
+		}
+		return object.InternString(leftStr.Value + rightObject.Value)
+	}
+	fail("can't add %s and %s", left.Type(), right.Type())
+	return nil
+}
+
+// integers returns the values of the operands of the operations that only work with integers
+func integers(left, right object.Object) (int64, int64) {
+	rightInteger, ok := right.(*object.Integer)
+	if !ok {
+		fail("expected integer object, got=%s", right.Type())
+	}
+	leftInteger, ok := left.(*object.Integer)
+	if !ok {
+		fail("expected integer object, got=%s", left.Type())
+	}
+	return leftInteger.Value, rightInteger.Value
+}
+
+// Sub returns left - right
+func Sub(left, right object.Object) object.Object {
+	l, r := integers(left, right)
+	return &object.Integer{Value: l - r}
+}
+
+// Mul returns left * right
+func Mul(left, right object.Object) object.Object {
+	l, r := integers(left, right)
+	return &object.Integer{Value: l * r}
+}
+
+// Div returns left / right
+func Div(left, right object.Object) object.Object {
+	l, r := integers(left, right)
+	if r == 0 {
+		fail("division by zero")
+	}
+	return &object.Integer{Value: l / r}
+}
+
+// GreaterThan returns left > right, a < b is b > a
+func GreaterThan(left, right object.Object) object.Object {
+	l, r := integers(left, right)
+	return Bool(l > r)
+}
+
+// Equal returns left == right
+func Equal(left, right object.Object) object.Object {
+	return Bool(object.Equal(left, right))
+}
+
+// NotEqual returns left != right
+func NotEqual(left, right object.Object) object.Object {
+	return Bool(!object.Equal(left, right))
+}
+
+// Not returns !o, it's only true for false and null
+func Not(o object.Object) object.Object {
+	switch o {
+	case True:
+		return False
+	case False, Null:
+		return True
+	}
+	return False
+}
+
+// Negate returns -o
+func Negate(o object.Object) object.Object {
+	integer, ok := o.(*object.Integer)
+	if !ok {
+		fail("expected integer object, got=%s", o.Type())
+	}
+	return &object.Integer{Value: -integer.Value}
+}
+
+// Array returns an array of the elements
+func Array(elements ...object.Object) object.Object {
+	return object.NewArray(elements)
+}
+
+// Tuple returns a tuple of the elements
+func Tuple(elements ...object.Object) object.Object {
+	return &object.Tuple{Elements: elements}
+}
+
+// Hash returns a hash of the keys and values that alternate in elements
+func Hash(elements ...object.Object) object.Object {
+	hash := object.NewHashMap()
+	for i := 0; i < len(elements); i += 2 {
+		hash.Put(elements[i], elements[i+1])
+	}
+	return hash
+}
+
+// Index returns left[index], null when it's out of the array or the key isn't in the hash.
+// A tuple has always the same size, so an index out of it fails
+func Index(left, index object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := position(left.Len(), index)
+		if !ok {
+			return Null
+		}
+		return left.Get(i)
+	case *object.Tuple:
+		i, ok := position(len(left.Elements), index)
+		if !ok {
+			fail("index %d out of range, the tuple has %d elements", index.(*object.Integer).Value, len(left.Elements))
+		}
+		return left.Elements[i]
+	case *object.HashMap:
+		value, ok := left.Get(index)
+		if !ok {
+			return Null
+		}
+		return value
+	case *object.Enum:
+		name, ok := index.(*object.String)
+		if !ok {
+			fail("expected string to get a variant of %s, got=%s", left.Name, index.Type())
+		}
+		return left.Get(name.Value)
+	}
+	fail("invalid index operation on %s", left.Type())
+	return nil
+}
+
+// SetIndex returns the copy of container with value at index that container[index] = value assigns, operator
+// is the one of a compound form like += or empty
+func SetIndex(container, index, value object.Object, operator string) object.Object {
+	switch operator {
+	case "+":
+		value = Add(Index(container, index), value)
+	case "-":
+		value = Sub(Index(container, index), value)
+	case "*":
+		value = Mul(Index(container, index), value)
+	case "/":
+		value = Div(Index(container, index), value)
+	}
+	updated, err := object.SetIndex(container, index, value)
+	if err != nil {
+		fail("%s", err)
+	}
+	return updated
+}
+
+// position returns the position of index in a sequence of length elements, ok is false when it is out of it
+func position(length int, index object.Object) (int, bool) {
+	integer, ok := index.(*object.Integer)
+	if !ok {
+		fail("expected integer got=%s", index.Type())
+	}
+	if integer.Value < 0 || integer.Value >= int64(length) {
+		return 0, false
+	}
+	return int(integer.Value), true
+}
+
+// Destructure returns the n elements of the tuple value, let (a, b) = value
+func Destructure(value object.Object, n int) []object.Object {
+	tuple, ok := value.(*object.Tuple)
+	if !ok {
+		fail("can't destructure a value of type %s, expected a TUPLE", value.Type())
+	}
+	if len(tuple.Elements) != n {
+		fail("can't destructure a tuple of %d elements into %d variables", len(tuple.Elements), n)
+	}
+	return tuple.Elements
+}
+
+// Matches returns if value is the variant of the tag, like Shape.Circle
+func Matches(value object.Object, tag string) bool {
+	variant, ok := value.(*object.Variant)
+	return ok && variant.Is(tag)
+}
+
+// Field returns the field i of a variant that matched a pattern
+func Field(value object.Object, i int) object.Object {
+	return value.(*object.Variant).Values[i]
+}
+
+// Call calls fn with the arguments, a function of the program or a builtin
+func Call(fn object.Object, args ...object.Object) object.Object {
+	switch fn := fn.(type) {
+	case *Function:
+		if fn.Parameters != len(args) {
+			fail("wrong number of parameters, expected=%d, got=%d", fn.Parameters, len(args))
+		}
+		if depth >= MaxDepth {
+			fail("stack overflow")
+		}
+		// A runtime error ends the program, so depth doesn't need to be restored when there is one
+		depth++
+		result := fn.Fn(args)
+		depth--
+		return result
+	case *object.Builtin:
+		// A runtime error of a function that the builtin called panics through it, so err is always nil
+		result, _ := fn.Call(caller{}, args...)
+		if result != nil {
+			return result
+		}
+		return Null
+	case nil:
+		fail("unexpected call of a function")
+	}
+	fail("can't call type=%s, expected a function", fn.Type())
+	return nil
+}
+
+// caller calls the functions that the builtins like map get
+type caller struct{}
+
+func (caller) CallFunction(fn object.Object, args ...object.Object) (object.Object, error) {
+	return Call(fn, args...), nil
+}
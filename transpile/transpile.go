@@ -0,0 +1,520 @@
+// Package transpile writes a program as the source of a Go program, so a script can be built into a native
+// binary with go build. The Go program uses the values of the object package and the runtime of the rt
+// package, it imports xlang/transpile/rt so it must be built inside this module or one that requires it.
+// Every expression is written to a variable of its own in the order that the VM evaluates it
+package transpile
+
+import (
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+	"xlang/ast"
+	"xlang/compiler"
+	"xlang/object"
+)
+
+// Transpile returns the source of the package main that runs program and prints its last value like xlang run.
+// The program is compiled first, the errors are the compiler.Diagnostics of the compiler and the code
+// that the Go backend doesn't support
+func Transpile(program *ast.Program) ([]byte, error) {
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return nil, err
+	}
+	t := &transpiler{constants: map[string]string{}, hoisted: map[*ast.LetStatement]string{}, early: map[string]bool{},
+		defining: map[string]bool{}}
+	main := t.enterFunction()
+	main.main = true
+	t.hoist(program.Statements)
+	for _, s := range program.Statements {
+		if t.fn.returned {
+			break
+		}
+		t.statement(s)
+	}
+	if !t.fn.endsInReturn {
+		t.writef("return last\n")
+	}
+	t.leaveFunction()
+	if t.report.Diagnostics.HasErrors() {
+		return nil, t.report.Diagnostics
+	}
+
+	var out strings.Builder
+	out.WriteString("// Code generated by xlang build --go. DO NOT EDIT.\n\n")
+	out.WriteString("package main\n\n")
+	out.WriteString("import (\n\"xlang/object\"\n\"xlang/transpile/rt\"\n)\n\n")
+	if len(t.globals) > 0 {
+		out.WriteString("var (\n")
+		for _, global := range t.globals {
+			out.WriteString(global + "\n")
+		}
+		out.WriteString(")\n\n")
+	}
+	out.WriteString("func run() object.Object {\nvar last object.Object\n")
+	main.writeDeclarations(&out)
+	out.WriteString(main.body.String())
+	out.WriteString("}\n\nfunc main() {\nrt.Run(run)\n}\n")
+	return format.Source([]byte(out.String()))
+}
+
+// function is a function of the program that is being written, the main function is the program itself
+type function struct {
+	outer *function
+	main  bool
+	// names are the Go variables of the names defined in the function
+	names map[string]string
+	// parameters and variables are the Go variables of the function, the variables are declared at the start
+	parameters []string
+	variables  []string
+	// read are the variables that the code reads, the others are marked as used so Go accepts them
+	read map[string]bool
+	// captured are the variables of the enclosing functions that the closure copies when it's created,
+	// like the VM does, an index assignment after that doesn't change the value that the closure sees
+	captured []string
+	body     strings.Builder
+	// returned is set after a return, the code that follows in the same block doesn't run
+	returned bool
+	// endsInReturn is set when the last statement written is a return, Go needs one at the end of the function
+	endsInReturn bool
+}
+
+func (f *function) capture(variable string) {
+	for _, captured := range f.captured {
+		if captured == variable {
+			return
+		}
+	}
+	f.captured = append(f.captured, variable)
+}
+
+// writeDeclarations writes the variables of the function
+func (f *function) writeDeclarations(out *strings.Builder) {
+	for i, parameter := range f.parameters {
+		fmt.Fprintf(out, "%s := args[%d]\n", parameter, i)
+	}
+	if len(f.variables) > 0 {
+		fmt.Fprintf(out, "var %s object.Object\n", strings.Join(f.variables, ", "))
+	}
+	for _, name := range append(append([]string{}, f.parameters...), f.variables...) {
+		if !f.read[name] {
+			fmt.Fprintf(out, "_ = %s\n", name)
+		}
+	}
+}
+
+type transpiler struct {
+	fn *function
+	// globals are the declarations of the constants, builtins and enums, constants has their names
+	globals   []string
+	constants map[string]string
+	hoisted   map[*ast.LetStatement]string
+	// early are the variables of the hoisted functions, they are checked when they are read
+	early map[string]bool
+	// defining are the variables of the functions whose let is being written, they can call themselves
+	defining map[string]bool
+	nextTemp int
+	nextVar  int
+	report   compiler.Reporter
+}
+
+func (t *transpiler) writef(format string, a ...interface{}) {
+	fmt.Fprintf(&t.fn.body, format, a...)
+	t.fn.endsInReturn = false
+}
+
+func (t *transpiler) unsupported(node ast.Node) string {
+	t.report.Errorf(node, "the Go backend doesn't support %s", strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast."))
+	return "rt.Null"
+}
+
+func (t *transpiler) enterFunction() *function {
+	t.fn = &function{outer: t.fn, names: map[string]string{}, read: map[string]bool{}}
+	return t.fn
+}
+
+func (t *transpiler) leaveFunction() *function {
+	fn := t.fn
+	t.fn = fn.outer
+	return fn
+}
+
+// temp returns a new Go variable for the value of an expression
+func (t *transpiler) temp() string {
+	t.nextTemp++
+	return "t" + strconv.Itoa(t.nextTemp)
+}
+
+// result returns a new variable of the current function for the value of an if or a match, it's set in every branch that doesn't return
+func (t *transpiler) result() string {
+	result := t.temp()
+	t.fn.variables = append(t.fn.variables, result)
+	return result
+}
+
+// define returns a new Go variable of the current function for name, it's bound to name with bind
+func (t *transpiler) define(name string) string {
+	t.nextVar++
+	variable := name + "_" + strconv.Itoa(t.nextVar)
+	t.fn.variables = append(t.fn.variables, variable)
+	return variable
+}
+
+func (t *transpiler) bind(name, variable string) {
+	t.fn.names[name] = variable
+}
+
+// global adds a package variable with that value, the same value is only added once
+func (t *transpiler) global(prefix, value string) string {
+	key := prefix + value
+	if name, ok := t.constants[key]; ok {
+		return name
+	}
+	name := prefix + strconv.Itoa(len(t.constants))
+	t.constants[key] = name
+	t.globals = append(t.globals, name+" = "+value)
+	return name
+}
+
+// resolve returns the Go expression of the value of a name, the functions capture the variables of the outer ones
+func (t *transpiler) resolve(name string) (string, bool) {
+	for fn := t.fn; fn != nil; fn = fn.outer {
+		if variable, ok := fn.names[name]; ok {
+			fn.read[variable] = true
+			// The globals are shared, the functions that aren't set yet are captured when they are
+			if !fn.main && !t.early[variable] && !t.defining[variable] {
+				for inner := t.fn; inner != fn; inner = inner.outer {
+					inner.capture(variable)
+				}
+			}
+			return variable, true
+		}
+	}
+	for _, builtin := range object.GetBuiltins() {
+		if builtin.Name == name {
+			return t.global("builtin_"+name+"_", fmt.Sprintf("rt.Builtin(%q)", name)), true
+		}
+	}
+	return "", false
+}
+
+// hoist binds the functions that compiler.Hoisted returns before the statements run, like the compiler does,
+// so two functions can call each other
+func (t *transpiler) hoist(statements []ast.Statement) {
+	for _, let := range compiler.HoistedLets(statements) {
+		t.hoisted[let] = t.define(let.Name.Value)
+		t.bind(let.Name.Value, t.hoisted[let])
+		t.early[t.hoisted[let]] = true
+	}
+}
+
+func (t *transpiler) statement(node ast.Statement) {
+	defer t.report.SetLine(node)()
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		variable, ok := t.hoisted[node]
+		if !ok {
+			variable = t.define(node.Name.Value)
+		}
+		// A function can call itself, the other values are defined after they are computed
+		if _, ok := node.Value.(*ast.FunctionLiteral); ok {
+			t.bind(node.Name.Value, variable)
+			t.defining[variable] = true
+		}
+		value := t.expression(node.Value)
+		delete(t.defining, variable)
+		t.bind(node.Name.Value, variable)
+		t.writef("%s = %s\n", variable, value)
+		if t.fn.main {
+			t.fn.read[variable] = true
+			t.setLast(variable)
+		}
+	case *ast.DestructureStatement:
+		value := t.expression(node.Value)
+		elements := t.temp()
+		t.writef("%s := rt.Destructure(%s, %d)\n", elements, value, len(node.Names))
+		for i, name := range node.Names {
+			variable := t.define(name.Value)
+			t.bind(name.Value, variable)
+			t.writef("%s = %s[%d]\n", variable, elements, i)
+		}
+		// The VM sets the variables from the last one
+		t.setLast(elements + "[0]")
+	case *ast.IndexAssignStatement:
+		// The compiler only accepts the variables of the current function and the global ones
+		variable, _ := t.resolve(node.Target.Left.(*ast.Identifier).Value)
+		container := t.assign("%s", t.expression(node.Target.Left))
+		index := t.expression(node.Target.Right)
+		value := t.expression(node.Value)
+		t.writef("%s = rt.SetIndex(%s, %s, %s, %q)\n", variable, container, index, value, node.Operator)
+		t.setLast(variable)
+	case *ast.EnumStatement:
+		enum := t.enum(node)
+		t.bind(node.Name.Value, enum)
+		t.setLast(enum)
+	case *ast.ReturnStatement:
+		value := t.expression(node.ReturnValue)
+		t.writef("return %s\n", value)
+		t.fn.returned = true
+		t.fn.endsInReturn = true
+	case *ast.ExpressionStatement:
+		value := t.expression(node.Expression)
+		if t.fn.main {
+			t.setLast(value)
+		} else if strings.HasPrefix(value, "t") {
+			t.writef("_ = %s\n", value)
+		}
+	default:
+		t.unsupported(node)
+	}
+}
+
+// setLast makes value the one that the program prints, it's the last value that the VM pops at the top level
+func (t *transpiler) setLast(value string) {
+	if t.fn.main {
+		t.writef("last = %s\n", value)
+	}
+}
+
+// enum adds the enum as a package variable, so its variants are the same every time the statement runs
+func (t *transpiler) enum(node *ast.EnumStatement) string {
+	names := make([]string, 0, len(node.Variants))
+	fields := make([]string, 0, len(node.Variants))
+	for _, variant := range node.Variants {
+		names = append(names, strconv.Quote(variant.Name.Value))
+		variantFields := make([]string, 0, len(variant.Fields))
+		for _, field := range variant.Fields {
+			variantFields = append(variantFields, strconv.Quote(field.Value))
+		}
+		fields = append(fields, "{"+strings.Join(variantFields, ", ")+"}")
+	}
+	value := fmt.Sprintf("object.NewEnum(%q, []string{%s}, [][]string{%s})",
+		node.Name.Value, strings.Join(names, ", "), strings.Join(fields, ", "))
+	// Two enums with the same declaration are still different enums
+	t.nextTemp++
+	name := "enum" + strconv.Itoa(t.nextTemp)
+	t.globals = append(t.globals, name+" = "+value)
+	return name
+}
+
+// block writes the statements of a block and returns the Go expression of its value,
+// the value of the last expression or null. It's empty if the block returned
+func (t *transpiler) block(node *ast.BlockStatement) string {
+	t.hoist(node.Statements)
+	value := "rt.Null"
+	for i, s := range node.Statements {
+		if t.fn.returned {
+			return ""
+		}
+		if expression, ok := s.(*ast.ExpressionStatement); ok && i == len(node.Statements)-1 {
+			restore := t.report.SetLine(s)
+			value = t.expression(expression.Expression)
+			restore()
+			continue
+		}
+		t.statement(s)
+	}
+	if t.fn.returned {
+		return ""
+	}
+	return value
+}
+
+// branch writes a block whose value is assigned to result
+func (t *transpiler) branch(node *ast.BlockStatement, result string) {
+	if value := t.block(node); value != "" {
+		t.writef("%s = %s\n", result, value)
+		t.fn.read[result] = true
+	}
+}
+
+// expression writes the code of an expression and returns the Go expression of its value,
+// a variable or a constant that can be used any number of times
+func (t *transpiler) expression(node ast.Expression) string {
+	defer t.report.SetLine(node)()
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return t.global("c", fmt.Sprintf("&object.Integer{Value: %d}", node.Value))
+	case *ast.StringLiteral:
+		return t.global("c", fmt.Sprintf("object.InternString(%s)", strconv.Quote(node.Value)))
+	case *ast.Boolean:
+		if node.Value {
+			return "rt.True"
+		}
+		return "rt.False"
+	case *ast.Identifier:
+		value, ok := t.resolve(node.Value)
+		if !ok {
+			t.report.Errorf(node, "undefined variable %s", node.Value)
+			return "rt.Null"
+		}
+		if t.early[value] {
+			// A hoisted function can be called before its let
+			return "rt.Defined(" + value + ")"
+		}
+		return value
+	case *ast.PrefixExpression:
+		right := t.expression(node.Right)
+		switch node.Operator {
+		case "!":
+			return t.assign("rt.Not(%s)", right)
+		case "-":
+			return t.assign("rt.Negate(%s)", right)
+		}
+		t.report.Errorf(node, "unknown prefix operator: %s", node.Operator)
+		return "rt.Null"
+	case *ast.InfixExpression:
+		// a < b is b > a, the right side is evaluated first like in the VM
+		if node.Operator == "<" {
+			right := t.expression(node.Right)
+			left := t.expression(node.Left)
+			return t.assign("rt.GreaterThan(%s, %s)", right, left)
+		}
+		left := t.expression(node.Left)
+		right := t.expression(node.Right)
+		operations := map[string]string{
+			"+": "Add", "-": "Sub", "*": "Mul", "/": "Div", ">": "GreaterThan", "==": "Equal", "!=": "NotEqual",
+		}
+		operation, ok := operations[node.Operator]
+		if !ok {
+			t.report.Errorf(node, "unknown operator %s", node.Operator)
+			return "rt.Null"
+		}
+		return t.assign("rt.%s(%s, %s)", operation, left, right)
+	case *ast.IfExpression:
+		condition := t.expression(node.Condition)
+		result := t.result()
+		t.writef("if rt.Truthy(%s) {\n", condition)
+		t.branch(node.Consequence, result)
+		consequenceReturned := t.fn.returned
+		t.fn.returned = false
+		t.writef("} else {\n")
+		if node.Alternative != nil {
+			t.branch(node.Alternative, result)
+		} else {
+			t.writef("%s = rt.Null\n", result)
+			t.fn.read[result] = true
+		}
+		t.fn.returned = consequenceReturned && t.fn.returned
+		t.writef("}\n")
+		return result
+	case *ast.MatchExpression:
+		return t.match(node)
+	case *ast.FunctionLiteral:
+		return t.function(node)
+	case *ast.CallExpression:
+		if ident, ok := node.Function.(*ast.Identifier); ok && ident.Value == "quote" {
+			if _, defined := t.resolve(ident.Value); !defined {
+				t.report.Errorf(node, "the Go backend doesn't support quote, the code isn't a value in Go")
+				return "rt.Null"
+			}
+		}
+		fn := t.expression(node.Function)
+		arguments := t.expressions(node.Arguments)
+		return t.assign("rt.Call(%s)", strings.Join(append([]string{fn}, arguments...), ", "))
+	case *ast.ArrayLiteral:
+		return t.assign("rt.Array(%s)", strings.Join(t.expressions(node.Elements), ", "))
+	case *ast.TupleLiteral:
+		return t.assign("rt.Tuple(%s)", strings.Join(t.expressions(node.Elements), ", "))
+	case *ast.HashLiteral:
+		elements := make([]string, 0, len(node.Keys)*2)
+		for _, key := range node.Keys {
+			elements = append(elements, t.expression(key), t.expression(node.Pairs[key]))
+		}
+		return t.assign("rt.Hash(%s)", strings.Join(elements, ", "))
+	case *ast.IndexExpression:
+		left := t.expression(node.Left)
+		index := t.expression(node.Right)
+		return t.assign("rt.Index(%s, %s)", left, index)
+	}
+	return t.unsupported(node)
+}
+
+func (t *transpiler) expressions(nodes []ast.Expression) []string {
+	values := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		values = append(values, t.expression(node))
+	}
+	return values
+}
+
+// assign writes the value to a new temporary variable and returns it
+func (t *transpiler) assign(format string, a ...interface{}) string {
+	result := t.temp()
+	t.writef("%s := %s\n", result, fmt.Sprintf(format, a...))
+	return result
+}
+
+// match writes the arms as the cases of a switch, a match without wildcard is null when nothing matches
+func (t *transpiler) match(node *ast.MatchExpression) string {
+	subject := t.expression(node.Subject)
+	result := t.result()
+	t.writef("switch {\n")
+	returned := true
+	hasWildcard := false
+	for _, arm := range node.Arms {
+		if arm.Pattern == nil {
+			t.writef("default:\n")
+			hasWildcard = true
+		} else {
+			t.writef("case rt.Matches(%s, %q):\n", subject, arm.Pattern.Tag())
+			for i, binding := range arm.Pattern.Bindings {
+				variable := t.define(binding.Value)
+				t.bind(binding.Value, variable)
+				t.writef("%s = rt.Field(%s, %d)\n", variable, subject, i)
+			}
+		}
+		t.branch(arm.Body, result)
+		returned = returned && t.fn.returned
+		t.fn.returned = false
+		if hasWildcard {
+			break
+		}
+	}
+	if !hasWildcard {
+		t.writef("default:\n%s = rt.Null\n", result)
+		t.fn.read[result] = true
+		returned = false
+	}
+	t.writef("}\n")
+	t.fn.returned = returned
+	return result
+}
+
+// function writes a function literal as a rt.Function, its body is a Go closure
+func (t *transpiler) function(node *ast.FunctionLiteral) string {
+	outer := t.fn
+	fn := t.enterFunction()
+	for _, parameter := range node.Parameters {
+		t.nextVar++
+		variable := parameter.Value + "_" + strconv.Itoa(t.nextVar)
+		fn.parameters = append(fn.parameters, variable)
+		t.bind(parameter.Value, variable)
+	}
+	if value := t.block(node.Body); value != "" {
+		t.writef("return %s\n", value)
+	} else if !fn.endsInReturn {
+		// Every path returned before, like in both branches of an if
+		t.writef("return rt.Null\n")
+	}
+	t.leaveFunction()
+
+	result := t.temp()
+	captured := strings.Join(fn.captured, ", ")
+	if captured != "" {
+		// The parameters are copies of the captured variables with the same names
+		fmt.Fprintf(&outer.body, "%s := func(%s object.Object) *rt.Function {\nreturn ", result, captured)
+	} else {
+		fmt.Fprintf(&outer.body, "%s := ", result)
+	}
+	fmt.Fprintf(&outer.body, "&rt.Function{Name: %q, Parameters: %d, Fn: func(args []object.Object) object.Object {\n",
+		node.Name, len(node.Parameters))
+	fn.writeDeclarations(&outer.body)
+	outer.body.WriteString(fn.body.String())
+	outer.body.WriteString("}}\n")
+	if captured != "" {
+		fmt.Fprintf(&outer.body, "}(%s)\n", captured)
+	}
+	return result
+}
@@ -0,0 +1,294 @@
+package transpile
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"xlang/ast"
+	"xlang/compiler"
+	"xlang/eval"
+	"xlang/lexer"
+	"xlang/parser"
+	"xlang/vm"
+)
+
+func parse(input string) *ast.Program {
+	return parser.New(lexer.New(input)).ParseProgram()
+}
+
+// runVM returns what xlang run prints for the program
+func runVM(t *testing.T, input string) string {
+	t.Helper()
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error for %q: %s", input, err)
+	}
+	machine := vm.New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		t.Fatalf("VM error for %q: %s", input, err)
+	}
+	if last := machine.LastPoppedStackElem(); last != nil {
+		return last.Inspect() + "\n"
+	}
+	return ""
+}
+
+// buildPrograms transpiles the programs and builds them with one go build in dir, it returns the paths of the binaries
+func buildPrograms(t *testing.T, dir string, inputs []string) []string {
+	t.Helper()
+	binaries := make([]string, len(inputs))
+	for i, input := range inputs {
+		source, err := Transpile(parse(input))
+		if err != nil {
+			t.Fatalf("transpile error for %q: %s", input, err)
+		}
+		name := fmt.Sprintf("program%d", i)
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name, "main.go"), source, 0644); err != nil {
+			t.Fatal(err)
+		}
+		binaries[i] = filepath.Join(dir, "bin", name)
+	}
+	build := exec.Command("go", "build", "-o", filepath.Join(dir, "bin")+string(filepath.Separator), "./"+dir+"/...")
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("go build failed: %s\n%s", err, output)
+	}
+	return binaries
+}
+
+// buildDir returns a new directory for the Go programs, they import xlang/transpile/rt so it's inside the module
+func buildDir(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("building Go programs is slow")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go isn't installed")
+	}
+	dir, err := ioutil.TempDir(".", "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestSameOutputAsVM(t *testing.T) {
+	example, err := ioutil.ReadFile("../examples/strings.xlang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []string{
+		string(example),
+		`1 + 2 * 3 - 4 / 2`,
+		`-5 + 10; !true; !!5; !(1 > 2)`,
+		`[1 < 2, 2 > 1, 1 == 1, 1 != 1, true == true, true != false, "a" == "a", (1, "b") == (1, "b")]`,
+		`[[1, [2, "a"]] == [1, [2, "a"]], {"a": [1]} == {"a": [1]}, "a" + "b" != "ab", {[1, 2]: "x"}[[1, 2]]]`,
+		`[{[1, 2]: "x"}[[1, 2]], {{"a": 1, "b": 2}: "x"}[{"b": 2, "a": 1}], {(1, [2]): "y"}[(1, [2])]]`,
+		`let h = {"b": 1, "a": [2], 3: "c"}; h["d"] = 4; [h, keys(h), values(h)]`,
+		`"mon" + "key" + "!"`,
+		`if (false) { 10 }`,
+		`let x = 5; if (x > 1) { if (x > 3) { 1 } else { 2 } } else { 3 }`,
+		`[1, 2 + 3, "a"][1]`,
+		`[[1, 2][5], {"a": 1}["b"], (1, 2)[1]]`,
+		`let h = set({}, "k", 5); [h["k"], set(h, "a", 1)["a"], h["a"], {"a": 1, 2: "b", true: 3}[2]]`,
+		`let f = fn() { }; f()`,
+		`let f = fn(n) { if (n > 0) { return 1; } return 2; }; f(1) + f(-1) * 10`,
+		`let add = fn(a) { fn(b) { fn(c) { a + b + c } } }; add(1)(2)(3)`,
+		`let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(20)`,
+		`let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } }; let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } }; even(11)`,
+		`let outer = fn() { let count = fn(n) { if (n == 0) { 0 } else { 1 + count(n - 1) } }; count(5) }; outer()`,
+		`let map = fn(arr, f) { let iter = fn(arr, acc) { if (len(arr) == 0) { acc } else { iter(shift(arr), push(acc, f(first(arr)))) } }; iter(arr, []) }; map([1, 2, 3], fn(x) { x * 2 })`,
+		`let x = 10; let f = fn(x) { x + 1 }; let x = 20; f(1) + x`,
+		`let arr = unshift(pop(push([1, 2, 3], 4)), 0); [arr, len(arr), last(arr), len("four")]`,
+		`let dict = set({"1": 2}, "2", true); [dict["1"], keys({"k": 1}), delete(dict, "2"), dict["2"]]`,
+		`log(1 + 2, "three")`,
+		`let f = fn(a, b) { return a + b, a * b }; let (sum, product) = f(3, 4); [sum, product]`,
+		`let pair = (1, "a")`,
+		`enum Shape { Circle(r), Rect(w, h), Empty }; let area = fn(s) { match (s) { Shape.Circle(r) => 3 * r * r, Shape.Rect(w, h) => w * h, Shape.Empty => 0 } }; [area(Shape.Circle(2)), area(Shape.Rect(2, 5)), area(Shape.Empty), Shape.Rect(1, 2) == Shape.Rect(1, 2)]`,
+		`enum Option { Some(v), None }; let get = fn(o) { match (o) { Option.Some(v) => v, _ => "none" } }; [get(Option.Some(1)), get(Option.None), Option.Some(Option.None)]`,
+		`let sign = fn(n) { let s = if (n < 0) { return -1; } else { if (n > 0) { return 1; } else { return 0; } }; s }; [sign(-5), sign(0), sign(8)]`,
+		`let f = fn(n) { if (n > 0) { 1 } else { return 2; } }; [f(1), f(0)]`,
+		`let a = [1, 2, 3]; let b = a; a[0] += 10; let h = {"k": 1}; h["k"] *= 5; h["n"] = "x"; let counts = {}; let count = fn(k) { counts[k] = 1; counts }; count("a"); count("b"); [a, b, h["k"], h["n"], len(keys(counts))]`,
+		`let f = fn(a) { a[1] = 0; a }; let a = [1, 2]; [f(a), a]`,
+		`let total = fn(arrays) { map(arrays, fn(arr) { reduce(arr, 0, fn(a, b) { a + b }) }) }; [total([[1, 2], [3, 4, 5]]), sort([3, 1, 2]), filter([1, 2, 3], fn(x) { x != 2 })]`,
+		``,
+	}
+	dir := buildDir(t)
+	defer os.RemoveAll(dir)
+	binaries := buildPrograms(t, dir, tests)
+	for i, input := range tests {
+		expected := runVM(t, input)
+		var stdout, stderr bytes.Buffer
+		run := exec.Command(binaries[i])
+		run.Stdout = &stdout
+		run.Stderr = &stderr
+		if err := run.Run(); err != nil {
+			t.Errorf("the Go program of %q failed: %s\n%s", input, err, stderr.String())
+			continue
+		}
+		if stdout.String() != expected {
+			t.Errorf("wrong output for %q. VM=%q, Go=%q", input, expected, stdout.String())
+		}
+	}
+}
+
+// An index assignment gives the variable a new value, a closure created before keeps the one that it captured,
+// unless the variable is global. The evaluator, the VM and the Go programs must agree
+func TestSameResultInAllEngines(t *testing.T) {
+	tests := []string{
+		`[[1] == 1, "a" != 1, true == [true], 1 == "a", "a" == 1, 1 != "a"]`,
+		`let w = fn() { let a = [1]; let f = fn() { a }; a[0] = 2; [f(), a] }; w()`,
+		`let a = [1]; let f = fn() { a }; a[0] = 2; [f(), a]`,
+		`let w = fn() { let a = [1]; let f = fn() { fn() { a } }; a[0] = 2; [f()(), a] }; w()`,
+		`let a = [1]; let c = len(a) > 0; if (c) { a[0] = 3 }; a`,
+		`let f = fn(c) { let a = {"k": 1}; let r = if (c) { a["k"] = 3 } else { let z = 1 }; [r, a] }; [f(true), f(false)]`,
+		// Local functions called before their let
+		`let w = fn() { let a = fn(n) { if (n < 1) { 0 } else { b(n - 1) } }; let b = fn(n) { a(n) + 1 }; a(4) }; w()`,
+		`let w = fn() { let mk = fn(n) { fn() { helper(n) } }; let helper = fn(x) { x * 2 }; mk(4)() }; w()`,
+		`let w = fn(k) { if (k > 0) { let a = fn() { b() + k }; let b = fn() { 7 }; a() } else { 0 } }; w(1)`,
+	}
+	dir := buildDir(t)
+	defer os.RemoveAll(dir)
+	binaries := buildPrograms(t, dir, tests)
+	for i, input := range tests {
+		expected := runVM(t, input)
+		if evaluated := eval.NewEval().Eval(parse(input)).Inspect() + "\n"; evaluated != expected {
+			t.Errorf("wrong result of the evaluator for %q. VM=%q, eval=%q", input, expected, evaluated)
+		}
+		output, err := exec.Command(binaries[i]).Output()
+		if err != nil {
+			t.Errorf("the Go program of %q failed: %s", input, err)
+			continue
+		}
+		if string(output) != expected {
+			t.Errorf("wrong output for %q. VM=%q, Go=%q", input, expected, output)
+		}
+	}
+}
+
+// A function used before its first let can't be defined again, the compiled functions would call the first
+// definition and the evaluator the last one
+func TestRedefinedHoistedFunction(t *testing.T) {
+	tests := []string{
+		"let g = fn() { f() };\nlet f = fn() { 1 };\nlet f = fn() { 2 };\ng()",
+		"let w = fn() {\n  let g = fn() { f() };\n  let f = fn() { 1 };\n  let (f, x) = (2, 3);\n  g()\n};\nw()",
+	}
+	for _, input := range tests {
+		message := "can't define f again, the functions defined before its first let use it"
+		if evaluated := eval.NewEval().Eval(parse(input)).Inspect(); evaluated != "Error: "+message {
+			t.Errorf("wrong result of the evaluator for %q: %s", input, evaluated)
+		}
+		if err := compiler.New().Compile(parse(input)); err == nil || !strings.HasSuffix(err.Error(), message) {
+			t.Errorf("wrong compiler error for %q: %v", input, err)
+		}
+		if _, err := Transpile(parse(input)); err == nil || !strings.HasSuffix(err.Error(), message) {
+			t.Errorf("wrong transpile error for %q: %v", input, err)
+		}
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`1 + "a"`, "expected STRING, got: INTEGER\n"},
+		{`let f = fn(a) { a }; f(1, 2)`, "wrong number of parameters, expected=1, got=2\n"},
+		{`5()`, "can't call type=INTEGER, expected a function\n"},
+		{`let (a, b) = (1, 2, 3)`, "can't destructure a tuple of 3 elements into 2 variables\n"},
+		{`let f = fn() { f() }; f()`, "stack overflow\n"},
+		{`let a = [1]; a[3] = 2`, "index 3 out of range, the array has 1 elements\n"},
+		{`(1, 2)[2]`, "index 2 out of range, the tuple has 2 elements\n"},
+		{`map([1, "a"], fn(x) { -x })`, "expected integer object, got=STRING\n"},
+	}
+	inputs := make([]string, len(tests))
+	for i, tt := range tests {
+		inputs[i] = tt.input
+	}
+	dir := buildDir(t)
+	defer os.RemoveAll(dir)
+	binaries := buildPrograms(t, dir, inputs)
+	for i, tt := range tests {
+		var stderr bytes.Buffer
+		run := exec.Command(binaries[i])
+		run.Stderr = &stderr
+		err := run.Run()
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+			t.Errorf("the Go program of %q should exit with 1, got=%v", tt.input, err)
+		}
+		if stderr.String() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected, stderr.String())
+		}
+	}
+}
+
+func TestTranspile(t *testing.T) {
+	source, err := Transpile(parse(`let double = fn(x) { x * 2 }; let unused = 1; double(21)`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"// Code generated by xlang build --go. DO NOT EDIT.",
+		"",
+		"package main",
+		"",
+		"import (",
+		"	\"xlang/object\"",
+		"	\"xlang/transpile/rt\"",
+		")",
+		"",
+		"var (",
+		"	c0 = &object.Integer{Value: 2}",
+		"	c1 = &object.Integer{Value: 1}",
+		"	c2 = &object.Integer{Value: 21}",
+		")",
+		"",
+		"func run() object.Object {",
+		"	var last object.Object",
+		"	var double_1, unused_3 object.Object",
+		"	t2 := &rt.Function{Name: \"double\", Parameters: 1, Fn: func(args []object.Object) object.Object {",
+		"		x_2 := args[0]",
+		"		t1 := rt.Mul(x_2, c0)",
+		"		return t1",
+		"	}}",
+		"	double_1 = t2",
+		"	last = double_1",
+		"	unused_3 = c1",
+		"	last = unused_3",
+		"	t3 := rt.Call(double_1, c2)",
+		"	last = t3",
+		"	return last",
+		"}",
+		"",
+		"func main() {",
+		"	rt.Run(run)",
+		"}",
+		"",
+	}, "\n")
+	if string(source) != expected {
+		t.Fatalf("wrong source.\nwant=\n%s\ngot=\n%s", expected, source)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = b;\nlet c = a + d;", "line 1:9: error: undefined variable b\nline 2:13: error: undefined variable d"},
+		{"let code = quote(1 + 2);", "line 1: error: the Go backend doesn't support quote, the code isn't a value in Go"},
+	}
+	for _, tt := range tests {
+		_, err := Transpile(parse(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q.\nwant=%q\ngot= %q", tt.input, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"xlang/code"
+	"xlang/object"
+)
+
+// errUsedBeforeDefinition is the error of reading a variable before its let runs, like calling a hoisted
+// function before it's defined
+var errUsedBeforeDefinition = errors.New("variable used before its definition")
+
+// RuntimeError is an error of the VM with the place of the program where it happened, the register VM
+// of the regvm package returns it too
+type RuntimeError struct {
+	// Line is the source line of the instruction that failed, 0 if it isn't known
+	Line int
+	// Function is the name of the function that was running, <main> outside of the functions
+	// and <anonymous> for the functions that weren't assigned to a variable
+	Function string
+	// Instruction is the name of the instruction that failed, like OpAdd
+	Instruction string
+	// Err is the error that the instruction returned
+	Err error
+	// Trace are the functions that were running, the last one is where the error happened
+	Trace object.StackTrace
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("in %s, %s: %s", e.Function, e.Instruction, e.Err)
+	}
+	return fmt.Sprintf("line %d, in %s, %s: %s", e.Line, e.Function, e.Instruction, e.Err)
+}
+
+// runtimeError wraps err with the instruction that the current frame is running
+func (vm *VM) runtimeError(err error) *RuntimeError {
+	frame := vm.currentFrame()
+	ins := frame.Instructions()
+	op := code.Opcode(0)
+	if frame.start < len(ins) {
+		op = code.Opcode(ins[frame.start])
+		if op == code.OpWide && frame.start+1 < len(ins) {
+			op = code.Opcode(ins[frame.start+1])
+		}
+	}
+	name := fmt.Sprintf("opcode %d", op)
+	if def, err := code.Lookup(byte(op)); err == nil {
+		name = def.Name
+	}
+	trace := vm.stackTrace()
+	last := trace[len(trace)-1]
+	return &RuntimeError{Line: last.Line, Function: last.Function, Instruction: name, Err: err, Trace: trace}
+}
+
+// stackTrace returns the functions of the frames with the line that each one is running
+func (vm *VM) stackTrace() object.StackTrace {
+	trace := make(object.StackTrace, 0, vm.framesIndex)
+	for i := 0; i < vm.framesIndex; i++ {
+		frame := vm.frames[i]
+		function := object.FunctionName(frame.fn.Fn.Name)
+		if i == 0 {
+			function = "<main>"
+		}
+		trace = append(trace, object.TraceFrame{Function: function, Line: frame.fn.Fn.Lines.Line(frame.start)})
+	}
+	return trace
+}
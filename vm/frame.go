@@ -10,6 +10,8 @@ type Frame struct {
 	fn *object.Closure
 	// Current position in the bytecode
 	ip int
+	// start is the position of the instruction that is running, ip can be on its operands
+	start int
 	// Stores where the function is stored in the stack
 	basePointer int
 }
@@ -0,0 +1,331 @@
+package vm
+
+import (
+	"fmt"
+	"xlang/code"
+	"xlang/compiler"
+	"xlang/object"
+)
+
+// VerifyError is an instruction of a bytecode that the VM can't run safely
+type VerifyError struct {
+	// Constant is the index of the function in the constants, -1 for the instructions of the program
+	Constant int
+	// Function is the name of the function, <main> for the instructions of the program
+	Function string
+	// Offset is the position of the instruction in the instructions of the function
+	Offset int
+	// Message tells what is wrong
+	Message string
+}
+
+func (e *VerifyError) Error() string {
+	if e.Constant < 0 {
+		return fmt.Sprintf("invalid bytecode in %s at %04d: %s", e.Function, e.Offset, e.Message)
+	}
+	return fmt.Sprintf("invalid bytecode in constant %d (%s) at %04d: %s", e.Constant, e.Function, e.Offset, e.Message)
+}
+
+// Verify checks that the instructions of the program and of every function in the constants
+// can run without breaking the VM: the opcodes exist, the operands are in range, the jumps
+// land on an instruction and the stack has the same depth whatever path reaches an instruction.
+// Run trusts the bytecode, so the bytecode that doesn't come from the compiler must be verified first.
+// It doesn't check the values, a wrong type is a runtime error, but it checks that the locals are set
+// before they are used, Run doesn't check for nil
+func Verify(bytecode *compiler.Bytecode) error {
+	free := freeCounts(bytecode)
+	main := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	v := &verifier{constants: bytecode.Constants, fn: main, constant: -1, free: -1}
+	if err := v.verify(); err != nil {
+		return err
+	}
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		v := &verifier{constants: bytecode.Constants, fn: fn, constant: i, free: -1}
+		if n, ok := free[fn]; ok {
+			v.free = n
+		}
+		if err := v.verify(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// freeCounts returns the least number of free variables that the closures of each function get,
+// the functions that aren't made closures by any OpClosure aren't in the result
+func freeCounts(bytecode *compiler.Bytecode) map[*object.CompiledFunction]int {
+	counts := map[*object.CompiledFunction]int{}
+	all := []code.Instructions{bytecode.Instructions}
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			all = append(all, fn.Instructions)
+		}
+	}
+	for _, ins := range all {
+		for i := 0; i < len(ins); {
+			op, operands, size, err := code.ReadInstruction(ins[i:])
+			if err != nil {
+				break
+			}
+			i += size
+			if op != code.OpClosure || operands[0] >= len(bytecode.Constants) {
+				continue
+			}
+			fn, ok := bytecode.Constants[operands[0]].(*object.CompiledFunction)
+			if n, seen := counts[fn]; ok && (!seen || operands[1] < n) {
+				counts[fn] = operands[1]
+			}
+		}
+	}
+	return counts
+}
+
+// verifier checks the instructions of one function
+type verifier struct {
+	constants []object.Object
+	fn        *object.CompiledFunction
+	// constant is the index of fn in the constants, -1 for the program
+	constant int
+	// free is the number of free variables of fn, -1 if it's not known
+	free int
+}
+
+// instruction is a decoded instruction of the function being verified
+type instruction struct {
+	op       code.Opcode
+	operands []int
+	size     int
+}
+
+func (v *verifier) errorf(offset int, format string, a ...interface{}) error {
+	name := "<main>"
+	if v.constant >= 0 {
+		name = object.FunctionName(v.fn.Name)
+	}
+	return &VerifyError{Constant: v.constant, Function: name, Offset: offset, Message: fmt.Sprintf(format, a...)}
+}
+
+func (v *verifier) isMain() bool {
+	return v.constant < 0
+}
+
+func (v *verifier) verify() error {
+	if v.fn.NumParameters > v.fn.NumLocals {
+		return v.errorf(0, "the function has %d parameters but only %d locals", v.fn.NumParameters, v.fn.NumLocals)
+	}
+	if v.fn.NumLocals >= StackSize {
+		return v.errorf(0, "the function has %d locals, the stack has room for %d", v.fn.NumLocals, StackSize)
+	}
+	ins := v.fn.Instructions
+	decoded := map[int]instruction{}
+	offsets := []int{}
+	for i := 0; i < len(ins); {
+		op, operands, size, err := code.ReadInstruction(ins[i:])
+		if err != nil {
+			return v.errorf(i, "%s", err)
+		}
+		decoded[i] = instruction{op: op, operands: operands, size: size}
+		offsets = append(offsets, i)
+		if err := v.checkOperands(i, op, operands); err != nil {
+			return err
+		}
+		i += size
+	}
+	for _, offset := range offsets {
+		ins := decoded[offset]
+		if !code.IsJump(ins.op) {
+			continue
+		}
+		if _, ok := decoded[ins.operands[0]]; !ok && ins.operands[0] != len(v.fn.Instructions) {
+			return v.errorf(offset, "the jump to %04d doesn't land on an instruction", ins.operands[0])
+		}
+	}
+	return v.checkStack(decoded)
+}
+
+func (v *verifier) checkOperands(offset int, op code.Opcode, operands []int) error {
+	def, _ := code.Lookup(byte(op))
+	switch op {
+	case code.OpConstant:
+		if operands[0] >= len(v.constants) {
+			return v.errorf(offset, "%s %d, there are %d constants", def.Name, operands[0], len(v.constants))
+		}
+	case code.OpAddLocalConstant, code.OpSubLocalConstant:
+		if operands[0] >= v.fn.NumLocals {
+			return v.errorf(offset, "%s uses the local %d, the function has %d locals", def.Name, operands[0], v.fn.NumLocals)
+		}
+		if operands[1] >= len(v.constants) {
+			return v.errorf(offset, "%s uses the constant %d, there are %d constants", def.Name, operands[1], len(v.constants))
+		}
+	case code.OpClosure:
+		if operands[0] >= len(v.constants) {
+			return v.errorf(offset, "%s %d, there are %d constants", def.Name, operands[0], len(v.constants))
+		}
+		if _, ok := v.constants[operands[0]].(*object.CompiledFunction); !ok {
+			return v.errorf(offset, "%s of the constant %d, which is a %s", def.Name, operands[0], v.constants[operands[0]].Type())
+		}
+	case code.OpMatchVariant:
+		if operands[0] >= len(v.constants) {
+			return v.errorf(offset, "%s %d, there are %d constants", def.Name, operands[0], len(v.constants))
+		}
+		if _, ok := v.constants[operands[0]].(*object.String); !ok {
+			return v.errorf(offset, "%s with the constant %d, which is a %s", def.Name, operands[0], v.constants[operands[0]].Type())
+		}
+	case code.OpGetGlobal, code.OpSetGlobal:
+		if operands[0] >= GlobalsSize {
+			return v.errorf(offset, "%s %d, there are %d globals", def.Name, operands[0], GlobalsSize)
+		}
+	case code.OpGetLocal, code.OpSetLocal:
+		if operands[0] >= v.fn.NumLocals {
+			return v.errorf(offset, "%s %d, the function has %d locals", def.Name, operands[0], v.fn.NumLocals)
+		}
+	case code.OpGetBuiltin:
+		if operands[0] >= len(object.GetBuiltins()) {
+			return v.errorf(offset, "%s %d, there are %d builtins", def.Name, operands[0], len(object.GetBuiltins()))
+		}
+	case code.OpGetFree:
+		if v.isMain() {
+			return v.errorf(offset, "%s outside of a function", def.Name)
+		}
+		if v.free >= 0 && operands[0] >= v.free {
+			return v.errorf(offset, "%s %d, the closures of the function have %d free variables", def.Name, operands[0], v.free)
+		}
+	case code.OpCurrentClosure, code.OpReturnValue, code.OpReturn:
+		if v.isMain() {
+			return v.errorf(offset, "%s outside of a function", def.Name)
+		}
+	case code.OpHash:
+		if operands[0]%2 != 0 {
+			return v.errorf(offset, "%s %d, a hashmap needs a key and a value for each pair", def.Name, operands[0])
+		}
+	case code.OpSetIndex:
+		switch code.Opcode(operands[0]) {
+		case 0, code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
+		default:
+			return v.errorf(offset, "%s with the operation %d, it isn't one of the compound assignments", def.Name, operands[0])
+		}
+	case code.OpWide:
+		return v.errorf(offset, "OpWide isn't followed by an instruction that it can widen")
+	}
+	return nil
+}
+
+// stackState is what the verifier knows when an instruction runs
+type stackState struct {
+	// assigned are the locals that every path has set
+	assigned []bool
+	// unset is, for each value on the stack, the local that it was read from when that local could be unset, or -1
+	unset []int
+}
+
+// merge returns the state that is true for both paths, changed is false when it's the same as s
+func (s stackState) merge(other stackState) (merged stackState, changed bool) {
+	merged = stackState{assigned: append([]bool{}, s.assigned...), unset: append([]int{}, s.unset...)}
+	for i, assigned := range other.assigned {
+		if merged.assigned[i] && !assigned {
+			merged.assigned[i], changed = false, true
+		}
+	}
+	for i, local := range other.unset {
+		if merged.unset[i] < 0 && local >= 0 {
+			merged.unset[i], changed = local, true
+		}
+	}
+	return merged, changed
+}
+
+// checkStack follows every path of the function and checks that the stack never has less values than
+// an instruction pops, that every path reaches an instruction with the same depth and that the functions return.
+// It also checks that the locals are set before they are used: a closure can capture a local that isn't set
+// yet, like a hoisted function that is fixed once its let runs, any other use of it is an error
+func (v *verifier) checkStack(decoded map[int]instruction) error {
+	end := len(v.fn.Instructions)
+	states := map[int]stackState{}
+	pending := []int{}
+	reach := func(from, offset int, state stackState) error {
+		if offset == end && !v.isMain() {
+			return v.errorf(from, "the function can end without returning")
+		}
+		if previous, ok := states[offset]; ok {
+			if len(previous.unset) != len(state.unset) {
+				return v.errorf(offset, "the stack has %d values coming from %04d and %d from another path", len(state.unset), from, len(previous.unset))
+			}
+			merged, changed := previous.merge(state)
+			if !changed {
+				return nil
+			}
+			state = merged
+		}
+		states[offset] = state
+		if offset != end {
+			pending = append(pending, offset)
+		}
+		return nil
+	}
+	entry := stackState{assigned: make([]bool, v.fn.NumLocals), unset: []int{}}
+	for i := 0; i < v.fn.NumParameters; i++ {
+		entry.assigned[i] = true
+	}
+	if err := reach(0, 0, entry); err != nil {
+		return err
+	}
+	for len(pending) > 0 {
+		offset := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		ins := decoded[offset]
+		def, _ := code.Lookup(byte(ins.op))
+		pops, pushes := code.StackEffect(ins.op, ins.operands)
+		state := states[offset]
+		depth := len(state.unset)
+		if pops > depth {
+			return v.errorf(offset, "%s pops %d values but the stack has %d", def.Name, pops, depth)
+		}
+		if ins.op != code.OpClosure {
+			for _, local := range state.unset[depth-pops:] {
+				if local >= 0 {
+					return v.errorf(offset, "%s uses the local %d before it's set", def.Name, local)
+				}
+			}
+		}
+		next := stackState{assigned: append([]bool{}, state.assigned...), unset: append([]int{}, state.unset[:depth-pops]...)}
+		for i := 0; i < pushes; i++ {
+			next.unset = append(next.unset, -1)
+		}
+		switch ins.op {
+		case code.OpGetLocal:
+			if !state.assigned[ins.operands[0]] {
+				next.unset[len(next.unset)-1] = ins.operands[0]
+			}
+		case code.OpSetLocal:
+			next.assigned[ins.operands[0]] = true
+		case code.OpAddLocalConstant, code.OpSubLocalConstant:
+			if !state.assigned[ins.operands[0]] {
+				return v.errorf(offset, "%s uses the local %d before it's set", def.Name, ins.operands[0])
+			}
+		}
+		if v.fn.NumLocals+len(next.unset) >= StackSize {
+			return v.errorf(offset, "the function needs more than %d values on the stack", StackSize)
+		}
+		switch ins.op {
+		case code.OpReturn, code.OpReturnValue:
+			continue
+		case code.OpJump:
+			if err := reach(offset, ins.operands[0], next); err != nil {
+				return err
+			}
+			continue
+		case code.OpJumpNotTruthy:
+			if err := reach(offset, ins.operands[0], next); err != nil {
+				return err
+			}
+		}
+		if err := reach(offset, offset+ins.size, next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
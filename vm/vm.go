@@ -10,9 +10,9 @@ import (
 
 const StackSize = 2048
 
-var True = &object.Boolean{Value: true}
-var False = &object.Boolean{Value: false}
-var Null = &object.Null{}
+var True = object.TRUE
+var False = object.FALSE
+var Null = object.NULL
 
 // VM holds all the Virtual Machine information and logic
 type VM struct {
@@ -24,6 +24,8 @@ type VM struct {
 	globals     []object.Object
 	frames      []*Frame
 	framesIndex int
+	// wide is set while running an instruction with the OpWide prefix
+	wide bool
 }
 
 const GlobalsSize = 65536
@@ -31,7 +33,7 @@ const MaxFrames = 65536
 
 // New returns a new VM from a bytecode
 func New(bytecode *compiler.Bytecode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, Lines: bytecode.Lines}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 
@@ -72,26 +74,43 @@ func isTruthy(o object.Object) bool {
 	return true
 }
 
-// Run runs the VM
+// Run runs the VM, the errors are *RuntimeError with the place where they happened
 func (vm *VM) Run() error {
+	if err := vm.run(0); err != nil {
+		return vm.runtimeError(err)
+	}
+	return nil
+}
+
+// run runs the instructions until the frame at stop returns, 0 runs the whole program
+func (vm *VM) run(stop int) error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
 
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+	for vm.framesIndex > stop && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
 		vm.currentFrame().ip++
 		ip = vm.currentFrame().ip
+		vm.currentFrame().start = ip
 		ins = vm.currentFrame().Instructions()
 		op = code.Opcode(ins[ip])
+		vm.wide = op == code.OpWide
+		if vm.wide {
+			vm.currentFrame().ip++
+			ip++
+			op = code.Opcode(ins[ip])
+		}
 		switch op {
 		case code.OpGetFree:
 			{
 				objects := vm.currentFrame().fn.Free
-				idx := int(ins[ip+1])
-				vm.currentFrame().ip++
+				idx := vm.readOperand(1)
 				if idx >= len(objects) || idx < 0 {
 					return fmt.Errorf("free object not defined, problem with the compiler code. index=%d", idx)
 				}
+				if objects[idx] == nil {
+					return errUsedBeforeDefinition
+				}
 				if err := vm.push(objects[idx]); err != nil {
 					return err
 				}
@@ -100,84 +119,95 @@ func (vm *VM) Run() error {
 			{
 				index := vm.pop()
 				element := vm.pop()
-				switch element := element.(type) {
-				case *object.Array:
-					{
-						integerObject, ok := index.(*object.Integer)
-						if !ok {
-							return fmt.Errorf("expected integer got=%s", index.Type())
-						}
-						var objectToPush object.Object
-						if integerObject.Value < 0 || integerObject.Value >= int64(len(element.Elements)) {
-							objectToPush = Null
-						} else {
-							objectToPush = element.Elements[integerObject.Value]
-						}
-						if err := vm.push(objectToPush); err != nil {
-							return err
-						}
+				result, err := vm.index(element, index)
+				if err != nil {
+					return err
+				}
+				if err := vm.push(result); err != nil {
+					return err
+				}
+			}
+		case code.OpSetIndex:
+			{
+				operation := code.Opcode(vm.readOperand(1))
+				value := vm.pop()
+				index := vm.pop()
+				container := vm.pop()
+				if operation != 0 {
+					current, err := vm.index(container, index)
+					if err != nil {
+						return err
 					}
-				case *object.HashMap:
-					{
-						hashable, ok := index.(object.Hashable)
-						var key object.HashKey
-						if !ok {
-							key = (&object.String{Value: index.Inspect()}).HashKey()
-						} else {
-							key = hashable.HashKey()
-						}
-						pair, ok := element.Pairs[key]
-						if !ok {
-							if err := vm.push(Null); err != nil {
-								return err
-							}
-							continue
-						}
-						if err := vm.push(pair.Value); err != nil {
-							return err
-						}
+					// The container, the index and the value were popped, so there is room for them
+					vm.push(current)
+					vm.push(value)
+					if err := vm.arithmetic(operation); err != nil {
+						return err
 					}
-				default:
-					return fmt.Errorf("invalid index operation on %s", element.Type())
+					value = vm.pop()
+				}
+				result, err := object.SetIndex(container, index, value)
+				if err != nil {
+					return err
+				}
+				if err := vm.push(result); err != nil {
+					return err
 				}
 			}
 		case code.OpHash:
 			{
-				lenOfHash := int(binary.BigEndian.Uint16(ins[ip+1:]))
-				vm.currentFrame().ip += 2
+				lenOfHash := vm.readOperand(2)
 				elements := vm.stack[vm.sp-lenOfHash : vm.sp]
-				hash := make(map[object.HashKey]object.HashPair, len(elements)/2)
+				hash := object.NewHashMap()
 				for i := 0; i < len(elements); i += 2 {
-					switch key := elements[i].(type) {
-					case object.Hashable:
-						hashed := key.HashKey()
-						hash[hashed] = object.HashPair{Value: elements[i+1], Key: elements[i]}
-					default:
-						str := key.Inspect()
-						obj := (&object.String{Value: str}).HashKey()
-						hash[obj] = object.HashPair{Value: elements[i+1], Key: elements[i]}
-					}
+					hash.Put(elements[i], elements[i+1])
 				}
-				if err := vm.push(&object.HashMap{Pairs: hash}); err != nil {
+				vm.sp = vm.sp - lenOfHash
+				if err := vm.push(hash); err != nil {
 					return err
 				}
 			}
 		case code.OpArray:
 			{
-				lenOfArray := int(binary.BigEndian.Uint16(ins[ip+1:]))
-				vm.currentFrame().ip += 2
-				var elements []object.Object = make([]object.Object, lenOfArray)
-				copy(elements, vm.stack[vm.sp-lenOfArray:vm.sp])
+				lenOfArray := vm.readOperand(2)
+				// The elements are copied in the vector of the array
+				array := object.NewArray(vm.stack[vm.sp-lenOfArray : vm.sp])
 				vm.sp = vm.sp - lenOfArray
-				if err := vm.push(&object.Array{Elements: elements}); err != nil {
+				if err := vm.push(array); err != nil {
 					return err
 				}
 
 			}
+		case code.OpTuple:
+			{
+				lenOfTuple := vm.readOperand(2)
+				elements := make([]object.Object, lenOfTuple)
+				copy(elements, vm.stack[vm.sp-lenOfTuple:vm.sp])
+				vm.sp = vm.sp - lenOfTuple
+				if err := vm.push(&object.Tuple{Elements: elements}); err != nil {
+					return err
+				}
+			}
+		case code.OpDestructure:
+			{
+				n := vm.readOperand(2)
+				value := vm.pop()
+				tuple, ok := value.(*object.Tuple)
+				if !ok {
+					return fmt.Errorf("can't destructure a value of type %s, expected a TUPLE", value.Type())
+				}
+				if len(tuple.Elements) != n {
+					return fmt.Errorf("can't destructure a tuple of %d elements into %d variables", len(tuple.Elements), n)
+				}
+				for _, element := range tuple.Elements {
+					if err := vm.push(element); err != nil {
+						return err
+					}
+				}
+			}
 		case code.OpGetBuiltin:
 			{
-				pos := int(ins[ip+1])
-				vm.currentFrame().ip++
+				pos := vm.readOperand(1)
 				builtin := object.GetBuiltins()[pos]
 				if err := vm.push(builtin.Builtin); err != nil {
 					return err
@@ -185,9 +215,11 @@ func (vm *VM) Run() error {
 			}
 		case code.OpGetGlobal:
 			{
-				pos := int(binary.BigEndian.Uint16(ins[ip+1:]))
-				vm.currentFrame().ip += 2
+				pos := vm.readOperand(2)
 				obj := vm.globals[pos]
+				if obj == nil {
+					return errUsedBeforeDefinition
+				}
 				if err := vm.push(obj); err != nil {
 					return err
 				}
@@ -218,8 +250,7 @@ func (vm *VM) Run() error {
 			}
 		case code.OpSetGlobal:
 			{
-				pos := int(binary.BigEndian.Uint16(ins[ip+1:]))
-				vm.currentFrame().ip += 2
+				pos := vm.readOperand(2)
 				if pos >= GlobalsSize {
 					return fmt.Errorf("There can't be more than %d global variables", pos)
 				}
@@ -228,14 +259,12 @@ func (vm *VM) Run() error {
 			}
 		case code.OpJump:
 			{
-				pos := int(binary.BigEndian.Uint16(ins[ip+1:]))
+				pos := vm.readOperand(2)
 				vm.currentFrame().ip = pos - 1
 			}
 		case code.OpJumpNotTruthy:
 			{
-				pos := int(binary.BigEndian.Uint16(ins[ip+1:]))
-				// Skip the 2 bytes of this operand
-				vm.currentFrame().ip += 2
+				pos := vm.readOperand(2)
 				condition := vm.pop()
 				if !isTruthy(condition) {
 					vm.currentFrame().ip = pos - 1
@@ -243,29 +272,62 @@ func (vm *VM) Run() error {
 			}
 		case code.OpGetLocal:
 			{
-				localIndex := byte(ins[ip+1])
-				vm.currentFrame().ip++
+				localIndex := vm.readOperand(1)
 				frame := vm.currentFrame()
 				if err := vm.push(vm.stack[frame.basePointer+int(localIndex)]); err != nil {
-					return nil
+					return err
 				}
 			}
 		case code.OpSetLocal:
 			{
-				localIndex := byte(ins[ip+1])
-				vm.currentFrame().ip++
+				localIndex := vm.readOperand(1)
 				frame := vm.currentFrame()
 				vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
 			}
 		case code.OpClosure:
 			{
-				indexFn := int(binary.BigEndian.Uint16(ins[ip+1:]))
-				nOfFreeVariables := int(ins[ip+3])
-				vm.currentFrame().ip += 3
+				indexFn := vm.readOperand(2)
+				nOfFreeVariables := vm.readOperand(1)
 				if err := vm.pushClosure(indexFn, nOfFreeVariables); err != nil {
 					return err
 				}
 			}
+		case code.OpSetFree:
+			{
+				idx := vm.readOperand(1)
+				value := vm.pop()
+				closure, ok := vm.pop().(*object.Closure)
+				if !ok || idx >= len(closure.Free) {
+					return fmt.Errorf("free object not defined, problem with the compiler code. index=%d", idx)
+				}
+				closure.Free[idx] = value
+			}
+		case code.OpDup:
+			{
+				if err := vm.push(vm.StackTop()); err != nil {
+					return err
+				}
+			}
+		case code.OpMatchVariant:
+			{
+				idx := vm.readOperand(2)
+				tag := vm.constants[idx].(*object.String).Value
+				variant, ok := vm.pop().(*object.Variant)
+				if err := vm.push(nativeToBooleanObject(ok && variant.Is(tag))); err != nil {
+					return err
+				}
+			}
+		case code.OpGetVariantField:
+			{
+				idx := vm.readOperand(1)
+				variant, ok := vm.pop().(*object.Variant)
+				if !ok || idx >= len(variant.Values) {
+					return fmt.Errorf("variant field not defined, problem with the compiler code. index=%d", idx)
+				}
+				if err := vm.push(variant.Values[idx]); err != nil {
+					return err
+				}
+			}
 		case code.OpCurrentClosure:
 			{
 				if err := vm.push(vm.currentFrame().fn); err != nil {
@@ -274,10 +336,9 @@ func (vm *VM) Run() error {
 			}
 		case code.OpCall:
 			{
-				nOfParameters := int(byte(ins[ip+1]))
+				nOfParameters := vm.readOperand(1)
 				fnPos := vm.sp - 1 - nOfParameters
 				fn, ok := vm.stack[fnPos].(*object.Closure)
-				vm.currentFrame().ip++
 				if !ok {
 					builtinFn, ok2 := vm.stack[fnPos].(*object.Builtin)
 					if !ok2 {
@@ -286,7 +347,10 @@ func (vm *VM) Run() error {
 						}
 						return fmt.Errorf("can't call type=%s, expected a function", vm.stack[fnPos].Type())
 					}
-					res := builtinFn.Fn(vm.stack[vm.sp-nOfParameters : vm.sp]...)
+					res, err := builtinFn.Call(vm, vm.stack[vm.sp-nOfParameters:vm.sp]...)
+					if err != nil {
+						return err
+					}
 					var objectToPush object.Object = res
 					if res == nil {
 						objectToPush = Null
@@ -298,16 +362,9 @@ func (vm *VM) Run() error {
 
 					continue
 				}
-				if fn.Fn.NumParameters != nOfParameters {
-					return fmt.Errorf("wrong number of parameters, expected=%d, got=%d", fn.Fn.NumParameters, nOfParameters)
+				if err := vm.callClosure(fn, nOfParameters); err != nil {
+					return err
 				}
-
-				// Set the basePointer to where the function next pointer is located
-				// [..., fn, args[basePointer], locals, ...]
-				frame := NewFrame(fn, vm.sp-nOfParameters)
-				vm.pushFrame(frame)
-				// Set the starting point for the function stack [..., fn, vm.sp+fn.NumLocals, stackOfTheFunction]
-				vm.sp = frame.basePointer + fn.Fn.NumLocals // NumLocals is = the number of local variables + nArguments
 			}
 		case code.OpNull:
 			{
@@ -317,8 +374,7 @@ func (vm *VM) Run() error {
 			}
 		case code.OpConstant:
 			{
-				idx := binary.BigEndian.Uint16(ins[ip+1:])
-				vm.currentFrame().ip += 2
+				idx := vm.readOperand(2)
 				if err := vm.push(vm.constants[idx]); err != nil {
 					return err
 				}
@@ -337,14 +393,7 @@ func (vm *VM) Run() error {
 			{
 				right := vm.pop()
 				left := vm.pop()
-				if leftInteger, k := left.(*object.Integer); k {
-					if err := vm.numericalComparison(leftInteger, right, op); err != nil {
-						return err
-					}
-					continue
-				}
-				// Standard comparison
-				equal := right == left
+				equal := object.Equal(left, right)
 				if code.OpNotEqual == op {
 					equal = !equal
 				}
@@ -368,64 +417,35 @@ func (vm *VM) Run() error {
 			{
 				vm.pop()
 			}
-		case code.OpDiv:
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv:
 			{
-				left, right, err := vm.unwrapTwoIntegers()
-				if err != nil {
-					return err
-				}
-				val := left.Value / right.Value
-				if err := vm.push(&object.Integer{Value: val}); err != nil {
+				if err := vm.arithmetic(op); err != nil {
 					return err
 				}
 			}
-		case code.OpMul:
+		case code.OpAddLocalConstant:
 			{
-				left, right, err := vm.unwrapTwoIntegers()
-				if err != nil {
-					return err
-				}
-				val := left.Value * right.Value
-				if err := vm.push(&object.Integer{Value: val}); err != nil {
+				localIndex := vm.readOperand(1)
+				constIndex := vm.readOperand(2)
+				left := vm.stack[vm.currentFrame().basePointer+localIndex]
+				if err := vm.add(left, vm.constants[constIndex]); err != nil {
 					return err
 				}
 			}
-		case code.OpSub:
+		case code.OpSubLocalConstant:
 			{
-				left, right, err := vm.unwrapTwoIntegers()
-				if err != nil {
-					return err
+				localIndex := vm.readOperand(1)
+				constIndex := vm.readOperand(2)
+				left, ok := vm.stack[vm.currentFrame().basePointer+localIndex].(*object.Integer)
+				if !ok {
+					return fmt.Errorf("expected integer object, got=%s", vm.stack[vm.currentFrame().basePointer+localIndex].Type())
 				}
-				val := left.Value - right.Value
-				if err := vm.push(&object.Integer{Value: val}); err != nil {
-					return err
+				right, ok := vm.constants[constIndex].(*object.Integer)
+				if !ok {
+					return fmt.Errorf("expected integer object, got=%s", vm.constants[constIndex].Type())
 				}
-			}
-		case code.OpAdd:
-			{
-				right := vm.pop()
-				left := vm.pop()
-				switch rightObject := right.(type) {
-				case *object.Integer:
-					{
-						leftObject, ok := left.(*object.Integer)
-						if !ok {
-							return fmt.Errorf("expected %s, got: %s", right.Type(), left.Type())
-						}
-						if err := vm.push(&object.Integer{Value: leftObject.Value + rightObject.Value}); err != nil {
-							return err
-						}
-					}
-				case *object.String:
-					{
-						leftStr, ok := left.(*object.String)
-						if !ok {
-							return fmt.Errorf("expected %s, got: %s", right.Type(), left.Type())
-						}
-						if err := vm.push(&object.String{Value: leftStr.Value + rightObject.Value}); err != nil {
-							return err
-						}
-					}
+				if err := vm.push(&object.Integer{Value: left.Value - right.Value}); err != nil {
+					return err
 				}
 			}
 		case code.OpBang:
@@ -440,14 +460,178 @@ func (vm *VM) Run() error {
 				if err != nil {
 					return err
 				}
-				integer.Value = -integer.Value
-				vm.push(integer)
+				// The integer can be a constant, so it can't be changed
+				if err := vm.push(&object.Integer{Value: -integer.Value}); err != nil {
+					return err
+				}
 			}
+		default:
+			return fmt.Errorf("unknown opcode %d", op)
 		}
 	}
 	return nil
 }
 
+// callClosure pushes the frame of fn, its arguments are the nOfParameters values on top of the stack
+func (vm *VM) callClosure(fn *object.Closure, nOfParameters int) error {
+	if fn.Fn.NumParameters != nOfParameters {
+		return fmt.Errorf("wrong number of parameters, expected=%d, got=%d", fn.Fn.NumParameters, nOfParameters)
+	}
+
+	// Set the basePointer to where the function next pointer is located
+	// [..., fn, args[basePointer], locals, ...]
+	frame := NewFrame(fn, vm.sp-nOfParameters)
+	if frame.basePointer+fn.Fn.NumLocals >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+	vm.pushFrame(frame)
+	// The locals are cleared, a closure can capture a hoisted function before it's set
+	locals := vm.stack[vm.sp : frame.basePointer+fn.Fn.NumLocals]
+	for i := range locals {
+		locals[i] = nil
+	}
+	// Set the starting point for the function stack [..., fn, vm.sp+fn.NumLocals, stackOfTheFunction]
+	vm.sp = frame.basePointer + fn.Fn.NumLocals // NumLocals is = the number of local variables + nArguments
+	return nil
+}
+
+// CallFunction calls fn with the arguments, it's how the builtins like map call the closures of the program.
+// A closure runs in a new frame on top of the ones that are running, so a runtime error keeps all of them in its trace
+func (vm *VM) CallFunction(fn object.Object, args ...object.Object) (object.Object, error) {
+	switch fn := fn.(type) {
+	case *object.Closure:
+		if err := vm.push(fn); err != nil {
+			return nil, err
+		}
+		for _, arg := range args {
+			if err := vm.push(arg); err != nil {
+				return nil, err
+			}
+		}
+		stop := vm.framesIndex
+		if err := vm.callClosure(fn, len(args)); err != nil {
+			return nil, err
+		}
+		if err := vm.run(stop); err != nil {
+			return nil, err
+		}
+		return vm.pop(), nil
+	case *object.Builtin:
+		result, err := fn.Call(vm, args...)
+		if result == nil && err == nil {
+			result = Null
+		}
+		return result, err
+	case nil:
+		return nil, fmt.Errorf("unexpected call of a function")
+	}
+	return nil, fmt.Errorf("can't call type=%s, expected a function", fn.Type())
+}
+
+// index returns element[index], null when it's out of the array or the key isn't in the hash.
+// A tuple has always the same size, so an index out of it is an error
+func (vm *VM) index(element, index object.Object) (object.Object, error) {
+	switch element := element.(type) {
+	case *object.Array:
+		integerObject, ok := index.(*object.Integer)
+		if !ok {
+			return nil, fmt.Errorf("expected integer got=%s", index.Type())
+		}
+		if integerObject.Value < 0 || integerObject.Value >= int64(element.Len()) {
+			return Null, nil
+		}
+		return element.Get(int(integerObject.Value)), nil
+	case *object.HashMap:
+		value, ok := element.Get(index)
+		if !ok {
+			return Null, nil
+		}
+		return value, nil
+	case *object.Tuple:
+		integerObject, ok := index.(*object.Integer)
+		if !ok {
+			return nil, fmt.Errorf("expected integer got=%s", index.Type())
+		}
+		if integerObject.Value < 0 || integerObject.Value >= int64(len(element.Elements)) {
+			return nil, fmt.Errorf("index %d out of range, the tuple has %d elements", integerObject.Value, len(element.Elements))
+		}
+		return element.Elements[integerObject.Value], nil
+	case *object.Enum:
+		name, ok := index.(*object.String)
+		if !ok {
+			return nil, fmt.Errorf("expected string to get a variant of %s, got=%s", element.Name, index.Type())
+		}
+		return element.Get(name.Value), nil
+	}
+	return nil, fmt.Errorf("invalid index operation on %s", element.Type())
+}
+
+// arithmetic pops the operands of op, OpAdd, OpSub, OpMul or OpDiv, and pushes its result
+func (vm *VM) arithmetic(op code.Opcode) error {
+	if op == code.OpAdd {
+		right := vm.pop()
+		left := vm.pop()
+		return vm.add(left, right)
+	}
+	left, right, err := vm.unwrapTwoIntegers()
+	if err != nil {
+		return err
+	}
+	var val int64
+	switch op {
+	case code.OpSub:
+		val = left.Value - right.Value
+	case code.OpMul:
+		val = left.Value * right.Value
+	case code.OpDiv:
+		if right.Value == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		val = left.Value / right.Value
+	}
+	return vm.push(&object.Integer{Value: val})
+}
+
+// add pushes left + right, for integers and strings
+func (vm *VM) add(left, right object.Object) error {
+	switch rightObject := right.(type) {
+	case *object.Integer:
+		leftObject, ok := left.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("expected %s, got: %s", right.Type(), left.Type())
+		}
+		return vm.push(&object.Integer{Value: leftObject.Value + rightObject.Value})
+	case *object.String:
+		leftStr, ok := left.(*object.String)
+		if !ok {
+			return fmt.Errorf("expected %s, got: %s", right.Type(), left.Type())
+		}
+		return vm.push(object.InternString(leftStr.Value + rightObject.Value))
+	}
+	return fmt.Errorf("can't add %s and %s", left.Type(), right.Type())
+}
+
+// readOperand reads the next operand of the current instruction, width is its size in bytes,
+// it's twice as much when the instruction has the OpWide prefix
+func (vm *VM) readOperand(width int) int {
+	frame := vm.currentFrame()
+	ins := frame.Instructions()
+	if vm.wide {
+		width *= 2
+	}
+	var operand int
+	switch width {
+	case 1:
+		operand = int(ins[frame.ip+1])
+	case 2:
+		operand = int(binary.BigEndian.Uint16(ins[frame.ip+1:]))
+	case 4:
+		operand = int(binary.BigEndian.Uint32(ins[frame.ip+1:]))
+	}
+	frame.ip += width
+	return operand
+}
+
 func (vm *VM) bangOperator() error {
 	operand := vm.pop()
 	switch operand {
@@ -463,18 +647,6 @@ func (vm *VM) bangOperator() error {
 	return vm.push(False)
 }
 
-func (vm *VM) numericalComparison(leftInteger *object.Integer, right object.Object, op code.Opcode) error {
-	rightInteger, k := right.(*object.Integer)
-	if !k {
-		return fmt.Errorf("expected numerical value, got=%s", rightInteger.Type())
-	}
-	equal := leftInteger.Value == rightInteger.Value
-	if op == code.OpNotEqual {
-		equal = !equal
-	}
-	return vm.push(nativeToBooleanObject(equal))
-}
-
 func nativeToBooleanObject(b bool) *object.Boolean {
 	if b {
 		return True
@@ -2,9 +2,12 @@ package vm
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"xlang/ast"
+	"xlang/code"
 	"xlang/compiler"
+	"xlang/eval"
 	"xlang/lexer"
 	"xlang/object"
 	"xlang/parser"
@@ -36,44 +39,61 @@ type vmTestCase struct {
 	expected interface{}
 }
 
+// optimisations are the compiler options that every test is run with
+var optimisations = []compiler.Options{
+	{},
+	{FoldConstants: true, Peephole: true},
+}
+
 func runVMTests(t *testing.B, tests []vmTestCase, printStackTraceAndStop ...bool) {
 	t.Helper()
 	for _, tt := range tests {
-		program := parse(tt.input)
-		comp := compiler.New()
-		err := comp.Compile(program)
-		if err != nil {
-			t.Fatalf("compile error: %s", err.Error())
+		for _, options := range optimisations {
+			runVMTest(t, tt, options, printStackTraceAndStop...)
 		}
-		// for i, constant := range comp.Bytecode().Constants {
-		// 	fmt.Printf("CONSTANT %d %p (%T):\n", i, constant, constant)
+	}
+}
 
-		// 	switch constant := constant.(type) {
-		// 	case *object.CompiledFunction:
-		// 		fmt.Printf(" Instructions:\n%s", constant.Instructions)
-		// 	case *object.Integer:
-		// 		fmt.Printf(" Value: %d\n", constant.Value)
-		// 	}
+func runVMTest(t *testing.B, tt vmTestCase, options compiler.Options, printStackTraceAndStop ...bool) {
+	t.Helper()
+	program := parse(tt.input)
+	comp := compiler.New()
+	comp.SetOptions(options)
+	err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compile error: %s", err.Error())
+	}
+	// for i, constant := range comp.Bytecode().Constants {
+	// 	fmt.Printf("CONSTANT %d %p (%T):\n", i, constant, constant)
 
-		// 	fmt.Printf("\n")
-		// }
-		vm := New(comp.Bytecode())
-		err = vm.Run()
-		if err != nil {
-			t.Errorf("vm error: %s", err)
-			if len(printStackTraceAndStop) > 0 && t.Failed() && printStackTraceAndStop[0] {
-				t.Fatal("Failed on: ", tt.input)
-			}
-		}
-		stackElem := vm.LastPoppedStackElem()
-		if stackElem == nil {
-			t.Fatalf("error, stackElement is null, expected %#v in %#v and ins %s", tt.expected, tt.input, vm.currentFrame().Instructions().String())
-		}
-		testExpectedObject(t, tt.expected, stackElem)
+	// 	switch constant := constant.(type) {
+	// 	case *object.CompiledFunction:
+	// 		fmt.Printf(" Instructions:\n%s", constant.Instructions)
+	// 	case *object.Integer:
+	// 		fmt.Printf(" Value: %d\n", constant.Value)
+	// 	}
+
+	// 	fmt.Printf("\n")
+	// }
+	if err := Verify(comp.Bytecode()); err != nil {
+		t.Fatalf("verify error: %s (options %+v) in %s", err, options, tt.input)
+	}
+	vm := New(comp.Bytecode())
+	err = vm.Run()
+	if err != nil {
+		t.Errorf("vm error: %s (options %+v)", err, options)
 		if len(printStackTraceAndStop) > 0 && t.Failed() && printStackTraceAndStop[0] {
 			t.Fatal("Failed on: ", tt.input)
 		}
 	}
+	stackElem := vm.LastPoppedStackElem()
+	if stackElem == nil {
+		t.Fatalf("error, stackElement is null, expected %#v in %#v and ins %s", tt.expected, tt.input, vm.currentFrame().Instructions().String())
+	}
+	testExpectedObject(t, tt.expected, stackElem)
+	if len(printStackTraceAndStop) > 0 && t.Failed() && printStackTraceAndStop[0] {
+		t.Fatal("Failed on: ", tt.input, " with options ", fmt.Sprintf("%+v", options))
+	}
 }
 
 func testExpectedObject(
@@ -121,16 +141,17 @@ func testExpectedObject(
 			return
 		}
 
-		if len(hash.Pairs) != len(expected) {
+		if hash.Len() != len(expected) {
 			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d",
-				len(expected), len(hash.Pairs))
+				len(expected), hash.Len())
 			return
 		}
 
-		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pairs[expectedKey]
+		for _, pair := range hash.Pairs() {
+			expectedValue, ok := expected[object.HashOf(pair.Key)]
 			if !ok {
 				t.Errorf("no pair for given key in Pairs")
+				continue
 			}
 
 			err := testIntegerObject(expectedValue, pair.Value)
@@ -146,19 +167,23 @@ func testExpectedObject(
 			return
 		}
 
-		if len(array.Elements) != len(expected) {
+		if array.Len() != len(expected) {
 			t.Errorf("wrong num of elements. want=%d, got=%d",
-				len(expected), len(array.Elements))
+				len(expected), array.Len())
 			return
 		}
 
 		for i, expectedElem := range expected {
-			err := testIntegerObject(int64(expectedElem), array.Elements[i])
+			err := testIntegerObject(int64(expectedElem), array.Get(i))
 			if err != nil {
 				t.Errorf("testIntegerObject failed: %s", err)
 			}
 		}
 
+	case object.Object:
+		if actual.Inspect() != expected.Inspect() {
+			t.Errorf("wrong object. want=%s, got=%s", expected.Inspect(), actual.Inspect())
+		}
 	}
 }
 
@@ -264,6 +289,9 @@ func BenchmarkGlobalLetStatements(t *testing.B) {
 		{"let one = 1; one", 1},
 		{"let one = 1; let two = 2; one + two", 3},
 		{"let one = if (false) { 1 } else { 2 }; let two = one + one; one + two", 6},
+		// The value of a let sees the previous definition of the name
+		{"let a = [1]; let a = push(a, 2); let a = len(a) * 10; a", 20},
+		{"let f = fn(x) { let x = x + 1; let x = x * 2; x }; f(3)", 8},
 	}
 
 	runVMTests(t, tests)
@@ -306,6 +334,71 @@ func BenchmarkIndexExpressions(t *testing.B) {
 	runVMTests(t, tests, true)
 }
 
+func BenchmarkIndexAssignment(t *testing.B) {
+	tests := []vmTestCase{
+		{`let a = [1, 2, 3]; a[1] = 20; a`, []int{1, 20, 3}},
+		{`let a = [1, 2, 3]; a[0] += 10; a[1] -= 1; a[2] *= 3; a`, []int{11, 1, 9}},
+		{`let a = [10]; a[0] /= 2; a[0]`, 5},
+		{`let h = {"a": 1}; h["b"] = 2; h["a"] + h["b"]`, 3},
+		{`let h = {"name": "x"}; h["name"] += "lang"; h["name"]`, "xlang"},
+		{`let h = {}; h.count = 1; h.count += 1; h.count`, 2},
+		// Arrays and hashmaps are copied when they are written
+		{`let a = [1, 2]; let b = a; a[0] = 5; b`, []int{1, 2}},
+		{`let h = {"a": 1}; let g = h; h["a"] = 2; g["a"]`, 1},
+		{`let h = {"a": 1}; let g = set(h, "a", 2); [h["a"], g["a"]]`, []int{1, 2}},
+		{`let f = fn(a) { a[0] = 0; a }; let a = [1, 2]; [f(a), a]`, object.NewArray([]object.Object{
+			object.NewArray([]object.Object{&object.Integer{Value: 0}, &object.Integer{Value: 2}}),
+			object.NewArray([]object.Object{&object.Integer{Value: 1}, &object.Integer{Value: 2}}),
+		})},
+		{`let counts = {}; let count = fn(k) { counts[k] = 1 }; count("a"); count("b"); len(keys(counts))`, 2},
+		{`let fill = fn(n) { let a = [0, 0, 0]; let i = 0; a[i + n] = n; a }; fill(2)`, []int{0, 0, 2}},
+		// A branch that ends with an assignment is null
+		{`let a = [1]; let c = len(a) > 0; if (c) { a[0] = 3 }; a`, []int{3}},
+		{`let f = fn(c) { let a = [1]; let r = if (c) { a[0] = 3 } else { 0 }; [r, a[0]] }; f(true)`, object.NewArray([]object.Object{
+			Null, &object.Integer{Value: 3},
+		})},
+		{`let f = fn() { let a = [1]; let g = fn() { a }; a[0] = 2; g() }; f()`, []int{1}},
+	}
+
+	runVMTests(t, tests, true)
+}
+
+func BenchmarkStructuralEquality(t *testing.B) {
+	tests := []vmTestCase{
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" == "b"`, false},
+		{`let s = "x"; s + "lang" == "xlang"`, true},
+		{`[1, 2, 3] == [1, 2, 3]`, true},
+		{`[1, 2, 3] == [1, 2]`, false},
+		{`[1, [2, "a"]] == [1, [2, "a"]]`, true},
+		{`[1, [2, "a"]] != [1, [2, "b"]]`, true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"a": 1, "b": 2}`, false},
+		{`{[1, 2]: "x"} == {[1, 2]: "x"}`, true},
+		{`[1, 2] == (1, 2)`, false},
+		// The values of different types are never equal
+		{`[1] == 1`, false},
+		{`"a" != 1`, true},
+		{`true == [true]`, false},
+		{`1 == "a"`, false},
+		{`"a" == 1`, false},
+		{`1 != "a"`, true},
+		{`[true, "a"] == [true, "a"]`, true},
+		{`let f = fn() { 1 }; [f] == [f]`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
+		// The keys of a hashmap are compared by their values too
+		{`let h = {[1, 2]: "x"}; h[[1, 2]]`, "x"},
+		{`let h = set({}, [1, 2], "x"); let h = set(h, [1, 2], "y"); [len(keys(h)), h[[1, 2]]]`, object.NewArray([]object.Object{
+			&object.Integer{Value: 1}, &object.String{Value: "y"},
+		})},
+		{`let h = {}; h[{"a": 1}] = 2; h[{"a": 1}]`, 2},
+	}
+
+	runVMTests(t, tests, true)
+}
+
 func BenchmarkHashLiterals(t *testing.B) {
 	tests := []vmTestCase{
 		{
@@ -325,11 +418,59 @@ func BenchmarkHashLiterals(t *testing.B) {
 				(&object.Integer{Value: 6}).HashKey(): 16,
 			},
 		},
+		{
+			// The keys and values are taken off the stack, the other arguments stay in place
+			"let f = fn(a, h, b) { a + b }; f(1, {1: 2}, 3)",
+			4,
+		},
 	}
 
 	runVMTests(t, tests)
 }
 
+func BenchmarkHashKeys(t *testing.B) {
+	tests := []vmTestCase{
+		{`{[1, 2]: "x"}[[1, 2]]`, "x"},
+		{`{[1, 2]: "x"}[[2, 1]]`, Null},
+		{`{{"a": 1, "b": 2}: "x"}[{"b": 2, "a": 1}]`, "x"},
+		{`{(1, [2]): "x"}[(1, [2])]`, "x"},
+		{`{[[1], {"a": [2]}]: "x"}[[[1], {"a": [2]}]]`, "x"},
+		// Functions are compared by identity, they share a bucket and Equal tells them apart
+		{`let f = fn() { 1 }; let g = fn() { 2 }; let h = {f: 1, g: 2}; [h[f], h[g], len(keys(h))]`, []int{1, 2, 2}},
+		{`let f = fn() { 1 }; let h = set({f: 1}, fn() { 1 }, 2); len(keys(h))`, 2},
+		{`let h = {[1]: 1}; h[[1]] = 2; [h[[1]], len(keys(h))]`, []int{2, 1}},
+		{`let h = {[1]: 1, [2]: 2}; let d = delete(h, [1]); [len(keys(d)), d[[2]], len(keys(h))]`, []int{1, 2, 2}},
+		{`let k = {"a": 1}; let h = {k: 1}; let k = delete(k, "a"); [h[{"a": 1}], h[k]]`, object.NewArray([]object.Object{
+			&object.Integer{Value: 1}, Null,
+		})},
+		{`let k = {"a": 1}; let h = {[k]: 1}; k["b"] = 2; [h[[{"a": 1}]], len(keys(k))]`, []int{1, 2}},
+	}
+
+	runVMTests(t, tests, true)
+}
+
+func BenchmarkHashOrder(t *testing.B) {
+	strings := func(values ...string) *object.Array {
+		elements := make([]object.Object, 0, len(values))
+		for _, value := range values {
+			elements = append(elements, &object.String{Value: value})
+		}
+		return object.NewArray(elements)
+	}
+	tests := []vmTestCase{
+		{`keys({"b": 1, "a": 2, "c": 3})`, strings("b", "a", "c")},
+		{`values({"b": 1, "a": 2, "c": 3})`, []int{1, 2, 3}},
+		{`let h = {"b": 1, "a": 2}; h["c"] = 3; h["b"] = 4; keys(h)`, strings("b", "a", "c")},
+		{`let h = {"b": 1, "a": 2}; h["b"] = 4; values(h)`, []int{4, 2}},
+		{`let h = {"c": 1, "b": 2, "a": 3}; let h = delete(h, "b"); let h = set(h, "b", 4); keys(h)`, strings("c", "a", "b")},
+		{`let h = {"c": 1, "b": 2, "a": 3}; let h = delete(h, "c"); [h["b"], h["a"], len(keys(h))]`, []int{2, 3, 2}},
+		{`values({})`, []int{}},
+		{`values(1)`, &object.Error{Message: "Error: Expected object of type HashTable on values(), got: INTEGER"}},
+	}
+
+	runVMTests(t, tests, true)
+}
+
 func BenchmarkCallingFunctionsWithoutArguments(t *testing.B) {
 	tests := []vmTestCase{
 		{
@@ -566,8 +707,12 @@ func BenchmarkCallingFunctionsWithWrongArguments(t *testing.B) {
 			t.Fatalf("expected VM error but resulted in none.")
 		}
 
-		if err.Error() != tt.expected {
-			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("expected a *RuntimeError, got=%T", err)
+		}
+		if runtimeErr.Err.Error() != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, runtimeErr.Err)
 		}
 	}
 }
@@ -590,7 +735,7 @@ func BenchmarkBuiltinFunctions(t *testing.B) {
 		},
 		{`len([1, 2, 3])`, 3},
 		{`len([])`, 0},
-		{`push(["hello"], "world!")`, &object.Array{Elements: []object.Object{&object.String{Value: "hello"}, &object.String{Value: "world!"}}}},
+		{`push(["hello"], "world!")`, object.NewArray([]object.Object{&object.String{Value: "hello"}, &object.String{Value: "world!"}})},
 		{`first([1, 2, 3])`, 1},
 		{`first([])`, Null},
 		{`first(1)`,
@@ -608,11 +753,50 @@ func BenchmarkBuiltinFunctions(t *testing.B) {
 		{`shift([1, 2, 3])`, []int{2, 3}},
 		{`shift([])`, Null},
 		{`push([], 1)`, []int{1}},
+		// The arrays that push, pop and unshift return don't share their elements
+		{`let a = [1, 2, 3]; let b = push(pop(a), 4); a`, []int{1, 2, 3}},
+		{`let a = unshift([2, 3], 1); let b = [4, 5, 6]; a`, []int{1, 2, 3}},
 		{`push(1, 1)`,
 			&object.Error{
 				Message: "Unexpected type for push(); got INTEGER",
 			},
 		},
+		// The builtins that call the functions of the program
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []int{2, 4, 6}},
+		{`map([], fn(x) { x * 2 })`, []int{}},
+		{`map([[1], [1, 2]], len)`, []int{1, 2}},
+		{`filter([1, 2, 3, 4], fn(x) { x > 2 })`, []int{3, 4}},
+		{`reduce([1, 2, 3, 4], 10, fn(acc, x) { acc + x })`, 20},
+		{`let add = fn(a, b) { a + b }; [1, 2, 3] |> map(fn(x) { x * x }) |> reduce(0, add)`, 14},
+		{`sort([3, 1, 2])`, []int{1, 2, 3}},
+		{`sort([3, 1, 2], fn(a, b) { a > b })`, []int{3, 2, 1}},
+		{`sort(["b", "c", "a"])[0]`, "a"},
+		{`find([1, 2, 3, 4], fn(x) { x > 2 })`, 3},
+		{`find([1, 2], fn(x) { x > 2 })`, Null},
+		{`any([1, 2, 3], fn(x) { x > 2 })`, true},
+		{`all([1, 2, 3], fn(x) { x > 2 })`, false},
+		{`all([], fn(x) { false })`, true},
+		{`let total = fn(arrays) { map(arrays, fn(arr) { reduce(arr, 0, fn(a, b) { a + b }) }) }; total([[1, 2], [3, 4, 5]])`, []int{3, 12}},
+		{`let depth = fn(n) { if (n == 0) { 0 } else { first(map([n - 1], depth)) + 1 } }; depth(50)`, 50},
+		{`map(1, fn(x) { x })`,
+			&object.Error{
+				Message: "Unexpected type for map(); got INTEGER",
+			},
+		},
+		{`filter([1], 2)`,
+			&object.Error{
+				Message: "Unexpected type for filter(); expected a function, got INTEGER",
+			},
+		},
+		{`sort([1, "a"])`,
+			&object.Error{
+				Message: "Error: sort() without a function expects integers or strings, got INTEGER and STRING",
+			},
+		},
+		// The first error that the function returns is the result, like in the evaluator
+		{`map([1, 2], len)`, &object.Error{Message: "Unexpected type: INTEGER for function len()"}},
+		{`filter([[1], 2], fn(x) { len(x) })`, &object.Error{Message: "Unexpected type: INTEGER for function len()"}},
+		{`sort([2, 1], fn(a, b) { len(a) })`, &object.Error{Message: "Unexpected type: INTEGER for function len()"}},
 	}
 
 	runVMTests(t, tests, true)
@@ -692,6 +876,11 @@ func BenchmarkClosures(t *testing.B) {
 	`,
 			expected: 99,
 		},
+		{
+			// The function literal in the branch adds a scope while the if is compiled
+			input:    `let f = fn(n) { if (n == 0) { 0 } else { fn(x) { f(x) } } }; f(0)`,
+			expected: 0,
+		},
 	}
 
 	runVMTests(t, tests)
@@ -772,3 +961,665 @@ func BenchmarkRecursiveFibonacci(t *testing.B) {
 
 	runVMTests(t, tests)
 }
+
+func BenchmarkArrowFunctionsAndPipes(t *testing.B) {
+	tests := []vmTestCase{
+		{"let add = (a, b) => a + b; add(1, 2)", 3},
+		{"let one = () => 1; one()", 1},
+		{"((x) => x + 1)(1)", 2},
+		{"let double = (x) => x * 2; 2 |> double", 4},
+		{"let add = (a, b) => a + b; 1 |> add(2) |> add(3)", 6},
+		{"let sub = (a, b) => a - b; 10 |> sub(3)", 7},
+		{"[1, 2, 3] |> push(4) |> len", 4},
+		{
+			input: `
+			let adder = (a) => (b) => a + b;
+			let addTwo = adder(2);
+			3 |> addTwo
+			`,
+			expected: 5,
+		},
+	}
+
+	runVMTests(t, tests, true)
+}
+
+func BenchmarkMutuallyRecursiveFunctions(t *testing.B) {
+	tests := []vmTestCase{
+		{
+			input: `
+			let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+			let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+			isEven(10)
+			`,
+			expected: true,
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+				let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+				isOdd(7)
+			};
+			wrapper()
+			`,
+			expected: true,
+		},
+		{
+			input: `
+			let outer = fn() {
+				let inner = fn() {
+					let ping = fn(n) { if (n == 0) { 0 } else { pong(n - 1) + 1 } };
+					let pong = fn(n) { if (n == 0) { 0 } else { ping(n - 1) + 10 } };
+					ping(4)
+				};
+				inner()
+			};
+			outer()
+			`,
+			expected: 22,
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let countDown = fn(x) {
+					let next = fn() { countDown(x - 1) };
+					if (x == 0) { 0 } else { next() }
+				};
+				countDown(3)
+			};
+			wrapper()
+			`,
+			expected: 0,
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				let first = fn() { second() + third() };
+				let second = fn() { 1 };
+				let value = 20;
+				let third = fn() { value };
+				first()
+			};
+			wrapper()
+			`,
+			expected: 21,
+		},
+		{
+			// An anonymous closure captures a function before its let
+			input: `
+			let wrapper = fn() {
+				let calls = [fn() { later() }];
+				let later = fn() { 1 };
+				calls[0]()
+			};
+			wrapper()
+			`,
+			expected: 1,
+		},
+		{
+			input: `
+			let wrapper = fn() {
+				if (true) {
+					let ping = fn(n) { if (n == 0) { 0 } else { pong(n - 1) + 1 } };
+					let pong = fn(n) { if (n == 0) { 0 } else { ping(n - 1) + 10 } };
+					ping(3)
+				}
+			};
+			wrapper()
+			`,
+			expected: 12,
+		},
+		{
+			// The g of the inner function shadows the global one from its start
+			input: `
+			let g = fn() { 0 };
+			let wrapper = fn() {
+				let f = fn() { g() };
+				let g = fn() { 1 };
+				f()
+			};
+			wrapper()
+			`,
+			expected: 1,
+		},
+		{
+			input: `
+			let f = fn(x) { x + 1 };
+			let wrapper = fn() {
+				let a = [1];
+				let a = push(a, 2);
+				let f = fn(x) { if (x == 0) { len(a) } else { f(x - 1) } };
+				f(3)
+			};
+			wrapper()
+			`,
+			expected: 2,
+		},
+	}
+
+	runVMTests(t, tests, true)
+}
+
+func BenchmarkEnums(t *testing.B) {
+	shape := `
+	enum Shape { Circle(r), Rect(w, h), Empty }
+	let area = fn(s) {
+		match (s) {
+			Shape.Circle(r) => 3 * r * r,
+			Shape.Rect(w, h) => w * h,
+			Shape.Empty => 0,
+		}
+	};
+	`
+	tests := []vmTestCase{
+		{shape + "area(Shape.Circle(2))", 12},
+		{shape + "area(Shape.Rect(2, 5))", 10},
+		{shape + "area(Shape.Empty)", 0},
+		{shape + "Shape.Rect(1, 2) == Shape.Rect(1, 2)", true},
+		{shape + "Shape.Rect(1, 2) == Shape.Rect(2, 1)", false},
+		{shape + "Shape.Circle(1) != Shape.Empty", true},
+		{shape + "if (Shape.Empty == Shape.Empty) { 1 } else { 2 }", 1},
+		{shape + `{Shape.Circle(1): 10}[Shape.Circle(1)]`, 10},
+		{shape + `match (Shape.Rect(1, 2)) { Shape.Circle(r) => r, _ => 99 }`, 99},
+		{shape + `match (5) { Shape.Circle(r) => r, _ => 1 }`, 1},
+		{shape + `match (Shape.Circle(4)) { Shape.Circle(r) => { let d = r * 2; d } _ => 0 }`, 8},
+		{shape + `Shape.Circle(1, 2)`, &object.Error{Message: "Shape.Circle expects 1 arguments, got 2"}},
+	}
+
+	runVMTests(t, tests, true)
+}
+
+func BenchmarkEnumInspect(t *testing.B) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`enum Shape { Circle(r), Empty } Shape.Circle(3)`, "Shape.Circle(3)"},
+		{`enum Shape { Circle(r), Empty } Shape.Empty`, "Shape.Empty"},
+		{`enum Shape { Circle(r), Empty } Shape`, "enum Shape { Circle(r), Empty }"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compile error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+			t.Errorf("wrong inspect. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func BenchmarkMacros(t *testing.B) {
+	unless := `
+	let unless = macro(cond, consequence, alternative) {
+		quote(if (!(unquote(cond))) { unquote(consequence) } else { unquote(alternative) })
+	};
+	`
+	tests := []vmTestCase{
+		{unless + `unless(10 > 5, 1, 2)`, 2},
+		{unless + `let f = fn(x) { unless(x == 0, 100 / x, 0) }; f(4) + f(0)`, 25},
+		{`let twice = macro(x) { quote(unquote(x) + unquote(x)) }; twice(3 * 2)`, 12},
+		{`let x = 10; let m = macro() { quote(x + fn(x) { x * 2 }(1)) }; m()`, 12},
+		{`let n = 1; let m = macro() { quote(if (true) { let n = n + 1; n * 10 }) }; m() + n`, 21},
+	}
+
+	for _, tt := range tests {
+		program, err := eval.Expand(parse(tt.input), object.NewEnvironment())
+		if err != nil {
+			t.Fatalf("expand error: %s", err)
+		}
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compile error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func BenchmarkTuples(t *testing.B) {
+	divide := `
+	let divide = fn(a, b) {
+		if (b == 0) { return 0, "division by zero" }
+		return a / b, ""
+	};
+	`
+	tests := []vmTestCase{
+		{`(1, "a")[1]`, "a"},
+		{`len((1, 2, 3))`, 3},
+		{divide + `let (v, err) = divide(10, 2); v`, 5},
+		{divide + `let (v, err) = divide(10, 0); err`, "division by zero"},
+		{divide + `let f = fn() { let (v, err) = divide(9, 3); v + len(err) }; f()`, 3},
+		{`let (a, b) = (1, 2); let (a, b) = (b, a); a * 10 + b`, 21},
+		{`(1, "a") == (1, "a")`, true},
+		{`(1, 2) == (2, 1)`, false},
+		{`{(1, 2): 5}[(1, 2)]`, 5},
+	}
+
+	runVMTests(t, tests, true)
+}
+
+func BenchmarkConstantFolding(t *testing.B) {
+	tests := []vmTestCase{
+		{`60 * 60 * 24`, 86400},
+		{`let f = fn(x) { x * (2 + 3) }; f(4)`, 20},
+		{`"a" + "b" + "c"`, "abc"},
+		{`!(1 == 2) == true`, true},
+		{`let x = 3; !!!(x > 1)`, false},
+		{`if (1 > 2) { 10 } else { 20 }`, 20},
+		{`if (false) { 10 }`, Null},
+		{`let f = fn() { if (true) { return 1 } 2 }; f()`, 1},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		comp.SetOptions(compiler.Options{FoldConstants: true})
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compile error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func BenchmarkPeepholeFibonacci(t *testing.B) {
+	input := `
+	let fibonacci = fn(n) {
+		if (n < 2) { return n }
+		fibonacci(n - 1) + fibonacci(n - 2)
+	};
+	fibonacci(20)
+	`
+	for _, options := range optimisations {
+		comp := compiler.New()
+		comp.SetOptions(options)
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compile error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+		name := "unoptimised"
+		if options.Peephole {
+			name = "peephole"
+		}
+		t.Run(name, func(t *testing.B) {
+			for i := 0; i < t.N; i++ {
+				vm := New(bytecode)
+				if err := vm.Run(); err != nil {
+					t.Fatalf("vm error: %s", err)
+				}
+				testExpectedObject(t, 6765, vm.LastPoppedStackElem())
+			}
+		})
+	}
+}
+
+func BenchmarkInterning(t *testing.B) {
+	tests := []vmTestCase{
+		{`"key" == "key"`, true},
+		{`"ke" + "y" == "key"`, true},
+		{`{"ke" + "y": 1}["key"]`, 1},
+		// Constants are shared, so they must not change
+		{`let f = fn() { -1 }; f(); f()`, -1},
+		{`let a = 5; let b = -a; a`, 5},
+	}
+
+	runVMTests(t, tests, true)
+
+	// The constants and the short strings made by the program are interned, the long ones aren't
+	long := strings.Repeat("a", 40)
+	for _, tt := range []struct {
+		input    string
+		expected string
+		interned bool
+	}{
+		{`"key"`, "key", true},
+		{`"ke" + "y"`, "key", true},
+		{fmt.Sprintf("%q + %q", long, long), long + long, false},
+	} {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compile error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if interned := vm.LastPoppedStackElem() == object.InternString(tt.expected); interned != tt.interned {
+			t.Errorf("wrong interning of %q. want=%t, got=%t", tt.input, tt.interned, interned)
+		}
+	}
+}
+
+func BenchmarkWideOperands(t *testing.B) {
+	// More than 65536 constants and a jump over more than 65535 bytes
+	statements := make([]string, 70000)
+	for i := range statements {
+		statements[i] = fmt.Sprintf("%d;", i)
+	}
+	body := strings.Join(statements, " ")
+
+	// More than 256 locals
+	lets := make([]string, 300)
+	for i := range lets {
+		name := string([]byte{'a' + byte(i/26/26), 'a' + byte(i/26%26), 'a' + byte(i%26)})
+		lets[i] = fmt.Sprintf("let %s = %d;", name, i)
+	}
+
+	tests := []vmTestCase{
+		{fmt.Sprintf("if (true) { %s }", body), 69999},
+		{fmt.Sprintf("let x = 1; if (x > 2) { %s } else { 5 }", body), 5},
+		{fmt.Sprintf("fn() { %s aln }()", strings.Join(lets, " ")), 299},
+	}
+
+	runVMTests(t, tests, true)
+}
+
+func BenchmarkRuntimeErrors(t *testing.B) {
+	tests := []struct {
+		input    string
+		expected RuntimeError
+		message  string
+	}{
+		{
+			"1 + true",
+			RuntimeError{Line: 1, Function: "<main>", Instruction: "OpAdd"},
+			"line 1, in <main>, OpAdd: ",
+		},
+		{
+			`let add = fn(a, b) {
+				a + b
+			};
+			add(1, 2);
+			add(1, "two");`,
+			RuntimeError{Line: 2, Function: "add", Instruction: "OpAdd"},
+			"line 2, in add, OpAdd: ",
+		},
+		{
+			`let x = 5;
+
+			fn() { -"five" }()`,
+			RuntimeError{Line: 3, Function: "<anonymous>", Instruction: "OpMinus"},
+			"line 3, in <anonymous>, OpMinus: ",
+		},
+		{
+			`let f = fn(x) { x };
+			f(1, 2)`,
+			RuntimeError{Line: 2, Function: "<main>", Instruction: "OpCall"},
+			"line 2, in <main>, OpCall: wrong number of parameters, expected=1, got=2",
+		},
+		{
+			`let a = [1];
+			a[3] = 2`,
+			RuntimeError{Line: 2, Function: "<main>", Instruction: "OpSetIndex"},
+			"line 2, in <main>, OpSetIndex: index 3 out of range, the array has 1 elements",
+		},
+		{
+			`let t = (1, 2);
+			t[2]`,
+			RuntimeError{Line: 2, Function: "<main>", Instruction: "OpIndex"},
+			"line 2, in <main>, OpIndex: index 2 out of range, the tuple has 2 elements",
+		},
+		{
+			`let h = {"a": "b"};
+			h["a"] -= 1`,
+			RuntimeError{Line: 2, Function: "<main>", Instruction: "OpSetIndex"},
+			"line 2, in <main>, OpSetIndex: expected integer object, got=STRING",
+		},
+		{
+			`let s = "abc";
+			s[0] = "d"`,
+			RuntimeError{Line: 2, Function: "<main>", Instruction: "OpSetIndex"},
+			"line 2, in <main>, OpSetIndex: can't assign an index of STRING, expected an ARRAY or a HASH",
+		},
+		{
+			`let f = fn() { g() };
+			f();
+			let g = fn() { 1 }`,
+			RuntimeError{Line: 1, Function: "f", Instruction: "OpGetGlobal"},
+			"line 1, in f, OpGetGlobal: variable used before its definition",
+		},
+		{
+			`let f = fn(x) { 10 / x };
+			f(0)`,
+			RuntimeError{Line: 1, Function: "f", Instruction: "OpDiv"},
+			"line 1, in f, OpDiv: division by zero",
+		},
+	}
+
+	optimisations := []compiler.Options{{}, {FoldConstants: true, Peephole: true}}
+	for _, tt := range tests {
+		for _, options := range optimisations {
+			comp := compiler.New()
+			comp.SetOptions(options)
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			err := New(comp.Bytecode()).Run()
+			runtimeErr, ok := err.(*RuntimeError)
+			if !ok {
+				t.Fatalf("expected a *RuntimeError, got=%T (%v)", err, err)
+			}
+			if runtimeErr.Line != tt.expected.Line || runtimeErr.Function != tt.expected.Function || runtimeErr.Instruction != tt.expected.Instruction {
+				t.Fatalf("wrong place of the error for %q with %+v: want=%d %s %s, got=%d %s %s", tt.input, options,
+					tt.expected.Line, tt.expected.Function, tt.expected.Instruction, runtimeErr.Line, runtimeErr.Function, runtimeErr.Instruction)
+			}
+			if !strings.HasPrefix(runtimeErr.Error(), tt.message) {
+				t.Fatalf("wrong message: want prefix %q, got=%q", tt.message, runtimeErr.Error())
+			}
+		}
+	}
+}
+
+func BenchmarkStackTraces(t *testing.B) {
+	tests := []struct {
+		input    string
+		expected object.StackTrace
+	}{
+		{
+			`1 + true`,
+			object.StackTrace{{Function: "<main>", Line: 1}},
+		},
+		{
+			`let add = fn(a, b) {
+				a + b
+			};
+			let twice = fn(x) {
+				add(x, x)
+			};
+			twice(1);
+			twice(true);`,
+			object.StackTrace{{Function: "<main>", Line: 8}, {Function: "twice", Line: 5}, {Function: "add", Line: 2}},
+		},
+		{
+			`let apply = fn(f) {
+				f()
+			};
+			apply(fn() {
+				-"five"
+			})`,
+			object.StackTrace{{Function: "<main>", Line: 4}, {Function: "apply", Line: 2}, {Function: "<anonymous>", Line: 5}},
+		},
+		{
+			`let double = fn(x) {
+				x * 2
+			};
+			map([1, "two"], double)`,
+			object.StackTrace{{Function: "<main>", Line: 4}, {Function: "double", Line: 2}},
+		},
+	}
+
+	optimisations := []compiler.Options{{}, {FoldConstants: true, Peephole: true}}
+	for _, tt := range tests {
+		for _, options := range optimisations {
+			comp := compiler.New()
+			comp.SetOptions(options)
+			if err := comp.Compile(parse(tt.input)); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			err := New(comp.Bytecode()).Run()
+			runtimeErr, ok := err.(*RuntimeError)
+			if !ok {
+				t.Fatalf("expected a *RuntimeError, got=%T (%v)", err, err)
+			}
+			if runtimeErr.Trace.String() != tt.expected.String() {
+				t.Fatalf("wrong trace for %q with %+v: want=%q, got=%q", tt.input, options, tt.expected, runtimeErr.Trace)
+			}
+		}
+	}
+}
+
+func BenchmarkVerify(t *testing.B) {
+	instructions := func(ins ...[]byte) code.Instructions {
+		out := code.Instructions{}
+		for _, i := range ins {
+			out = append(out, i...)
+		}
+		return out
+	}
+	function := func(numLocals, numParameters int, ins ...[]byte) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: instructions(ins...), NumLocals: numLocals, NumParameters: numParameters, Name: "f"}
+	}
+
+	tests := []struct {
+		bytecode *compiler.Bytecode
+		expected string
+	}{
+		{
+			&compiler.Bytecode{Instructions: code.Instructions{255}},
+			"invalid bytecode in <main> at 0000: opcode 255 undefined",
+		},
+		{
+			&compiler.Bytecode{Instructions: instructions(code.Make(code.OpConstant, 0), code.Make(code.OpPop))},
+			"invalid bytecode in <main> at 0000: OpConstant 0, there are 0 constants",
+		},
+		{
+			&compiler.Bytecode{Instructions: code.Make(code.OpConstant, 1)[:2]},
+			"invalid bytecode in <main> at 0000: OpConstant is truncated, it needs 2 bytes of operands and has 1",
+		},
+		{
+			&compiler.Bytecode{Instructions: instructions(code.Make(code.OpGetLocal, 0), code.Make(code.OpPop))},
+			"invalid bytecode in <main> at 0000: OpGetLocal 0, the function has 0 locals",
+		},
+		{
+			&compiler.Bytecode{Instructions: instructions(code.Make(code.OpGetBuiltin, 200), code.Make(code.OpPop))},
+			fmt.Sprintf("invalid bytecode in <main> at 0000: OpGetBuiltin 200, there are %d builtins", len(object.GetBuiltins())),
+		},
+		{
+			// The jump lands on the operand of OpConstant
+			&compiler.Bytecode{
+				Instructions: instructions(code.Make(code.OpJump, 4), code.Make(code.OpConstant, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+			},
+			"invalid bytecode in <main> at 0000: the jump to 0004 doesn't land on an instruction",
+		},
+		{
+			&compiler.Bytecode{Instructions: instructions(code.Make(code.OpAdd), code.Make(code.OpPop))},
+			"invalid bytecode in <main> at 0000: OpAdd pops 2 values but the stack has 0",
+		},
+		{
+			// One path pushes a value and the other one doesn't
+			&compiler.Bytecode{
+				Instructions: instructions(
+					code.Make(code.OpTrue),
+					code.Make(code.OpJumpNotTruthy, 5),
+					code.Make(code.OpTrue),
+					code.Make(code.OpNull),
+					code.Make(code.OpPop),
+				),
+			},
+			"invalid bytecode in <main> at 0005: the stack has 1 values coming from 0004 and 0 from another path",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: instructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{function(0, 0, code.Make(code.OpNull))},
+			},
+			"invalid bytecode in constant 0 (f) at 0000: the function can end without returning",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: instructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{function(0, 0, code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue))},
+			},
+			"invalid bytecode in constant 0 (f) at 0000: OpGetFree 0, the closures of the function have 0 free variables",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: instructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+			},
+			"invalid bytecode in <main> at 0000: OpClosure of the constant 0, which is a INTEGER",
+		},
+		{
+			&compiler.Bytecode{Instructions: instructions(code.Make(code.OpNull), code.Make(code.OpReturnValue))},
+			"invalid bytecode in <main> at 0001: OpReturnValue outside of a function",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: instructions(code.Make(code.OpConstant, 0), code.Make(code.OpHash, 1), code.Make(code.OpPop)),
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+			},
+			"invalid bytecode in <main> at 0003: OpHash 1, a hashmap needs a key and a value for each pair",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: instructions(code.Make(code.OpConstant, 0), code.Make(code.OpHash, 4), code.Make(code.OpPop)),
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+			},
+			"invalid bytecode in <main> at 0003: OpHash pops 4 values but the stack has 1",
+		},
+		{
+			// The local 1 isn't set when it's added
+			&compiler.Bytecode{
+				Instructions: instructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants: []object.Object{function(2, 1,
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				)},
+			},
+			"invalid bytecode in constant 0 (f) at 0004: OpAdd uses the local 1 before it's set",
+		},
+		{
+			// The local is only set by one of the branches
+			&compiler.Bytecode{
+				Instructions: instructions(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants: []object.Object{function(2, 1,
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJumpNotTruthy, 8),
+					code.Make(code.OpTrue),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpSubLocalConstant, 1, 0),
+					code.Make(code.OpReturnValue),
+				)},
+			},
+			"invalid bytecode in constant 0 (f) at 0008: OpSubLocalConstant uses the local 1 before it's set",
+		},
+	}
+
+	for _, tt := range tests {
+		err := Verify(tt.bytecode)
+		if err == nil {
+			t.Fatalf("expected the error %q, got none", tt.expected)
+		}
+		if _, ok := err.(*VerifyError); !ok {
+			t.Fatalf("expected a *VerifyError, got=%T", err)
+		}
+		if err.Error() != tt.expected {
+			t.Fatalf("wrong error:\nwant=%q\ngot= %q", tt.expected, err.Error())
+		}
+	}
+}